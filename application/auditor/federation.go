@@ -0,0 +1,195 @@
+// This module implements a lightweight registry of CONIKS auditors,
+// which allows auditors to advertise the directories they track, and
+// clients to discover the auditors tracking a given directory.
+
+package auditor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol/auditlog"
+)
+
+// ErrRegistry indicates that a federation registry rejected a request
+// or returned a malformed response.
+var ErrRegistry = errors.New("[auditor] The federation registry request failed")
+
+// An Advertisement is the message an auditor sends to a federation
+// registry to advertise the directories it tracks. It consists of the
+// auditor's address Addr and the identifiers (i.e. the hashes of the
+// initial STRs) of all Directories the auditor currently tracks.
+type Advertisement struct {
+	Addr        string
+	Directories [][crypto.HashSizeByte]byte
+}
+
+// A Coverage is the message a federation registry returns to a client
+// querying which auditors track a given directory. It contains the
+// addresses of these Auditors in ascending order.
+type Coverage struct {
+	Auditors []string
+}
+
+// A Registry is an http.Handler that keeps track of the directories
+// each auditor of a federation has advertised.
+//
+// A Registry accepts an Advertisement as a JSON-encoded POST body,
+// and answers a GET request with the query parameter "dir" (the
+// hex-encoded hash of a directory's initial STR) with the JSON-encoded
+// Coverage of that directory.
+// A Registry is safe for concurrent use.
+type Registry struct {
+	mu       sync.RWMutex
+	auditors map[[crypto.HashSizeByte]byte]map[string]bool
+}
+
+var _ http.Handler = (*Registry)(nil)
+
+// NewRegistry constructs a new empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		auditors: make(map[[crypto.HashSizeByte]byte]map[string]bool),
+	}
+}
+
+// Advertise records that the auditor addr tracks exactly the
+// directories dirs. An auditor that is no longer listed for a
+// directory it advertised before is removed from that directory's
+// coverage; in particular, advertising no directories withdraws
+// the auditor from the registry.
+func (r *Registry) Advertise(addr string, dirs [][crypto.HashSizeByte]byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tracked := make(map[[crypto.HashSizeByte]byte]bool, len(dirs))
+	for _, dir := range dirs {
+		tracked[dir] = true
+		if r.auditors[dir] == nil {
+			r.auditors[dir] = make(map[string]bool)
+		}
+		r.auditors[dir][addr] = true
+	}
+	for dir, auditors := range r.auditors {
+		if tracked[dir] || !auditors[addr] {
+			continue
+		}
+		delete(auditors, addr)
+		if len(auditors) == 0 {
+			delete(r.auditors, dir)
+		}
+	}
+}
+
+// Auditors returns the addresses of all auditors which track the
+// directory identified by dirInitHash, in ascending order.
+func (r *Registry) Auditors(dirInitHash [crypto.HashSizeByte]byte) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var addrs []string
+	for addr := range r.auditors[dirInitHash] {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// ServeHTTP records the Advertisement in the body of a POST request r,
+// or writes the Coverage of the directory in the query parameters of
+// any other request r to w.
+// A request that cannot be parsed results in http.StatusBadRequest.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodPost {
+		var ad Advertisement
+		if err := json.NewDecoder(req.Body).Decode(&ad); err != nil || ad.Addr == "" {
+			http.Error(w, "malformed advertisement", http.StatusBadRequest)
+			return
+		}
+		r.Advertise(ad.Addr, ad.Directories)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	dir, err := hex.DecodeString(req.URL.Query().Get("dir"))
+	if err != nil || len(dir) != crypto.HashSizeByte {
+		http.Error(w, "malformed directory", http.StatusBadRequest)
+		return
+	}
+	var dirInitHash [crypto.HashSizeByte]byte
+	copy(dirInitHash[:], dir)
+	msg, err := json.Marshal(&Coverage{Auditors: r.Auditors(dirInitHash)})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(msg)
+}
+
+// A FederationClient talks to the federation Registry at a known URL,
+// both on behalf of auditors advertising the directories they track,
+// and on behalf of clients looking for auditors of a directory.
+type FederationClient struct {
+	registry string
+	client   *http.Client
+}
+
+// NewFederationClient constructs a new FederationClient for the
+// registry at the given URL, which uses http.DefaultClient.
+func NewFederationClient(registry string) *FederationClient {
+	return &FederationClient{registry: registry, client: http.DefaultClient}
+}
+
+// Advertise advertises to the registry that the auditor addr tracks
+// all directories in its audit log l (see auditlog.Directories()).
+// The auditor should advertise again whenever it starts or stops
+// tracking a directory.
+// Advertise() returns ErrRegistry if the registry rejects the
+// advertisement, or any error that occurs while sending it.
+func (c *FederationClient) Advertise(addr string, l auditlog.ConiksAuditLog) error {
+	msg, err := json.Marshal(&Advertisement{Addr: addr, Directories: l.Directories()})
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Post(c.registry, "application/json", bytes.NewReader(msg))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return ErrRegistry
+	}
+	return nil
+}
+
+// Auditors queries the registry for the addresses of the auditors
+// which track the directory identified by dirInitHash.
+// Auditors() returns ErrRegistry if the registry rejects the query or
+// returns a malformed response, or any error that occurs while sending
+// the query.
+func (c *FederationClient) Auditors(dirInitHash [crypto.HashSizeByte]byte) ([]string, error) {
+	u, err := url.Parse(c.registry)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = url.Values{"dir": {hex.EncodeToString(dirInitHash[:])}}.Encode()
+	resp, err := c.client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, ErrRegistry
+	}
+	var cov Coverage
+	if err := json.NewDecoder(resp.Body).Decode(&cov); err != nil {
+		return nil, ErrRegistry
+	}
+	return cov.Auditors, nil
+}
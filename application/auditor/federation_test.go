@@ -0,0 +1,76 @@
+package auditor
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol/auditlog"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+func TestFederationRegistry(t *testing.T) {
+	ts := httptest.NewServer(NewRegistry())
+	defer ts.Close()
+	c := NewFederationClient(ts.URL)
+
+	_, aud1, snaps := auditlog.NewTestAuditLog(t, 3)
+	dirInitHash := auditor.ComputeDirectoryIdentity(snaps[0])
+
+	if auditors, err := c.Auditors(dirInitHash); err != nil || len(auditors) != 0 {
+		t.Fatal("Expect no auditors, got", auditors, err)
+	}
+
+	for _, addr := range []string{"auditor-b", "auditor-a"} {
+		if err := c.Advertise(addr, aud1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	auditors, err := c.Auditors(dirInitHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(auditors, []string{"auditor-a", "auditor-b"}) {
+		t.Error("Unexpected auditors", auditors)
+	}
+
+	var unknown [crypto.HashSizeByte]byte
+	unknown[0] = 1
+	if auditors, err := c.Auditors(unknown); err != nil || len(auditors) != 0 {
+		t.Error("Expect no auditors for an unknown directory, got", auditors, err)
+	}
+
+	// auditor-b stops tracking the directory
+	if err := c.Advertise("auditor-b", auditlog.New()); err != nil {
+		t.Fatal(err)
+	}
+	auditors, err = c.Auditors(dirInitHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(auditors, []string{"auditor-a"}) {
+		t.Error("Expect auditor-b to be removed, got", auditors)
+	}
+}
+
+func TestFederationRegistryMalformed(t *testing.T) {
+	ts := httptest.NewServer(NewRegistry())
+	defer ts.Close()
+
+	if err := NewFederationClient(ts.URL).Advertise("", auditlog.New()); err != ErrRegistry {
+		t.Error("Expect", ErrRegistry, "for an advertisement without an address, got", err)
+	}
+	for _, query := range []string{"", "?dir=xyz", "?dir=abcd"} {
+		resp, err := http.Get(fmt.Sprintf("%s%s", ts.URL, query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Error("Expect", http.StatusBadRequest, "for", query, "got", resp.StatusCode)
+		}
+	}
+}
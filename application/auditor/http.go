@@ -0,0 +1,109 @@
+// This module implements an HTTP+JSON interface to a CONIKS
+// auditor's audit log, as a lighter-weight alternative to the
+// auditor's socket-based interface (e.g. for serving behind a CDN).
+
+package auditor
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditlog"
+)
+
+// An STRHandler is an http.Handler that serves the STR history
+// observed by a CONIKS auditor for the directories in its audit log.
+//
+// An STRHandler accepts an AuditingRequest either as a JSON-encoded
+// POST body, or as the query parameters "dir" (the hex-encoded hash
+// of the directory's initial STR), "start" and "end" (the StartEpoch
+// and EndEpoch of the requested range).
+type STRHandler struct {
+	log auditlog.ConiksAuditLog
+}
+
+var _ http.Handler = (*STRHandler)(nil)
+
+// NewSTRHandler constructs a new STRHandler that serves the STRs
+// in the given audit log l.
+func NewSTRHandler(l auditlog.ConiksAuditLog) *STRHandler {
+	return &STRHandler{log: l}
+}
+
+// ServeHTTP parses an AuditingRequest from the HTTP request r,
+// passes it to the audit log's GetObservedSTRs(), and writes the
+// JSON-encoded protocol.Response to w.
+// The status code of the HTTP response is determined by the error code
+// of the protocol.Response (see statusCode()). A request that cannot be
+// parsed results in a message.NewErrorResponse(ErrMalformedMessage).
+func (h *STRHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var res *protocol.Response
+	req, err := parseAuditingRequest(r)
+	if err != nil {
+		res = protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	} else {
+		res, err = h.log.GetObservedSTRsContext(r.Context(), req)
+		if err != nil {
+			// the client has gone away
+			return
+		}
+	}
+
+	msg, err := application.MarshalResponse(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode(res.Error))
+	w.Write(msg)
+}
+
+// parseAuditingRequest decodes the AuditingRequest in the body
+// of a POST request, or in the query parameters of any other request.
+func parseAuditingRequest(r *http.Request) (*protocol.AuditingRequest, error) {
+	req := new(protocol.AuditingRequest)
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return nil, err
+		}
+		return req, nil
+	}
+
+	q := r.URL.Query()
+	dir, err := hex.DecodeString(q.Get("dir"))
+	if err != nil {
+		return nil, err
+	}
+	if len(dir) != crypto.HashSizeByte {
+		return nil, protocol.ErrMalformedMessage
+	}
+	copy(req.DirInitSTRHash[:], dir)
+	if req.StartEpoch, err = strconv.ParseUint(q.Get("start"), 10, 64); err != nil {
+		return nil, err
+	}
+	if req.EndEpoch, err = strconv.ParseUint(q.Get("end"), 10, 64); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// statusCode maps the error code of an auditor's response
+// to an HTTP status code.
+func statusCode(e protocol.ErrorCode) int {
+	switch e {
+	case protocol.ReqSuccess:
+		return http.StatusOK
+	case protocol.ReqUnknownDirectory:
+		return http.StatusNotFound
+	case protocol.ErrMalformedMessage:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
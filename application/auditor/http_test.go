@@ -0,0 +1,139 @@
+package auditor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditlog"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+func newTestServer(t *testing.T) (*httptest.Server, []*protocol.DirSTR) {
+	_, aud, snaps := auditlog.NewTestAuditLog(t, 3)
+	return httptest.NewServer(NewSTRHandler(aud)), snaps
+}
+
+func get(t *testing.T, url string) (int, *protocol.Response) {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return readResponse(t, resp)
+}
+
+func readResponse(t *testing.T, resp *http.Response) (int, *protocol.Response) {
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		// UnmarshalResponse() only preserves the codes in protocol.Errors
+		res := new(protocol.Response)
+		if err := json.Unmarshal(body, res); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, res
+	}
+	return resp.StatusCode, application.UnmarshalResponse(protocol.STRType, body)
+}
+
+func TestSTRHandlerQuery(t *testing.T) {
+	ts, snaps := newTestServer(t)
+	defer ts.Close()
+
+	dirInitHash := auditor.ComputeDirectoryIdentity(snaps[0])
+	code, res := get(t, fmt.Sprintf("%s?dir=%s&start=1&end=3",
+		ts.URL, hex.EncodeToString(dirInitHash[:])))
+	if code != http.StatusOK {
+		t.Fatal("Expect", http.StatusOK, "got", code)
+	}
+	if err := res.ValidateFor(protocol.AuditType); err != nil {
+		t.Fatal(err)
+	}
+	strs := res.DirectoryResponse.(*protocol.STRHistoryRange).STR
+	if len(strs) != 3 {
+		t.Fatal("Expect 3 STRs, got", len(strs))
+	}
+	for i, str := range strs {
+		if !bytes.Equal(str.Signature, snaps[i+1].Signature) {
+			t.Error("Unexpected STR for epoch", str.Epoch)
+		}
+	}
+}
+
+func TestSTRHandlerPost(t *testing.T) {
+	ts, snaps := newTestServer(t)
+	defer ts.Close()
+
+	req, _ := json.Marshal(&protocol.AuditingRequest{
+		DirInitSTRHash: auditor.ComputeDirectoryIdentity(snaps[0]),
+		StartEpoch:     0,
+		EndEpoch:       3,
+	})
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewReader(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, res := readResponse(t, resp)
+	if code != http.StatusOK {
+		t.Fatal("Expect", http.StatusOK, "got", code)
+	}
+	if strs := res.DirectoryResponse.(*protocol.STRHistoryRange).STR; len(strs) != 4 {
+		t.Fatal("Expect 4 STRs, got", len(strs))
+	}
+}
+
+func TestSTRHandlerStatusCodes(t *testing.T) {
+	ts, snaps := newTestServer(t)
+	defer ts.Close()
+
+	dirInitHash := auditor.ComputeDirectoryIdentity(snaps[0])
+	dir := hex.EncodeToString(dirInitHash[:])
+	var unknown [32]byte
+	unknown[0] = 1
+
+	for _, tc := range []struct {
+		name  string
+		query string
+		code  int
+		err   protocol.ErrorCode
+	}{
+		{"unknown directory", fmt.Sprintf("dir=%s&start=0&end=0",
+			hex.EncodeToString(unknown[:])),
+			http.StatusNotFound, protocol.ReqUnknownDirectory},
+		{"bad range", fmt.Sprintf("dir=%s&start=2&end=1", dir),
+			http.StatusBadRequest, protocol.ErrMalformedMessage},
+		{"future epoch", fmt.Sprintf("dir=%s&start=0&end=4", dir),
+			http.StatusBadRequest, protocol.ErrMalformedMessage},
+		{"bad directory", "dir=xyz&start=0&end=0",
+			http.StatusBadRequest, protocol.ErrMalformedMessage},
+		{"missing epoch", fmt.Sprintf("dir=%s&start=0", dir),
+			http.StatusBadRequest, protocol.ErrMalformedMessage},
+	} {
+		code, res := get(t, ts.URL+"?"+tc.query)
+		if code != tc.code {
+			t.Error(tc.name, "- Expect status", tc.code, "got", code)
+		}
+		if res.Error != tc.err {
+			t.Error(tc.name, "- Expect", tc.err, "got", res.Error)
+		}
+	}
+
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewReader([]byte("{")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, res := readResponse(t, resp); code != http.StatusBadRequest ||
+		res.Error != protocol.ErrMalformedMessage {
+		t.Error("malformed body - Expect", http.StatusBadRequest, "got", code, res.Error)
+	}
+}
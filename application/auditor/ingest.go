@@ -0,0 +1,221 @@
+// This module implements a transport-agnostic adapter which feeds the
+// STRs a CONIKS directory publishes to a message queue (e.g. NATS or
+// Kafka) into an auditor's audit log.
+
+package auditor
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditlog"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+// An IngestMessage is a single message delivered by an IngestSource.
+//
+// Key is the hex-encoded identifier (i.e. the hash of the initial STR)
+// of the directory which published the message, e.g. a Kafka record key
+// or the last token of a NATS subject. Value is either a JSON-encoded
+// protocol.Response to an STRHistoryRequest, or a single JSON-encoded
+// protocol.DirSTR.
+//
+// Ack acknowledges that the message has been processed, so that the
+// source doesn't deliver it again. Nack rejects the message; if requeue
+// is set, the source should deliver it again later, and otherwise drop
+// it (e.g. move it to a dead-letter queue).
+// Each message is acked or nacked exactly once.
+type IngestMessage interface {
+	Key() []byte
+	Value() []byte
+	Ack() error
+	Nack(requeue bool) error
+}
+
+// An IngestSource delivers the messages an Ingestor audits.
+// Receive blocks until the next message is available, and returns
+// ctx.Err() if ctx is done before, or any other error if the source
+// has failed permanently (e.g. its connection was closed).
+type IngestSource interface {
+	Receive(ctx context.Context) (IngestMessage, error)
+}
+
+// An Ingestor audits the STRs it receives from an IngestSource, as
+// AuditIdContext() does for the directory identified by each message's
+// key, and acks or nacks each message according to the outcome:
+//
+//   - a message whose STRs pass the audit (including a re-delivered
+//     message whose STRs have all been observed already) is acked;
+//   - a message that cannot be decoded is nacked without requeueing,
+//     since it will never pass;
+//   - a message whose STRs don't follow the latest verified STR of the
+//     directory (i.e. auditor.ErrRangeGap) is nacked with requeueing,
+//     since it may pass once the missing STRs have been delivered;
+//   - a message that fails the audit for any other reason, e.g. because
+//     the directory is unknown or has equivocated, is nacked without
+//     requeueing.
+//
+// An Ingestor processes one message at a time, and doesn't receive the
+// next message before it has acked or nacked the current one. The
+// number of messages in flight is therefore bounded by the source's own
+// prefetch limit (e.g. Kafka's max.poll.records or a NATS consumer's
+// MaxAckPending), which applies backpressure to the queue if the
+// auditor falls behind.
+type Ingestor struct {
+	log    auditlog.ConiksAuditLog
+	src    IngestSource
+	onFail func(msg IngestMessage, err error)
+}
+
+// NewIngestor constructs a new Ingestor which audits the messages
+// received from src into the audit log l.
+func NewIngestor(l auditlog.ConiksAuditLog, src IngestSource) *Ingestor {
+	return &Ingestor{log: l, src: src}
+}
+
+// SetErrorHandler makes in call f with each message it nacks and the
+// error which caused it to be nacked, e.g. to log the failure or raise
+// an alert. f is called before the message is nacked.
+func (in *Ingestor) SetErrorHandler(f func(msg IngestMessage, err error)) {
+	in.onFail = f
+}
+
+// Run receives and audits messages from the source until ctx is done
+// or the source fails.
+// Run() returns ctx.Err() if ctx is done, in which case the message
+// being audited, if any, is nacked with requeueing, or the error
+// returned by the source's Receive() or by a message's Ack() or Nack().
+func (in *Ingestor) Run(ctx context.Context) error {
+	for {
+		msg, err := in.src.Receive(ctx)
+		if err != nil {
+			return err
+		}
+		if err := in.process(ctx, msg); err != nil {
+			return err
+		}
+	}
+}
+
+// process audits the message msg and acks or nacks it as described
+// in Ingestor.
+func (in *Ingestor) process(ctx context.Context, msg IngestMessage) error {
+	dirInitHash, res, err := decodeIngestMessage(msg)
+	if err == nil {
+		err = in.log.AuditIdContext(ctx, dirInitHash, res)
+	}
+	switch {
+	case err == nil:
+		return msg.Ack()
+	case ctx.Err() != nil:
+		if err := msg.Nack(true); err != nil {
+			return err
+		}
+		return ctx.Err()
+	}
+	if in.onFail != nil {
+		in.onFail(msg, err)
+	}
+	return msg.Nack(err == auditor.ErrRangeGap)
+}
+
+// decodeIngestMessage decodes the directory identifier and the STR range
+// contained in msg, or returns ErrMalformedMessage.
+func decodeIngestMessage(msg IngestMessage) (
+	[crypto.HashSizeByte]byte, *protocol.Response, error) {
+	var dirInitHash [crypto.HashSizeByte]byte
+	key, err := hex.DecodeString(string(msg.Key()))
+	if err != nil || len(key) != crypto.HashSizeByte {
+		return dirInitHash, nil, protocol.ErrMalformedMessage
+	}
+	copy(dirInitHash[:], key)
+
+	value := msg.Value()
+	if len(value) > application.MaxResponseSize {
+		return dirInitHash, nil, protocol.ErrMalformedMessage
+	}
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(value, &probe); err != nil {
+		return dirInitHash, nil, protocol.ErrMalformedMessage
+	}
+	if _, ok := probe["DirectoryResponse"]; ok {
+		res := application.UnmarshalResponse(protocol.STRType, value)
+		if res.Error != protocol.ReqSuccess {
+			return dirInitHash, nil, res.Error
+		}
+		return dirInitHash, res, nil
+	}
+	str := new(protocol.DirSTR)
+	if err := json.Unmarshal(value, str); err != nil || str.SignedTreeRoot == nil {
+		return dirInitHash, nil, protocol.ErrMalformedMessage
+	}
+	return dirInitHash, protocol.NewSTRHistoryRange([]*protocol.DirSTR{str}), nil
+}
+
+// ErrQueueClosed is returned by a QueueClient's Fetch() once the
+// client has been closed.
+var ErrQueueClosed = errors.New("[auditor] The message queue is closed")
+
+// A QueueRecord is a record fetched by a QueueClient. ID identifies the
+// delivery of the record to the client (e.g. a Kafka partition and
+// offset, or a NATS reply subject), and Key and Value are as described
+// in IngestMessage.
+type QueueRecord struct {
+	ID    string
+	Key   []byte
+	Value []byte
+}
+
+// A QueueClient is a minimal client of a message queue, which an
+// operator implements on top of a concrete queue library (e.g. a NATS
+// JetStream pull consumer, or a Kafka consumer group with manual
+// commits) and passes to NewQueueSource().
+// Fetch blocks until the next record is available, and returns
+// ctx.Err() if ctx is done before, or ErrQueueClosed once the client
+// has been closed. Ack and Nack acknowledge and reject the delivery
+// with the given ID, as described in IngestMessage.
+type QueueClient interface {
+	Fetch(ctx context.Context) (*QueueRecord, error)
+	Ack(id string) error
+	Nack(id string, requeue bool) error
+}
+
+// queueSource is the IngestSource for a QueueClient.
+type queueSource struct {
+	client QueueClient
+}
+
+var _ IngestSource = (*queueSource)(nil)
+
+// NewQueueSource returns an IngestSource which receives the records
+// fetched by the queue client c.
+func NewQueueSource(c QueueClient) IngestSource {
+	return &queueSource{client: c}
+}
+
+func (s *queueSource) Receive(ctx context.Context) (IngestMessage, error) {
+	rec, err := s.client.Fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &queueMessage{client: s.client, rec: rec}, nil
+}
+
+// queueMessage is the IngestMessage for a QueueRecord.
+type queueMessage struct {
+	client QueueClient
+	rec    *QueueRecord
+}
+
+func (m *queueMessage) Key() []byte   { return m.rec.Key }
+func (m *queueMessage) Value() []byte { return m.rec.Value }
+func (m *queueMessage) Ack() error    { return m.client.Ack(m.rec.ID) }
+
+func (m *queueMessage) Nack(requeue bool) error {
+	return m.client.Nack(m.rec.ID, requeue)
+}
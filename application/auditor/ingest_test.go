@@ -0,0 +1,169 @@
+package auditor
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditlog"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+// memQueue is an in-memory QueueClient which delivers a fixed sequence
+// of records, and records how each delivery was acknowledged.
+type memQueue struct {
+	records chan *QueueRecord
+	pushed  int
+	acked   map[string]bool
+	requeue map[string]bool
+}
+
+// newMemQueue returns a memQueue which holds up to n records.
+func newMemQueue(n int) *memQueue {
+	return &memQueue{
+		records: make(chan *QueueRecord, n),
+		acked:   make(map[string]bool),
+		requeue: make(map[string]bool),
+	}
+}
+
+func (q *memQueue) push(key [crypto.HashSizeByte]byte, value []byte) string {
+	id := strconv.Itoa(q.pushed)
+	q.pushed++
+	q.records <- &QueueRecord{
+		ID:    id,
+		Key:   []byte(hex.EncodeToString(key[:])),
+		Value: value,
+	}
+	return id
+}
+
+func (q *memQueue) Fetch(ctx context.Context) (*QueueRecord, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case rec, ok := <-q.records:
+		if !ok {
+			return nil, ErrQueueClosed
+		}
+		return rec, nil
+	}
+}
+
+func (q *memQueue) Ack(id string) error {
+	q.acked[id] = true
+	return nil
+}
+
+func (q *memQueue) Nack(id string, requeue bool) error {
+	q.acked[id] = false
+	q.requeue[id] = requeue
+	return nil
+}
+
+func marshalSTR(t *testing.T, str *protocol.DirSTR) []byte {
+	msg, err := json.Marshal(str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func marshalRange(t *testing.T, strs ...*protocol.DirSTR) []byte {
+	msg, err := application.MarshalResponse(protocol.NewSTRHistoryRange(strs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func latestEpoch(l auditlog.ConiksAuditLog, dirInitHash [crypto.HashSizeByte]byte) uint64 {
+	res := l.GetLatestSTR(dirInitHash)
+	return res.DirectoryResponse.(*protocol.STRHistoryRange).STR[0].Epoch
+}
+
+func TestIngestor(t *testing.T) {
+	d, aud, snaps := auditlog.NewTestAuditLog(t, 3)
+	dirInitHash := auditor.ComputeDirectoryIdentity(snaps[0])
+	var strs []*protocol.DirSTR
+	for i := 0; i < 3; i++ {
+		d.Update()
+		strs = append(strs, d.LatestSTR())
+	}
+
+	q := newMemQueue(8)
+	single := q.push(dirInitHash, marshalSTR(t, strs[0]))
+	malformed := q.push(dirInitHash, []byte("{not json"))
+	gap := q.push(dirInitHash, marshalSTR(t, strs[2]))
+	rng := q.push(dirInitHash, marshalRange(t, strs[1], strs[2]))
+	redelivered := q.push(dirInitHash, marshalSTR(t, strs[0]))
+	unknown := q.push([crypto.HashSizeByte]byte{}, marshalSTR(t, strs[2]))
+	close(q.records)
+
+	var failed []error
+	in := NewIngestor(aud, NewQueueSource(q))
+	in.SetErrorHandler(func(msg IngestMessage, err error) {
+		failed = append(failed, err)
+	})
+	if err := in.Run(context.Background()); err != ErrQueueClosed {
+		t.Fatal("Expect", ErrQueueClosed, "got", err)
+	}
+
+	if ep := latestEpoch(aud, dirInitHash); ep != strs[2].Epoch {
+		t.Fatal("Expect the auditor to advance to epoch", strs[2].Epoch, "got", ep)
+	}
+	for _, id := range []string{single, rng, redelivered} {
+		if !q.acked[id] {
+			t.Error("Expect message", id, "to be acked")
+		}
+	}
+	for id, requeue := range map[string]bool{
+		malformed: false, gap: true, unknown: false} {
+		if q.acked[id] || q.requeue[id] != requeue {
+			t.Error("Expect message", id, "to be nacked with requeue", requeue)
+		}
+	}
+	if len(failed) != 3 || failed[0] != protocol.ErrMalformedMessage ||
+		failed[1] != auditor.ErrRangeGap ||
+		!errors.Is(failed[2], auditor.ErrUnknownDirectory) {
+		t.Error("Unexpected failures", failed)
+	}
+}
+
+func TestIngestorRejectsForgedSTR(t *testing.T) {
+	d, aud, snaps := auditlog.NewTestAuditLog(t, 2)
+	dirInitHash := auditor.ComputeDirectoryIdentity(snaps[0])
+	d.Update()
+	str := d.LatestSTR()
+	str.Signature = append([]byte{}, str.Signature...)
+	str.Signature[0] ^= 1
+
+	q := newMemQueue(1)
+	id := q.push(dirInitHash, marshalSTR(t, str))
+	close(q.records)
+	if err := NewIngestor(aud, NewQueueSource(q)).Run(context.Background()); err != ErrQueueClosed {
+		t.Fatal("Expect", ErrQueueClosed, "got", err)
+	}
+	if q.acked[id] || q.requeue[id] {
+		t.Error("Expect the forged STR to be nacked without requeue")
+	}
+	if ep := latestEpoch(aud, dirInitHash); ep != snaps[2].Epoch {
+		t.Error("Expect the auditor not to advance, got epoch", ep)
+	}
+}
+
+func TestIngestorCancel(t *testing.T) {
+	_, aud, _ := auditlog.NewTestAuditLog(t, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	q := newMemQueue(0)
+	if err := NewIngestor(aud, NewQueueSource(q)).Run(ctx); err != context.Canceled {
+		t.Error("Expect", context.Canceled, "got", err)
+	}
+}
@@ -39,6 +39,8 @@ func UnmarshalRequest(msg []byte) (*protocol.Request, error) {
 		request = new(protocol.KeyLookupInEpochRequest)
 	case protocol.MonitoringType:
 		request = new(protocol.MonitoringRequest)
+	case protocol.STRType:
+		request = new(protocol.STRHistoryRequest)
 	}
 	if err := json.Unmarshal(content, &request); err != nil {
 		return nil, err
@@ -47,7 +49,18 @@ func UnmarshalRequest(msg []byte) (*protocol.Request, error) {
 	return &req, nil
 }
 
+// MaxResponseSize is the maximum size in bytes of an encoded response
+// that UnmarshalResponse() decodes, so that a malicious server can't
+// exhaust a client's or an auditor's memory.
+var MaxResponseSize = 16 << 20
+
 // MarshalResponse returns a JSON encoding of the server's response.
+// The encoding is deterministic: the same response always encodes to
+// the same bytes, in any process, since the responses consist of
+// structs and slices only, whose fields and elements are encoded in
+// declaration and index order. This allows hashing the encoding of a
+// response, e.g. of a DirectoryProof, and comparing it against golden
+// values.
 func MarshalResponse(response *protocol.Response) ([]byte, error) {
 	return json.Marshal(response)
 }
@@ -55,11 +68,19 @@ func MarshalResponse(response *protocol.Response) ([]byte, error) {
 // UnmarshalResponse decodes the given message into a protocol.Response
 // according to the given request type t. The request types are integer
 // constants defined in the protocol package.
+// UnmarshalResponse returns a response with ErrMalformedMessage without
+// decoding msg if msg is larger than MaxResponseSize bytes, or, for
+// STRType, if msg contains more than protocol.MaxSTRHistoryRangeLen STRs.
 func UnmarshalResponse(t int, msg []byte) *protocol.Response {
 	type Response struct {
 		Error             protocol.ErrorCode
 		DirectoryResponse json.RawMessage
 	}
+	if len(msg) > MaxResponseSize {
+		return &protocol.Response{
+			Error: protocol.ErrMalformedMessage,
+		}
+	}
 	var res Response
 	if err := json.Unmarshal(msg, &res); err != nil {
 		return &protocol.Response{
@@ -92,6 +113,16 @@ func UnmarshalResponse(t int, msg []byte) *protocol.Response {
 			DirectoryResponse: response,
 		}
 	case protocol.STRType:
+		// count the STRs before decoding them
+		var strs struct {
+			STR []json.RawMessage
+		}
+		if err := json.Unmarshal(res.DirectoryResponse, &strs); err != nil ||
+			len(strs.STR) > protocol.MaxSTRHistoryRangeLen {
+			return &protocol.Response{
+				Error: protocol.ErrMalformedMessage,
+			}
+		}
 		response := new(protocol.STRHistoryRange)
 		if err := json.Unmarshal(res.DirectoryResponse, &response); err != nil {
 			return &protocol.Response{
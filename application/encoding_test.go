@@ -3,9 +3,15 @@ package application
 import (
 	"bytes"
 	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
 
+	"github.com/coniks-sys/coniks-go/crypto"
 	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditlog"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
 	"github.com/coniks-sys/coniks-go/protocol/directory"
 )
 
@@ -45,3 +51,156 @@ func TestUnmarshalSampleMessage(t *testing.T) {
 		t.Error("Cannot unmarshal Associate Data properly")
 	}
 }
+
+func TestUnmarshalOversizedResponse(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	for i := 0; i < 3; i++ {
+		d.Update()
+	}
+	res := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 0,
+		EndEpoch:   3})
+	msg, _ := MarshalResponse(res)
+
+	defer func(n int) { protocol.MaxSTRHistoryRangeLen = n }(protocol.MaxSTRHistoryRangeLen)
+	protocol.MaxSTRHistoryRangeLen = 3
+	if res := UnmarshalResponse(protocol.STRType, msg); res.Error != protocol.ErrMalformedMessage {
+		t.Error("Expect", protocol.ErrMalformedMessage, "for too many STRs, got", res.Error)
+	}
+	protocol.MaxSTRHistoryRangeLen = 4
+	if res := UnmarshalResponse(protocol.STRType, msg); res.Validate() != nil {
+		t.Error("Expect a range of the maximum length to be accepted")
+	}
+
+	defer func(n int) { MaxResponseSize = n }(MaxResponseSize)
+	MaxResponseSize = len(msg) - 1
+	if res := UnmarshalResponse(protocol.STRType, msg); res.Error != protocol.ErrMalformedMessage {
+		t.Error("Expect", protocol.ErrMalformedMessage, "for too many bytes, got", res.Error)
+	}
+}
+
+// FuzzAuditSTRHistoryRange decodes arbitrary bytes as a range of STRs
+// and audits the range, as an auditor does with ranges it receives from
+// the network. Auditing must never panic; the auditor recovers from
+// panics by quarantining the directory, so a quarantine fails the test.
+func FuzzAuditSTRHistoryRange(f *testing.F) {
+	signKey := crypto.NewStaticTestSigningKey()
+	pk, _ := signKey.Public()
+	d := directory.New(1, crypto.NewStaticTestVRFKey(), signKey, 10, true)
+	for i := 0; i < 3; i++ {
+		d.Update()
+	}
+	initSTR := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 0,
+		EndEpoch:   0}).DirectoryResponse.(*protocol.STRHistoryRange).STR[0]
+	dirInitHash := auditor.ComputeDirectoryIdentity(initSTR)
+
+	// seed the corpus with valid ranges, including ones with gaps
+	for _, r := range [][2]uint64{{0, 0}, {0, 3}, {1, 3}, {2, 3}, {3, 3}} {
+		res := d.GetSTRHistory(&protocol.STRHistoryRequest{
+			StartEpoch: r[0],
+			EndEpoch:   r[1]})
+		msg, err := json.Marshal(res)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(msg)
+	}
+	f.Add([]byte(`{"Error":100,"DirectoryResponse":{"STR":[null]}}`))
+	f.Add([]byte(`{"Error":100,"DirectoryResponse":{"STR":[{"Epoch":18446744073709551615}]}}`))
+
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		aud := auditlog.New()
+		if err := aud.InitHistory("test-server", pk,
+			[]*protocol.DirSTR{initSTR}); err != nil {
+			t.Fatal(err)
+		}
+		res := UnmarshalResponse(protocol.STRType, msg)
+		if err := aud.AuditId(dirInitHash, res); err == auditor.ErrQuarantined {
+			t.Fatal("Auditing the range panicked")
+		}
+	})
+}
+
+// marshalSeededProofs returns the encodings of a proof of inclusion, a
+// proof of absence with a TB, and a proof of absence, issued by a test
+// directory with seeded randomness.
+func marshalSeededProofs(t *testing.T) [][]byte {
+	d := directory.NewSeededTestDirectory(t, 1)
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	d.Update()
+	d.Register(&protocol.RegistrationRequest{Username: "bob", Key: []byte("key")})
+	var msgs [][]byte
+	for _, name := range []string{"alice", "bob", "carol"} {
+		msg, err := MarshalResponse(d.KeyLookup(&protocol.KeyLookupRequest{Username: name}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func TestMarshalDirectoryProofDeterministic(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	d.Update()
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"})
+	msg1, _ := MarshalResponse(res)
+	msg2, _ := MarshalResponse(res)
+	if !bytes.Equal(msg1, msg2) {
+		t.Fatal("Expect the same proof to encode to the same bytes")
+	}
+
+	// a proof issued again, or decoded and encoded again, is identical
+	msg3, _ := MarshalResponse(d.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"}))
+	if !bytes.Equal(msg1, msg3) {
+		t.Error("Expect a repeated lookup to encode to the same bytes")
+	}
+	msg4, _ := MarshalResponse(UnmarshalResponse(protocol.KeyLookupType, msg1))
+	if !bytes.Equal(msg1, msg4) {
+		t.Error("Expect a decoded proof to encode to the same bytes")
+	}
+
+	// as are the proofs of two directories with the same randomness
+	seeded := marshalSeededProofs(t)
+	for i, msg := range marshalSeededProofs(t) {
+		if !bytes.Equal(seeded[i], msg) {
+			t.Error("Expect seeded proof", i, "to encode to the same bytes")
+		}
+	}
+}
+
+// proofFileEnv names the environment variable which makes
+// TestMarshalDirectoryProofAcrossProcesses write the seeded proofs to
+// the given file rather than comparing them.
+const proofFileEnv = "CONIKS_TEST_PROOF_FILE"
+
+func TestMarshalDirectoryProofAcrossProcesses(t *testing.T) {
+	msg, err := json.Marshal(marshalSeededProofs(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file := os.Getenv(proofFileEnv); file != "" {
+		if err := os.WriteFile(file, msg, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	// encode the same proofs in a new process, whose map iteration
+	// order and memory layout differ from this process
+	file := filepath.Join(t.TempDir(), "proofs.json")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestMarshalDirectoryProofAcrossProcesses$")
+	cmd.Env = append(os.Environ(), proofFileEnv+"="+file)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatal(err, string(out))
+	}
+	got, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Error("Expect the proofs to encode to the same bytes in another process")
+	}
+}
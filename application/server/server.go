@@ -47,6 +47,7 @@ func NewConiksServer(conf *Config) *ConiksServer {
 		perms[addr.ServerAddress][protocol.KeyLookupType] = true
 		perms[addr.ServerAddress][protocol.KeyLookupInEpochType] = true
 		perms[addr.ServerAddress][protocol.MonitoringType] = true
+		perms[addr.ServerAddress][protocol.STRType] = true
 		perms[addr.ServerAddress][protocol.RegistrationType] = addr.AllowRegistration
 	}
 
@@ -95,6 +96,10 @@ func (server *ConiksServer) HandleRequests(req *protocol.Request) *protocol.Resp
 		if msg, ok := req.Request.(*protocol.MonitoringRequest); ok {
 			return server.dir.Monitor(msg)
 		}
+	case protocol.STRType:
+		if msg, ok := req.Request.(*protocol.STRHistoryRequest); ok {
+			return server.dir.GetSTRHistory(msg)
+		}
 	}
 
 	return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
@@ -498,3 +498,37 @@ func TestMonitoring(t *testing.T) {
 		t.Fatal("Expect", N, "STRs/APs in reponse", "got", len(response.DirectoryResponse.STR))
 	}
 }
+
+func TestGetSTRHistory(t *testing.T) {
+	server, teardown := startServer(t, 60, true, "")
+	defer teardown()
+
+	for i := 0; i < 3; i++ {
+		server.dir.Update()
+	}
+
+	var strHistoryMsg = `
+{
+    "type": 5,
+    "request": {
+        "StartEpoch": 1,
+        "EndEpoch": 3
+    }
+}
+`
+	rev, err := testutil.NewTCPClientDefault([]byte(strHistoryMsg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := application.UnmarshalResponse(protocol.STRType, rev)
+	if err := response.ValidateFor(protocol.STRType); err != nil {
+		t.Fatal(err)
+	}
+	strs := response.DirectoryResponse.(*protocol.STRHistoryRange).STR
+	if len(strs) != 3 || strs[0].Epoch != 1 {
+		t.Fatal("Expect the STRs for epochs 1 to 3 in response")
+	}
+	if !strs[1].VerifyHashChain(strs[0]) || !strs[2].VerifyHashChain(strs[1]) {
+		t.Fatal("Expect a linear range of STRs")
+	}
+}
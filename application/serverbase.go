@@ -214,7 +214,8 @@ func (sb *ServerBase) acceptClient(addr *ServerAddress, conn net.Conn,
 			response = malformedClientMsg(err)
 		} else {
 			switch req.Type {
-			case protocol.KeyLookupType, protocol.KeyLookupInEpochType, protocol.MonitoringType:
+			case protocol.KeyLookupType, protocol.KeyLookupInEpochType, protocol.MonitoringType,
+				protocol.STRType:
 				sb.RLock()
 			default:
 				sb.Lock()
@@ -223,7 +224,8 @@ func (sb *ServerBase) acceptClient(addr *ServerAddress, conn net.Conn,
 			response = handler(req)
 
 			switch req.Type {
-			case protocol.KeyLookupType, protocol.KeyLookupInEpochType, protocol.MonitoringType:
+			case protocol.KeyLookupType, protocol.KeyLookupInEpochType, protocol.MonitoringType,
+				protocol.STRType:
 				sb.RUnlock()
 			default:
 				sb.Unlock()
@@ -261,15 +263,19 @@ func (sb *ServerBase) RunInBackground(f func()) {
 }
 
 // EpochUpdate runs function `f`, which is supposed to be a CONIK's update
-// procedure every epoch, following the given timer.
-func (sb *ServerBase) EpochUpdate(timer *EpochTimer, f func()) {
+// procedure every epoch, following the given timer. A failed update is
+// logged and retried at the next tick.
+func (sb *ServerBase) EpochUpdate(timer *EpochTimer, f func() error) {
 	for {
 		select {
 		case <-sb.stop:
 			return
 		case <-timer.C:
 			sb.Lock()
-			f()
+			if err := f(); err != nil {
+				sb.logger.Error("epoch update failed",
+					"error", err.Error())
+			}
 			timer.Reset(timer.duration)
 			sb.Unlock()
 		}
@@ -45,7 +45,10 @@ func run(cmd *cobra.Command, args []string) {
 
 	// FIXME: right now we're passing the initSTR, but we should really
 	// be passing the latest pinned STR here
-	cc := client.New(conf.InitSTR, true, conf.SigningPubKey)
+	cc, err := client.NewChecked(conf.InitSTR, true, conf.SigningPubKey)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	state, err := terminal.MakeRaw(int(os.Stdin.Fd()))
 	if err != nil {
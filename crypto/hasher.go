@@ -0,0 +1,69 @@
+package crypto
+
+import (
+	"crypto/sha512"
+	"sync"
+)
+
+// SHA512_256ID identifies SHA-512/256 as a string.
+const SHA512_256ID = "SHA-512/256"
+
+// A Hasher is a hash function which can be selected by a CONIKS
+// directory, e.g., for linking its signed tree roots.
+// Every Hasher must output HashSizeByte bytes.
+type Hasher interface {
+	// ID identifies the hash function as a string.
+	ID() string
+	// Digest hashes all passed byte slices.
+	// The passed slices won't be mutated.
+	Digest(ms ...[]byte) []byte
+}
+
+// DefaultHasher is the Hasher implementing Digest().
+var DefaultHasher Hasher = shake128Hasher{}
+
+type shake128Hasher struct{}
+
+func (shake128Hasher) ID() string { return HashID }
+
+func (shake128Hasher) Digest(ms ...[]byte) []byte { return Digest(ms...) }
+
+type sha512_256Hasher struct{}
+
+func (sha512_256Hasher) ID() string { return SHA512_256ID }
+
+func (sha512_256Hasher) Digest(ms ...[]byte) []byte {
+	h := sha512.New512_256()
+	for _, m := range ms {
+		h.Write(m)
+	}
+	return h.Sum(nil)
+}
+
+var (
+	hashersMu sync.RWMutex
+	hashers   = map[string]Hasher{
+		HashID:       DefaultHasher,
+		SHA512_256ID: sha512_256Hasher{},
+	}
+)
+
+// RegisterHasher makes h available via GetHasher under h.ID().
+// It panics if a different Hasher was already registered under
+// the same ID.
+func RegisterHasher(h Hasher) {
+	hashersMu.Lock()
+	defer hashersMu.Unlock()
+	if old, ok := hashers[h.ID()]; ok && old != h {
+		panic("[crypto] Hasher already registered: " + h.ID())
+	}
+	hashers[h.ID()] = h
+}
+
+// GetHasher returns the Hasher registered under the given id,
+// or nil if there is none.
+func GetHasher(id string) Hasher {
+	hashersMu.RLock()
+	defer hashersMu.RUnlock()
+	return hashers[id]
+}
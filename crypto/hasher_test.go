@@ -0,0 +1,24 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGetHasher(t *testing.T) {
+	for _, id := range []string{HashID, SHA512_256ID} {
+		h := GetHasher(id)
+		if h == nil || h.ID() != id {
+			t.Fatal("Expect hasher", id)
+		}
+		if len(h.Digest([]byte("test"))) != HashSizeByte {
+			t.Fatal("Expect", HashSizeByte, "byte digests from", id)
+		}
+	}
+	if !bytes.Equal(DefaultHasher.Digest([]byte("test")), Digest([]byte("test"))) {
+		t.Fatal("Expect the default hasher to implement Digest")
+	}
+	if GetHasher("unknown") != nil {
+		t.Fatal("Expect no hasher for an unknown ID")
+	}
+}
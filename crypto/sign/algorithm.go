@@ -0,0 +1,65 @@
+package sign
+
+import (
+	"sync"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// Ed25519ID identifies the Ed25519 signature scheme implemented by
+// this package as a string.
+const Ed25519ID = "Ed25519"
+
+// An Algorithm is a signature scheme which a CONIKS directory can
+// declare in its policies, and which clients and auditors use to
+// verify the directory's signatures.
+type Algorithm interface {
+	// ID identifies the signature scheme as a string.
+	ID() string
+	// Verify verifies a signature sig on message using the
+	// public-key pk. It returns true if and only if the signature
+	// is valid. The passed slices aren't modified.
+	Verify(pk PublicKey, message, sig []byte) bool
+}
+
+// DefaultAlgorithm is the Algorithm implementing PublicKey.Verify(),
+// i.e. Ed25519.
+var DefaultAlgorithm Algorithm = ed25519Algorithm{}
+
+type ed25519Algorithm struct{}
+
+func (ed25519Algorithm) ID() string { return Ed25519ID }
+
+func (ed25519Algorithm) Verify(pk PublicKey, message, sig []byte) bool {
+	if len(pk) != PublicKeySize {
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(pk), message, sig)
+}
+
+var (
+	algorithmsMu sync.RWMutex
+	algorithms   = map[string]Algorithm{
+		Ed25519ID: DefaultAlgorithm,
+	}
+)
+
+// RegisterAlgorithm makes alg available via GetAlgorithm under
+// alg.ID(). It panics if a different Algorithm was already registered
+// under the same ID.
+func RegisterAlgorithm(alg Algorithm) {
+	algorithmsMu.Lock()
+	defer algorithmsMu.Unlock()
+	if old, ok := algorithms[alg.ID()]; ok && old != alg {
+		panic("[sign] Algorithm already registered: " + alg.ID())
+	}
+	algorithms[alg.ID()] = alg
+}
+
+// GetAlgorithm returns the Algorithm registered under the given id,
+// or nil if there is none.
+func GetAlgorithm(id string) Algorithm {
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+	return algorithms[id]
+}
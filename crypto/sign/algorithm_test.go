@@ -0,0 +1,33 @@
+package sign
+
+import (
+	"testing"
+)
+
+func TestGetAlgorithm(t *testing.T) {
+	alg := GetAlgorithm(Ed25519ID)
+	if alg == nil || alg.ID() != Ed25519ID {
+		t.Fatal("Expect algorithm", Ed25519ID)
+	}
+
+	key, err := GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := key.Public()
+	message := []byte("test message")
+	sig := key.Sign(message)
+	if !alg.Verify(pk, message, sig) {
+		t.Error("valid signature rejected")
+	}
+	if alg.Verify(pk, []byte("wrong message"), sig) {
+		t.Error("signature of different message accepted")
+	}
+	if alg.Verify(pk[:PublicKeySize-1], message, sig) {
+		t.Error("signature under a malformed key accepted")
+	}
+
+	if GetAlgorithm("unknown") != nil {
+		t.Fatal("Expect no algorithm for an unknown ID")
+	}
+}
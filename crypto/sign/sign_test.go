@@ -44,3 +44,18 @@ func TestConvertPrivateKeyToPublicKey(t *testing.T) {
 		t.Fatal("Raw byte respresentation doesn't match public key.")
 	}
 }
+
+func TestKeySignerMalformedKey(t *testing.T) {
+	key, err := GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := []byte("test message")
+	sig, err := KeySigner(key).Sign(message)
+	if err != nil || !bytes.Equal(sig, key.Sign(message)) {
+		t.Fatal("Expect the signer to sign with the key, got", err)
+	}
+	if _, err := KeySigner(key[:1]).Sign(message); err != ErrMalformedKey {
+		t.Fatal("Expect", ErrMalformedKey, "got", err)
+	}
+}
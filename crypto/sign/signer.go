@@ -0,0 +1,33 @@
+package sign
+
+import "errors"
+
+// ErrMalformedKey indicates that an in-memory private key doesn't have
+// PrivateKeySize bytes and can't be used for signing.
+var ErrMalformedKey = errors.New("[sign] Malformed private key")
+
+// A Signer signs messages with a private key which doesn't need to
+// be held in process memory, e.g. a key stored in an HSM or a cloud KMS.
+// Sign returns the signature on message, or an error if the message
+// couldn't be signed, e.g. because the external signer is unreachable.
+// The signatures must be verifiable with Verify() using the signer's
+// public key.
+type Signer interface {
+	Sign(message []byte) ([]byte, error)
+}
+
+// keySigner is the Signer for an in-memory private key.
+type keySigner PrivateKey
+
+// KeySigner returns a Signer which signs messages with the in-memory
+// private key key.
+func KeySigner(key PrivateKey) Signer {
+	return keySigner(key)
+}
+
+func (s keySigner) Sign(message []byte) ([]byte, error) {
+	if len(s) != PrivateKeySize {
+		return nil, ErrMalformedKey
+	}
+	return PrivateKey(s).Sign(message), nil
+}
@@ -3,6 +3,7 @@ package crypto
 import (
 	"bytes"
 	"crypto/rand"
+	"io"
 
 	"golang.org/x/crypto/sha3"
 )
@@ -36,8 +37,17 @@ func Digest(ms ...[]byte) []byte {
 // as unpredictable as desired).
 // See https://trac.torproject.org/projects/tor/ticket/17694
 func MakeRand() ([]byte, error) {
+	return MakeRandFrom(nil)
+}
+
+// MakeRandFrom is like MakeRand but uses the passed io.Reader rnd as
+// a source of randomness, or, if rnd is nil, rand.Reader.
+func MakeRandFrom(rnd io.Reader) ([]byte, error) {
+	if rnd == nil {
+		rnd = rand.Reader
+	}
 	r := make([]byte, HashSizeByte)
-	if _, err := rand.Read(r); err != nil {
+	if _, err := io.ReadFull(rnd, r); err != nil {
 		return nil, err
 	}
 	// Do not directly reveal bytes from rand.Read on the wire
@@ -58,7 +68,13 @@ type Commit struct {
 // stuff (which won't be mutated). It creates a random salt before
 // committing to the values.
 func NewCommit(stuff ...[]byte) (*Commit, error) {
-	salt, err := MakeRand()
+	return NewCommitFrom(nil, stuff...)
+}
+
+// NewCommitFrom is like NewCommit but creates the salt using the passed
+// io.Reader rnd as a source of randomness (see MakeRandFrom()).
+func NewCommitFrom(rnd io.Reader, stuff ...[]byte) (*Commit, error) {
+	salt, err := MakeRandFrom(rnd)
 	if err != nil {
 		return nil, err
 	}
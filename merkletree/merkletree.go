@@ -3,6 +3,7 @@ package merkletree
 import (
 	"bytes"
 	"errors"
+	"io"
 
 	"github.com/coniks-sys/coniks-go/crypto"
 	"github.com/coniks-sys/coniks-go/utils"
@@ -31,20 +32,29 @@ type MerkleTree struct {
 	nonce []byte
 	root  *interiorNode
 	hash  []byte
+	rand  io.Reader // source of randomness; nil for the default
 }
 
 // NewMerkleTree returns an empty Merkle prefix tree
 // with a secure random nonce. The tree root is an interior node
 // and its children are two empty leaf nodes.
 func NewMerkleTree() (*MerkleTree, error) {
+	return newMerkleTree(nil)
+}
+
+// newMerkleTree returns an empty Merkle prefix tree that uses
+// rnd as the source of randomness for its nonce and commitments
+// (see crypto.MakeRandFrom()).
+func newMerkleTree(rnd io.Reader) (*MerkleTree, error) {
 	root := newInteriorNode(nil, 0, []bool{})
-	nonce, err := crypto.MakeRand()
+	nonce, err := crypto.MakeRandFrom(rnd)
 	if err != nil {
 		return nil, err
 	}
 	m := &MerkleTree{
 		nonce: nonce,
 		root:  root,
+		rand:  rnd,
 	}
 	return m, nil
 }
@@ -128,7 +138,7 @@ func (m *MerkleTree) Get(lookupIndex []byte) *AuthenticationPath {
 // commitment are replaced with the new value and newly generated
 // commitment.
 func (m *MerkleTree) Set(index []byte, key string, value []byte) error {
-	commitment, err := crypto.NewCommit([]byte(key), value)
+	commitment, err := crypto.NewCommitFrom(m.rand, []byte(key), value)
 	if err != nil {
 		return err
 	}
@@ -239,6 +249,13 @@ func visitULNsInternal(nodePtr merkleNode, callBack func(*userLeafNode)) {
 	}
 }
 
+// Size returns the number of leaves, i.e. bindings, in the tree m.
+func (m *MerkleTree) Size() int {
+	n := 0
+	m.visitLeafNodes(func(*userLeafNode) { n++ })
+	return n
+}
+
 func (m *MerkleTree) recomputeHash() {
 	m.hash = m.root.hash(m)
 }
@@ -251,5 +268,6 @@ func (m *MerkleTree) Clone() *MerkleTree {
 		nonce: m.nonce,
 		root:  m.root.clone(nil).(*interiorNode),
 		hash:  append([]byte{}, m.hash...),
+		rand:  m.rand,
 	}
 }
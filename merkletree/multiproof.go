@@ -0,0 +1,204 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// ErrMalformedMultiProof indicates that a MultiProof's paths don't
+// form a valid pruned tree, or that it doesn't include exactly the
+// sibling hashes needed to recompute the tree's root node.
+var ErrMalformedMultiProof = errors.New("[merkletree] Malformed multiproof")
+
+// A MultiProof is a compact encoding of the authentication paths for
+// several lookup indices in the same tree. Rather than including all
+// sibling hashes of each path, it only includes the Siblings which
+// can't be computed from the leaves of the other paths, so that the
+// nodes shared by several paths (e.g. those close to the root) are
+// included only once.
+//
+// Each of the Paths is an AuthenticationPath without its TreeNonce and
+// PrunedTree, which are replaced by the shared TreeNonce and Siblings.
+// The Siblings are ordered by decreasing level, and by their position
+// within the same level (see siblingPositions()).
+type MultiProof struct {
+	TreeNonce []byte
+	Paths     []*AuthenticationPath
+	Siblings  [][crypto.HashSizeByte]byte
+}
+
+// NewMultiProof creates the MultiProof for the authentication paths
+// aps, which must have been returned by the same tree.
+// It returns ErrMalformedMultiProof if aps is empty, or if the paths
+// don't share the same tree nonce or are otherwise inconsistent.
+// aps aren't modified.
+func NewMultiProof(aps []*AuthenticationPath) (*MultiProof, error) {
+	if len(aps) == 0 {
+		return nil, ErrMalformedMultiProof
+	}
+	mp := &MultiProof{TreeNonce: aps[0].TreeNonce}
+	hashes := make(map[string][crypto.HashSizeByte]byte)
+	for _, ap := range aps {
+		if ap == nil || ap.Leaf == nil || !bytes.Equal(ap.TreeNonce, mp.TreeNonce) {
+			return nil, ErrMalformedMultiProof
+		}
+		pos, err := leafPosition(ap.Leaf)
+		if err != nil {
+			return nil, ErrMalformedMultiProof
+		}
+		siblings, err := ap.siblings()
+		if err != nil || len(siblings) < len(pos) {
+			return nil, ErrMalformedMultiProof
+		}
+		for depth := range pos {
+			hashes[siblingOf(pos[:depth+1])] = siblings[depth]
+		}
+		path := *ap
+		path.TreeNonce = nil
+		path.PrunedTree = nil
+		path.EmptySiblings = nil
+		mp.Paths = append(mp.Paths, &path)
+	}
+
+	leaves, err := mp.leafHashes()
+	if err != nil {
+		return nil, err
+	}
+	for _, pos := range siblingPositions(leaves) {
+		mp.Siblings = append(mp.Siblings, hashes[pos])
+	}
+	return mp, nil
+}
+
+// VerifyRoot recomputes the tree's root node from all paths in mp at
+// once, and compares it to treeHash, which is taken from a STR.
+// It returns ErrMalformedMultiProof if mp is malformed, and
+// ErrUnequalTreeHashes if the root doesn't match treeHash.
+// VerifyRoot() doesn't verify the leaves of the paths; use
+// AuthenticationPath.VerifyBinding() on each of mp.Paths for this.
+func (mp *MultiProof) VerifyRoot(treeHash []byte) error {
+	known, err := mp.leafHashes()
+	if err != nil {
+		return err
+	}
+	siblings := siblingPositions(known)
+	if len(siblings) != len(mp.Siblings) {
+		return ErrMalformedMultiProof
+	}
+	for i, pos := range siblings {
+		known[pos] = mp.Siblings[i][:]
+	}
+
+	var hashAt func(pos string) []byte
+	hashAt = func(pos string) []byte {
+		if h, ok := known[pos]; ok {
+			return h
+		}
+		// pos is an interior node on one of the paths, since all
+		// siblings of the paths' nodes are known
+		return crypto.Digest(hashAt(pos+"0"), hashAt(pos+"1"))
+	}
+	if !bytes.Equal(treeHash, hashAt("")) {
+		return ErrUnequalTreeHashes
+	}
+	return nil
+}
+
+// leafHashes returns the hashes of the leaves of mp's paths by
+// their position in the tree (see leafPosition()).
+// It returns ErrMalformedMultiProof if two paths include different
+// leaves at the same position, or if the leaf of a path is an interior
+// node on another path.
+func (mp *MultiProof) leafHashes() (map[string][]byte, error) {
+	if len(mp.Paths) == 0 {
+		return nil, ErrMalformedMultiProof
+	}
+	leaves := make(map[string][]byte, len(mp.Paths))
+	for _, ap := range mp.Paths {
+		if ap == nil || ap.Leaf == nil {
+			return nil, ErrMalformedMultiProof
+		}
+		pos, err := leafPosition(ap.Leaf)
+		if err != nil {
+			return nil, err
+		}
+		h := ap.Leaf.hash(mp.TreeNonce)
+		if old, ok := leaves[pos]; ok && !bytes.Equal(old, h) {
+			return nil, ErrMalformedMultiProof
+		}
+		leaves[pos] = h
+	}
+	for pos := range leaves {
+		for l := 0; l < len(pos); l++ {
+			if _, ok := leaves[pos[:l]]; ok {
+				return nil, ErrMalformedMultiProof
+			}
+		}
+	}
+	return leaves, nil
+}
+
+// siblingPositions returns the positions of the sibling nodes needed to
+// recompute the root node from the leaves at the given positions
+// (see leafHashes()), i.e. the siblings of all nodes on the paths to the
+// leaves which aren't on any of these paths themselves. The positions
+// are sorted by decreasing level, and in ascending order within the
+// same level.
+func siblingPositions(leaves map[string][]byte) []string {
+	onPath := make(map[string]bool)
+	for pos := range leaves {
+		for l := 0; l <= len(pos); l++ {
+			onPath[pos[:l]] = true
+		}
+	}
+	needed := make(map[string]bool)
+	for pos := range leaves {
+		for l := 1; l <= len(pos); l++ {
+			if sib := siblingOf(pos[:l]); !onPath[sib] {
+				needed[sib] = true
+			}
+		}
+	}
+	siblings := make([]string, 0, len(needed))
+	for pos := range needed {
+		siblings = append(siblings, pos)
+	}
+	sort.Slice(siblings, func(i, j int) bool {
+		if len(siblings[i]) != len(siblings[j]) {
+			return len(siblings[i]) > len(siblings[j])
+		}
+		return siblings[i] < siblings[j]
+	})
+	return siblings
+}
+
+// leafPosition returns the position of the leaf n in the tree, i.e.
+// the first n.Level bits of its index as a string of '0's and '1's.
+func leafPosition(n *ProofNode) (string, error) {
+	bits := utils.ToBits(n.Index)
+	if int(n.Level) > len(bits) {
+		return "", ErrMalformedMultiProof
+	}
+	pos := make([]byte, n.Level)
+	for i := range pos {
+		pos[i] = '0'
+		if bits[i] {
+			pos[i] = '1'
+		}
+	}
+	return string(pos), nil
+}
+
+// siblingOf returns the position of the sibling of the non-root node
+// at position pos.
+func siblingOf(pos string) string {
+	last := byte('1')
+	if pos[len(pos)-1] == '1' {
+		last = '0'
+	}
+	return pos[:len(pos)-1] + string(last)
+}
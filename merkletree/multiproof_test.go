@@ -0,0 +1,156 @@
+package merkletree
+
+import (
+	"strconv"
+	"testing"
+)
+
+// setupMultiProof creates a MerkleTree with n inclusions, and returns
+// the authentication paths of the first lookups entries, followed by
+// the authentication path of an absent key.
+func setupMultiProof(tb testing.TB, n, lookups int) (*MerkleTree, []*AuthenticationPath) {
+	m, err := NewMerkleTree()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		key := keyPrefix + strconv.Itoa(i)
+		index := staticVRFKey.Compute([]byte(key))
+		if err := m.Set(index, key, append(valuePrefix, byte(i))); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	m.recomputeHash()
+
+	var aps []*AuthenticationPath
+	for i := 0; i < lookups; i++ {
+		aps = append(aps, m.Get(staticVRFKey.Compute([]byte(keyPrefix+strconv.Itoa(i)))))
+	}
+	aps = append(aps, m.Get(staticVRFKey.Compute([]byte("absent"))))
+	return m, aps
+}
+
+func TestMultiProof(t *testing.T) {
+	m, aps := setupMultiProof(t, 100, 10)
+	mp, err := NewMultiProof(aps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mp.VerifyRoot(m.hash); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, ap := range mp.Paths {
+		if i == len(mp.Paths)-1 {
+			if ap.ProofType() != ProofOfAbsence {
+				t.Fatal("Expect a proof of absence")
+			}
+			if err := ap.VerifyBinding([]byte("absent"), nil); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		key := keyPrefix + strconv.Itoa(i)
+		if err := ap.VerifyBinding([]byte(key), append(valuePrefix, byte(i))); err != nil {
+			t.Fatal(err)
+		}
+		if err := ap.VerifyBinding([]byte(key), []byte("wrong")); err != ErrBindingsDiffer {
+			t.Fatal("Expect error", ErrBindingsDiffer, "got", err)
+		}
+	}
+
+	var total int
+	for _, ap := range aps {
+		total += len(ap.PrunedTree)
+	}
+	if len(mp.Siblings) >= total {
+		t.Fatal("Expect the multiproof to include fewer sibling hashes than the paths",
+			"got", len(mp.Siblings), "want <", total)
+	}
+}
+
+func TestMultiProofSinglePath(t *testing.T) {
+	m, aps := setupMultiProof(t, 10, 1)
+	mp, err := NewMultiProof(aps[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mp.Siblings) != len(aps[0].PrunedTree) {
+		t.Fatal("Unexpected number of sibling hashes",
+			"want", len(aps[0].PrunedTree), "got", len(mp.Siblings))
+	}
+	if err := mp.VerifyRoot(m.hash); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMultiProofTampered(t *testing.T) {
+	m, aps := setupMultiProof(t, 100, 10)
+	newProof := func() *MultiProof {
+		mp, err := NewMultiProof(aps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mp
+	}
+
+	mp := newProof()
+	mp.Siblings[0][0] ^= 1
+	if err := mp.VerifyRoot(m.hash); err != ErrUnequalTreeHashes {
+		t.Fatal("Expect error", ErrUnequalTreeHashes, "got", err)
+	}
+
+	mp = newProof()
+	mp.Paths[0].Leaf.Commitment.Value[0] ^= 1
+	if err := mp.VerifyRoot(m.hash); err != ErrUnequalTreeHashes {
+		t.Fatal("Expect error", ErrUnequalTreeHashes, "got", err)
+	}
+	mp.Paths[0].Leaf.Commitment.Value[0] ^= 1
+
+	mp = newProof()
+	mp.Siblings = mp.Siblings[1:]
+	if err := mp.VerifyRoot(m.hash); err != ErrMalformedMultiProof {
+		t.Fatal("Expect error", ErrMalformedMultiProof, "got", err)
+	}
+
+	mp = newProof()
+	mp.Siblings = append(mp.Siblings, mp.Siblings[0])
+	if err := mp.VerifyRoot(m.hash); err != ErrMalformedMultiProof {
+		t.Fatal("Expect error", ErrMalformedMultiProof, "got", err)
+	}
+
+	mp = newProof()
+	mp.Paths = nil
+	if err := mp.VerifyRoot(m.hash); err != ErrMalformedMultiProof {
+		t.Fatal("Expect error", ErrMalformedMultiProof, "got", err)
+	}
+
+	if _, err := NewMultiProof(nil); err != ErrMalformedMultiProof {
+		t.Fatal("Expect error", ErrMalformedMultiProof, "got", err)
+	}
+}
+
+// BenchmarkMultiProof reports the number of sibling hashes needed for a
+// batch of 100 lookups in a tree with 10K entries, once as individual
+// authentication paths and once as a MultiProof.
+func BenchmarkMultiProof(b *testing.B) {
+	m, aps := setupMultiProof(b, 10000, 100)
+	var paths int
+	for _, ap := range aps {
+		paths += len(ap.PrunedTree)
+	}
+
+	b.ResetTimer()
+	var mp *MultiProof
+	for n := 0; n < b.N; n++ {
+		var err error
+		if mp, err = NewMultiProof(aps); err != nil {
+			b.Fatal(err)
+		}
+		if err := mp.VerifyRoot(m.hash); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(paths), "path-hashes/op")
+	b.ReportMetric(float64(len(mp.Siblings)), "multiproof-hashes/op")
+}
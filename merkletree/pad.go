@@ -3,6 +3,8 @@ package merkletree
 import (
 	"bytes"
 	"errors"
+	"io"
+	"time"
 
 	"github.com/coniks-sys/coniks-go/crypto"
 	"github.com/coniks-sys/coniks-go/crypto/sign"
@@ -14,6 +16,10 @@ var (
 	// memory, because the maximum number of cached PAD snapshots
 	// has been exceeded.
 	ErrSTRNotFound = errors.New("[merkletree] STR not found")
+	// ErrSTRSigning indicates that the PAD couldn't sign a new STR,
+	// e.g. because its signing key is malformed or its external signer
+	// failed (see NewPADWithSigner()).
+	ErrSTRSigning = errors.New("[merkletree] Could not sign the STR")
 )
 
 // A PAD represents a persistent authenticated dictionary,
@@ -21,64 +27,120 @@ var (
 // the latest SignedTreeRoot, two key pairs for signing and VRF
 // computation, and additional developer-specified AssocData.
 type PAD struct {
-	signKey      sign.PrivateKey
+	signer       sign.Signer
 	vrfKey       vrf.PrivateKey
 	tree         *MerkleTree // will be used to create the next STR
 	snapshots    map[uint64]*SignedTreeRoot
 	loadedEpochs []uint64 // slice of epochs in snapshots
 	latestSTR    *SignedTreeRoot
 	ad           AssocData
+	rand         io.Reader        // source of randomness; nil for the default
+	clock        func() time.Time // timestamps the STRs; nil for none
 }
 
 // NewPAD creates new PAD with the given associated data ad,
 // signing key pair signKey, VRF key pair vrfKey, and the
 // maximum capacity for the snapshot cache len.
 func NewPAD(ad AssocData, signKey sign.PrivateKey, vrfKey vrf.PrivateKey, len uint64) (*PAD, error) {
+	return newPAD(ad, sign.KeySigner(signKey), vrfKey, len, nil)
+}
+
+// NewPADFrom is like NewPAD but uses the passed io.Reader rnd as the
+// source of randomness for its tree nonces, commitments and the
+// initial STR's previous hash, or, if rnd is nil, crypto/rand.Reader
+// (see crypto.MakeRandFrom()). This allows reproducing the exact
+// history of a PAD from a recording of its randomness.
+func NewPADFrom(ad AssocData, signKey sign.PrivateKey, vrfKey vrf.PrivateKey,
+	len uint64, rnd io.Reader) (*PAD, error) {
+	return newPAD(ad, sign.KeySigner(signKey), vrfKey, len, rnd)
+}
+
+// NewPADWithSigner is like NewPADFrom but signs the STRs with signer
+// rather than with an in-memory signing key, e.g. to keep the signing
+// key in an HSM or a cloud KMS.
+func NewPADWithSigner(ad AssocData, signer sign.Signer, vrfKey vrf.PrivateKey,
+	len uint64, rnd io.Reader) (*PAD, error) {
+	return newPAD(ad, signer, vrfKey, len, rnd)
+}
+
+// newPAD creates a new PAD as NewPAD() does, which signs its STRs with
+// signer and uses rnd as the source of randomness for its tree nonces,
+// commitments and the initial STR's previous hash
+// (see crypto.MakeRandFrom()).
+func newPAD(ad AssocData, signer sign.Signer, vrfKey vrf.PrivateKey,
+	len uint64, rnd io.Reader) (*PAD, error) {
 	if ad == nil {
 		panic("[merkletree] PAD must be created with non-nil associated data")
 	}
 	var err error
 	pad := new(PAD)
-	pad.signKey = signKey
+	pad.signer = signer
 	pad.vrfKey = vrfKey
-	pad.tree, err = NewMerkleTree()
+	pad.rand = rnd
+	pad.tree, err = newMerkleTree(rnd)
 	if err != nil {
 		return nil, err
 	}
 	pad.ad = ad
 	pad.snapshots = make(map[uint64]*SignedTreeRoot, len)
 	pad.loadedEpochs = make([]uint64, 0, len)
-	pad.updateInternal(nil, 0)
+	if err := pad.updateInternal(nil, 0); err != nil {
+		return nil, err
+	}
 	return pad, nil
 }
 
-func (pad *PAD) signTreeRoot(epoch uint64) {
+// signTreeRoot creates and signs the STR for the given epoch
+// without adding it to the PAD's snapshots.
+func (pad *PAD) signTreeRoot(epoch uint64) (str *SignedTreeRoot, err error) {
 	var prevHash []byte
 	if pad.latestSTR == nil {
-		var err error
-		prevHash, err = crypto.MakeRand()
+		prevHash, err = crypto.MakeRandFrom(pad.rand)
 		if err != nil {
-			// panic here since if there is an error, it
-			// will break the PAD.
-			panic(err)
+			return nil, err
 		}
 	} else {
-		prevHash = crypto.Digest(pad.latestSTR.Signature)
+		prevHash = strHasher(pad.ad).Digest(pad.latestSTR.Signature)
 	}
 	pad.tree.recomputeHash()
 	m := pad.tree.Clone()
-	pad.latestSTR = NewSTR(pad.signKey, pad.ad, m, epoch, prevHash)
+	var timestamp uint64
+	if pad.clock != nil {
+		timestamp = uint64(pad.clock().Unix())
+	}
+	str, err = newSTR(pad.signer, pad.ad, m, epoch, prevHash, timestamp)
+	if err != nil {
+		return nil, ErrSTRSigning
+	}
+	str.vrfKey = pad.vrfKey
+	return str, nil
 }
 
-func (pad *PAD) updateInternal(ad AssocData, epoch uint64) {
+// updateInternal issues the STR for the given epoch and then commits it
+// to the PAD's snapshots. If the STR can't be issued, the PAD's
+// snapshots remain unchanged.
+func (pad *PAD) updateInternal(ad AssocData, epoch uint64) error {
 	// Create STR with the `ad` that was used in the prev. Set()
 	// operation.
-	pad.signTreeRoot(epoch)
-	pad.snapshots[epoch] = pad.latestSTR
+	str, err := pad.signTreeRoot(epoch)
+	if err != nil {
+		return err
+	}
+	// delete older str(s) as needed
+	if len(pad.loadedEpochs) == cap(pad.loadedEpochs) {
+		n := cap(pad.loadedEpochs) / 2
+		for i := 0; i < n; i++ {
+			delete(pad.snapshots, pad.loadedEpochs[i])
+		}
+		pad.loadedEpochs = append(pad.loadedEpochs[:0], pad.loadedEpochs[n:]...)
+	}
+	pad.latestSTR = str
+	pad.snapshots[epoch] = str
 	pad.loadedEpochs = append(pad.loadedEpochs, epoch)
 	if ad != nil { // update the `ad` if necessary
 		pad.ad = ad
 	}
+	return nil
 }
 
 // Update generates a new snapshot of the tree.
@@ -87,16 +149,52 @@ func (pad *PAD) updateInternal(ad AssocData, epoch uint64) {
 // a new signed tree root. It may remove some older signed tree roots from
 // memory if the cached PAD snapshots exceeded the maximum capacity.
 // ad should be nil if the PAD's associated data ad do not change.
-func (pad *PAD) Update(ad AssocData) {
-	// delete older str(s) as needed
-	if len(pad.loadedEpochs) == cap(pad.loadedEpochs) {
-		n := cap(pad.loadedEpochs) / 2
-		for i := 0; i < n; i++ {
-			delete(pad.snapshots, pad.loadedEpochs[i])
-		}
-		pad.loadedEpochs = append(pad.loadedEpochs[:0], pad.loadedEpochs[n:]...)
+// Update is atomic: if the new signed tree root can't be issued
+// (e.g. ErrSTRSigning), it returns the error and leaves the PAD's
+// snapshots and associated data unchanged, so the bindings set
+// since the last update will be included in the next snapshot.
+func (pad *PAD) Update(ad AssocData) error {
+	return pad.updateInternal(ad, pad.latestSTR.Epoch+1)
+}
+
+// SetClock makes the PAD include the time returned by clock in each
+// signed tree root it issues from now on (see SignedTreeRoot.Timestamp).
+// A nil clock, the default, disables timestamps.
+func (pad *PAD) SetClock(clock func() time.Time) {
+	pad.clock = clock
+}
+
+// SetSigner makes the PAD sign the signed tree roots it issues from
+// now on using signer (see NewPADWithSigner()).
+func (pad *PAD) SetSigner(signer sign.Signer) {
+	pad.signer = signer
+}
+
+// UpdateAnnounced is like Update but issues the new signed tree root
+// with the associated data ad (e.g. to announce a change of the
+// associated data) rather than the PAD's current associated data.
+// The associated data nextAd replaces ad for the following signed
+// tree roots, as if it was passed to Update().
+// UpdateAnnounced is atomic like Update.
+func (pad *PAD) UpdateAnnounced(ad, nextAd AssocData) error {
+	oldAd := pad.ad
+	pad.ad = ad
+	if err := pad.updateInternal(nextAd, pad.latestSTR.Epoch+1); err != nil {
+		pad.ad = oldAd
+		return err
+	}
+	return nil
+}
+
+// Stage returns a function which rolls the PAD's tree back to the
+// bindings set when Stage() was called, e.g. to discard bindings which
+// must only be included in the next snapshot if it can be issued.
+// Stage() copies the tree, so it takes time linear in the tree's size.
+func (pad *PAD) Stage() (rollback func()) {
+	tree := pad.tree.Clone()
+	return func() {
+		pad.tree = tree
 	}
-	pad.updateInternal(ad, pad.latestSTR.Epoch+1)
 }
 
 // Set computes the private index for the given key using
@@ -124,9 +222,13 @@ func (pad *PAD) LookupInEpoch(key string, epoch uint64) (*AuthenticationPath, er
 	if str == nil {
 		return nil, ErrSTRNotFound
 	}
-	// TODO: If the vrf key is rotated, we'd need to use the key
-	// corresponding to the `epoch` here.  See #120
-	lookupIndex, proof := pad.computePrivateIndex(key, pad.vrfKey)
+	// use the VRF key the snapshot was created with,
+	// since the key may have been rotated since
+	vrfKey := str.vrfKey
+	if vrfKey == nil {
+		vrfKey = pad.vrfKey
+	}
+	lookupIndex, proof := pad.computePrivateIndex(key, vrfKey)
 	ap := str.tree.Get(lookupIndex)
 	ap.VrfProof = proof
 	return ap, nil
@@ -142,14 +244,30 @@ func (pad *PAD) GetSTR(epoch uint64) *SignedTreeRoot {
 	return pad.snapshots[epoch]
 }
 
+// NumBindings returns the number of bindings in the snapshot at the
+// requested epoch. It returns ErrSTRNotFound if the epoch is later
+// than the latest epoch, or if the signed tree root of the epoch has
+// been removed from memory.
+func (pad *PAD) NumBindings(epoch uint64) (int, error) {
+	if epoch > pad.latestSTR.Epoch {
+		return 0, ErrSTRNotFound
+	}
+	str := pad.GetSTR(epoch)
+	if str == nil {
+		return 0, ErrSTRNotFound
+	}
+	return str.tree.Size(), nil
+}
+
 // LatestSTR returns the latest signed tree root of the PAD.
 func (pad *PAD) LatestSTR() *SignedTreeRoot {
 	return pad.latestSTR
 }
 
-// Sign uses the _current_ signing key underlying the PAD to sign msg.
-func (pad *PAD) Sign(msg ...[]byte) []byte {
-	return pad.signKey.Sign(bytes.Join(msg, nil))
+// Sign uses the _current_ signer underlying the PAD to sign msg.
+// It returns the signer's error if msg couldn't be signed.
+func (pad *PAD) Sign(msg ...[]byte) ([]byte, error) {
+	return pad.signer.Sign(bytes.Join(msg, nil))
 }
 
 // Index uses the _current_ VRF private key of the PAD to compute
@@ -159,21 +277,49 @@ func (pad *PAD) Index(key string) []byte {
 	return index
 }
 
+// RotateVRFKey replaces the PAD's VRF private key with vrfKey, and
+// immediately issues a new STR with the associated data ad (which
+// should announce the rotation), whose tree contains all bindings of
+// the pending tree at their private indices under vrfKey.
+// The associated data nextAd replaces ad for the following STRs,
+// as if it was passed to Update().
+// Lookups in the snapshots taken before the rotation still use the
+// VRF key the snapshot was created with.
+// RotateVRFKey is atomic: if the new STR can't be issued, it returns
+// the error and leaves the PAD unchanged.
+func (pad *PAD) RotateVRFKey(vrfKey vrf.PrivateKey, ad, nextAd AssocData) error {
+	oldKey, oldTree, oldAd := pad.vrfKey, pad.tree, pad.ad
+	pad.vrfKey = vrfKey
+	err := pad.reshuffle()
+	if err == nil {
+		pad.ad = ad
+		if err = pad.updateInternal(nextAd, pad.latestSTR.Epoch+1); err == nil {
+			return nil
+		}
+	}
+	pad.vrfKey, pad.tree, pad.ad = oldKey, oldTree, oldAd
+	return err
+}
+
 // reshuffle recomputes indices of keys and store them with their values
 // in new tree with new new position; swaps pad.tree if everything worked
 // out. If there is any error on the way (lack of entropy for randomness)
-// reshuffle will panic
-func (pad *PAD) reshuffle() {
-	newTree, err := NewMerkleTree()
+// reshuffle returns the error and leaves pad.tree unchanged.
+func (pad *PAD) reshuffle() error {
+	newTree, err := newMerkleTree(pad.rand)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	pad.tree.visitLeafNodes(func(n *userLeafNode) {
-		if err := newTree.Set(pad.Index(n.key), n.key, n.value); err != nil {
-			panic(err)
+		if err == nil {
+			err = newTree.Set(pad.Index(n.key), n.key, n.value)
 		}
 	})
+	if err != nil {
+		return err
+	}
 	pad.tree = newTree
+	return nil
 }
 
 func (pad *PAD) computePrivateIndex(key string, vrfKey vrf.PrivateKey) (index, proof []byte) {
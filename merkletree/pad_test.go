@@ -397,3 +397,81 @@ func createPadSimple(N uint64, keyPrefix string, valuePrefix []byte,
 	snapLen uint64) (*PAD, error) {
 	return createPad(N, keyPrefix, valuePrefix, snapLen, nil, nil)
 }
+
+func TestPADUpdateSigningFailure(t *testing.T) {
+	pad, err := NewPAD(TestAd{""}, signKey, vrfKey, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pad.Update(nil)
+	pad.Update(nil)
+	prev := pad.LatestSTR()
+	loaded := append([]uint64(nil), pad.loadedEpochs...)
+
+	pad.signer = sign.KeySigner(signKey[:1])
+	if err := pad.Update(TestAd{"new"}); err != ErrSTRSigning {
+		t.Fatal("Expect", ErrSTRSigning, "got", err)
+	}
+	if pad.LatestSTR() != prev || pad.ad.(TestAd).data != "" {
+		t.Fatal("Expect the latest STR and associated data to be unchanged")
+	}
+	// no snapshots should have been evicted
+	for _, ep := range loaded {
+		if pad.GetSTR(ep).Epoch != ep {
+			t.Fatal("Expect snapshot", ep, "to be kept")
+		}
+	}
+
+	pad.signer = failingSigner{}
+	if err := pad.Update(TestAd{"new"}); err != ErrSTRSigning {
+		t.Fatal("Expect", ErrSTRSigning, "got", err)
+	}
+	if pad.LatestSTR() != prev {
+		t.Fatal("Expect the latest STR to be unchanged")
+	}
+
+	pad.signer = sign.KeySigner(signKey)
+	if err := pad.Update(nil); err != nil {
+		t.Fatal(err)
+	}
+	if str := pad.LatestSTR(); str.Epoch != prev.Epoch+1 || !str.VerifyHashChain(prev) {
+		t.Fatal("Expect the PAD to advance by one epoch")
+	}
+}
+
+func TestPADStage(t *testing.T) {
+	pad, err := NewPAD(TestAd{""}, signKey, vrfKey, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pad.Set("alice", []byte("alice's key")); err != nil {
+		t.Fatal(err)
+	}
+	rollback := pad.Stage()
+	if err := pad.Set("alice", []byte("tombstone")); err != nil {
+		t.Fatal(err)
+	}
+	if err := pad.Set("bob", []byte("bob's key")); err != nil {
+		t.Fatal(err)
+	}
+	rollback()
+	if err := pad.Update(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ap, _ := pad.Lookup("alice")
+	if ap.ProofType() != ProofOfInclusion || !bytes.Equal(ap.Leaf.Value, []byte("alice's key")) {
+		t.Fatal("Expect the binding set before Stage(), got", ap.Leaf.Value)
+	}
+	if ap, _ := pad.Lookup("bob"); ap.ProofType() != ProofOfAbsence {
+		t.Fatal("Expect the binding set after Stage() to be rolled back")
+	}
+}
+
+// failingSigner is a sign.Signer which can't sign anything, e.g. since
+// the HSM holding its key is unreachable.
+type failingSigner struct{}
+
+func (failingSigner) Sign([]byte) ([]byte, error) {
+	return nil, errors.New("signer unavailable")
+}
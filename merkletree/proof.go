@@ -20,6 +20,10 @@ var (
 	// ErrUnequalTreeHashes indicates that the hash computed from the authentication path
 	// and the hash taken from the signed tree root are different.
 	ErrUnequalTreeHashes = errors.New("[merkletree] The hashes computed from the authentication path and the STR are unequal")
+	// ErrMalformedAuthPath indicates that the positions of the omitted
+	// empty siblings of a compressed authentication path are
+	// inconsistent with its sibling hashes.
+	ErrMalformedAuthPath = errors.New("[merkletree] The omitted siblings of the authentication path are inconsistent")
 )
 
 // ProofNode can be a user node or an empty node,
@@ -73,27 +77,130 @@ const (
 // of inclusion or absence of requested index.
 // A proof of inclusion is when the leaf index
 // equals the lookup index.
+//
+// A compressed authentication path (see Compress()) omits the
+// siblings which are empty branches from PrunedTree, and instead marks
+// their depths in the bitmap EmptySiblings (in which the bit for depth 0
+// is the most significant bit of the first byte). The hashes of these
+// siblings are deterministic, and are reconstructed when the root is
+// recomputed from the authentication path.
 type AuthenticationPath struct {
-	TreeNonce   []byte
-	PrunedTree  [][crypto.HashSizeByte]byte
-	LookupIndex []byte
-	VrfProof    []byte
-	Leaf        *ProofNode
-	proofType   ProofType
+	TreeNonce     []byte
+	PrunedTree    [][crypto.HashSizeByte]byte
+	EmptySiblings []byte `json:",omitempty"`
+	LookupIndex   []byte
+	VrfProof      []byte
+	Leaf          *ProofNode
+	proofType     ProofType
+}
+
+// emptySiblingHash returns the hash of the empty branch which is the
+// sibling at the given depth of the node with the index bits indexBits.
+func emptySiblingHash(treeNonce []byte, indexBits []bool, depth int) [crypto.HashSizeByte]byte {
+	prefix := append([]bool(nil), indexBits[:depth]...)
+	prefix = append(prefix, !indexBits[depth])
+	sibling := &ProofNode{
+		Level:   uint32(depth + 1),
+		Index:   utils.ToBytes(prefix),
+		IsEmpty: true,
+	}
+	var hash [crypto.HashSizeByte]byte
+	copy(hash[:], sibling.hash(treeNonce))
+	return hash
+}
+
+// Compress returns a copy of ap which omits the sibling hashes of empty
+// branches, which make up most of the authentication path in a sparse
+// tree. It marks their depths in EmptySiblings instead, so that the
+// omitted hashes can be reconstructed when verifying the copy.
+func (ap *AuthenticationPath) Compress() *AuthenticationPath {
+	c := *ap
+	c.PrunedTree = nil
+	indexBits := utils.ToBits(ap.Leaf.Index)
+	empty := make([]bool, len(ap.PrunedTree))
+	for depth, hash := range ap.PrunedTree {
+		if hash == emptySiblingHash(ap.TreeNonce, indexBits, depth) {
+			empty[depth] = true
+		} else {
+			c.PrunedTree = append(c.PrunedTree, hash)
+		}
+	}
+	c.EmptySiblings = utils.ToBytes(empty)
+	return &c
 }
 
-func (ap *AuthenticationPath) authPathHash() []byte {
+// siblings returns the sibling hashes of ap for all depths from the
+// root to ap's leaf, reconstructing the empty siblings omitted from a
+// compressed authentication path. It returns ErrMalformedAuthPath if
+// ap doesn't include the right number of sibling hashes for its leaf's
+// level, or marks an empty sibling below its leaf.
+func (ap *AuthenticationPath) siblings() ([][crypto.HashSizeByte]byte, error) {
+	level := int(ap.Leaf.Level)
+	indexBits := utils.ToBits(ap.Leaf.Index)
+	if len(indexBits) < level {
+		return nil, ErrMalformedAuthPath
+	}
+	if ap.EmptySiblings == nil {
+		if len(ap.PrunedTree) < level {
+			return nil, ErrMalformedAuthPath
+		}
+		return ap.PrunedTree, nil
+	}
+	empty := utils.ToBits(ap.EmptySiblings)
+	if len(empty) < level {
+		return nil, ErrMalformedAuthPath
+	}
+	siblings := make([][crypto.HashSizeByte]byte, level)
+	next := 0
+	for depth, isEmpty := range empty {
+		switch {
+		case depth >= level && isEmpty:
+			return nil, ErrMalformedAuthPath
+		case depth >= level:
+		case isEmpty:
+			siblings[depth] = emptySiblingHash(ap.TreeNonce, indexBits, depth)
+		case next == len(ap.PrunedTree):
+			return nil, ErrMalformedAuthPath
+		default:
+			siblings[depth] = ap.PrunedTree[next]
+			next++
+		}
+	}
+	if next != len(ap.PrunedTree) {
+		return nil, ErrMalformedAuthPath
+	}
+	return siblings, nil
+}
+
+func (ap *AuthenticationPath) authPathHash() ([]byte, error) {
+	siblings, err := ap.siblings()
+	if err != nil {
+		return nil, err
+	}
 	hash := ap.Leaf.hash(ap.TreeNonce)
 	indexBits := utils.ToBits(ap.Leaf.Index)
 	depth := ap.Leaf.Level
 	for depth > 0 {
 		depth -= 1
 		if indexBits[depth] { // right child
-			hash = crypto.Digest(ap.PrunedTree[depth][:], hash)
+			hash = crypto.Digest(siblings[depth][:], hash)
 		} else {
-			hash = crypto.Digest(hash, ap.PrunedTree[depth][:])
+			hash = crypto.Digest(hash, siblings[depth][:])
 		}
 	}
+	return hash, nil
+}
+
+// RootHash recomputes the tree's root node from ap, without verifying
+// ap's leaf. This is useful to report which root a forged or stale
+// ap commits to; use Verify() to check ap against a tree hash.
+// RootHash returns nil if ap doesn't include enough sibling hashes
+// for its leaf's level.
+func (ap *AuthenticationPath) RootHash() []byte {
+	if ap.Leaf == nil {
+		return nil
+	}
+	hash, _ := ap.authPathHash()
 	return hash
 }
 
@@ -102,16 +209,66 @@ func (ap *AuthenticationPath) authPathHash() []byte {
 // first l bits with l is the Level of the proof node if ap is
 // a proof of absence. It also verifies the value and
 // the commitment (in case of the proof of inclusion).
-// Finally, it recomputes the tree's root node from ap,
+// Finally, it recomputes the tree's root node from ap (reconstructing
+// any omitted empty siblings, see Compress()),
 // and compares it to treeHash, which is taken from a STR.
+// Verify returns ErrMalformedAuthPath if ap's sibling hashes
+// are inconsistent with its leaf's level.
 // Specifically, treeHash has to come from the STR whose tree returns ap.
 //
 // This should be called after the VRF index is verified successfully.
 func (ap *AuthenticationPath) Verify(key, value, treeHash []byte) error {
+	if err := ap.VerifyBinding(key, value); err != nil {
+		return err
+	}
+	hash, err := ap.authPathHash()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(treeHash, hash) {
+		return ErrUnequalTreeHashes
+	}
+	return nil
+}
+
+// VerifyIndex performs the checks of Verify() which don't require the
+// key: it verifies that ap proves either the presence of a leaf at
+// ap's lookup index, or the absence of any leaf there, in the tree
+// committed to by treeHash. Unlike Verify(), VerifyIndex doesn't verify
+// the value of a proof of inclusion, since only the leaf's commitment,
+// which can't be opened without the key, binds the value to the tree.
+// This allows a verifier which only knows the lookup index of a key to
+// check whether the key is bound in the tree; the caller must check
+// that ap.LookupIndex is the expected index.
+func (ap *AuthenticationPath) VerifyIndex(treeHash []byte) error {
+	if ap.ProofType() == ProofOfAbsence {
+		if err := ap.VerifyBinding(nil, nil); err != nil {
+			return err
+		}
+	}
+	hash, err := ap.authPathHash()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(treeHash, hash) {
+		return ErrUnequalTreeHashes
+	}
+	return nil
+}
+
+// VerifyBinding performs the checks of Verify() which only concern the
+// leaf of ap, i.e. all checks except recomputing the tree's root node.
+// This allows verifying the leaves of several authentication paths
+// whose root is recomputed at once (see MultiProof).
+func (ap *AuthenticationPath) VerifyBinding(key, value []byte) error {
 	if ap.ProofType() == ProofOfAbsence {
 		// Check if i and j match in the first l bits
 		indexBits := utils.ToBits(ap.Leaf.Index)
 		lookupIndexBits := utils.ToBits(ap.LookupIndex)
+		if int(ap.Leaf.Level) > len(indexBits) ||
+			int(ap.Leaf.Level) > len(lookupIndexBits) {
+			return ErrIndicesMismatch
+		}
 		for i := 0; i < int(ap.Leaf.Level); i++ {
 			if indexBits[i] != lookupIndexBits[i] {
 				return ErrIndicesMismatch
@@ -131,10 +288,6 @@ func (ap *AuthenticationPath) Verify(key, value, treeHash []byte) error {
 			return ErrUnverifiableCommitment
 		}
 	}
-
-	if !bytes.Equal(treeHash, ap.authPathHash()) {
-		return ErrUnequalTreeHashes
-	}
 	return nil
 }
 
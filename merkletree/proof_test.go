@@ -148,3 +148,112 @@ func TestProofVerificationErrors(t *testing.T) {
 		t.Error("Expect", ErrIndicesMismatch, "got", err)
 	}
 }
+
+func TestVerifyProofIndex(t *testing.T) {
+	m, tests := setupTestProofs(t)
+
+	for _, tt := range tests {
+		proof := m.Get(tt.index)
+		if err := proof.VerifyIndex(m.hash); err != nil {
+			t.Error("Expect the proof for", tt.key, "to verify, got", err)
+		}
+	}
+
+	// ErrUnequalTreeHashes
+	hash := append([]byte{}, m.hash...)
+	hash[0] += 1
+	if err := m.Get(tests[0].index).VerifyIndex(hash); err != ErrUnequalTreeHashes {
+		t.Error("Expect", ErrUnequalTreeHashes, "got", err)
+	}
+	// ErrIndicesMismatch
+	proof := m.Get(tests[N].index)
+	if proof.ProofType() != ProofOfAbsence {
+		t.Fatal("Expect a proof of absence")
+	}
+	proof.Leaf.Index[0] &= 0x01
+	if err := proof.VerifyIndex(m.hash); err != ErrIndicesMismatch {
+		t.Error("Expect", ErrIndicesMismatch, "got", err)
+	}
+}
+
+func TestCompressedProof(t *testing.T) {
+	m, tests := setupTestProofs(t)
+
+	var full, compressed int
+	for _, tt := range tests {
+		proof := m.Get(tt.index)
+		c := proof.Compress()
+		if err := c.Verify([]byte(tt.key), tt.value, m.hash); err != nil {
+			t.Error("Expect the compressed proof for", tt.key, "to verify, got", err)
+		}
+		if !bytes.Equal(c.RootHash(), proof.RootHash()) {
+			t.Error("Expect the compressed proof to verify to the same root")
+		}
+		full += len(proof.PrunedTree)
+		compressed += len(c.PrunedTree)
+	}
+	if compressed >= full {
+		t.Error("Expect the compressed proofs to be smaller, got",
+			compressed, "siblings, want fewer than", full)
+	}
+}
+
+func TestCompressedProofBadMarkers(t *testing.T) {
+	m, tests := setupTestProofs(t)
+	tt := tests[N] // the absent key shares a prefix with an included key
+	c := m.Get(tt.index).Compress()
+	level := int(c.Leaf.Level)
+	marked := utils.ToBits(c.EmptySiblings)
+	var emptyDepth, siblingDepth = -1, -1
+	for depth := 0; depth < level; depth++ {
+		if marked[depth] {
+			emptyDepth = depth
+		} else {
+			siblingDepth = depth
+		}
+	}
+	if emptyDepth < 0 || siblingDepth < 0 {
+		t.Fatal("Expect both empty and non-empty siblings, got", marked[:level])
+	}
+
+	corrupt := func(f func(bits []bool) []bool) *AuthenticationPath {
+		bad := *c
+		bad.EmptySiblings = utils.ToBytes(f(append([]bool(nil), marked...)))
+		return &bad
+	}
+	for _, tc := range []struct {
+		name string
+		ap   *AuthenticationPath
+		want error
+	}{
+		{"non-empty sibling marked empty",
+			corrupt(func(bits []bool) []bool {
+				bits[siblingDepth] = true
+				return bits
+			}),
+			ErrMalformedAuthPath},
+		{"empty sibling marked non-empty",
+			corrupt(func(bits []bool) []bool {
+				bits[emptyDepth] = false
+				return bits
+			}),
+			ErrMalformedAuthPath},
+		{"swapped markers",
+			corrupt(func(bits []bool) []bool {
+				bits[emptyDepth], bits[siblingDepth] = false, true
+				return bits
+			}),
+			ErrUnequalTreeHashes},
+		{"marker below the leaf",
+			corrupt(func(bits []bool) []bool {
+				return append(bits, false, false, false, false, false, false, false, true)
+			}),
+			ErrMalformedAuthPath},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.ap.Verify([]byte(tt.key), tt.value, m.hash); err != tc.want {
+				t.Error("Expect", tc.want, "got", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package merkletree
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+)
+
+// A Binding is a key-to-value binding stored in a leaf of a PAD's
+// tree, along with the leaf's Commitment to the binding. The
+// commitment's salt is required to reproduce the leaf's hash.
+type Binding struct {
+	Key        string
+	Value      []byte
+	Commitment *crypto.Commit
+}
+
+// A RootMismatchError indicates that the root of a tree rebuilt from a
+// set of bindings (Got) differs from the tree hash of the STR for the
+// given Epoch (Want).
+type RootMismatchError struct {
+	Epoch uint64
+	Want  []byte
+	Got   []byte
+}
+
+// Error returns a human-readable description of the mismatch.
+func (e *RootMismatchError) Error() string {
+	return fmt.Sprintf("[merkletree] Rebuilt tree root %x doesn't match the STR root %x for epoch %d",
+		e.Got, e.Want, e.Epoch)
+}
+
+// Bindings returns all bindings included in the tree of the PAD's
+// latest STR, e.g. to back them up as the authoritative set of
+// bindings from which the tree can be rebuilt (see RebuildFrom()).
+func (pad *PAD) Bindings() []*Binding {
+	var bindings []*Binding
+	pad.latestSTR.tree.visitLeafNodes(func(n *userLeafNode) {
+		bindings = append(bindings, &Binding{
+			Key:   n.key,
+			Value: append([]byte{}, n.value...),
+			Commitment: &crypto.Commit{
+				Salt:  append([]byte{}, n.commitment.Salt...),
+				Value: append([]byte{}, n.commitment.Value...),
+			},
+		})
+	})
+	return bindings
+}
+
+// RebuildFrom reconstructs the tree of the PAD's latest STR from the
+// authoritative set of bindings, e.g. after the PAD's in-memory tree
+// has been corrupted. It returns ErrUnverifiableCommitment if the
+// commitment of any binding doesn't verify, and a *RootMismatchError if
+// the rebuilt tree's root differs from the latest STR's tree hash. In
+// either case, the PAD remains unchanged.
+//
+// Otherwise, RebuildFrom() replaces the latest STR's tree with the
+// rebuilt tree, and the PAD's pending tree (which will be used to
+// create the next STR) with a copy of the rebuilt tree in which the
+// bindings of the keys in pending (i.e. the keys set since the latest
+// update) are carried over from the previous pending tree.
+func (pad *PAD) RebuildFrom(bindings []*Binding, pending []string) error {
+	str := pad.latestSTR
+	m := &MerkleTree{
+		nonce: str.tree.nonce,
+		root:  newInteriorNode(nil, 0, []bool{}),
+		rand:  pad.rand,
+	}
+	for _, b := range bindings {
+		if b == nil || b.Commitment == nil ||
+			!b.Commitment.Verify([]byte(b.Key), b.Value) {
+			return ErrUnverifiableCommitment
+		}
+		index := pad.Index(b.Key)
+		m.insertNode(index, &userLeafNode{
+			key:   b.Key,
+			value: append([]byte{}, b.Value...),
+			index: index,
+			commitment: &crypto.Commit{
+				Salt:  append([]byte{}, b.Commitment.Salt...),
+				Value: append([]byte{}, b.Commitment.Value...),
+			},
+		})
+	}
+	m.recomputeHash()
+	if !bytes.Equal(m.hash, str.TreeHash) {
+		return &RootMismatchError{Epoch: str.Epoch, Want: str.TreeHash, Got: m.hash}
+	}
+
+	isPending := make(map[string]bool, len(pending))
+	for _, key := range pending {
+		isPending[key] = true
+	}
+	tree := m.Clone()
+	pad.tree.visitLeafNodes(func(n *userLeafNode) {
+		if isPending[n.key] {
+			tree.insertNode(n.index, n.clone(nil).(*userLeafNode))
+		}
+	})
+	str.tree = m
+	pad.tree = tree
+	return nil
+}
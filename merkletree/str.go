@@ -5,6 +5,7 @@ import (
 
 	"github.com/coniks-sys/coniks-go/crypto"
 	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
 	"github.com/coniks-sys/coniks-go/utils"
 )
 
@@ -13,6 +14,25 @@ type AssocData interface {
 	Serialize() []byte
 }
 
+// HasherSelector can be implemented by an AssocData to select the
+// hash function used to link the STRs of a PAD (see crypto.Hasher).
+// A nil Hasher, or AssocData not implementing HasherSelector,
+// selects crypto.DefaultHasher.
+// The Merkle tree itself always uses crypto.Digest.
+type HasherSelector interface {
+	Hasher() crypto.Hasher
+}
+
+// strHasher returns the hash function selected by ad.
+func strHasher(ad AssocData) crypto.Hasher {
+	if s, ok := ad.(HasherSelector); ok {
+		if h := s.Hasher(); h != nil {
+			return h
+		}
+	}
+	return crypto.DefaultHasher
+}
+
 // SignedTreeRoot represents a signed tree root (STR), which is generated
 // at the beginning of every epoch.
 // Signed tree roots contain the current root node,
@@ -20,12 +40,17 @@ type AssocData interface {
 // previous STR, its signature, and developer-specified associated data.
 // The epoch number is a counter from 0, and increases by 1
 // when a new signed tree root is issued by the PAD.
+// Timestamp is the time at which the PAD issued the signed tree root
+// in seconds since the Unix epoch, or 0 if the PAD doesn't timestamp
+// its signed tree roots (see PAD.SetClock()).
 type SignedTreeRoot struct {
 	tree            *MerkleTree
+	vrfKey          vrf.PrivateKey // the VRF key of tree's indices
 	TreeHash        []byte
 	Epoch           uint64
 	PreviousEpoch   uint64
 	PreviousSTRHash []byte
+	Timestamp       uint64 `json:",omitempty"`
 	Signature       []byte
 	Ad              AssocData `json:"-"`
 }
@@ -34,6 +59,16 @@ type SignedTreeRoot struct {
 // associated data, MerkleTree, epoch, previous STR hash, and
 // digitally signs the STR using the given signing key.
 func NewSTR(key sign.PrivateKey, ad AssocData, m *MerkleTree, epoch uint64, prevHash []byte) *SignedTreeRoot {
+	// signing with an in-memory key never fails
+	str, _ := newSTR(sign.KeySigner(key), ad, m, epoch, prevHash, 0)
+	return str
+}
+
+// newSTR is like NewSTR but signs the STR using signer and also includes
+// the given timestamp in the signed tree root. It returns the signer's
+// error if the STR couldn't be signed.
+func newSTR(signer sign.Signer, ad AssocData, m *MerkleTree, epoch uint64,
+	prevHash []byte, timestamp uint64) (*SignedTreeRoot, error) {
 	prevEpoch := epoch - 1
 	if epoch == 0 {
 		prevEpoch = 0
@@ -44,11 +79,16 @@ func NewSTR(key sign.PrivateKey, ad AssocData, m *MerkleTree, epoch uint64, prev
 		Epoch:           epoch,
 		PreviousEpoch:   prevEpoch,
 		PreviousSTRHash: prevHash,
+		Timestamp:       timestamp,
 		Ad:              ad,
 	}
 	bytesPreSig := str.Serialize()
-	str.Signature = key.Sign(bytesPreSig)
-	return str
+	sig, err := signer.Sign(bytesPreSig)
+	if err != nil {
+		return nil, err
+	}
+	str.Signature = sig
+	return str, nil
 }
 
 // Serialize serializes the signed tree root
@@ -63,6 +103,8 @@ func (str *SignedTreeRoot) Serialize() []byte {
 
 // SerializeInternal serializes the signed tree root into
 // a specified format.
+// The timestamp is only serialized if it is set, so that signed tree
+// roots issued without timestamps still verify.
 func (str *SignedTreeRoot) SerializeInternal() []byte {
 	var strBytes []byte
 	strBytes = append(strBytes, utils.ULongToBytes(str.Epoch)...) // t - epoch number
@@ -71,6 +113,9 @@ func (str *SignedTreeRoot) SerializeInternal() []byte {
 	}
 	strBytes = append(strBytes, str.TreeHash...)        // root
 	strBytes = append(strBytes, str.PreviousSTRHash...) // previous STR hash
+	if str.Timestamp != 0 {
+		strBytes = append(strBytes, utils.ULongToBytes(str.Timestamp)...) // issue time
+	}
 	return strBytes
 }
 
@@ -78,8 +123,16 @@ func (str *SignedTreeRoot) SerializeInternal() []byte {
 // and compares it to the hash of previous STR included
 // in the issued STR. The hash chain is valid if
 // these two hash values are equal and consecutive.
+// The hash function is the one selected by str.Ad
+// (see HasherSelector).
 func (str *SignedTreeRoot) VerifyHashChain(savedSTR *SignedTreeRoot) bool {
-	hash := crypto.Digest(savedSTR.Signature)
+	return str.VerifyHashChainWith(strHasher(str.Ad), savedSTR)
+}
+
+// VerifyHashChainWith is like VerifyHashChain but uses the
+// passed hash function h.
+func (str *SignedTreeRoot) VerifyHashChainWith(h crypto.Hasher, savedSTR *SignedTreeRoot) bool {
+	hash := h.Digest(savedSTR.Signature)
 	return str.PreviousEpoch == savedSTR.Epoch &&
 		str.Epoch == savedSTR.Epoch+1 &&
 		bytes.Equal(hash, str.PreviousSTRHash)
@@ -13,7 +13,7 @@ func TestVerifyHashChain(t *testing.T) {
 	}
 
 	savedSTR := pad.LatestSTR()
-	pk, _ := pad.signKey.Public()
+	pk, _ := staticSigningKey.Public()
 
 	for i := uint64(1); i < N; i++ {
 		key := keyPrefix + string(i)
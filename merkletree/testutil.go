@@ -1,9 +1,11 @@
 package merkletree
 
 import (
+	"math/rand"
 	"testing"
 
 	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
 )
 
 var keyPrefix = "key"
@@ -18,12 +20,30 @@ func StaticPAD(t *testing.T, ad AssocData) *PAD {
 	if err != nil {
 		t.Fatal(err)
 	}
-	str := NewSTR(pad.signKey, pad.ad, staticTree(t), 0, []byte{})
+	str := NewSTR(staticSigningKey, pad.ad, staticTree(t), 0, []byte{})
 	pad.latestSTR = str
 	pad.snapshots[0] = pad.latestSTR
 	return pad
 }
 
+// SeededPAD returns a pad for _tests_ whose randomness is drawn from a
+// PRNG seeded with seed, so that the same sequence of operations on two
+// pads created with the same seed produces byte-identical STRs.
+func SeededPAD(t *testing.T, ad AssocData, seed int64) *PAD {
+	rnd := rand.New(rand.NewSource(seed))
+	pad, err := newPAD(ad, sign.KeySigner(staticSigningKey), staticVRFKey, 10, rnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pad
+}
+
+// SetSignKey replaces the signing key of pad with key for _tests_,
+// e.g. to make the next Update() fail with a malformed key.
+func SetSignKey(t *testing.T, pad *PAD, key sign.PrivateKey) {
+	pad.signer = sign.KeySigner(key)
+}
+
 func staticTree(t *testing.T) *MerkleTree {
 	m, err := NewMerkleTree()
 	if err != nil {
@@ -40,3 +60,33 @@ func newEmptyTreeForTest(t *testing.T) *MerkleTree {
 	}
 	return m
 }
+
+// ForkPAD returns a copy of pad whose history is identical to the
+// history of pad up to and including the given epoch, for _tests_.
+// Updating either PAD afterwards doesn't affect the other PAD, so the
+// histories of the two PADs diverge after epoch if they are updated
+// differently.
+func ForkPAD(t *testing.T, pad *PAD, epoch uint64) *PAD {
+	str := pad.GetSTR(epoch)
+	if str == nil || str.Epoch != epoch {
+		t.Fatal(ErrSTRNotFound)
+	}
+	fork := &PAD{
+		signer:       pad.signer,
+		vrfKey:       pad.vrfKey,
+		rand:         pad.rand,
+		clock:        pad.clock,
+		tree:         str.tree.Clone(),
+		snapshots:    make(map[uint64]*SignedTreeRoot, cap(pad.loadedEpochs)),
+		loadedEpochs: make([]uint64, 0, cap(pad.loadedEpochs)),
+		latestSTR:    str,
+		ad:           str.Ad,
+	}
+	for _, ep := range pad.loadedEpochs {
+		if ep <= epoch {
+			fork.snapshots[ep] = pad.snapshots[ep]
+			fork.loadedEpochs = append(fork.loadedEpochs, ep)
+		}
+	}
+	return fork
+}
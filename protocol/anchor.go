@@ -0,0 +1,46 @@
+// Implements the digests of STRs which a directory posts to an external
+// transparency anchor, e.g. a blockchain or a public log, so that
+// clients can check that the anchored value is the STR they hold.
+
+package protocol
+
+import (
+	"bytes"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// anchorPrefix separates the anchor digests of STRs from any other
+// digest of an STR, e.g. its hash (see DirSTR.Hash()).
+var anchorPrefix = []byte("coniks-str-anchor")
+
+// AnchorDigest returns the canonical digest of str to post to an
+// external anchor: the hash, under the default hash function (see
+// crypto.Digest()), of str's serialization, prefixed with its length,
+// and its signature. Unlike str.Hash(), the digest doesn't depend on the hash
+// function declared in str's policies, so that anyone can recompute it
+// from str alone.
+func AnchorDigest(str *DirSTR) []byte {
+	var bs []byte
+	bs = append(bs, anchorPrefix...)
+	ser := str.Serialize()
+	bs = append(bs, utils.ULongToBytes(uint64(len(ser)))...)
+	bs = append(bs, ser...)
+	return crypto.Digest(bs, str.Signature)
+}
+
+// VerifyAnchor verifies that the anchored digest is the anchor digest
+// of str (see AnchorDigest()). It returns ErrMalformedMessage if str is
+// nil, or CheckBadSTR if digest isn't the digest of str.
+// VerifyAnchor() doesn't verify str itself, i.e. its signature or its
+// place in the directory's hash chain.
+func VerifyAnchor(digest []byte, str *DirSTR) error {
+	if str == nil || str.SignedTreeRoot == nil || str.Policies == nil {
+		return ErrMalformedMessage
+	}
+	if !bytes.Equal(digest, AnchorDigest(str)) {
+		return CheckBadSTR
+	}
+	return nil
+}
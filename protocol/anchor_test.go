@@ -0,0 +1,60 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+)
+
+func TestAnchorDigest(t *testing.T) {
+	strs := newTestHistory(t, 4)
+	digest := AnchorDigest(strs[2])
+	if len(digest) != crypto.HashSizeByte {
+		t.Fatal("Expect a digest of", crypto.HashSizeByte, "bytes, got", len(digest))
+	}
+	// the digest only depends on the STR
+	if !bytes.Equal(digest, AnchorDigest(strs[2])) ||
+		!bytes.Equal(digest, AnchorDigest(NewDirSTR(strs[2].SignedTreeRoot))) {
+		t.Fatal("Expect the digest to be stable")
+	}
+	if bytes.Equal(digest, strs[2].Hash()) {
+		t.Error("Expect the digest to differ from the STR's hash")
+	}
+}
+
+func TestVerifyAnchor(t *testing.T) {
+	strs := newTestHistory(t, 4)
+	digest := AnchorDigest(strs[2])
+	if err := VerifyAnchor(digest, strs[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	// the digest doesn't verify against any other STR
+	for i, str := range strs {
+		if i == 2 {
+			continue
+		}
+		if err := VerifyAnchor(digest, str); err != CheckBadSTR {
+			t.Error("Expect", CheckBadSTR, "for epoch", i, "got", err)
+		}
+	}
+	forged := *strs[2].SignedTreeRoot
+	forged.TreeHash = append([]byte{}, forged.TreeHash...)
+	forged.TreeHash[0] ^= 1
+	if err := VerifyAnchor(digest, NewDirSTR(&forged)); err != CheckBadSTR {
+		t.Error("Expect", CheckBadSTR, "got", err)
+	}
+	forged = *strs[2].SignedTreeRoot
+	forged.Signature = append([]byte{}, forged.Signature...)
+	forged.Signature[0] ^= 1
+	if err := VerifyAnchor(digest, NewDirSTR(&forged)); err != CheckBadSTR {
+		t.Error("Expect", CheckBadSTR, "got", err)
+	}
+	if err := VerifyAnchor(digest[1:], strs[2]); err != CheckBadSTR {
+		t.Error("Expect", CheckBadSTR, "got", err)
+	}
+	if err := VerifyAnchor(digest, nil); err != ErrMalformedMessage {
+		t.Error("Expect", ErrMalformedMessage, "got", err)
+	}
+}
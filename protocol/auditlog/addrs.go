@@ -0,0 +1,86 @@
+// This module implements recording the addresses at which a directory
+// is reachable, e.g. its mirrors, so that clients discovering the
+// directory through the auditor learn all of its endpoints.
+
+package auditlog
+
+import (
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+// addAddress adds addr to the known addresses of h, unless addr is
+// empty or known already.
+func (h *directoryHistory) addAddress(addr string) {
+	if addr == "" {
+		return
+	}
+	for _, a := range h.addrs {
+		if a == addr {
+			return
+		}
+	}
+	h.addrs = append(h.addrs, addr)
+}
+
+// removeAddress removes addr from the known addresses of h, if any.
+func (h *directoryHistory) removeAddress(addr string) {
+	for i, a := range h.addrs {
+		if a == addr {
+			h.addrs = append(h.addrs[:i:i], h.addrs[i+1:]...)
+			return
+		}
+	}
+}
+
+// Addresses returns the known addresses of the CONIKS directory
+// identified by dirInitHash, starting with the address the directory's
+// history was created with (see InitHistory()), followed by the ones
+// added with AddAddress() in the order in which they were added.
+// The directory's identity doesn't depend on its addresses.
+// Addresses() returns auditor.ErrUnknownDirectory if the auditor
+// doesn't have a history for the directory.
+func (l ConiksAuditLog) Addresses(dirInitHash [crypto.HashSizeByte]byte) ([]string, error) {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return nil, auditor.ErrUnknownDirectory
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.addrs...), nil
+}
+
+// AddAddress records that the CONIKS directory identified by
+// dirInitHash is also reachable at addr, e.g. at a mirror.
+// Adding an empty or known address is a no-op.
+// AddAddress() returns auditor.ErrUnknownDirectory if the auditor
+// doesn't have a history for the directory.
+func (l ConiksAuditLog) AddAddress(dirInitHash [crypto.HashSizeByte]byte,
+	addr string) error {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return auditor.ErrUnknownDirectory
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.addAddress(addr)
+	return nil
+}
+
+// RemoveAddress removes addr from the known addresses of the CONIKS
+// directory identified by dirInitHash, e.g. once a mirror has been
+// decommissioned. Removing an unknown address is a no-op; the
+// directory's history is kept even if it has no known address left.
+// RemoveAddress() returns auditor.ErrUnknownDirectory if the auditor
+// doesn't have a history for the directory.
+func (l ConiksAuditLog) RemoveAddress(dirInitHash [crypto.HashSizeByte]byte,
+	addr string) error {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return auditor.ErrUnknownDirectory
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removeAddress(addr)
+	return nil
+}
@@ -0,0 +1,67 @@
+package auditlog
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+func TestDirectoryAddresses(t *testing.T) {
+	_, aud, hist := NewTestAuditLog(t, 1)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	for _, addr := range []string{"mirror-1", "mirror-2", "mirror-1", ""} {
+		if err := aud.AddAddress(dirInitHash, addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"test-server", "mirror-1", "mirror-2"}
+	addrs, err := aud.Addresses(dirInitHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(addrs, want) {
+		t.Fatal("Expect addresses", want, "got", addrs)
+	}
+	// the returned addresses are a copy
+	addrs[0] = "evil"
+	if addrs, _ := aud.Addresses(dirInitHash); addrs[0] != "test-server" {
+		t.Fatal("Expect the known addresses to be unaffected by callers")
+	}
+	snapshot := aud.Snapshot()
+
+	if err := aud.RemoveAddress(dirInitHash, "test-server"); err != nil {
+		t.Fatal(err)
+	}
+	if err := aud.RemoveAddress(dirInitHash, "unknown"); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"mirror-1", "mirror-2"}
+	if addrs, _ := aud.Addresses(dirInitHash); !reflect.DeepEqual(addrs, want) {
+		t.Fatal("Expect addresses", want, "got", addrs)
+	}
+	if addrs, _ := snapshot.Addresses(dirInitHash); len(addrs) != 3 {
+		t.Error("Expect the snapshot's addresses to be unchanged, got", addrs)
+	}
+
+	// the directory is still identified by its initial STR
+	if dirs := aud.Directories(); len(dirs) != 1 || dirs[0] != dirInitHash {
+		t.Fatal("Expect the directory's identity to be unchanged")
+	}
+}
+
+func TestDirectoryAddressesUnknownDirectory(t *testing.T) {
+	aud := New()
+	var unknown [crypto.HashSizeByte]byte
+	if _, err := aud.Addresses(unknown); err != auditor.ErrUnknownDirectory {
+		t.Error("Expect", auditor.ErrUnknownDirectory, "got", err)
+	}
+	if err := aud.AddAddress(unknown, "mirror"); err != auditor.ErrUnknownDirectory {
+		t.Error("Expect", auditor.ErrUnknownDirectory, "got", err)
+	}
+	if err := aud.RemoveAddress(unknown, "mirror"); err != auditor.ErrUnknownDirectory {
+		t.Error("Expect", auditor.ErrUnknownDirectory, "got", err)
+	}
+}
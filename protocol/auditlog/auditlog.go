@@ -6,6 +6,13 @@
 package auditlog
 
 import (
+	"bytes"
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
 	"github.com/coniks-sys/coniks-go/crypto"
 	"github.com/coniks-sys/coniks-go/crypto/sign"
 	"github.com/coniks-sys/coniks-go/protocol"
@@ -13,19 +20,53 @@ import (
 )
 
 type directoryHistory struct {
+	// serializes the audits and reads of this history, so that
+	// distinct directories can be audited concurrently (see AuditAll())
+	mu sync.Mutex
 	*auditor.AudState
-	addr      string
-	snapshots map[uint64]*protocol.DirSTR
+	// the directory's identity, which never changes after its initial
+	// STR (see auditor.ComputeDirectoryIdentity())
+	id [crypto.HashSizeByte]byte
+	// the known addresses of the directory, in the order in which
+	// they were added (see AddAddress())
+	addrs      []string
+	snapshots  map[uint64]*protocol.DirSTR
+	observedAt map[uint64]time.Time
+	// the first equivocation detected for this directory, if any
+	equivocation *auditor.EquivocationProof
+	// set if auditing this directory failed unexpectedly,
+	// in which case its state can't be trusted anymore
+	quarantined bool
+	// authenticates pushes of STRs for this directory, if set
+	// (see AuditPush())
+	ingestAuth IngestAuth
+	// future STRs received ahead of the gap before them, indexed by
+	// epoch, and the maximum number of such STRs (see SetReorderBuffer())
+	pending    map[uint64]*protocol.DirSTR
+	maxPending int
+	// the interval at which the directory is expected to publish
+	// new STRs, if configured (see InitHistoryWithCadence())
+	epochInterval time.Duration
+	// whether to keep the STRs diverging from the canonical history,
+	// and the branches they form (see SetQuarantineForks())
+	keepForks bool
+	branches  []*QuarantinedBranch
 }
 
+// now returns the current time; tests may replace it with a fake clock.
+var now = time.Now
+
 // A ConiksAuditLog maintains the histories
 // of all CONIKS directories known to a CONIKS auditor,
 // indexing the histories by the hash of a directory's initial
 // STR (specifically, the hash of the STR's signature).
-// Each history includes the directory's domain addr as a string, its
-// public signing key enabling the auditor to verify the corresponding
-// signed tree roots, and a list with all observed snapshots in
-// chronological order.
+// Each history includes the directory's known addresses (see
+// AddAddress()), its public signing key enabling the auditor to verify
+// the corresponding signed tree roots, and a list with all observed
+// snapshots in chronological order.
+// Each history is locked while it is audited or read, so the methods
+// of a ConiksAuditLog may be called concurrently, except for the ones
+// adding or removing directories, e.g. InitHistory() or RePin().
 type ConiksAuditLog map[[crypto.HashSizeByte]byte]*directoryHistory
 
 // caller validates that initSTR is for epoch 0.
@@ -34,19 +75,25 @@ func newDirectoryHistory(addr string,
 	initSTR *protocol.DirSTR) *directoryHistory {
 	a := auditor.New(signKey, initSTR)
 	h := &directoryHistory{
-		AudState:  a,
-		addr:      addr,
-		snapshots: make(map[uint64]*protocol.DirSTR),
+		AudState:   a,
+		id:         auditor.ComputeDirectoryIdentity(initSTR),
+		snapshots:  make(map[uint64]*protocol.DirSTR),
+		observedAt: make(map[uint64]time.Time),
 	}
+	h.addAddress(addr)
 	h.updateVerifiedSTR(initSTR)
 	return h
 }
 
 // updateVerifiedSTR inserts the latest verified STR into a directory
 // history; assumes the STRs have been validated by the caller.
+// It also records the time at which the auditor first observed the STR.
 func (h *directoryHistory) updateVerifiedSTR(newVerified *protocol.DirSTR) {
 	h.Update(newVerified)
 	h.snapshots[newVerified.Epoch] = newVerified
+	if _, ok := h.observedAt[newVerified.Epoch]; !ok {
+		h.observedAt[newVerified.Epoch] = now()
+	}
 }
 
 // insertRange inserts the given range of STRs snaps
@@ -58,38 +105,205 @@ func (h *directoryHistory) insertRange(snaps []*protocol.DirSTR) {
 	}
 }
 
+// epochOf returns the epoch of the observed STR whose hash
+// (see protocol.DirSTR.Hash()) is strHash, if there is one.
+func (h *directoryHistory) epochOf(strHash []byte) (uint64, bool) {
+	for ep, str := range h.snapshots {
+		if bytes.Equal(str.Hash(), strHash) {
+			return ep, true
+		}
+	}
+	return 0, false
+}
+
+// ForEachSnapshot calls f for each observed STR in h in chronological
+// order, until f returns false.
+// ForEachSnapshot() only holds h's lock while it takes a copy of the
+// list of observed STRs before calling f, so STRs inserted into h in
+// the meantime (e.g. by f itself) aren't visited.
+func (h *directoryHistory) ForEachSnapshot(f func(*protocol.DirSTR) bool) {
+	h.mu.Lock()
+	strs := h.observedSTRs()
+	h.mu.Unlock()
+	for _, str := range strs {
+		if !f(str) {
+			return
+		}
+	}
+}
+
+// observedSTRs returns the observed STRs of h in chronological order.
+// The caller must hold h's lock.
+func (h *directoryHistory) observedSTRs() []*protocol.DirSTR {
+	strs := make([]*protocol.DirSTR, 0, len(h.snapshots))
+	for _, str := range h.snapshots {
+		strs = append(strs, str)
+	}
+	sort.Slice(strs, func(i, j int) bool { return strs[i].Epoch < strs[j].Epoch })
+	return strs
+}
+
 // Audit checks that a directory's STR history
 // is linear and updates the auditor's state
 // if the checks pass.
-// Audit() first checks the oldest STR in the
-// STR range received in message against the h.verfiedSTR,
-// and then verifies the remaining STRs in msg, and
-// finally updates the snapshots if the checks pass.
+// Audit() first deduplicates the STRs in the range received in msg
+// that overlap with the already observed snapshots, making sure they
+// are identical to the snapshots. It then checks the oldest remaining STR
+// against the h.verfiedSTR, and then verifies the remaining STRs in msg,
+// and finally updates the snapshots if the checks pass.
+// Audit() returns auditor.ErrRangeGap if the non-overlapping part of
+// the range doesn't start at the epoch following h.verifiedSTR, unless
+// h buffers STRs received out of order (see SetReorderBuffer()).
 // Audit() is called when an auditor receives new STRs
 // from a specific directory.
+//
+// Audit() is idempotent, so a range can safely be re-delivered, e.g.
+// after a transient failure of the auditor's ingest: a range whose
+// STRs have all been observed already is a no-op which returns nil
+// and leaves h (including the observation times of its STRs)
+// unchanged. A re-delivered STR that differs from the observed STR of
+// the same epoch still causes Audit() to return CheckBadSTR. An empty
+// range, however, isn't a no-op: Audit() rejects it with
+// ErrMalformedMessage.
 func (h *directoryHistory) Audit(msg *protocol.Response) error {
-	if err := msg.Validate(); err != nil {
+	return h.AuditContext(context.Background(), msg)
+}
+
+// AuditContext is like Audit but checks ctx between the STRs it verifies.
+// If ctx is done before the entire range has been verified,
+// AuditContext() returns ctx.Err() and leaves h unchanged.
+//
+// If auditing panics, e.g. because of a malformed STR that slipped past
+// validation, AuditContext() recovers and quarantines h, so that the
+// failure only affects this directory: AuditContext() then returns
+// auditor.ErrQuarantined, as do all subsequent audits of h.
+func (h *directoryHistory) AuditContext(ctx context.Context, msg *protocol.Response) (err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.quarantined {
+		return auditor.ErrQuarantined
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			h.quarantined = true
+			err = auditor.ErrQuarantined
+		}
+	}()
+	return h.auditContext(ctx, msg)
+}
+
+func (h *directoryHistory) auditContext(ctx context.Context, msg *protocol.Response) error {
+	if err := msg.ValidateFor(protocol.STRType); err != nil {
 		return err
 	}
 
 	strs := msg.DirectoryResponse.(*protocol.STRHistoryRange)
+	// an empty range must not pass as a re-delivered range,
+	// which would mask a stalled directory
+	if len(strs.STR) == 0 {
+		return protocol.ErrMalformedMessage
+	}
+
+	// skip the STRs we have already observed
+	newSTRs, err := h.dedup(strs.STR)
+	if err != nil {
+		h.quarantineFork(strs.STR)
+		return err
+	}
+	if len(newSTRs) == 0 {
+		return nil
+	}
+	if newSTRs[0].Epoch != h.VerifiedSTR().Epoch+1 {
+		if h.quarantineFork(newSTRs) {
+			return protocol.CheckBadSTR
+		}
+		if h.maxPending > 0 {
+			return h.buffer(newSTRs)
+		}
+		return auditor.ErrRangeGap
+	}
+	if err := h.auditRange(ctx, newSTRs); err != nil {
+		if err == protocol.CheckBadSTR {
+			h.quarantineFork(newSTRs)
+		}
+		return err
+	}
+	return h.drain(ctx)
+}
 
-	// audit the STRs
-	// if strs.STR is somehow malformed or invalid (e.g. strs.STR
-	// contains old STRs), AuditDirectory() will detect this
+// auditRange audits the range of STRs newSTRs, which starts at the
+// epoch following h.verifiedSTR, and inserts the range into h if the
+// checks pass.
+func (h *directoryHistory) auditRange(ctx context.Context, newSTRs []*protocol.DirSTR) error {
+	// audit the STRs one at a time
+	// if newSTRs is somehow malformed or invalid,
+	// AuditDirectory() will detect this
 	// and throw and error
-	if err := h.AuditDirectory(strs.STR); err != nil {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := h.AuditDirectory(newSTRs[:1]); err != nil {
 		return err
 	}
+	for i := 1; i < len(newSTRs); i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := h.VerifySTRRange(newSTRs[i-1], newSTRs[i:i+1]); err != nil {
+			return err
+		}
+	}
 
-	// TODO: we should be storing inconsistent STRs nonetheless
-	// so clients can detect inconsistencies -- or auditors
-	// should blow the whistle and not store the bad STRs
-	h.insertRange(strs.STR)
+	// inconsistent STRs never make it into the canonical history;
+	// they may be kept in a quarantined branch instead
+	// (see SetQuarantineForks())
+	h.insertRange(newSTRs)
 
 	return nil
 }
 
+// dedup returns the suffix of the given range of STRs snaps that
+// hasn't been observed yet. dedup() returns a CheckBadSTR if any STR in
+// the overlapping prefix differs from the corresponding snapshot in h.
+// If the differing STR is signed by the directory, dedup() also records
+// an auditor.EquivocationProof (see EquivocationProof()).
+func (h *directoryHistory) dedup(snaps []*protocol.DirSTR) ([]*protocol.DirSTR, error) {
+	i := 0
+	for ; i < len(snaps) && snaps[i].Epoch <= h.VerifiedSTR().Epoch; i++ {
+		observed, ok := h.snapshots[snaps[i].Epoch]
+		if !ok || !bytes.Equal(observed.Signature, snaps[i].Signature) ||
+			!bytes.Equal(observed.Serialize(), snaps[i].Serialize()) {
+			if ok {
+				h.recordEquivocation(observed, snaps[i])
+			}
+			return nil, protocol.CheckBadSTR
+		}
+	}
+	return snaps[i:], nil
+}
+
+// recordEquivocation records an auditor.EquivocationProof for the
+// observed STR and the conflicting STR str for the same epoch,
+// unless h already holds a proof or str isn't signed by the directory.
+func (h *directoryHistory) recordEquivocation(observed, str *protocol.DirSTR) {
+	if h.equivocation != nil ||
+		h.VerifySTR(str) != nil ||
+		bytes.Equal(observed.Serialize(), str.Serialize()) {
+		return
+	}
+	h.equivocation = &auditor.EquivocationProof{STR1: observed, STR2: str}
+}
+
+// EquivocationProof returns the auditor.EquivocationProof for the first
+// equivocation the auditor detected for this directory, i.e. two
+// different STRs for the same epoch signed by the directory, and
+// whether such an equivocation has been detected.
+// The proof can be published and checked by anyone
+// (see auditor.VerifyEquivocationProof()).
+func (h *directoryHistory) EquivocationProof() (*auditor.EquivocationProof, bool) {
+	return h.equivocation, h.equivocation != nil
+}
+
 // New constructs a new ConiksAuditLog. It creates an empty
 // log; the auditor will add an entry for each CONIKS directory
 // the first time it observes an STR for that directory.
@@ -121,17 +335,59 @@ func (l ConiksAuditLog) get(dirInitHash [crypto.HashSizeByte]byte) (*directoryHi
 // signing key signKey, and a list of one or more snapshots snaps
 // containing the pinned initial STR as well as the saved directory's
 // STR history so far, in chronological order.
-// InitHistory() returns an ErrAuditLog if the auditor attempts to create
-// a new history for a known directory, and nil otherwise.
+// InitHistory() returns an ErrMalformedMessage if the epochs of snaps
+// aren't exactly 0, 1, ..., len(snaps)-1, i.e. if the snapshots don't
+// start with the initial STR, or include a gap, a duplicate or an
+// out-of-order epoch, or the error of protocol.ValidateGenesisSTR() if
+// the initial STR isn't valid under signKey. It returns an ErrAuditLog
+// if the auditor attempts to create a new history for a known
+// directory, and nil otherwise.
 func (l ConiksAuditLog) InitHistory(addr string, signKey sign.PublicKey,
 	snaps []*protocol.DirSTR) error {
-	// make sure we're getting an initial STR at the very least
-	if len(snaps) < 1 || snaps[0].Epoch != 0 {
+	// make sure we're getting an initial STR at the very least,
+	// followed by the contiguous history
+	if len(snaps) < 1 {
 		return protocol.ErrMalformedMessage
 	}
+	for i, str := range snaps {
+		if str == nil || str.Epoch != uint64(i) {
+			return protocol.ErrMalformedMessage
+		}
+	}
+	if err := protocol.ValidateGenesisSTR(snaps[0], signKey); err != nil {
+		return err
+	}
+	return l.initHistory(addr, signKey, snaps)
+}
+
+// InitHistoryWithCadence creates a new directory history for the key
+// directory addr as InitHistory() does, and records that the directory
+// is expected to publish a new STR every epochInterval.
+// StaleDirectories() then judges the directory against its own cadence
+// rather than against the auditor-wide maximum gap.
+// An epochInterval <= 0 is treated as if no cadence was configured.
+func (l ConiksAuditLog) InitHistoryWithCadence(addr string, signKey sign.PublicKey,
+	snaps []*protocol.DirSTR, epochInterval time.Duration) error {
+	if err := l.InitHistory(addr, signKey, snaps); err != nil {
+		return err
+	}
+	if epochInterval > 0 {
+		h, _ := l.get(auditor.ComputeDirectoryIdentity(snaps[0]))
+		h.epochInterval = epochInterval
+	}
+	return nil
+}
 
+// initHistory inserts a new directory history initialized with snaps
+// into l, as InitHistory() does, but only requires snaps to start with
+// the initial STR.
+func (l ConiksAuditLog) initHistory(addr string, signKey sign.PublicKey,
+	snaps []*protocol.DirSTR) error {
 	// compute the hash of the initial STR
-	dirInitHash := auditor.ComputeDirectoryIdentity(snaps[0])
+	dirInitHash, err := auditor.DirectoryIdentity(snaps[0])
+	if err != nil {
+		return err
+	}
 
 	// error if we want to create a new entry for a directory
 	// we already know
@@ -154,13 +410,244 @@ func (l ConiksAuditLog) InitHistory(addr string, signKey sign.PublicKey,
 	return nil
 }
 
+// InitHistoryVerified creates a new directory history for the key
+// directory addr and inserts it into the audit log l, as InitHistory()
+// does, but without trusting the snapshots snaps (e.g. when restoring
+// the history from an untrusted cache).
+// The snapshots must be in chronological order and start with the
+// directory's initial STR, but may leave gaps in the history.
+// InitHistoryVerified() verifies the signature of every snapshot under
+// signKey, as well as the hash chain between the snapshots of any two
+// consecutive epochs, and rejects the entire set if any check fails.
+// InitHistoryVerified() returns an *auditor.SnapshotError reporting the
+// first bad snapshot, or ErrAuditLog if the auditor already has a
+// history for the directory.
+func (l ConiksAuditLog) InitHistoryVerified(addr string, signKey sign.PublicKey,
+	snaps []*protocol.DirSTR) error {
+	// make sure we're getting an initial STR at the very least
+	if len(snaps) < 1 || snaps[0] == nil || snaps[0].Epoch != 0 {
+		return protocol.ErrMalformedMessage
+	}
+
+	a := auditor.New(signKey, snaps[0])
+	if err := verifySnapshots(a, snaps); err != nil {
+		return err
+	}
+
+	return l.initHistory(addr, signKey, snaps)
+}
+
+// verifySnapshots verifies the signature of every snapshot in snaps
+// under the directory's keys pinned in a, as well as the hash chain
+// between the snapshots of any two consecutive epochs, and returns an
+// *auditor.SnapshotError reporting the first bad snapshot.
+// snaps must be non-empty, and snaps[0] must not be nil.
+func verifySnapshots(a *auditor.AudState, snaps []*protocol.DirSTR) error {
+	for i, str := range snaps {
+		if str == nil {
+			return &auditor.SnapshotError{Epoch: snaps[i-1].Epoch + 1,
+				Err: protocol.ErrMalformedMessage}
+		}
+		if i > 0 && str.Epoch <= snaps[i-1].Epoch {
+			return &auditor.SnapshotError{Epoch: str.Epoch,
+				Err: protocol.ErrMalformedMessage}
+		}
+		if err := a.VerifySTR(str); err != nil {
+			return &auditor.SnapshotError{Epoch: str.Epoch, Err: err}
+		}
+		if i > 0 && str.Epoch == snaps[i-1].Epoch+1 &&
+			!str.VerifyHashChain(snaps[i-1]) {
+			return &auditor.SnapshotError{Epoch: str.Epoch,
+				Err: protocol.CheckBadSTR}
+		}
+	}
+	return nil
+}
+
+// RePin replaces the history of the CONIKS directory identified by
+// dirInitHash with a new history for the key directory newAddr,
+// pinning the new signing key newSignKey and the new initial STR
+// newPinnedSTR. RePin() is meant for operators who rotated a
+// compromised directory signing key out-of-band, and it can't be undone.
+//
+// To make sure the directory's observed history (which may contain
+// evidence of a fork) isn't erased by accident, RePin() requires
+// confirm to be true, records the re-pin as an auditor.TraceRePin
+// event in the old history's trace sink, which the new history
+// inherits, and returns the dropped snapshots in chronological order;
+// the caller is responsible for archiving the snapshots.
+// The old history is quarantined under its lock, so audits which still
+// hold it (e.g. a running AuditStream()) fail with
+// auditor.ErrQuarantined rather than updating a dropped history.
+// RePin() returns auditor.ErrRePinNotConfirmed if confirm is false,
+// auditor.ErrUnknownDirectory if the auditor doesn't have a history for
+// the directory, the error of protocol.ValidateGenesisSTR() if
+// newPinnedSTR isn't a valid initial STR under newSignKey,
+// or ErrAuditLog if the new directory identity is already in the log.
+// The audit log is unchanged if RePin() returns an error.
+func (l ConiksAuditLog) RePin(dirInitHash [crypto.HashSizeByte]byte,
+	newAddr string, newSignKey sign.PublicKey, newPinnedSTR *protocol.DirSTR,
+	confirm bool) ([]*protocol.DirSTR, error) {
+	if !confirm {
+		return nil, auditor.ErrRePinNotConfirmed
+	}
+	old, ok := l.get(dirInitHash)
+	if !ok {
+		return nil, auditor.ErrUnknownDirectory
+	}
+	if err := protocol.ValidateGenesisSTR(newPinnedSTR, newSignKey); err != nil {
+		return nil, err
+	}
+	newInitHash := auditor.ComputeDirectoryIdentity(newPinnedSTR)
+	if _, ok := l.get(newInitHash); ok && newInitHash != dirInitHash {
+		return nil, protocol.ErrAuditLog
+	}
+
+	old.mu.Lock()
+	defer old.mu.Unlock()
+	dropped := old.observedSTRs()
+	old.quarantined = true
+	old.Trace(&auditor.TraceEvent{
+		Step:  auditor.TraceRePin,
+		Epoch: old.VerifiedSTR().Epoch,
+		Want:  dirInitHash[:],
+		Got:   newInitHash[:],
+	})
+
+	h := newDirectoryHistory(newAddr, newSignKey, newPinnedSTR)
+	h.SetTraceSink(old.TraceSink())
+	delete(l, dirInitHash)
+	l.set(newInitHash, h)
+	return dropped, nil
+}
+
+// AuditId audits the range of STRs contained in msg for the CONIKS
+// directory identified by dirInitHash, and updates the directory's
+// history in the audit log l if the checks pass.
+// AuditId() is called when an auditor receives new STRs from a
+// directory it already tracks.
+// AuditId() returns auditor.ErrUnknownDirectory if the auditor doesn't
+// have a history for the directory, or the error returned by Audit()
+// otherwise.
+func (l ConiksAuditLog) AuditId(dirInitHash [crypto.HashSizeByte]byte,
+	msg *protocol.Response) error {
+	return l.AuditIdContext(context.Background(), dirInitHash, msg)
+}
+
+// AuditIdContext is like AuditId but aborts the audit with ctx.Err()
+// if ctx is done before the entire range has been verified.
+func (l ConiksAuditLog) AuditIdContext(ctx context.Context,
+	dirInitHash [crypto.HashSizeByte]byte, msg *protocol.Response) error {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return auditor.ErrUnknownDirectory
+	}
+	return h.AuditContext(ctx, msg)
+}
+
+// A DirectoryClient is used by an auditor to request a CONIKS directory's
+// STR history, e.g. a network client for a remote directory or an
+// in-process directory.ConiksDirectory.
+type DirectoryClient interface {
+	GetSTRHistory(req *protocol.STRHistoryRequest) *protocol.Response
+}
+
+// CatchUp brings the history of the CONIKS directory identified by
+// dirInitHash up to date, e.g. when the auditor notices that it has
+// fallen behind the directory.
+// CatchUp() requests the directory's STRs from the epoch of the
+// latest verified STR up to the directory's latest epoch through dir,
+// and audits the returned range as AuditId() does. Including the latest
+// verified STR in the request ensures that the directory's response is
+// consistent with the history the auditor has already observed.
+// CatchUp() returns auditor.ErrUnknownDirectory if the auditor doesn't
+// have a history for the directory, the error code of the directory's
+// response if the request fails, or the error returned by Audit()
+// otherwise (e.g. auditor.ErrRangeGap if the directory returns a
+// gapped range, or CheckBadSTR if it returns a forked one).
+func (l ConiksAuditLog) CatchUp(dirInitHash [crypto.HashSizeByte]byte,
+	dir DirectoryClient) error {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return auditor.ErrUnknownDirectory
+	}
+	h.mu.Lock()
+	start := h.VerifiedSTR().Epoch
+	h.mu.Unlock()
+	// the directory caps the end of the range at its latest epoch
+	resp := dir.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: start,
+		EndEpoch:   math.MaxUint64})
+	return h.Audit(resp)
+}
+
+// Follow audits each STR received on strs for the CONIKS directory
+// identified by dirInitHash as AuditId() does, until strs is closed,
+// e.g. to audit the STRs pushed by a directory to its subscribers
+// (see directory.Subscribe()).
+// dropped is closed before strs if the directory drops the
+// subscription, since the auditor fell behind.
+// Follow() returns nil once strs is closed, auditor.ErrSubscriberDropped
+// if the subscription was dropped, in which case the caller should
+// CatchUp() and subscribe again, or the first error returned by
+// AuditId().
+func (l ConiksAuditLog) Follow(dirInitHash [crypto.HashSizeByte]byte,
+	strs <-chan *protocol.DirSTR, dropped <-chan struct{}) error {
+	if err := l.AuditStream(context.Background(), dirInitHash, strs); err != nil {
+		return err
+	}
+	select {
+	case <-dropped:
+		return auditor.ErrSubscriberDropped
+	default:
+		return nil
+	}
+}
+
+// AuditStream audits a range of STRs for the CONIKS directory identified
+// by dirInitHash which it receives one at a time on strs, until strs is
+// closed. Unlike AuditId(), which requires the entire range to be in
+// memory, AuditStream() verifies and inserts each STR as soon as it is
+// received, e.g. for the initial sync of a directory with a very long
+// history.
+// Each STR is audited as Audit() does for a range of length 1, so the
+// same consistency checks apply; however, if AuditStream() returns an
+// error, the STRs it received before the offending STR remain in the
+// directory's history.
+// AuditStream() returns nil once strs is closed, auditor.ErrUnknownDirectory
+// if the auditor doesn't have a history for the directory, ctx.Err() if
+// ctx is done before strs is closed, or the first error returned by
+// Audit().
+func (l ConiksAuditLog) AuditStream(ctx context.Context,
+	dirInitHash [crypto.HashSizeByte]byte, strs <-chan *protocol.DirSTR) error {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return auditor.ErrUnknownDirectory
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case str, ok := <-strs:
+			if !ok {
+				return nil
+			}
+			resp := protocol.NewSTRHistoryRange([]*protocol.DirSTR{str})
+			if err := h.AuditContext(ctx, resp); err != nil {
+				return err
+			}
+		}
+	}
+}
+
 // GetObservedSTRs gets a range of observed STRs for the CONIKS directory
 // address indicated in the AuditingRequest req received from a
 // CONIKS client, and returns a protocol.Response.
 // The response (which also includes the error code) is sent back to
 // the client.
 //
-// A request without a directory address, with a StartEpoch or EndEpoch
+// A nil request, a request without a directory address (see
+// AuditingRequest.Validate()), with a StartEpoch or EndEpoch
 // greater than the latest observed epoch of this directory, or with
 // at StartEpoch > EndEpoch is considered
 // malformed and causes GetObservedSTRs() to return a
@@ -170,24 +657,268 @@ func (l ConiksAuditLog) InitHistory(addr string, signKey sign.PublicKey,
 // if StartEpoch == EndEpoch, the list returned is of length 1.
 // If the auditor doesn't have any history entries for the requested CONIKS
 // directory, GetObservedSTRs() returns a
-// message.NewErrorResponse(ReqUnknownDirectory).
+// message.NewErrorResponse(ReqUnknownDirectory), and if the history
+// is missing any of the requested STRs or quarantined (see Audit()),
+// it returns a message.NewErrorResponse(ErrAuditLog).
+//
+// If the request sets SinceSTRHash, GetObservedSTRs() instead returns
+// the STRs for the epoch range [e+1, latest], where e is the epoch of
+// the observed STR with the hash SinceSTRHash. If that STR is already
+// the latest STR, the list only contains the latest STR. A SinceSTRHash
+// that doesn't match any observed STR causes GetObservedSTRs() to
+// return a message.NewErrorResponse(ErrMalformedMessage).
+//
+// If the request sets a Limit and the requested range includes more
+// than Limit STRs, GetObservedSTRs() only returns the first Limit STRs
+// of the range, and sets the NextToken of the returned STRHistoryRange
+// to a token encoding the rest of the range. A request with a
+// PageToken returns the range encoded in the token instead; a
+// malformed token, or one issued for another directory, causes
+// GetObservedSTRs() to return a
+// message.NewErrorResponse(ErrMalformedMessage).
 func (l ConiksAuditLog) GetObservedSTRs(req *protocol.AuditingRequest) *protocol.Response {
+	res, _ := l.GetObservedSTRsContext(context.Background(), req)
+	return res
+}
+
+// GetObservedSTRsContext is like GetObservedSTRs but checks ctx between
+// the STRs it collects, e.g. to stop serving a large range to a client
+// that has disconnected. If ctx is done before the entire range has been
+// collected, GetObservedSTRsContext() returns a nil response and
+// ctx.Err(); otherwise the returned error is always nil.
+func (l ConiksAuditLog) GetObservedSTRsContext(ctx context.Context,
+	req *protocol.AuditingRequest) (*protocol.Response, error) {
+	if err := req.Validate(); err != nil {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage), nil
+	}
+
 	// make sure we have a history for the requested directory in the log
 	h, ok := l.get(req.DirInitSTRHash)
 	if !ok {
-		return protocol.NewErrorResponse(protocol.ReqUnknownDirectory)
+		return protocol.NewErrorResponse(protocol.ReqUnknownDirectory), nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.quarantined {
+		return protocol.NewErrorResponse(protocol.ErrAuditLog), nil
+	}
+
+	if len(req.PageToken) != 0 {
+		start, end, ok := parsePageToken(req.DirInitSTRHash, req.PageToken)
+		if !ok {
+			return protocol.NewErrorResponse(protocol.ErrMalformedMessage), nil
+		}
+		req = &protocol.AuditingRequest{
+			DirInitSTRHash: req.DirInitSTRHash,
+			StartEpoch:     start,
+			EndEpoch:       end,
+			Limit:          req.Limit,
+		}
+	} else if req.SinceSTRHash != [crypto.HashSizeByte]byte{} {
+		ep, ok := h.epochOf(req.SinceSTRHash[:])
+		if !ok {
+			return protocol.NewErrorResponse(protocol.ErrMalformedMessage), nil
+		}
+		req = &protocol.AuditingRequest{
+			DirInitSTRHash: req.DirInitSTRHash,
+			StartEpoch:     ep + 1,
+			EndEpoch:       h.VerifiedSTR().Epoch,
+			Limit:          req.Limit,
+		}
+		if req.StartEpoch > req.EndEpoch {
+			req.StartEpoch = req.EndEpoch
+		}
 	}
 
 	// make sure the request is well-formed
 	if req.EndEpoch > h.VerifiedSTR().Epoch || req.StartEpoch > req.EndEpoch {
-		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage), nil
+	}
+
+	end := req.EndEpoch
+	if req.Limit > 0 && end-req.StartEpoch >= req.Limit {
+		end = req.StartEpoch + req.Limit - 1
 	}
 
 	var strs []*protocol.DirSTR
-	for ep := req.StartEpoch; ep <= req.EndEpoch; ep++ {
-		str := h.snapshots[ep]
+	for ep := req.StartEpoch; ep <= end; ep++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		str, ok := h.snapshots[ep]
+		if !ok {
+			// the history may have gaps if it was restored
+			// with InitHistoryVerified()
+			return protocol.NewErrorResponse(protocol.ErrAuditLog), nil
+		}
 		strs = append(strs, str)
 	}
 
-	return protocol.NewSTRHistoryRange(strs)
+	res := protocol.NewSTRHistoryRange(strs)
+	if end < req.EndEpoch {
+		res.DirectoryResponse.(*protocol.STRHistoryRange).NextToken =
+			newPageToken(req.DirInitSTRHash, end+1, req.EndEpoch)
+	}
+	return res, nil
+}
+
+// GetObservedSTRsByTime gets the STRs of the CONIKS directory identified
+// by dirInitHash that the auditor observed in the time window
+// [start, end], and returns a protocol.Response as GetObservedSTRs() does
+// for the corresponding epoch range.
+// The time of an STR is the time at which the auditor first inserted it
+// into its history rather than the STR's own timestamp, which not all
+// directories set (see merkletree.SignedTreeRoot.Timestamp); in
+// particular, all STRs passed to InitHistory() share the same time.
+// A window that only partially overlaps with the observed history is
+// clipped to the observed STRs. If the window doesn't contain any
+// observed STR, or if start is after end, GetObservedSTRsByTime()
+// returns a message.NewErrorResponse(ErrMalformedMessage).
+func (l ConiksAuditLog) GetObservedSTRsByTime(dirInitHash [crypto.HashSizeByte]byte,
+	start, end time.Time) *protocol.Response {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return protocol.NewErrorResponse(protocol.ReqUnknownDirectory)
+	}
+	if start.After(end) {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+
+	var first, last uint64
+	found := false
+	h.mu.Lock()
+	for ep, t := range h.observedAt {
+		if t.Before(start) || t.After(end) {
+			continue
+		}
+		if !found || ep < first {
+			first = ep
+		}
+		if !found || ep > last {
+			last = ep
+		}
+		found = true
+	}
+	h.mu.Unlock()
+	if !found {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+	return l.GetObservedSTRs(&protocol.AuditingRequest{
+		DirInitSTRHash: dirInitHash,
+		StartEpoch:     first,
+		EndEpoch:       last,
+	})
+}
+
+// ForEachSnapshot calls f for each observed STR of the CONIKS directory
+// identified by dirInitHash in chronological order, until f returns
+// false (see directoryHistory.ForEachSnapshot()), e.g. to analyze the
+// directory's history without copying it.
+// ForEachSnapshot() returns auditor.ErrUnknownDirectory if the auditor
+// doesn't have a history for the directory.
+func (l ConiksAuditLog) ForEachSnapshot(dirInitHash [crypto.HashSizeByte]byte,
+	f func(*protocol.DirSTR) bool) error {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return auditor.ErrUnknownDirectory
+	}
+	h.ForEachSnapshot(f)
+	return nil
+}
+
+// EquivocationProof returns the proof of the first equivocation the
+// auditor detected for the CONIKS directory identified by dirInitHash
+// (see directoryHistory.EquivocationProof()), and whether such a proof
+// exists.
+func (l ConiksAuditLog) EquivocationProof(
+	dirInitHash [crypto.HashSizeByte]byte) (*auditor.EquivocationProof, bool) {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return nil, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.EquivocationProof()
+}
+
+// staleIntervals is the number of expected epoch intervals after which
+// a directory with a configured cadence is considered stale.
+const staleIntervals = 2
+
+// StaleDirectories returns the identifiers (i.e. the hashes of the
+// initial STRs) of all CONIKS directories in the audit log l whose latest
+// verified STR was observed more than maxGap ago, e.g. because the
+// directory stopped publishing new STRs. A directory whose expected
+// epoch interval is configured (see InitHistoryWithCadence()) is instead
+// stale once its latest verified STR was observed more than twice its
+// interval ago, regardless of maxGap.
+// Clients of a stale directory keep using outdated state, so an auditor
+// should alert on the returned directories. The identifiers are returned
+// in ascending byte order.
+func (l ConiksAuditLog) StaleDirectories(maxGap time.Duration) [][crypto.HashSizeByte]byte {
+	var stale [][crypto.HashSizeByte]byte
+	t := now()
+	for dirInitHash, h := range l {
+		gap := maxGap
+		h.mu.Lock()
+		if h.epochInterval > 0 {
+			gap = staleIntervals * h.epochInterval
+		}
+		observed := h.observedAt[h.VerifiedSTR().Epoch]
+		h.mu.Unlock()
+		if t.Sub(observed) > gap {
+			stale = append(stale, dirInitHash)
+		}
+	}
+	sortDirectories(stale)
+	return stale
+}
+
+// Directories returns the identifiers (i.e. the hashes of the initial
+// STRs) of all CONIKS directories in the audit log l, e.g. for
+// advertising the directories the auditor tracks. The identifiers are
+// returned in ascending byte order. The known addresses of each
+// directory are returned by Addresses().
+func (l ConiksAuditLog) Directories() [][crypto.HashSizeByte]byte {
+	dirs := make([][crypto.HashSizeByte]byte, 0, len(l))
+	for dirInitHash := range l {
+		dirs = append(dirs, dirInitHash)
+	}
+	sortDirectories(dirs)
+	return dirs
+}
+
+// sortDirectories sorts the directory identifiers dirs
+// in ascending byte order.
+func sortDirectories(dirs [][crypto.HashSizeByte]byte) {
+	sort.Slice(dirs, func(i, j int) bool {
+		return bytes.Compare(dirs[i][:], dirs[j][:]) < 0
+	})
+}
+
+// GetLatestSTR gets the latest observed STR for the CONIKS directory
+// identified by dirInitHash, and returns a protocol.Response.
+// The response (which also includes the error code) is sent back to
+// the client.
+//
+// GetLatestSTR() is a cheaper alternative to GetObservedSTRs() for
+// clients that only need the freshest STR, e.g. for equivocation checks.
+// It returns a message.NewSTRHistoryRange(strs), where strs only
+// contains the latest verified STR of this directory.
+// If the auditor doesn't have any history entries for the requested CONIKS
+// directory, GetLatestSTR() returns a
+// message.NewErrorResponse(ReqUnknownDirectory), and if the directory's
+// history is quarantined (see Audit()), it returns a
+// message.NewErrorResponse(ErrAuditLog).
+func (l ConiksAuditLog) GetLatestSTR(dirInitHash [crypto.HashSizeByte]byte) *protocol.Response {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return protocol.NewErrorResponse(protocol.ReqUnknownDirectory)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.quarantined {
+		return protocol.NewErrorResponse(protocol.ErrAuditLog)
+	}
+	return protocol.NewSTRHistoryRange([]*protocol.DirSTR{h.VerifiedSTR()})
 }
@@ -1,11 +1,21 @@
 package auditlog
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
 	"github.com/coniks-sys/coniks-go/protocol"
 	"github.com/coniks-sys/coniks-go/protocol/auditor"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
 )
 
 func TestInsertEmptyHistory(t *testing.T) {
@@ -13,6 +23,22 @@ func TestInsertEmptyHistory(t *testing.T) {
 	NewTestAuditLog(t, 0)
 }
 
+func TestAuditEmptyRange(t *testing.T) {
+	_, aud, hist := NewTestAuditLog(t, 0)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	h, _ := aud.get(dirInitHash)
+
+	for _, strs := range [][]*protocol.DirSTR{nil, {}} {
+		resp := protocol.NewSTRHistoryRange(strs)
+		if err := h.Audit(resp); err != protocol.ErrMalformedMessage {
+			t.Fatal("Expect", protocol.ErrMalformedMessage, "got", err)
+		}
+	}
+	if h.quarantined || h.VerifiedSTR().Epoch != 0 {
+		t.Error("Expect the history to be unchanged")
+	}
+}
+
 func TestUpdateHistory(t *testing.T) {
 	// create basic test directory and audit log with 1 STR
 	d, aud, hist := NewTestAuditLog(t, 0)
@@ -41,10 +67,82 @@ func TestInsertExistingHistory(t *testing.T) {
 
 	// let's make sure that we can't re-insert a new server
 	// history into our log
-	err := aud.InitHistory("test-server", nil, hist)
+	pk, _ := staticSigningKey.Public()
+	err := aud.InitHistory("test-server", pk, hist)
 	if err != protocol.ErrAuditLog {
 		t.Fatal("Expected an ErrAuditLog when inserting an existing server history")
 	}
+
+	// the initial STR is validated before it is looked up
+	if err := aud.InitHistory("test-server", nil, hist); err != protocol.CheckBadSignature {
+		t.Fatal("Expect", protocol.CheckBadSignature, "got", err)
+	}
+}
+
+func TestInsertNonContiguousHistory(t *testing.T) {
+	_, snaps := newTestSnapshots(t, 3)
+	pk, _ := staticSigningKey.Public()
+
+	for _, tc := range []struct {
+		name  string
+		snaps []*protocol.DirSTR
+	}{
+		{"gap", []*protocol.DirSTR{snaps[0], snaps[1], snaps[3]}},
+		{"duplicate", []*protocol.DirSTR{snaps[0], snaps[1], snaps[1], snaps[2]}},
+		{"descending", []*protocol.DirSTR{snaps[0], snaps[2], snaps[1]}},
+		{"no initial STR", []*protocol.DirSTR{snaps[1], snaps[2]}},
+	} {
+		aud := New()
+		if err := aud.InitHistory("test-server", pk, tc.snaps); err != protocol.ErrMalformedMessage {
+			t.Fatal(tc.name, "expect", protocol.ErrMalformedMessage, "got", err)
+		}
+		if len(aud.Directories()) != 0 {
+			t.Fatal(tc.name, "expect the history to be rejected")
+		}
+	}
+}
+
+func TestInsertBadGenesis(t *testing.T) {
+	_, snaps := newTestSnapshots(t, 1)
+	pk, _ := staticSigningKey.Public()
+
+	bogus := *snaps[0].SignedTreeRoot
+	bogus.Signature = append([]byte{}, bogus.Signature...)
+	bogus.Signature[0] ^= 1
+	bad := []*protocol.DirSTR{protocol.NewDirSTR(&bogus), snaps[1]}
+
+	aud := New()
+	if err := aud.InitHistory("test-server", pk, bad); err != protocol.CheckBadSignature {
+		t.Fatal("Expect", protocol.CheckBadSignature, "got", err)
+	}
+	if len(aud.Directories()) != 0 {
+		t.Fatal("Expect the bad history not to be inserted")
+	}
+	if err := aud.InitHistory("test-server", pk, snaps); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInsertUnknownHashFunction(t *testing.T) {
+	_, snaps := newTestSnapshots(t, 1)
+	pk, _ := staticSigningKey.Public()
+
+	// the initial STR declares a hash function the auditor doesn't know
+	p := *snaps[0].Policies
+	p.HashID = "bogus"
+	bogus := &protocol.DirSTR{SignedTreeRoot: snaps[0].SignedTreeRoot, Policies: &p}
+	bad := []*protocol.DirSTR{bogus, snaps[1]}
+
+	aud := New()
+	if err := aud.InitHistory("test-server", pk, bad); err != protocol.ErrMalformedMessage {
+		t.Error("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+	if err := aud.InitHistoryVerified("test-server", pk, bad); err == nil {
+		t.Error("Expect the unknown hash function to be rejected")
+	}
+	if len(aud.Directories()) != 0 {
+		t.Fatal("Expect the bad history not to be inserted")
+	}
 }
 
 func TestAuditLogBadEpochRange(t *testing.T) {
@@ -79,11 +177,22 @@ func TestAuditLogBadEpochRange(t *testing.T) {
 		t.Fatalf("Error occurred while auditing STR history: %s", err.Error())
 	}
 
-	// now try to audit the same range again: should fail because the
-	// verified epoch is at 1
+	// now try to audit the same range again: the range overlaps
+	// the already observed snapshots, so it is deduplicated
 	err = h.Audit(resp)
-	if err != protocol.CheckBadSTR {
-		t.Fatalf("Expecting CheckBadSTR, got %s", err.Error())
+	if err != nil {
+		t.Fatalf("Error occurred while re-auditing STR history: %s", err.Error())
+	}
+	if h.VerifiedSTR().Epoch != 1 {
+		t.Fatalf("Expect verified epoch of 1, got %d", h.VerifiedSTR().Epoch)
+	}
+
+	// a range that skips an epoch leaves a gap in the history
+	d.Update()
+	d.Update()
+	err = h.Audit(protocol.NewSTRHistoryRange([]*protocol.DirSTR{d.LatestSTR()}))
+	if err != auditor.ErrRangeGap {
+		t.Fatalf("Expecting ErrRangeGap, got %v", err)
 	}
 }
 
@@ -202,6 +311,7 @@ func TestGetObservedSTRUnknown(t *testing.T) {
 	d, aud, _ := NewTestAuditLog(t, 10)
 
 	var unknown [crypto.HashSizeByte]byte
+	unknown[0] = 1
 	res := aud.GetObservedSTRs(&protocol.AuditingRequest{
 		DirInitSTRHash: unknown,
 		StartEpoch:     uint64(d.LatestSTR().Epoch),
@@ -244,6 +354,33 @@ func TestGetObservedSTRMalformed(t *testing.T) {
 	}
 }
 
+func TestGetObservedSTRsInvalidRequest(t *testing.T) {
+	_, aud, hist := NewTestAuditLog(t, 10)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	for _, tc := range []struct {
+		name string
+		req  *protocol.AuditingRequest
+	}{
+		{"nil request", nil},
+		{"zero hash", &protocol.AuditingRequest{StartEpoch: 0, EndEpoch: 1}},
+		{"inverted range", &protocol.AuditingRequest{
+			DirInitSTRHash: dirInitHash, StartEpoch: 2, EndEpoch: 1}},
+	} {
+		if err := tc.req.Validate(); err != protocol.ErrMalformedMessage {
+			t.Error(tc.name, "- Expect", protocol.ErrMalformedMessage, "got", err)
+		}
+		if res := aud.GetObservedSTRs(tc.req); res.Error != protocol.ErrMalformedMessage {
+			t.Error(tc.name, "- Expect", protocol.ErrMalformedMessage, "got", res.Error)
+		}
+	}
+
+	valid := &protocol.AuditingRequest{DirInitSTRHash: dirInitHash, StartEpoch: 1, EndEpoch: 1}
+	if err := valid.Validate(); err != nil {
+		t.Error("Unexpected error", err)
+	}
+}
+
 func TestVerifyHashChainBadPrevSTRHash(t *testing.T) {
 	// create basic test directory and audit log with 4 STRs
 	d, aud, hist := NewTestAuditLog(t, 3)
@@ -416,3 +553,1408 @@ func TestSTRHistoryRequestInEpoch(t *testing.T) {
 		t.Fatalf("Error occurred auditing the latest STR: %s", err.Error())
 	}
 }
+
+func TestGetLatestSTR(t *testing.T) {
+	// create basic test directory and audit log with 11 STRs
+	d, aud, hist := NewTestAuditLog(t, 10)
+
+	// compute the hash of the initial STR for later lookups
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	res := aud.GetLatestSTR(dirInitHash)
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Unable to get latest observed STR")
+	}
+	latest := res.DirectoryResponse.(*protocol.STRHistoryRange)
+	if len(latest.STR) != 1 {
+		t.Fatal("Expect exactly 1 returned STR, got", len(latest.STR))
+	}
+
+	// compare against the last STR of a full range query
+	res = aud.GetObservedSTRs(&protocol.AuditingRequest{
+		DirInitSTRHash: dirInitHash,
+		StartEpoch:     0,
+		EndEpoch:       d.LatestSTR().Epoch})
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Unable to get range of STRs")
+	}
+	obs := res.DirectoryResponse.(*protocol.STRHistoryRange)
+	if !reflect.DeepEqual(latest.STR[0], obs.STR[len(obs.STR)-1]) {
+		t.Fatal("Expect the latest STR to equal the last STR of the full range")
+	}
+
+	var unknown [crypto.HashSizeByte]byte
+	if res := aud.GetLatestSTR(unknown); res.Error != protocol.ReqUnknownDirectory {
+		t.Fatal("Expect ReqUnknownDirectory, got", res.Error)
+	}
+}
+
+func TestAuditId(t *testing.T) {
+	// create basic test directory and audit log with 1 STR
+	d, aud, hist := NewTestAuditLog(t, 0)
+
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	d.Update()
+	resp := protocol.NewSTRHistoryRange([]*protocol.DirSTR{d.LatestSTR()})
+
+	if err := aud.AuditId(dirInitHash, resp); err != nil {
+		t.Fatal("Error auditing the directory", err)
+	}
+	if res := aud.GetLatestSTR(dirInitHash); res.DirectoryResponse.(*protocol.STRHistoryRange).STR[0].Epoch != 1 {
+		t.Fatal("Expect the audited STR to be the latest observed STR")
+	}
+
+	// auditing a gapped range should return the error
+	d.Update()
+	d.Update()
+	resp = protocol.NewSTRHistoryRange([]*protocol.DirSTR{d.LatestSTR()})
+	if err := aud.AuditId(dirInitHash, resp); err != auditor.ErrRangeGap {
+		t.Fatal("Expect", auditor.ErrRangeGap, "got", err)
+	}
+
+	var unknown [crypto.HashSizeByte]byte
+	if err := aud.AuditId(unknown, resp); !errors.Is(err, auditor.ErrUnknownDirectory) {
+		t.Fatal("Expect", auditor.ErrUnknownDirectory, "got", err)
+	}
+}
+
+func TestAuditWrongResponseType(t *testing.T) {
+	// create basic test directory and audit log with 1 STR
+	d, aud, hist := NewTestAuditLog(t, 0)
+
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	d.Update()
+
+	// a DirectoryProof must not be accepted in place of an STRHistoryRange
+	resp := d.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"})
+	if err := aud.AuditId(dirInitHash, resp); err != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+}
+
+func TestAuditContiguousAppend(t *testing.T) {
+	// create basic test directory and audit log with 4 STRs
+	d, aud, hist := NewTestAuditLog(t, 3)
+	h, _ := aud.get(auditor.ComputeDirectoryIdentity(hist[0]))
+
+	for i := 0; i < 3; i++ {
+		d.Update()
+	}
+	resp := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: h.VerifiedSTR().Epoch + 1,
+		EndEpoch:   d.LatestSTR().Epoch})
+	if err := h.Audit(resp); err != nil {
+		t.Fatal("Error auditing a contiguous range", err)
+	}
+	if h.VerifiedSTR().Epoch != d.LatestSTR().Epoch || len(h.snapshots) != 7 {
+		t.Fatal("Expect the range to be appended to the history")
+	}
+}
+
+func TestAuditOverlappingAppend(t *testing.T) {
+	// create basic test directory and audit log with 4 STRs
+	d, aud, hist := NewTestAuditLog(t, 3)
+	h, _ := aud.get(auditor.ComputeDirectoryIdentity(hist[0]))
+
+	for i := 0; i < 3; i++ {
+		d.Update()
+	}
+	// epochs 1-3 have already been observed
+	resp := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 1,
+		EndEpoch:   d.LatestSTR().Epoch})
+	if err := h.Audit(resp); err != nil {
+		t.Fatal("Error auditing an overlapping range", err)
+	}
+	if h.VerifiedSTR().Epoch != d.LatestSTR().Epoch || len(h.snapshots) != 7 {
+		t.Fatal("Expect the new part of the range to be appended to the history")
+	}
+}
+
+func TestAuditOverlappingConflict(t *testing.T) {
+	// create basic test directory and audit log with 4 STRs
+	d, aud, hist := NewTestAuditLog(t, 3)
+	h, _ := aud.get(auditor.ComputeDirectoryIdentity(hist[0]))
+
+	d.Update()
+	resp := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 2,
+		EndEpoch:   d.LatestSTR().Epoch})
+
+	// modify an already observed STR in the overlapping part
+	strs := resp.DirectoryResponse.(*protocol.STRHistoryRange).STR
+	str2 := *strs[0].SignedTreeRoot
+	str2.Signature = append([]byte{}, str2.Signature...)
+	str2.Signature[0]++
+	strs[0] = &protocol.DirSTR{SignedTreeRoot: &str2, Policies: strs[0].Policies}
+
+	if err := h.Audit(resp); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+	if h.VerifiedSTR().Epoch != 3 {
+		t.Fatal("Expect the history to be unchanged")
+	}
+}
+
+func TestAuditRedeliveredRange(t *testing.T) {
+	clock := newFakeClock(t)
+	// create basic test directory and audit log with 4 STRs
+	d, aud, hist := NewTestAuditLog(t, 3)
+	h, _ := aud.get(auditor.ComputeDirectoryIdentity(hist[0]))
+
+	for i := 0; i < 3; i++ {
+		d.Update()
+	}
+	resp := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: h.VerifiedSTR().Epoch + 1,
+		EndEpoch:   d.LatestSTR().Epoch})
+	if err := h.Audit(resp); err != nil {
+		t.Fatal(err)
+	}
+	observedAt := make(map[uint64]time.Time)
+	for ep, at := range h.observedAt {
+		observedAt[ep] = at
+	}
+
+	// re-delivering the same range, and the entire history, is a no-op
+	clock.advance(time.Hour)
+	full := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 0,
+		EndEpoch:   d.LatestSTR().Epoch})
+	for _, r := range []*protocol.Response{resp, resp, full} {
+		if err := h.Audit(r); err != nil {
+			t.Fatal("Expect a re-delivered range to be accepted, got", err)
+		}
+	}
+	if h.VerifiedSTR().Epoch != d.LatestSTR().Epoch || len(h.snapshots) != 7 {
+		t.Fatal("Expect the history to be unchanged")
+	}
+	if !reflect.DeepEqual(h.observedAt, observedAt) {
+		t.Fatal("Expect the observation times to be unchanged")
+	}
+	if _, ok := h.EquivocationProof(); ok {
+		t.Fatal("Unexpected equivocation proof")
+	}
+}
+
+func TestAuditRedeliveredConflict(t *testing.T) {
+	// create basic test directory and audit log with 6 STRs
+	d, aud, hist := NewTestAuditLog(t, 5)
+	h, _ := aud.get(auditor.ComputeDirectoryIdentity(hist[0]))
+
+	// a re-delivered range in which the directory equivocates at epoch 3
+	fork := d.ForkAt(t, 2)
+	fork.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("evil")})
+	fork.Update()
+	fork.Update()
+	resp := fork.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 1,
+		EndEpoch:   4})
+	if err := h.Audit(resp); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+	if h.VerifiedSTR().Epoch != 5 || len(h.snapshots) != 6 {
+		t.Fatal("Expect the history to be unchanged")
+	}
+	if proof, ok := h.EquivocationProof(); !ok || proof.STR1.Epoch != 3 {
+		t.Fatal("Expect an equivocation proof for epoch 3")
+	}
+}
+
+func TestAuditGappedRange(t *testing.T) {
+	// create basic test directory and audit log with 4 STRs
+	d, aud, hist := NewTestAuditLog(t, 3)
+	h, _ := aud.get(auditor.ComputeDirectoryIdentity(hist[0]))
+
+	for i := 0; i < 4; i++ {
+		d.Update()
+	}
+	// epoch 4 is missing
+	resp := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 5,
+		EndEpoch:   d.LatestSTR().Epoch})
+	if err := h.Audit(resp); err != auditor.ErrRangeGap {
+		t.Fatal("Expect", auditor.ErrRangeGap, "got", err)
+	}
+	if h.VerifiedSTR().Epoch != 3 || len(h.snapshots) != 4 {
+		t.Fatal("Expect the history to be unchanged")
+	}
+}
+
+func TestAuditDetectsPastFork(t *testing.T) {
+	// create basic test directory and audit log with 6 STRs
+	d, aud, hist := NewTestAuditLog(t, 5)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	// the fork diverges several epochs back
+	fork := d.ForkAt(t, 2)
+	fork.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("evil")})
+	for i := 0; i < 4; i++ {
+		fork.Update()
+	}
+
+	resp := fork.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 0,
+		EndEpoch:   fork.LatestSTR().Epoch})
+	if err := aud.AuditId(dirInitHash, resp); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+}
+
+// countdownContext is a context that is canceled once Err()
+// has been called n times.
+type countdownContext struct {
+	context.Context
+	n int
+}
+
+func (ctx *countdownContext) Err() error {
+	if ctx.n <= 0 {
+		return context.Canceled
+	}
+	ctx.n--
+	return nil
+}
+
+func TestAuditContextCanceled(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 0)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	// the directory only keeps a limited history in memory,
+	// so collect the range as we go
+	var strs []*protocol.DirSTR
+	for i := 0; i < 50; i++ {
+		d.Update()
+		strs = append(strs, d.LatestSTR())
+	}
+	resp := protocol.NewSTRHistoryRange(strs)
+
+	// cancel the audit halfway through the range
+	ctx := &countdownContext{context.Background(), 25}
+	if err := aud.AuditIdContext(ctx, dirInitHash, resp); err != context.Canceled {
+		t.Fatal("Expect", context.Canceled, "got", err)
+	}
+	if ctx.n != 0 {
+		t.Fatal("Expect the audit to stop at the cancellation")
+	}
+	h, _ := aud.get(dirInitHash)
+	if h.VerifiedSTR().Epoch != 0 || len(h.snapshots) != 1 {
+		t.Fatal("Expect a canceled audit to leave the history unchanged")
+	}
+
+	// the same range still passes with a live context
+	if err := aud.AuditIdContext(context.Background(), dirInitHash, resp); err != nil {
+		t.Fatal(err)
+	}
+	if h.VerifiedSTR().Epoch != 50 {
+		t.Fatal("Expect verified epoch 50, got", h.VerifiedSTR().Epoch)
+	}
+}
+
+func TestGetObservedSTRsContextCanceled(t *testing.T) {
+	_, aud, hist := NewTestAuditLog(t, 50)
+	req := &protocol.AuditingRequest{
+		DirInitSTRHash: auditor.ComputeDirectoryIdentity(hist[0]),
+		StartEpoch:     uint64(0),
+		EndEpoch:       uint64(50)}
+
+	ctx := &countdownContext{context.Background(), 25}
+	res, err := aud.GetObservedSTRsContext(ctx, req)
+	if err != context.Canceled || res != nil {
+		t.Fatal("Expect", context.Canceled, "got", err)
+	}
+	if ctx.n != 0 {
+		t.Fatal("Expect the lookup to stop at the cancellation")
+	}
+
+	ctx2, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := aud.GetObservedSTRsContext(ctx2, req); err != context.Canceled {
+		t.Fatal("Expect", context.Canceled, "got", err)
+	}
+
+	res, err = aud.GetObservedSTRsContext(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strs := res.DirectoryResponse.(*protocol.STRHistoryRange).STR; len(strs) != 51 {
+		t.Fatal("Expect 51 STRs, got", len(strs))
+	}
+}
+
+// gappedDirectory drops the second STR of every range the underlying
+// directory returns.
+type gappedDirectory struct {
+	DirectoryClient
+}
+
+func (d gappedDirectory) GetSTRHistory(req *protocol.STRHistoryRequest) *protocol.Response {
+	resp := d.DirectoryClient.GetSTRHistory(req)
+	strs := resp.DirectoryResponse.(*protocol.STRHistoryRange)
+	gapped := append([]*protocol.DirSTR{strs.STR[0]}, strs.STR[2:]...)
+	return protocol.NewSTRHistoryRange(gapped)
+}
+
+func TestCatchUp(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 2)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	// nothing to catch up on
+	if err := aud.CatchUp(dirInitHash, d); err != nil {
+		t.Fatal(err)
+	}
+
+	// the auditor falls behind by several epochs
+	for i := 0; i < 5; i++ {
+		d.Update()
+	}
+	if err := aud.CatchUp(dirInitHash, d); err != nil {
+		t.Fatal(err)
+	}
+	h, _ := aud.get(dirInitHash)
+	if h.VerifiedSTR().Epoch != d.LatestSTR().Epoch {
+		t.Fatal("Expect verified epoch", d.LatestSTR().Epoch, "got", h.VerifiedSTR().Epoch)
+	}
+	for ep := uint64(0); ep <= d.LatestSTR().Epoch; ep++ {
+		if _, ok := h.snapshots[ep]; !ok {
+			t.Error("Missing snapshot for epoch", ep)
+		}
+	}
+
+	var unknown [crypto.HashSizeByte]byte
+	if err := aud.CatchUp(unknown, d); err != auditor.ErrUnknownDirectory {
+		t.Error("Expect", auditor.ErrUnknownDirectory, "got", err)
+	}
+}
+
+func TestCatchUpBadRange(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 2)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	fork := d.ForkAt(t, 1)
+
+	d.Update()
+	d.Update()
+	fork.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("evil")})
+	for i := 0; i < 3; i++ {
+		fork.Update()
+	}
+
+	if err := aud.CatchUp(dirInitHash, gappedDirectory{d}); err != auditor.ErrRangeGap {
+		t.Error("Expect", auditor.ErrRangeGap, "got", err)
+	}
+	if err := aud.CatchUp(dirInitHash, fork); err != protocol.CheckBadSTR {
+		t.Error("Expect", protocol.CheckBadSTR, "got", err)
+	}
+	h, _ := aud.get(dirInitHash)
+	if h.VerifiedSTR().Epoch != 2 {
+		t.Fatal("Expect the history to be unchanged")
+	}
+
+	// the honest directory's range still passes
+	if err := aud.CatchUp(dirInitHash, d); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestSnapshots(t *testing.T, n int) (*directory.ConiksDirectory, []*protocol.DirSTR) {
+	d := directory.NewTestDirectory(t)
+	snaps := []*protocol.DirSTR{d.LatestSTR()}
+	for i := 0; i < n; i++ {
+		d.Update()
+		snaps = append(snaps, d.LatestSTR())
+	}
+	return d, snaps
+}
+
+func TestInitHistoryVerified(t *testing.T) {
+	_, snaps := newTestSnapshots(t, 5)
+	pk, _ := staticSigningKey.Public()
+
+	aud := New()
+	if err := aud.InitHistoryVerified("test-server", pk, snaps); err != nil {
+		t.Fatal(err)
+	}
+	h, _ := aud.get(auditor.ComputeDirectoryIdentity(snaps[0]))
+	if h.VerifiedSTR().Epoch != 5 {
+		t.Fatal("Expect verified epoch 5, got", h.VerifiedSTR().Epoch)
+	}
+
+	// a non-contiguous set
+	aud = New()
+	gapped := []*protocol.DirSTR{snaps[0], snaps[1], snaps[3], snaps[4]}
+	if err := aud.InitHistoryVerified("test-server", pk, gapped); err != nil {
+		t.Fatal(err)
+	}
+	res := aud.GetObservedSTRs(&protocol.AuditingRequest{
+		DirInitSTRHash: auditor.ComputeDirectoryIdentity(snaps[0]),
+		StartEpoch:     uint64(1),
+		EndEpoch:       uint64(3)})
+	if res.Error != protocol.ErrAuditLog {
+		t.Fatal("Expect", protocol.ErrAuditLog, "for a missing epoch, got", res.Error)
+	}
+}
+
+func TestInitHistoryVerifiedBadSnapshot(t *testing.T) {
+	d, snaps := newTestSnapshots(t, 5)
+	pk, _ := staticSigningKey.Public()
+
+	// an STR from a fork of the directory at epoch 2
+	fork := d.ForkAt(t, 1)
+	fork.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("evil")})
+	fork.Update()
+
+	tampered := *snaps[3].SignedTreeRoot
+	tampered.TreeHash = append([]byte{}, tampered.TreeHash...)
+	tampered.TreeHash[0] ^= 1
+
+	for _, tc := range []struct {
+		name  string
+		snaps []*protocol.DirSTR
+		epoch uint64
+		err   error
+	}{
+		{"tampered snapshot", []*protocol.DirSTR{snaps[0], snaps[1], snaps[2],
+			{SignedTreeRoot: &tampered, Policies: snaps[3].Policies}, snaps[4]},
+			3, protocol.CheckBadSignature},
+		{"forked snapshot", []*protocol.DirSTR{snaps[0], snaps[1],
+			fork.LatestSTR(), snaps[3], snaps[4]}, 3, protocol.CheckBadSTR},
+		{"out of order", []*protocol.DirSTR{snaps[0], snaps[2], snaps[1]},
+			1, protocol.ErrMalformedMessage},
+	} {
+		aud := New()
+		err := aud.InitHistoryVerified("test-server", pk, tc.snaps)
+		var serr *auditor.SnapshotError
+		if !errors.As(err, &serr) || serr.Epoch != tc.epoch || !errors.Is(err, tc.err) {
+			t.Error(tc.name, "- Expect a bad snapshot at epoch", tc.epoch, "got", err)
+		}
+		if len(aud) != 0 {
+			t.Error(tc.name, "- Expect the log to be unchanged")
+		}
+	}
+}
+
+func TestFollowSubscription(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 0)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	sub, dropped := d.Subscribe(10)
+	for i := 0; i < 3; i++ {
+		d.Update()
+	}
+	d.Unsubscribe(sub)
+
+	if err := aud.Follow(dirInitHash, sub, dropped); err != nil {
+		t.Fatal(err)
+	}
+	h, _ := aud.get(dirInitHash)
+	if h.VerifiedSTR().Epoch != 3 {
+		t.Fatal("Expect verified epoch 3, got", h.VerifiedSTR().Epoch)
+	}
+
+	// a subscription for another directory
+	other := directory.NewTestDirectory(t)
+	other.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	sub, dropped = other.Subscribe(10)
+	for i := 0; i < 4; i++ {
+		other.Update()
+	}
+	other.Unsubscribe(sub)
+	if err := aud.Follow(dirInitHash, sub, dropped); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+}
+
+func TestFollowDroppedSubscription(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 0)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	// the auditor falls behind after the first STR
+	sub, dropped := d.Subscribe(1)
+	for i := 0; i < 3; i++ {
+		d.Update()
+	}
+	if err := aud.Follow(dirInitHash, sub, dropped); err != auditor.ErrSubscriberDropped {
+		t.Fatal("Expect", auditor.ErrSubscriberDropped, "got", err)
+	}
+	h, _ := aud.get(dirInitHash)
+	if h.VerifiedSTR().Epoch != 1 {
+		t.Fatal("Expect the buffered STR to be audited, got epoch", h.VerifiedSTR().Epoch)
+	}
+
+	// the auditor catches up and subscribes again
+	if err := aud.CatchUp(dirInitHash, d); err != nil {
+		t.Fatal(err)
+	}
+	sub, dropped = d.Subscribe(10)
+	d.Update()
+	d.Unsubscribe(sub)
+	if err := aud.Follow(dirInitHash, sub, dropped); err != nil {
+		t.Fatal(err)
+	}
+	if h.VerifiedSTR().Epoch != 4 {
+		t.Fatal("Expect verified epoch 4, got", h.VerifiedSTR().Epoch)
+	}
+}
+
+func TestAuditMixedHashers(t *testing.T) {
+	aud := New()
+	pk, _ := staticSigningKey.Public()
+	dirs := []*directory.ConiksDirectory{
+		directory.NewTestDirectory(t),
+		directory.NewTestDirectoryWithHasher(t, crypto.GetHasher(crypto.SHA512_256ID)),
+	}
+	var ids [][crypto.HashSizeByte]byte
+	for i, d := range dirs {
+		str0 := d.LatestSTR()
+		d.Update()
+		snaps := []*protocol.DirSTR{str0, d.LatestSTR()}
+		if err := aud.InitHistory(fmt.Sprintf("test-server-%d", i), pk, snaps); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, auditor.ComputeDirectoryIdentity(snaps[0]))
+	}
+	if ids[0] == ids[1] {
+		t.Fatal("Expect distinct directory identities")
+	}
+	if h := dirs[1].LatestSTR().Hash(); reflect.DeepEqual(h,
+		crypto.Digest(dirs[1].LatestSTR().Signature)) {
+		t.Fatal("Expect the declared hasher to be used")
+	}
+
+	for i, d := range dirs {
+		d.Update()
+		resp := protocol.NewSTRHistoryRange([]*protocol.DirSTR{d.LatestSTR()})
+		if err := aud.AuditId(ids[i], resp); err != nil {
+			t.Fatal("Error auditing directory", i, err)
+		}
+	}
+
+	// an STR declaring a different hash function must be rejected
+	d := dirs[1]
+	d.Update()
+	str := d.LatestSTR()
+	bad := *str.Policies
+	bad.HashID = crypto.HashID
+	resp := protocol.NewSTRHistoryRange([]*protocol.DirSTR{
+		{SignedTreeRoot: str.SignedTreeRoot, Policies: &bad}})
+	if err := aud.AuditId(ids[1], resp); err == nil {
+		t.Fatal("Expect an error for an STR with a mismatching hash function")
+	}
+}
+
+func TestGetObservedSTRsSinceHash(t *testing.T) {
+	// create basic test directory and audit log with 5 STRs
+	_, aud, hist := NewTestAuditLog(t, 4)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	var since [crypto.HashSizeByte]byte
+	copy(since[:], hist[1].Hash())
+	res := aud.GetObservedSTRs(&protocol.AuditingRequest{
+		DirInitSTRHash: dirInitHash,
+		SinceSTRHash:   since,
+	})
+	if err := res.ValidateFor(protocol.AuditType); err != nil {
+		t.Fatal(err)
+	}
+	strs := res.DirectoryResponse.(*protocol.STRHistoryRange).STR
+	if len(strs) != 3 || strs[0].Epoch != 2 || strs[2].Epoch != 4 {
+		t.Fatal("Expect STRs for epochs 2 to 4")
+	}
+
+	// the pinned STR is already the latest STR
+	copy(since[:], hist[4].Hash())
+	res = aud.GetObservedSTRs(&protocol.AuditingRequest{
+		DirInitSTRHash: dirInitHash,
+		SinceSTRHash:   since,
+	})
+	if err := res.ValidateFor(protocol.AuditType); err != nil {
+		t.Fatal(err)
+	}
+	strs = res.DirectoryResponse.(*protocol.STRHistoryRange).STR
+	if len(strs) != 1 || strs[0].Epoch != 4 {
+		t.Fatal("Expect only the latest STR")
+	}
+
+	// unknown hash
+	since[0] ^= 0xff
+	res = aud.GetObservedSTRs(&protocol.AuditingRequest{
+		DirInitSTRHash: dirInitHash,
+		SinceSTRHash:   since,
+	})
+	if res.Error != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", res.Error)
+	}
+}
+
+func TestGetObservedSTRsPaging(t *testing.T) {
+	// create basic test directory and audit log with 10 STRs
+	_, aud, hist := NewTestAuditLog(t, 9)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	req := &protocol.AuditingRequest{
+		DirInitSTRHash: dirInitHash,
+		StartEpoch:     1,
+		EndEpoch:       9,
+		Limit:          4,
+	}
+	var strs []*protocol.DirSTR
+	var pages int
+	for {
+		res := aud.GetObservedSTRs(req)
+		if err := res.ValidateFor(protocol.AuditType); err != nil {
+			t.Fatal(err)
+		}
+		page := res.DirectoryResponse.(*protocol.STRHistoryRange)
+		if uint64(len(page.STR)) > req.Limit {
+			t.Fatal("Expect at most", req.Limit, "STRs, got", len(page.STR))
+		}
+		strs = append(strs, page.STR...)
+		pages++
+		if len(page.NextToken) == 0 {
+			break
+		}
+		req = &protocol.AuditingRequest{
+			DirInitSTRHash: dirInitHash,
+			Limit:          4,
+			PageToken:      page.NextToken,
+		}
+	}
+	if pages != 3 {
+		t.Fatal("Expect 3 pages, got", pages)
+	}
+	if len(strs) != 9 {
+		t.Fatal("Expect 9 STRs, got", len(strs))
+	}
+	for i, str := range strs {
+		if str.Epoch != uint64(i+1) || !bytes.Equal(str.Signature, hist[i+1].Signature) {
+			t.Fatal("Unexpected STR at position", i)
+		}
+	}
+
+	// a range within the limit isn't paged
+	res := aud.GetObservedSTRs(&protocol.AuditingRequest{
+		DirInitSTRHash: dirInitHash,
+		StartEpoch:     0,
+		EndEpoch:       3,
+		Limit:          4,
+	})
+	if page := res.DirectoryResponse.(*protocol.STRHistoryRange); len(page.STR) != 4 ||
+		page.NextToken != nil {
+		t.Fatal("Expect all 4 STRs without a next token")
+	}
+}
+
+func TestGetObservedSTRsBadPageToken(t *testing.T) {
+	_, aud, hist := NewTestAuditLog(t, 9)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	res := aud.GetObservedSTRs(&protocol.AuditingRequest{
+		DirInitSTRHash: dirInitHash,
+		StartEpoch:     0,
+		EndEpoch:       9,
+		Limit:          2,
+	})
+	token := res.DirectoryResponse.(*protocol.STRHistoryRange).NextToken
+	if token == nil {
+		t.Fatal("Expect a next token")
+	}
+
+	// a token issued for another directory, and truncated or padded tokens
+	otherHash := dirInitHash
+	otherHash[0] ^= 0xff
+	for _, bad := range [][]byte{
+		newPageToken(otherHash, 2, 9),
+		token[1:],
+		append(token, 0),
+	} {
+		res = aud.GetObservedSTRs(&protocol.AuditingRequest{
+			DirInitSTRHash: dirInitHash,
+			PageToken:      bad,
+		})
+		if res.Error != protocol.ErrMalformedMessage {
+			t.Fatal("Expect", protocol.ErrMalformedMessage, "got", res.Error)
+		}
+	}
+
+	// a token whose range exceeds the observed history
+	res = aud.GetObservedSTRs(&protocol.AuditingRequest{
+		DirInitSTRHash: dirInitHash,
+		PageToken:      newPageToken(dirInitHash, 2, 10),
+	})
+	if res.Error != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", res.Error)
+	}
+}
+
+func TestRePin(t *testing.T) {
+	_, aud, hist := NewTestAuditLog(t, 3)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	newSK, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPK, _ := newSK.Public()
+	d := directory.New(1, crypto.NewStaticTestVRFKey(), newSK, 10, true)
+	pinned := d.LatestSTR()
+	old, _ := aud.get(dirInitHash)
+	trace := new(auditor.TraceLog)
+	old.SetTraceSink(trace)
+
+	if _, err := aud.RePin(dirInitHash, "new-server", newPK, pinned,
+		false); err != auditor.ErrRePinNotConfirmed {
+		t.Fatal("Expect", auditor.ErrRePinNotConfirmed, "got", err)
+	}
+	if _, err := aud.RePin(dirInitHash, "new-server", staticPublicKey(t), pinned,
+		true); err != protocol.CheckBadSignature {
+		t.Fatal("Expect", protocol.CheckBadSignature, "got", err)
+	}
+
+	dropped, err := aud.RePin(dirInitHash, "new-server", newPK, pinned, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dropped) != len(hist) || !reflect.DeepEqual(dropped, hist) {
+		t.Fatal("Expect the dropped snapshots to be returned in order")
+	}
+	if _, ok := aud.get(dirInitHash); ok {
+		t.Fatal("Expect the old history to be removed")
+	}
+	newInitHash := auditor.ComputeDirectoryIdentity(pinned)
+	h, ok := aud.get(newInitHash)
+	if !ok || !reflect.DeepEqual(h.addrs, []string{"new-server"}) || h.VerifiedSTR() != pinned {
+		t.Fatal("Expect a new history pinning the new STR")
+	}
+	if len(trace.Events) != 1 || trace.Events[0].Step != auditor.TraceRePin ||
+		trace.Events[0].Epoch != 3 ||
+		!bytes.Equal(trace.Events[0].Want, dirInitHash[:]) ||
+		!bytes.Equal(trace.Events[0].Got, newInitHash[:]) {
+		t.Fatal("Expect the re-pin to be traced, got", trace.Events)
+	}
+	if h.TraceSink() != trace {
+		t.Fatal("Expect the new history to inherit the trace sink")
+	}
+	// audits still holding the dropped history fail
+	if err := old.Audit(protocol.NewSTRHistoryRange(hist[3:])); err != auditor.ErrQuarantined {
+		t.Fatal("Expect", auditor.ErrQuarantined, "got", err)
+	}
+
+	d.Update()
+	resp := protocol.NewSTRHistoryRange([]*protocol.DirSTR{d.LatestSTR()})
+	if err := aud.AuditId(newInitHash, resp); err != nil {
+		t.Fatal("Error auditing the re-pinned directory", err)
+	}
+}
+
+func TestRePinGenesisEpochMismatch(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 1)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	d.Update()
+	if _, err := aud.RePin(dirInitHash, "new-server", staticPublicKey(t), d.LatestSTR(),
+		true); err != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+	if _, ok := aud.get(dirInitHash); !ok {
+		t.Fatal("Expect the old history to be kept")
+	}
+}
+
+func staticPublicKey(t *testing.T) sign.PublicKey {
+	pk, ok := staticSigningKey.Public()
+	if !ok {
+		t.Fatal("Couldn't get the static public key")
+	}
+	return pk
+}
+
+// fakeClock replaces the audit log's clock for the duration of a test.
+type fakeClock struct {
+	t time.Time
+}
+
+func newFakeClock(t *testing.T) *fakeClock {
+	c := &fakeClock{t: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)}
+	now = func() time.Time { return c.t }
+	t.Cleanup(func() { now = time.Now })
+	return c
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func TestGetObservedSTRsByTime(t *testing.T) {
+	clock := newFakeClock(t)
+	t0 := clock.t
+	// epoch 0 is observed at t0, epoch i at t0 + i hours
+	d, aud, hist := NewTestAuditLog(t, 0)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	for i := 0; i < 4; i++ {
+		clock.advance(time.Hour)
+		d.Update()
+		resp := protocol.NewSTRHistoryRange([]*protocol.DirSTR{d.LatestSTR()})
+		if err := aud.AuditId(dirInitHash, resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name        string
+		start, end  time.Time
+		first, last uint64
+	}{
+		{"inner window", t0.Add(90 * time.Minute), t0.Add(3 * time.Hour), 2, 3},
+		{"starts before history", t0.Add(-time.Hour), t0.Add(time.Hour), 0, 1},
+		{"ends after history", t0.Add(3 * time.Hour), t0.Add(10 * time.Hour), 3, 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res := aud.GetObservedSTRsByTime(dirInitHash, tc.start, tc.end)
+			if err := res.ValidateFor(protocol.AuditType); err != nil {
+				t.Fatal(err)
+			}
+			strs := res.DirectoryResponse.(*protocol.STRHistoryRange).STR
+			if strs[0].Epoch != tc.first || strs[len(strs)-1].Epoch != tc.last ||
+				len(strs) != int(tc.last-tc.first+1) {
+				t.Fatal("Expect epochs", tc.first, "to", tc.last)
+			}
+		})
+	}
+
+	for _, tc := range []struct {
+		name       string
+		start, end time.Time
+	}{
+		{"before history", t0.Add(-2 * time.Hour), t0.Add(-time.Hour)},
+		{"after history", t0.Add(5 * time.Hour), t0.Add(6 * time.Hour)},
+		{"between STRs", t0.Add(61 * time.Minute), t0.Add(119 * time.Minute)},
+		{"inverted window", t0.Add(2 * time.Hour), t0.Add(time.Hour)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res := aud.GetObservedSTRsByTime(dirInitHash, tc.start, tc.end)
+			if res.Error != protocol.ErrMalformedMessage {
+				t.Fatal("Expect", protocol.ErrMalformedMessage, "got", res.Error)
+			}
+		})
+	}
+}
+
+func TestStaleDirectories(t *testing.T) {
+	clock := newFakeClock(t)
+	pk, _ := staticSigningKey.Public()
+	aud := New()
+	var dirs []*directory.ConiksDirectory
+	var ids [][crypto.HashSizeByte]byte
+	for i, h := range []crypto.Hasher{crypto.DefaultHasher,
+		crypto.GetHasher(crypto.SHA512_256ID)} {
+		d := directory.NewTestDirectoryWithHasher(t, h)
+		if err := aud.InitHistory(fmt.Sprintf("test-server-%d", i), pk,
+			[]*protocol.DirSTR{d.LatestSTR()}); err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, d)
+		ids = append(ids, auditor.ComputeDirectoryIdentity(d.LatestSTR()))
+	}
+
+	if stale := aud.StaleDirectories(time.Hour); len(stale) != 0 {
+		t.Fatal("Expect no stale directories, got", len(stale))
+	}
+
+	// only the first directory keeps publishing STRs
+	for i := 0; i < 3; i++ {
+		clock.advance(45 * time.Minute)
+		dirs[0].Update()
+		resp := protocol.NewSTRHistoryRange([]*protocol.DirSTR{dirs[0].LatestSTR()})
+		if err := aud.AuditId(ids[0], resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stale := aud.StaleDirectories(time.Hour)
+	if len(stale) != 1 || stale[0] != ids[1] {
+		t.Fatal("Expect only the silent directory to be stale")
+	}
+
+	clock.advance(2 * time.Hour)
+	if stale := aud.StaleDirectories(time.Hour); len(stale) != 2 {
+		t.Fatal("Expect both directories to be stale, got", len(stale))
+	}
+}
+
+func TestStaleDirectoriesCadence(t *testing.T) {
+	clock := newFakeClock(t)
+	pk, _ := staticSigningKey.Public()
+	aud := New()
+	var dirs []*directory.ConiksDirectory
+	var ids [][crypto.HashSizeByte]byte
+	// the first directory publishes every 10 minutes, the second daily
+	cadences := []time.Duration{10 * time.Minute, 24 * time.Hour}
+	for i, h := range []crypto.Hasher{crypto.DefaultHasher,
+		crypto.GetHasher(crypto.SHA512_256ID)} {
+		d := directory.NewTestDirectoryWithHasher(t, h)
+		if err := aud.InitHistoryWithCadence(fmt.Sprintf("test-server-%d", i), pk,
+			[]*protocol.DirSTR{d.LatestSTR()}, cadences[i]); err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, d)
+		ids = append(ids, auditor.ComputeDirectoryIdentity(d.LatestSTR()))
+	}
+
+	// the fast directory keeps up with its cadence for a while
+	for i := 0; i < 6; i++ {
+		clock.advance(10 * time.Minute)
+		dirs[0].Update()
+		resp := protocol.NewSTRHistoryRange([]*protocol.DirSTR{dirs[0].LatestSTR()})
+		if err := aud.AuditId(ids[0], resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the maximum gap doesn't apply to directories with a cadence
+	if stale := aud.StaleDirectories(time.Minute); len(stale) != 0 {
+		t.Fatal("Expect no stale directories, got", len(stale))
+	}
+
+	// the fast directory then misses more than two of its epochs,
+	// while the daily one is still within its cadence
+	clock.advance(30 * time.Minute)
+	stale := aud.StaleDirectories(time.Minute)
+	if len(stale) != 1 || stale[0] != ids[0] {
+		t.Fatal("Expect only the stalled directory to be stale")
+	}
+}
+
+func TestAuditStreamLargeHistory(t *testing.T) {
+	const numEpochs = 1000
+	d, snaps := newTestSnapshots(t, numEpochs)
+	pk, _ := staticSigningKey.Public()
+	dirInitHash := auditor.ComputeDirectoryIdentity(snaps[0])
+
+	// the non-streaming path
+	batch := New()
+	if err := batch.InitHistory("test-server", pk, snaps[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.AuditId(dirInitHash,
+		protocol.NewSTRHistoryRange(snaps[1:])); err != nil {
+		t.Fatal(err)
+	}
+
+	aud := New()
+	if err := aud.InitHistory("test-server", pk, snaps[:1]); err != nil {
+		t.Fatal(err)
+	}
+	strs := make(chan *protocol.DirSTR)
+	go func() {
+		defer close(strs)
+		for _, str := range snaps[1:] {
+			strs <- str
+		}
+	}()
+	if err := aud.AuditStream(context.Background(), dirInitHash, strs); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := aud.get(dirInitHash)
+	want, _ := batch.get(dirInitHash)
+	if got.VerifiedSTR().Epoch != d.LatestSTR().Epoch ||
+		!reflect.DeepEqual(got.VerifiedSTR(), want.VerifiedSTR()) {
+		t.Fatal("Expect the streamed tip to match the non-streaming path")
+	}
+	if len(got.snapshots) != numEpochs+1 {
+		t.Fatal("Expect", numEpochs+1, "snapshots, got", len(got.snapshots))
+	}
+}
+
+func TestAuditStreamCanceled(t *testing.T) {
+	_, aud, hist := NewTestAuditLog(t, 0)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := aud.AuditStream(ctx, dirInitHash,
+		make(chan *protocol.DirSTR)); err != context.Canceled {
+		t.Fatal("Expect", context.Canceled, "got", err)
+	}
+}
+
+func TestEquivocationProof(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 5)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	if _, ok := aud.EquivocationProof(dirInitHash); ok {
+		t.Fatal("Unexpected equivocation proof")
+	}
+
+	fork := d.ForkAt(t, 2)
+	fork.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("evil")})
+	for i := 0; i < 4; i++ {
+		fork.Update()
+	}
+	resp := fork.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 0,
+		EndEpoch:   fork.LatestSTR().Epoch})
+	if err := aud.AuditId(dirInitHash, resp); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+
+	proof, ok := aud.EquivocationProof(dirInitHash)
+	if !ok {
+		t.Fatal("Expect an equivocation proof")
+	}
+	if proof.STR1.Epoch != 3 {
+		t.Fatal("Expect the proof for epoch 3, got", proof.STR1.Epoch)
+	}
+
+	// the proof can be verified standalone, e.g. after publishing it
+	bs, err := json.Marshal(proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	published := new(auditor.EquivocationProof)
+	if err := json.Unmarshal(bs, published); err != nil {
+		t.Fatal(err)
+	}
+	if err := auditor.VerifyEquivocationProof(published, staticPublicKey(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	otherSK, _ := sign.GenerateKey(nil)
+	otherPK, _ := otherSK.Public()
+	if err := auditor.VerifyEquivocationProof(published, otherPK); err != protocol.CheckBadSignature {
+		t.Fatal("Expect", protocol.CheckBadSignature, "got", err)
+	}
+	published.STR2 = published.STR1
+	if err := auditor.VerifyEquivocationProof(published, staticPublicKey(t)); err != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+}
+
+func TestAuditPanicQuarantinesDirectory(t *testing.T) {
+	aud := New()
+	var dirs []*directory.ConiksDirectory
+	var ids [][crypto.HashSizeByte]byte
+	for i, h := range []crypto.Hasher{crypto.DefaultHasher,
+		crypto.GetHasher(crypto.SHA512_256ID)} {
+		d := directory.NewTestDirectoryWithHasher(t, h)
+		if err := aud.InitHistory(fmt.Sprintf("test-server-%d", i),
+			staticPublicKey(t), []*protocol.DirSTR{d.LatestSTR()}); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, auditor.ComputeDirectoryIdentity(d.LatestSTR()))
+		d.Update()
+		dirs = append(dirs, d)
+	}
+
+	// corrupt the first directory's history, so that auditing it panics
+	bad, _ := aud.get(ids[0])
+	bad.snapshots = nil
+
+	errs := make([]error, len(dirs))
+	var wg sync.WaitGroup
+	for i, d := range dirs {
+		wg.Add(1)
+		go func(i int, d *directory.ConiksDirectory) {
+			defer wg.Done()
+			resp := protocol.NewSTRHistoryRange([]*protocol.DirSTR{d.LatestSTR()})
+			errs[i] = aud.AuditId(ids[i], resp)
+		}(i, d)
+	}
+	wg.Wait()
+
+	if errs[0] != auditor.ErrQuarantined {
+		t.Fatal("Expect", auditor.ErrQuarantined, "got", errs[0])
+	}
+	if errs[1] != nil {
+		t.Fatal("Expect the healthy directory to be audited, got", errs[1])
+	}
+	if res := aud.GetLatestSTR(ids[0]); res.Error != protocol.ErrAuditLog {
+		t.Fatal("Expect", protocol.ErrAuditLog, "got", res.Error)
+	}
+	if res := aud.GetLatestSTR(ids[1]); res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+
+	// the quarantined directory stays quarantined
+	dirs[0].Update()
+	resp := protocol.NewSTRHistoryRange([]*protocol.DirSTR{dirs[0].LatestSTR()})
+	if err := aud.AuditId(ids[0], resp); err != auditor.ErrQuarantined {
+		t.Fatal("Expect", auditor.ErrQuarantined, "got", err)
+	}
+}
+
+func TestForEachSnapshot(t *testing.T) {
+	_, aud, hist := NewTestAuditLog(t, 9)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	var epochs []uint64
+	if err := aud.ForEachSnapshot(dirInitHash, func(str *protocol.DirSTR) bool {
+		epochs = append(epochs, str.Epoch)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(epochs) != len(hist) {
+		t.Fatal("Expect", len(hist), "snapshots, got", len(epochs))
+	}
+	for i, ep := range epochs {
+		if ep != uint64(i) {
+			t.Fatal("Expect the snapshots in chronological order, got", epochs)
+		}
+	}
+
+	// stop early
+	epochs = nil
+	aud.ForEachSnapshot(dirInitHash, func(str *protocol.DirSTR) bool {
+		epochs = append(epochs, str.Epoch)
+		return str.Epoch < 3
+	})
+	if !reflect.DeepEqual(epochs, []uint64{0, 1, 2, 3}) {
+		t.Fatal("Expect the iteration to stop at epoch 3, got", epochs)
+	}
+
+	var unknown [crypto.HashSizeByte]byte
+	if err := aud.ForEachSnapshot(unknown, func(*protocol.DirSTR) bool {
+		return true
+	}); err != auditor.ErrUnknownDirectory {
+		t.Fatal("Expect", auditor.ErrUnknownDirectory, "got", err)
+	}
+}
+
+func TestDirectories(t *testing.T) {
+	pk, _ := staticSigningKey.Public()
+	aud := New()
+	if dirs := aud.Directories(); len(dirs) != 0 {
+		t.Fatal("Expect no directories, got", len(dirs))
+	}
+	var ids [][crypto.HashSizeByte]byte
+	for i, h := range []crypto.Hasher{crypto.DefaultHasher,
+		crypto.GetHasher(crypto.SHA512_256ID)} {
+		d := directory.NewTestDirectoryWithHasher(t, h)
+		if err := aud.InitHistory(fmt.Sprintf("test-server-%d", i), pk,
+			[]*protocol.DirSTR{d.LatestSTR()}); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, auditor.ComputeDirectoryIdentity(d.LatestSTR()))
+	}
+	if bytes.Compare(ids[0][:], ids[1][:]) > 0 {
+		ids[0], ids[1] = ids[1], ids[0]
+	}
+	if dirs := aud.Directories(); !reflect.DeepEqual(dirs, ids) {
+		t.Error("Expect the directories in ascending order, got", dirs)
+	}
+}
+
+func TestSnapshotConcurrentAudit(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 9)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	for i := 0; i < 10; i++ {
+		d.Update()
+	}
+	snap := aud.Snapshot()
+
+	// audit new epochs while walking the snapshot
+	done := make(chan error)
+	go func() {
+		for ep := uint64(10); ep <= d.LatestSTR().Epoch; ep++ {
+			resp := d.GetSTRHistory(&protocol.STRHistoryRequest{
+				StartEpoch: ep,
+				EndEpoch:   ep})
+			if err := aud.AuditId(dirInitHash, resp); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for i := 0; i < 10; i++ {
+		var epochs []uint64
+		if err := snap.ForEachSnapshot(dirInitHash, func(str *protocol.DirSTR) bool {
+			epochs = append(epochs, str.Epoch)
+			return true
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if len(epochs) != len(hist) || epochs[len(epochs)-1] != 9 {
+			t.Fatal("Expect the snapshot to be stable, got", epochs)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// the log has moved on, but the snapshot hasn't
+	res := snap.GetLatestSTR(dirInitHash)
+	if err := res.ValidateFor(protocol.AuditType); err != nil {
+		t.Fatal(err)
+	}
+	if str := res.DirectoryResponse.(*protocol.STRHistoryRange).STR[0]; str.Epoch != 9 {
+		t.Error("Expect the snapshot's latest epoch 9, got", str.Epoch)
+	}
+	res = snap.GetObservedSTRs(&protocol.AuditingRequest{
+		DirInitSTRHash: dirInitHash,
+		StartEpoch:     0,
+		EndEpoch:       10})
+	if res.Error != protocol.ErrMalformedMessage {
+		t.Error("Expect epoch 10 not to be in the snapshot, got", res.Error)
+	}
+	res = aud.GetLatestSTR(dirInitHash)
+	if str := res.DirectoryResponse.(*protocol.STRHistoryRange).STR[0]; str.Epoch != 19 {
+		t.Error("Expect the log's latest epoch 19, got", str.Epoch)
+	}
+	if !reflect.DeepEqual(snap.Directories(), aud.Directories()) {
+		t.Error("Expect the same directories in the snapshot")
+	}
+}
+
+func TestSnapshotDuringAudit(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 0)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	var resps []*protocol.Response
+	for i := 0; i < 9; i++ {
+		d.Update()
+		resps = append(resps, d.GetSTRHistory(&protocol.STRHistoryRequest{
+			StartEpoch: d.LatestSTR().Epoch,
+			EndEpoch:   d.LatestSTR().Epoch}))
+	}
+
+	// take snapshots while new epochs are audited
+	done := make(chan error)
+	go func() {
+		for _, resp := range resps {
+			if err := aud.AuditId(dirInitHash, resp); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for i := 0; i < 20; i++ {
+		snap := aud.Snapshot()
+		res := snap.GetLatestSTR(dirInitHash)
+		latest := res.DirectoryResponse.(*protocol.STRHistoryRange).STR[0].Epoch
+		var epochs []uint64
+		snap.ForEachSnapshot(dirInitHash, func(str *protocol.DirSTR) bool {
+			epochs = append(epochs, str.Epoch)
+			return true
+		})
+		if uint64(len(epochs)) != latest+1 || epochs[len(epochs)-1] != latest {
+			t.Fatal("Expect a consistent snapshot up to epoch", latest, "got", epochs)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCachedDirectoryIdentity(t *testing.T) {
+	_, aud, hist := NewTestAuditLog(t, 3)
+	// a fresh copy of the initial STR has no cached hash
+	fresh := protocol.NewDirSTR(hist[0].SignedTreeRoot)
+	dirInitHash := auditor.ComputeDirectoryIdentity(fresh)
+	h, ok := aud.get(dirInitHash)
+	if !ok {
+		t.Fatal("Expect the directory to be found by its identity")
+	}
+	if h.id != dirInitHash {
+		t.Fatal("Expect the cached identity", h.id, "to equal", dirInitHash)
+	}
+	if h.copy().id != dirInitHash {
+		t.Error("Expect a copy of the history to keep its identity")
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	clock := newFakeClock(t)
+	_, aud, hist := NewTestAuditLog(t, 3)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	auditorSK, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditorPK, _ := auditorSK.Public()
+
+	c, err := aud.Checkpoint(dirInitHash, auditorSK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.DirInitSTRHash != dirInitHash || c.Epoch != 3 ||
+		!bytes.Equal(c.STRHash, hist[3].Hash()) ||
+		c.Time != uint64(clock.t.Unix()) {
+		t.Fatal("Unexpected checkpoint", c)
+	}
+	if err := auditor.VerifyCheckpoint(c, auditorPK); err != nil {
+		t.Fatal(err)
+	}
+
+	// a checkpoint doesn't verify under another auditor's key
+	otherSK, _ := sign.GenerateKey(nil)
+	otherPK, _ := otherSK.Public()
+	if err := auditor.VerifyCheckpoint(c, otherPK); err != protocol.CheckBadSignature {
+		t.Error("Expect", protocol.CheckBadSignature, "got", err)
+	}
+
+	// tampering with any field invalidates the signature
+	tamper := []func(c *auditor.Checkpoint){
+		func(c *auditor.Checkpoint) { c.DirInitSTRHash[0] ^= 1 },
+		func(c *auditor.Checkpoint) { c.Epoch++ },
+		func(c *auditor.Checkpoint) { c.STRHash = hist[2].Hash() },
+		func(c *auditor.Checkpoint) { c.Time-- },
+		func(c *auditor.Checkpoint) { c.Signature = otherSK.Sign(c.Serialize()) },
+	}
+	for i, f := range tamper {
+		tampered := *c
+		f(&tampered)
+		if err := auditor.VerifyCheckpoint(&tampered, auditorPK); err != protocol.CheckBadSignature {
+			t.Error(i, "Expect", protocol.CheckBadSignature, "got", err)
+		}
+	}
+	if err := auditor.VerifyCheckpoint(nil, auditorPK); err != protocol.ErrMalformedMessage {
+		t.Error("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+
+	if _, err := aud.Checkpoint([crypto.HashSizeByte]byte{}, auditorSK); err != auditor.ErrUnknownDirectory {
+		t.Error("Expect", auditor.ErrUnknownDirectory, "got", err)
+	}
+}
+
+func TestInitHistoryFromCheckpoint(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 3)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	auditorSK, _ := sign.GenerateKey(nil)
+	auditorPK, _ := auditorSK.Public()
+	c, err := aud.Checkpoint(dirInitHash, auditorSK)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a checkpoint for another STR is rejected
+	newAud := New()
+	if err := newAud.InitHistoryFromCheckpoint("test-server", staticPublicKey(t),
+		auditorPK, c, hist[0], hist[2]); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+	tampered := *c
+	tampered.Epoch = 2
+	if err := newAud.InitHistoryFromCheckpoint("test-server", staticPublicKey(t),
+		auditorPK, &tampered, hist[0], hist[2]); err != protocol.CheckBadSignature {
+		t.Fatal("Expect", protocol.CheckBadSignature, "got", err)
+	}
+	if len(newAud) != 0 {
+		t.Fatal("Expect no history to be created")
+	}
+
+	// bootstrap from the checkpoint and keep auditing from there
+	if err := newAud.InitHistoryFromCheckpoint("test-server", staticPublicKey(t),
+		auditorPK, c, hist[0], hist[3]); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+	d.Update()
+	resp := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 4,
+		EndEpoch:   5})
+	if err := newAud.AuditId(dirInitHash, resp); err != nil {
+		t.Fatal(err)
+	}
+	h, _ := newAud.get(dirInitHash)
+	if h.VerifiedSTR().Epoch != 5 {
+		t.Error("Expect the verified epoch 5, got", h.VerifiedSTR().Epoch)
+	}
+}
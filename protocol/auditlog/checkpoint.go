@@ -0,0 +1,77 @@
+// This module implements exporting a directory history as a signed
+// auditor checkpoint, and bootstrapping a new history from one.
+
+package auditlog
+
+import (
+	"bytes"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+// Checkpoint returns the auditor.Checkpoint stating that, as of now,
+// the auditor has verified h up to its latest verified STR, signed
+// with the auditor's signing key auditorKey.
+func (h *directoryHistory) Checkpoint(auditorKey sign.PrivateKey) *auditor.Checkpoint {
+	return auditor.NewCheckpoint(h.id, h.VerifiedSTR(),
+		uint64(now().Unix()), auditorKey)
+}
+
+// Checkpoint returns the checkpoint of the history of the CONIKS
+// directory identified by dirInitHash (see
+// directoryHistory.Checkpoint()), signed with auditorKey.
+// Checkpoint() returns auditor.ErrUnknownDirectory if the auditor
+// doesn't have a history for the directory, and auditor.ErrQuarantined
+// if the history is quarantined (see Audit()).
+func (l ConiksAuditLog) Checkpoint(dirInitHash [crypto.HashSizeByte]byte,
+	auditorKey sign.PrivateKey) (*auditor.Checkpoint, error) {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return nil, auditor.ErrUnknownDirectory
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.quarantined {
+		return nil, auditor.ErrQuarantined
+	}
+	return h.Checkpoint(auditorKey), nil
+}
+
+// InitHistoryFromCheckpoint creates a new directory history for the key
+// directory addr from the checkpoint c of a trusted auditor, rather
+// than from the directory's entire history (see InitHistoryVerified()).
+// The history starts with the directory's initial STR initSTR, followed
+// by the STR str the checkpoint was created for; new STRs can then be
+// audited from str onward.
+// InitHistoryFromCheckpoint() returns an error if c doesn't verify
+// under the auditor's public key auditorKey (see
+// auditor.VerifyCheckpoint()), ErrMalformedMessage if initSTR or str is
+// nil or initSTR is malformed (see auditor.DirectoryIdentity()),
+// CheckBadSTR if initSTR or str isn't the STR the checkpoint was
+// created for, or any error returned by InitHistoryVerified().
+func (l ConiksAuditLog) InitHistoryFromCheckpoint(addr string,
+	signKey sign.PublicKey, auditorKey sign.PublicKey, c *auditor.Checkpoint,
+	initSTR, str *protocol.DirSTR) error {
+	if err := auditor.VerifyCheckpoint(c, auditorKey); err != nil {
+		return err
+	}
+	if str == nil {
+		return protocol.ErrMalformedMessage
+	}
+	dirInitHash, err := auditor.DirectoryIdentity(initSTR)
+	if err != nil {
+		return err
+	}
+	if dirInitHash != c.DirInitSTRHash ||
+		str.Epoch != c.Epoch || !bytes.Equal(str.Hash(), c.STRHash) {
+		return protocol.CheckBadSTR
+	}
+	snaps := []*protocol.DirSTR{initSTR}
+	if str.Epoch > 0 {
+		snaps = append(snaps, str)
+	}
+	return l.InitHistoryVerified(addr, signKey, snaps)
+}
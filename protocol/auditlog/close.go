@@ -0,0 +1,63 @@
+// This module implements shutting down an LRUAuditLog gracefully, so
+// that an auditor process can stop without losing audited STRs or
+// leaving a partially applied range behind.
+
+package auditlog
+
+import (
+	"context"
+
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+// beginIngest registers an ingest of STRs into c, which Close() waits
+// for, or returns auditor.ErrClosed if c has been closed. The caller
+// must call c.inflight.Done() once the ingest is done.
+func (c *LRUAuditLog) beginIngest() error {
+	c.closeMu.Lock()
+	defer c.closeMu.Unlock()
+	if c.closed {
+		return auditor.ErrClosed
+	}
+	c.inflight.Add(1)
+	return nil
+}
+
+// Close shuts c down gracefully: it stops accepting new ingests, i.e.
+// InitHistory() and AuditId() return auditor.ErrClosed from then on,
+// waits for the ingests accepted before to finish, and then saves the
+// observed STRs of all directories held in memory to the store, so
+// that the store holds every completed audit. Since an audit either
+// applies an entire range or leaves the history unchanged, no partially
+// applied range is ever saved. The log can still be read after Close().
+//
+// If ctx is done before the accepted ingests finish, Close() returns
+// ctx.Err() without saving anything; it may be called again to retry.
+// Otherwise, it returns the first error returned by the store, if any.
+func (c *LRUAuditLog) Close(ctx context.Context) error {
+	c.closeMu.Lock()
+	c.closed = true
+	c.closeMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		c.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	for dirInitHash := range c.entries {
+		h, _ := c.log.get(dirInitHash)
+		if serr := c.store.Save(dirInitHash, h.stored()); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	return err
+}
@@ -0,0 +1,93 @@
+package auditlog
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+func TestLRUAuditLogClose(t *testing.T) {
+	l, store, dirs := newTestLRUAuditLog(t, 4, 2)
+
+	// audit a range of two new STRs for every directory concurrently,
+	// and close the log while the audits are in flight
+	var mu sync.Mutex
+	results := make(map[[crypto.HashSizeByte]byte]error, len(dirs))
+	var wg sync.WaitGroup
+	for dirInitHash, h := range dirs {
+		h.d.Update()
+		h.d.Update()
+		res := h.d.GetSTRHistory(&protocol.STRHistoryRequest{
+			StartEpoch: h.strs[len(h.strs)-1].Epoch + 1,
+			EndEpoch:   h.d.LatestSTR().Epoch})
+		wg.Add(1)
+		go func(dirInitHash [crypto.HashSizeByte]byte) {
+			defer wg.Done()
+			err := l.AuditId(dirInitHash, res)
+			mu.Lock()
+			results[dirInitHash] = err
+			mu.Unlock()
+		}(dirInitHash)
+	}
+	if err := l.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	for dirInitHash, h := range dirs {
+		want := len(h.strs)
+		switch err := results[dirInitHash]; err {
+		case nil:
+			want += 2
+		case auditor.ErrClosed:
+		default:
+			t.Fatal(err)
+		}
+		saved, err := store.Load(dirInitHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(saved.STRs) != want {
+			t.Error("Expect", want, "persisted STRs, got", len(saved.STRs))
+		}
+	}
+
+	// the closed log rejects new ingests, but can still be read
+	for dirInitHash, h := range dirs {
+		res := protocol.NewSTRHistoryRange([]*protocol.DirSTR{h.d.LatestSTR()})
+		if err := l.AuditId(dirInitHash, res); err != auditor.ErrClosed {
+			t.Error("Expect", auditor.ErrClosed, "got", err)
+		}
+		checkObservedSTRs(t, l, dirInitHash, h)
+		break
+	}
+}
+
+func TestLRUAuditLogCloseTimeout(t *testing.T) {
+	l, store, _ := newTestLRUAuditLog(t, 2, 2)
+
+	// an ingest accepted before the log is closed never finishes
+	if err := l.beginIngest(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Close(ctx); err != context.Canceled {
+		t.Fatal("Expect", context.Canceled, "got", err)
+	}
+	if len(store.saved) != 0 {
+		t.Fatal("Expect nothing to be saved before the ingest finishes")
+	}
+
+	l.inflight.Done()
+	if err := l.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.saved) != 2 {
+		t.Error("Expect both directories to be saved, got", len(store.saved))
+	}
+}
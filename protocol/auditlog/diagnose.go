@@ -0,0 +1,80 @@
+// This module implements a diagnostic variant of GetObservedSTRs(),
+// which reports the problems in a range of a directory's history
+// rather than failing on the first one.
+
+package auditlog
+
+import (
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+// An EpochProblem reports why the auditor can't serve the STR for
+// Epoch: Err is auditor.ErrSTRMissing if the auditor's history doesn't
+// include the STR, or the error with which the STR fails verification.
+type EpochProblem struct {
+	Epoch uint64
+	Err   error
+}
+
+// A RangeDiagnosis lists the STRs an auditor has for a requested range
+// of a directory's history in chronological order, and the Problems
+// with the remaining epochs of the range.
+type RangeDiagnosis struct {
+	STRs     []*protocol.DirSTR
+	Problems []EpochProblem
+}
+
+// DiagnoseObservedSTRs returns a RangeDiagnosis of the epoch range
+// [req.StartEpoch, req.EndEpoch] of the history of the directory
+// identified by req.DirInitSTRHash, e.g. for an operator tool
+// inspecting a history restored with gaps (see InitHistoryVerified()).
+// Unlike GetObservedSTRs(), which serves either the entire range or
+// nothing, DiagnoseObservedSTRs() returns the STRs it has, and reports
+// each missing epoch, as well as each STR whose signature doesn't
+// verify or which doesn't chain to the STR of the previous epoch. It
+// also inspects quarantined histories, and ignores the request's
+// SinceSTRHash, Limit and PageToken.
+// DiagnoseObservedSTRs() returns auditor.ErrUnknownDirectory if the
+// auditor doesn't have a history for the directory, or
+// ErrMalformedMessage if the range is empty or extends past the
+// latest verified epoch.
+func (l ConiksAuditLog) DiagnoseObservedSTRs(req *protocol.AuditingRequest) (*RangeDiagnosis, error) {
+	h, ok := l.get(req.DirInitSTRHash)
+	if !ok {
+		return nil, auditor.ErrUnknownDirectory
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if req.StartEpoch > req.EndEpoch || req.EndEpoch > h.VerifiedSTR().Epoch {
+		return nil, protocol.ErrMalformedMessage
+	}
+
+	diag := new(RangeDiagnosis)
+	for ep := req.StartEpoch; ep <= req.EndEpoch; ep++ {
+		str, ok := h.snapshots[ep]
+		if !ok {
+			diag.Problems = append(diag.Problems,
+				EpochProblem{Epoch: ep, Err: auditor.ErrSTRMissing})
+			continue
+		}
+		if err := h.diagnose(str); err != nil {
+			diag.Problems = append(diag.Problems, EpochProblem{Epoch: ep, Err: err})
+			continue
+		}
+		diag.STRs = append(diag.STRs, str)
+	}
+	return diag, nil
+}
+
+// diagnose verifies the signature of str, and, if h includes the STR
+// of the previous epoch, the consistency of str with that STR.
+func (h *directoryHistory) diagnose(str *protocol.DirSTR) error {
+	if err := h.VerifySTR(str); err != nil {
+		return err
+	}
+	if prev, ok := h.snapshots[str.Epoch-1]; ok && str.Epoch > 0 {
+		return h.VerifySTRRange(prev, []*protocol.DirSTR{str})
+	}
+	return nil
+}
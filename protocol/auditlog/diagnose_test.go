@@ -0,0 +1,88 @@
+package auditlog
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+func TestDiagnoseMissingEpoch(t *testing.T) {
+	_, snaps := newTestSnapshots(t, 4)
+	pk, _ := staticSigningKey.Public()
+	aud := New()
+	gapped := []*protocol.DirSTR{snaps[0], snaps[1], snaps[3], snaps[4]}
+	if err := aud.InitHistoryVerified("test-server", pk, gapped); err != nil {
+		t.Fatal(err)
+	}
+	req := &protocol.AuditingRequest{
+		DirInitSTRHash: auditor.ComputeDirectoryIdentity(snaps[0]),
+		StartEpoch:     uint64(1),
+		EndEpoch:       uint64(4)}
+
+	diag, err := aud.DiagnoseObservedSTRs(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diag.Problems) != 1 || diag.Problems[0].Epoch != 2 ||
+		diag.Problems[0].Err != auditor.ErrSTRMissing {
+		t.Fatal("Expect epoch 2 to be reported missing, got", diag.Problems)
+	}
+	if len(diag.STRs) != 3 || diag.STRs[0].Epoch != 1 ||
+		diag.STRs[1].Epoch != 3 || diag.STRs[2].Epoch != 4 {
+		t.Fatal("Expect the STRs for epochs 1, 3 and 4, got", diag.STRs)
+	}
+
+	// the strict path still fails on the missing epoch
+	if res := aud.GetObservedSTRs(req); res.Error != protocol.ErrAuditLog {
+		t.Error("Expect", protocol.ErrAuditLog, "got", res.Error)
+	}
+}
+
+func TestDiagnoseBadSnapshot(t *testing.T) {
+	_, snaps := newTestSnapshots(t, 4)
+	pk, _ := staticSigningKey.Public()
+
+	// InitHistory() trusts the snapshots, so a tampered one is kept
+	tampered := *snaps[3].SignedTreeRoot
+	tampered.TreeHash = append([]byte{}, tampered.TreeHash...)
+	tampered.TreeHash[0] ^= 1
+	snaps[3] = &protocol.DirSTR{SignedTreeRoot: &tampered, Policies: snaps[3].Policies}
+	aud := New()
+	if err := aud.InitHistory("test-server", pk, snaps); err != nil {
+		t.Fatal(err)
+	}
+
+	diag, err := aud.DiagnoseObservedSTRs(&protocol.AuditingRequest{
+		DirInitSTRHash: auditor.ComputeDirectoryIdentity(snaps[0]),
+		StartEpoch:     uint64(0),
+		EndEpoch:       uint64(4)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diag.Problems) != 1 || diag.Problems[0].Epoch != 3 ||
+		diag.Problems[0].Err != protocol.CheckBadSignature {
+		t.Fatal("Expect epoch 3 to fail verification, got", diag.Problems)
+	}
+	if len(diag.STRs) != 4 {
+		t.Error("Expect the other 4 STRs, got", len(diag.STRs))
+	}
+}
+
+func TestDiagnoseBadRequest(t *testing.T) {
+	_, aud, hist := NewTestAuditLog(t, 0)
+	if _, err := aud.DiagnoseObservedSTRs(&protocol.AuditingRequest{
+		EndEpoch: 0}); err != auditor.ErrUnknownDirectory {
+		t.Error("Expect", auditor.ErrUnknownDirectory, "got", err)
+	}
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	latest := hist[len(hist)-1].Epoch
+	for _, r := range [][2]uint64{{2, 1}, {0, latest + 1}} {
+		if _, err := aud.DiagnoseObservedSTRs(&protocol.AuditingRequest{
+			DirInitSTRHash: dirInitHash,
+			StartEpoch:     r[0],
+			EndEpoch:       r[1]}); err != protocol.ErrMalformedMessage {
+			t.Error("Expect", protocol.ErrMalformedMessage, "for", r, "got", err)
+		}
+	}
+}
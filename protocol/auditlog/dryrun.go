@@ -0,0 +1,51 @@
+// This module implements dry-run audits, which tell whether a range of
+// STRs would pass an audit without changing the audit log, e.g. for an
+// operator's pre-flight checks of a range before committing it.
+
+package auditlog
+
+import (
+	"context"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+// DryAudit runs all the checks Audit() runs on the range of STRs in msg,
+// and returns the error Audit() would return, but leaves h unchanged:
+// neither h's verified STR and observed snapshots, nor its reorder
+// buffer, equivocation proof or quarantine are updated.
+// DryAudit() audits a copy of h, so it takes time linear in the number
+// of observed snapshots. The copy doesn't share h's cache of verified
+// signatures (see auditor.AudState.CacheSTRSignatures()) and doesn't
+// trace its checks.
+func (h *directoryHistory) DryAudit(msg *protocol.Response) error {
+	return h.DryAuditContext(context.Background(), msg)
+}
+
+// DryAuditContext is like DryAudit but aborts the checks with ctx.Err()
+// if ctx is done, as AuditContext() does.
+func (h *directoryHistory) DryAuditContext(ctx context.Context, msg *protocol.Response) error {
+	h.mu.Lock()
+	c := h.copy()
+	h.mu.Unlock()
+	c.CacheSTRSignatures(false)
+	c.SetTraceSink(nil)
+	return c.AuditContext(ctx, msg)
+}
+
+// DryAuditId runs the checks AuditId() runs on the range of STRs in msg
+// for the CONIKS directory identified by dirInitHash, without changing
+// the audit log l (see directoryHistory.DryAudit()).
+// DryAuditId() returns auditor.ErrUnknownDirectory if the auditor doesn't
+// have a history for the directory, or the error Audit() would return
+// otherwise.
+func (l ConiksAuditLog) DryAuditId(dirInitHash [crypto.HashSizeByte]byte,
+	msg *protocol.Response) error {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return auditor.ErrUnknownDirectory
+	}
+	return h.DryAudit(msg)
+}
@@ -0,0 +1,129 @@
+package auditlog
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+// checkUnchanged checks that h still has the verified STR of the given
+// epoch, numSnaps snapshots and no buffered STRs or equivocation proof.
+func checkUnchanged(t *testing.T, h *directoryHistory, epoch uint64, numSnaps int) {
+	if h.VerifiedSTR().Epoch != epoch {
+		t.Error("Expect the verified epoch to stay", epoch, "got", h.VerifiedSTR().Epoch)
+	}
+	if len(h.snapshots) != numSnaps {
+		t.Error("Expect", numSnaps, "snapshots, got", len(h.snapshots))
+	}
+	if len(h.pending) != 0 {
+		t.Error("Expect no buffered STRs, got", len(h.pending))
+	}
+	if _, ok := h.EquivocationProof(); ok || h.quarantined {
+		t.Error("Unexpected equivocation proof")
+	}
+}
+
+func TestDryAuditPassing(t *testing.T) {
+	// create basic test directory and audit log with 4 STRs
+	d, aud, hist := NewTestAuditLog(t, 3)
+	h, _ := aud.get(auditor.ComputeDirectoryIdentity(hist[0]))
+	d.Update()
+	d.Update()
+
+	resp := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 4,
+		EndEpoch:   5})
+	if err := h.DryAudit(resp); err != nil {
+		t.Fatal(err)
+	}
+	checkUnchanged(t, h, 3, 4)
+
+	// the range still passes a real audit
+	if err := h.Audit(resp); err != nil {
+		t.Fatal(err)
+	}
+	if h.VerifiedSTR().Epoch != 5 || len(h.snapshots) != 6 {
+		t.Fatal("Expect the range to be audited")
+	}
+}
+
+func TestDryAuditFailing(t *testing.T) {
+	// create basic test directory and audit log with 4 STRs
+	d, aud, hist := NewTestAuditLog(t, 3)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	h, _ := aud.get(dirInitHash)
+
+	// a conflicting STR for an observed epoch
+	fork := d.ForkAt(t, 2)
+	fork.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("evil")})
+	fork.Update()
+	fork.Update()
+	d.Update()
+	d.Update()
+
+	for _, tc := range []struct {
+		name string
+		resp func() *protocol.Response
+		want error
+	}{
+		{"gap", func() *protocol.Response {
+			return d.GetSTRHistory(&protocol.STRHistoryRequest{
+				StartEpoch: 5,
+				EndEpoch:   5})
+		}, auditor.ErrRangeGap},
+		{"forged", func() *protocol.Response {
+			resp := d.GetSTRHistory(&protocol.STRHistoryRequest{
+				StartEpoch: 4,
+				EndEpoch:   5})
+			strs := resp.DirectoryResponse.(*protocol.STRHistoryRange).STR
+			str := *strs[0].SignedTreeRoot
+			str.Signature = append([]byte{}, str.Signature...)
+			str.Signature[0] ^= 1
+			strs[0] = &protocol.DirSTR{SignedTreeRoot: &str, Policies: strs[0].Policies}
+			return resp
+		}, protocol.CheckBadSignature},
+		{"conflict", func() *protocol.Response {
+			return fork.GetSTRHistory(&protocol.STRHistoryRequest{
+				StartEpoch: 1,
+				EndEpoch:   4})
+		}, protocol.CheckBadSTR},
+	} {
+		if err := h.DryAudit(tc.resp()); err != tc.want {
+			t.Error(tc.name, ": Expect", tc.want, "got", err)
+		}
+		checkUnchanged(t, h, 3, 4)
+	}
+
+	if err := aud.DryAuditId(dirInitHash, d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 4,
+		EndEpoch:   5})); err != nil {
+		t.Fatal(err)
+	}
+	checkUnchanged(t, h, 3, 4)
+
+	var unknown [crypto.HashSizeByte]byte
+	if err := aud.DryAuditId(unknown, nil); err != auditor.ErrUnknownDirectory {
+		t.Error("Expect", auditor.ErrUnknownDirectory, "got", err)
+	}
+}
+
+func TestDryAuditReorderBuffer(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 0)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	if err := aud.SetReorderBuffer(dirInitHash, 4); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+	d.Update()
+	h, _ := aud.get(dirInitHash)
+
+	resp := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 2,
+		EndEpoch:   2})
+	if err := h.DryAudit(resp); err != nil {
+		t.Fatal(err)
+	}
+	checkUnchanged(t, h, 0, 1)
+}
@@ -0,0 +1,74 @@
+// This module implements exporting an auditor's observed STRs for
+// analysis, e.g. in a spreadsheet. The export isn't meant to be
+// re-verified, and never modifies the audit log.
+
+package auditlog
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"io"
+	"strconv"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+// csvHeader names the columns of the rows written by ExportCSV().
+var csvHeader = []string{"epoch", "timestamp", "tree_hash", "str_hash"}
+
+// ExportCSV writes the observed STRs of h to w in CSV format: a header
+// row naming the columns, followed by one row per observed STR in
+// chronological order with the STR's epoch, its timestamp in seconds
+// since the Unix epoch (empty if the STR isn't timestamped), the hex
+// encoding of its tree's root hash, and the hex encoding of its hash
+// (see protocol.DirSTR.Hash()). STRs don't record the size of their
+// trees, so the export doesn't include it.
+// ExportCSV() returns the first error encountered writing to w.
+func (h *directoryHistory) ExportCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	var err error
+	h.ForEachSnapshot(func(str *protocol.DirSTR) bool {
+		var timestamp string
+		if str.Timestamp != 0 {
+			timestamp = strconv.FormatUint(str.Timestamp, 10)
+		}
+		err = cw.Write([]string{
+			strconv.FormatUint(str.Epoch, 10),
+			timestamp,
+			hex.EncodeToString(str.TreeHash),
+			hex.EncodeToString(str.Hash()),
+		})
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ExportCSV writes the observed STRs of the CONIKS directory identified
+// by dirInitHash to w in CSV format (see directoryHistory.ExportCSV()).
+// ExportCSV() returns auditor.ErrUnknownDirectory if the auditor doesn't
+// have a history for the directory, or the first error encountered
+// writing to w.
+func (l ConiksAuditLog) ExportCSV(dirInitHash [crypto.HashSizeByte]byte, w io.Writer) error {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return auditor.ErrUnknownDirectory
+	}
+	return h.ExportCSV(w)
+}
+
+// ExportCSV writes the observed STRs of the CONIKS directory identified
+// by dirInitHash in the Snapshot to w, as ConiksAuditLog.ExportCSV()
+// does. Unlike the latter, it writes the STRs observed when the
+// Snapshot was taken, regardless of any audits of the log since.
+func (s *Snapshot) ExportCSV(dirInitHash [crypto.HashSizeByte]byte, w io.Writer) error {
+	return s.log.ExportCSV(dirInitHash, w)
+}
@@ -0,0 +1,76 @@
+package auditlog
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+func TestExportCSV(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 0)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	// timestamp the STRs from epoch 2 on
+	d.Update()
+	clock := time.Unix(1500000000, 0)
+	d.SetClock(func() time.Time { return clock })
+	d.Update()
+	d.Update()
+	if err := aud.AuditId(dirInitHash, d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 1,
+		EndEpoch:   3})); err != nil {
+		t.Fatal(err)
+	}
+	h, _ := aud.get(dirInitHash)
+	verified := h.VerifiedSTR()
+
+	var buf bytes.Buffer
+	if err := aud.ExportCSV(dirInitHash, &buf); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rows[0], []string{"epoch", "timestamp", "tree_hash", "str_hash"}) {
+		t.Fatal("Unexpected header", rows[0])
+	}
+	if len(rows)-1 != len(h.snapshots) {
+		t.Fatal("Expect one row per snapshot, got", len(rows)-1, "want", len(h.snapshots))
+	}
+	for i, row := range rows[1:] {
+		str := h.snapshots[uint64(i)]
+		var timestamp string
+		if i >= 2 {
+			timestamp = "1500000000"
+		}
+		want := []string{strconv.Itoa(i), timestamp,
+			hex.EncodeToString(str.TreeHash), hex.EncodeToString(str.Hash())}
+		if !reflect.DeepEqual(row, want) {
+			t.Error("Unexpected row", row, "want", want)
+		}
+	}
+
+	// exporting doesn't modify the log, and is stable
+	var again bytes.Buffer
+	if err := aud.Snapshot().ExportCSV(dirInitHash, &again); err != nil {
+		t.Fatal(err)
+	}
+	var first bytes.Buffer
+	aud.ExportCSV(dirInitHash, &first)
+	if !bytes.Equal(again.Bytes(), first.Bytes()) || h.VerifiedSTR() != verified {
+		t.Error("Expect a stable export which doesn't modify the log")
+	}
+
+	if err := aud.ExportCSV([crypto.HashSizeByte]byte{}, &buf); err != auditor.ErrUnknownDirectory {
+		t.Error("Expect", auditor.ErrUnknownDirectory, "got", err)
+	}
+}
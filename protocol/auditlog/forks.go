@@ -0,0 +1,173 @@
+// This module implements quarantining the branches of a directory's
+// history which diverge from the canonical history the auditor
+// verified, so that a forensic deployment can keep ingesting and later
+// analyse a fork rather than dropping its STRs.
+
+package auditlog
+
+import (
+	"bytes"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+// A QuarantinedBranch is a branch of a directory's history which
+// diverges from the canonical history after the epoch ForkEpoch:
+// STRs holds the branch's STRs from epoch ForkEpoch+1 onward, in
+// chronological order. Each STR of the branch is signed by the
+// directory and links to the STR before it, the first one to the
+// canonical STR for ForkEpoch.
+type QuarantinedBranch struct {
+	ForkEpoch uint64
+	STRs      []*protocol.DirSTR
+	// verifies the STRs appended to the branch
+	aud *auditor.AudState
+}
+
+// tip returns the latest STR of the branch b, given the canonical
+// history h it diverges from.
+func (b *QuarantinedBranch) tip(h *directoryHistory) *protocol.DirSTR {
+	if len(b.STRs) == 0 {
+		return h.snapshots[b.ForkEpoch]
+	}
+	return b.STRs[len(b.STRs)-1]
+}
+
+// extend appends the range of STRs snaps to the branch b, skipping the
+// prefix of snaps b holds already, if the remaining STRs link to the
+// tip of b. extend() leaves b unchanged and returns false if snaps
+// conflicts with b or any of its checks fail.
+func (b *QuarantinedBranch) extend(h *directoryHistory, snaps []*protocol.DirSTR) bool {
+	for len(snaps) > 0 && snaps[0].Epoch <= b.ForkEpoch+uint64(len(b.STRs)) {
+		if snaps[0].Epoch <= b.ForkEpoch {
+			return false
+		}
+		str := b.STRs[snaps[0].Epoch-b.ForkEpoch-1]
+		if !bytes.Equal(str.Signature, snaps[0].Signature) ||
+			!bytes.Equal(str.Serialize(), snaps[0].Serialize()) {
+			return false
+		}
+		snaps = snaps[1:]
+	}
+	if len(snaps) == 0 {
+		return true
+	}
+	tip := b.tip(h)
+	if tip == nil || b.aud.VerifySTRRange(tip, snaps) != nil {
+		return false
+	}
+	b.STRs = append(b.STRs, snaps...)
+	b.aud.Update(snaps[len(snaps)-1])
+	return true
+}
+
+// copy returns a deep copy of the branch b.
+func (b *QuarantinedBranch) copy() *QuarantinedBranch {
+	a := *b.aud
+	return &QuarantinedBranch{
+		ForkEpoch: b.ForkEpoch,
+		STRs:      append([]*protocol.DirSTR(nil), b.STRs...),
+		aud:       &a,
+	}
+}
+
+// quarantineFork ingests the range of STRs snaps, which failed the
+// audit of the canonical history h, into a quarantined branch of h if
+// h keeps forks (see SetQuarantineForks()). The STRs of snaps that
+// match the canonical history are skipped; the remaining STRs either
+// extend one of h's branches, or start a new branch if they diverge
+// from an observed canonical STR. quarantineFork() returns whether the
+// STRs have been quarantined.
+func (h *directoryHistory) quarantineFork(snaps []*protocol.DirSTR) bool {
+	if !h.keepForks {
+		return false
+	}
+	for len(snaps) > 0 {
+		observed, ok := h.snapshots[snaps[0].Epoch]
+		if !ok || !bytes.Equal(observed.Signature, snaps[0].Signature) ||
+			!bytes.Equal(observed.Serialize(), snaps[0].Serialize()) {
+			break
+		}
+		snaps = snaps[1:]
+	}
+	if len(snaps) == 0 {
+		return false
+	}
+	for _, b := range h.branches {
+		if b.extend(h, snaps) {
+			return true
+		}
+	}
+	if snaps[0].Epoch == 0 {
+		return false
+	}
+	prev, ok := h.snapshots[snaps[0].Epoch-1]
+	if !ok {
+		return false
+	}
+	a := *h.AudState
+	a.CacheSTRSignatures(false)
+	a.SetTraceSink(nil)
+	a.Update(prev)
+	b := &QuarantinedBranch{ForkEpoch: prev.Epoch, aud: &a}
+	if !b.extend(h, snaps) {
+		return false
+	}
+	h.branches = append(h.branches, b)
+	return true
+}
+
+// QuarantinedBranches returns copies of the branches diverging from
+// h's canonical history which the auditor quarantined (see
+// SetQuarantineForks()), in the order in which they were detected.
+func (h *directoryHistory) QuarantinedBranches() []*QuarantinedBranch {
+	branches := make([]*QuarantinedBranch, len(h.branches))
+	for i, b := range h.branches {
+		branches[i] = b.copy()
+	}
+	return branches
+}
+
+// SetQuarantineForks makes Audit() keep the STRs of the CONIKS directory
+// identified by dirInitHash which diverge from the directory's
+// canonical history, rather than dropping them: once Audit() detects a
+// fork, it ingests the divergent STRs, as well as the STRs extending
+// them in later ranges, into a QuarantinedBranch of the history for
+// later analysis. The canonical history is never changed by such STRs,
+// and Audit() still signals the inconsistency by returning CheckBadSTR
+// for any range with quarantined STRs.
+// Disabling the mode, the default, drops all quarantined branches.
+// SetQuarantineForks() returns auditor.ErrUnknownDirectory if the
+// auditor doesn't have a history for the directory.
+func (l ConiksAuditLog) SetQuarantineForks(dirInitHash [crypto.HashSizeByte]byte,
+	on bool) error {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return auditor.ErrUnknownDirectory
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.keepForks = on
+	if !on {
+		h.branches = nil
+	}
+	return nil
+}
+
+// QuarantinedBranches returns the quarantined branches of the history
+// of the CONIKS directory identified by dirInitHash (see
+// directoryHistory.QuarantinedBranches()).
+// QuarantinedBranches() returns auditor.ErrUnknownDirectory if the
+// auditor doesn't have a history for the directory.
+func (l ConiksAuditLog) QuarantinedBranches(
+	dirInitHash [crypto.HashSizeByte]byte) ([]*QuarantinedBranch, error) {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return nil, auditor.ErrUnknownDirectory
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.QuarantinedBranches(), nil
+}
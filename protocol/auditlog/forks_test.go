@@ -0,0 +1,121 @@
+package auditlog
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+func TestQuarantineForks(t *testing.T) {
+	// create basic test directory and audit log with 6 STRs
+	d, aud, hist := NewTestAuditLog(t, 5)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	if err := aud.SetQuarantineForks(dirInitHash, true); err != nil {
+		t.Fatal(err)
+	}
+	h, _ := aud.get(dirInitHash)
+	canonical := make(map[uint64]*protocol.DirSTR)
+	for ep, str := range h.snapshots {
+		canonical[ep] = str
+	}
+
+	// the directory forks after epoch 2
+	fork := d.ForkAt(t, 2)
+	fork.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("evil")})
+	for i := 0; i < 5; i++ {
+		fork.Update()
+	}
+	for _, r := range []struct{ start, end uint64 }{
+		{1, 4}, // overlaps the canonical history and diverges at epoch 3
+		{5, 6}, // extends the branch past the canonical history
+		{7, 7}, // extends the branch past a gap in the canonical history
+	} {
+		resp := fork.GetSTRHistory(&protocol.STRHistoryRequest{
+			StartEpoch: r.start,
+			EndEpoch:   r.end})
+		if err := h.Audit(resp); err != protocol.CheckBadSTR {
+			t.Fatal("Expect", protocol.CheckBadSTR, "for epochs", r.start, "to", r.end,
+				"got", err)
+		}
+	}
+
+	branches, err := aud.QuarantinedBranches(dirInitHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(branches) != 1 || branches[0].ForkEpoch != 2 || len(branches[0].STRs) != 5 {
+		t.Fatal("Expect a single branch with epochs 3 to 7")
+	}
+	for i, str := range branches[0].STRs {
+		want := fork.GetSTRHistory(&protocol.STRHistoryRequest{
+			StartEpoch: uint64(i + 3),
+			EndEpoch:   uint64(i + 3)}).DirectoryResponse.(*protocol.STRHistoryRange).STR[0]
+		if str.Epoch != want.Epoch || !bytes.Equal(str.Signature, want.Signature) {
+			t.Error("Unexpected quarantined STR for epoch", i+3)
+		}
+	}
+
+	// the canonical history is untouched
+	if h.VerifiedSTR().Epoch != 5 || len(h.snapshots) != len(canonical) {
+		t.Fatal("Expect the canonical history to be unchanged")
+	}
+	for ep, str := range canonical {
+		if h.snapshots[ep] != str {
+			t.Error("Unexpected canonical STR for epoch", ep)
+		}
+	}
+	if proof, ok := h.EquivocationProof(); !ok || proof.STR1.Epoch != 3 {
+		t.Error("Expect an equivocation proof for epoch 3")
+	}
+
+	// and can still be extended
+	d.Update()
+	resp := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 6,
+		EndEpoch:   6})
+	if err := h.Audit(resp); err != nil {
+		t.Fatal(err)
+	}
+	if branches := h.QuarantinedBranches(); len(branches[0].STRs) != 5 {
+		t.Error("Expect canonical STRs not to be quarantined")
+	}
+
+	// disabling the mode drops the branches
+	if err := aud.SetQuarantineForks(dirInitHash, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.QuarantinedBranches()) != 0 {
+		t.Error("Expect the quarantined branches to be dropped")
+	}
+}
+
+func TestQuarantineForksDisabled(t *testing.T) {
+	// create basic test directory and audit log with 6 STRs
+	d, aud, hist := NewTestAuditLog(t, 5)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	h, _ := aud.get(dirInitHash)
+
+	fork := d.ForkAt(t, 2)
+	fork.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("evil")})
+	fork.Update()
+	resp := fork.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 3,
+		EndEpoch:   3})
+	if err := h.Audit(resp); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+	if len(h.QuarantinedBranches()) != 0 {
+		t.Error("Expect the divergent STRs to be dropped")
+	}
+
+	var unknown [crypto.HashSizeByte]byte
+	if err := aud.SetQuarantineForks(unknown, true); err != auditor.ErrUnknownDirectory {
+		t.Error("Expect", auditor.ErrUnknownDirectory, "got", err)
+	}
+	if _, err := aud.QuarantinedBranches(unknown); err != auditor.ErrUnknownDirectory {
+		t.Error("Expect", auditor.ErrUnknownDirectory, "got", err)
+	}
+}
@@ -0,0 +1,275 @@
+// This module implements bounding the memory of an audit log tracking
+// a large number of directories, most of which are rarely queried, by
+// evicting the observed STRs of the least recently used directories to
+// a HistoryStore.
+
+package auditlog
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+// A StoredHistory holds the observed STRs of a directory which an
+// LRUAuditLog evicted from memory, in chronological order, along with
+// the times at which the auditor observed them.
+type StoredHistory struct {
+	STRs       []*protocol.DirSTR
+	ObservedAt []time.Time
+}
+
+// A HistoryStore persists the StoredHistory of the directories an
+// LRUAuditLog evicts from memory, e.g. by encoding it to disk.
+// Save stores h for the directory identified by dirInitHash, replacing
+// any StoredHistory saved for it before, and Load returns the
+// StoredHistory saved last. The LRUAuditLog doesn't call the store
+// concurrently.
+type HistoryStore interface {
+	Save(dirInitHash [crypto.HashSizeByte]byte, h *StoredHistory) error
+	Load(dirInitHash [crypto.HashSizeByte]byte) (*StoredHistory, error)
+}
+
+// An LRUAuditLog is an audit log which keeps the observed STRs of at
+// most a fixed number of directories in memory. Whenever a directory is
+// accessed and more directories than the capacity are in memory, the
+// observed STRs of the least recently accessed directory are moved to
+// a HistoryStore; they are loaded back transparently the next time the
+// directory is accessed. All other state of a directory's history
+// (e.g. its verified STR and pinned keys) stays in memory, so that
+// evicting and reloading a directory doesn't affect its audits.
+//
+// Unlike a ConiksAuditLog, an LRUAuditLog is safe for concurrent use;
+// its methods are serialized.
+type LRUAuditLog struct {
+	mu       sync.Mutex
+	log      ConiksAuditLog
+	store    HistoryStore
+	capacity int
+	// the directories whose observed STRs are in memory,
+	// from the most to the least recently accessed
+	lru     *list.List
+	entries map[[crypto.HashSizeByte]byte]*list.Element
+
+	// guards closed, and the ingests accepted before the log
+	// was closed (see Close())
+	closeMu  sync.Mutex
+	closed   bool
+	inflight sync.WaitGroup
+}
+
+// NewLRUAuditLog constructs an LRUAuditLog which takes ownership of the
+// audit log l, and keeps the observed STRs of at most capacity of its
+// directories in memory, evicting the others to store; capacity < 1 is
+// treated as 1. The directories of l are evicted lazily, as directories
+// are accessed. l must not be used directly anymore.
+func NewLRUAuditLog(l ConiksAuditLog, store HistoryStore, capacity int) *LRUAuditLog {
+	if capacity < 1 {
+		capacity = 1
+	}
+	c := &LRUAuditLog{
+		log:      l,
+		store:    store,
+		capacity: capacity,
+		lru:      list.New(),
+		entries:  make(map[[crypto.HashSizeByte]byte]*list.Element),
+	}
+	for _, dirInitHash := range l.Directories() {
+		c.entries[dirInitHash] = c.lru.PushBack(dirInitHash)
+	}
+	return c
+}
+
+// InMemory returns the number of directories whose observed STRs are
+// currently held in memory.
+func (c *LRUAuditLog) InMemory() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// touch makes sure the observed STRs of the directory identified by
+// dirInitHash are in memory, loading them from the store if the
+// directory was evicted, and marks the directory as the most recently
+// accessed one. touch() returns auditor.ErrUnknownDirectory if the log
+// doesn't have a history for the directory, or the error returned by
+// the store.
+func (c *LRUAuditLog) touch(dirInitHash [crypto.HashSizeByte]byte) error {
+	h, ok := c.log.get(dirInitHash)
+	if !ok {
+		return auditor.ErrUnknownDirectory
+	}
+	if e, ok := c.entries[dirInitHash]; ok {
+		c.lru.MoveToFront(e)
+		return nil
+	}
+	stored, err := c.store.Load(dirInitHash)
+	if err != nil {
+		return err
+	}
+	h.snapshots = make(map[uint64]*protocol.DirSTR, len(stored.STRs))
+	h.observedAt = make(map[uint64]time.Time, len(stored.STRs))
+	for i, str := range stored.STRs {
+		h.snapshots[str.Epoch] = str
+		if i < len(stored.ObservedAt) && !stored.ObservedAt[i].IsZero() {
+			h.observedAt[str.Epoch] = stored.ObservedAt[i]
+		}
+	}
+	c.entries[dirInitHash] = c.lru.PushFront(dirInitHash)
+	return nil
+}
+
+// stored returns the StoredHistory of the observed STRs of h.
+func (h *directoryHistory) stored() *StoredHistory {
+	s := new(StoredHistory)
+	h.ForEachSnapshot(func(str *protocol.DirSTR) bool {
+		s.STRs = append(s.STRs, str)
+		s.ObservedAt = append(s.ObservedAt, h.observedAt[str.Epoch])
+		return true
+	})
+	return s
+}
+
+// evict moves the observed STRs of the least recently accessed
+// directories to the store until at most c.capacity directories are
+// in memory. A directory whose STRs can't be saved stays in memory,
+// so the capacity is exceeded until the store recovers.
+func (c *LRUAuditLog) evict() {
+	for e := c.lru.Back(); e != nil && c.lru.Len() > c.capacity; {
+		prev := e.Prev()
+		dirInitHash := e.Value.([crypto.HashSizeByte]byte)
+		h, _ := c.log.get(dirInitHash)
+		if err := c.store.Save(dirInitHash, h.stored()); err == nil {
+			h.snapshots = nil
+			h.observedAt = nil
+			c.lru.Remove(e)
+			delete(c.entries, dirInitHash)
+		}
+		e = prev
+	}
+}
+
+// InitHistory creates a new directory history in the log, as
+// ConiksAuditLog.InitHistory() does. The new directory counts as the
+// most recently accessed one.
+// InitHistory() returns auditor.ErrClosed once the log is closed.
+func (c *LRUAuditLog) InitHistory(addr string, signKey sign.PublicKey,
+	snaps []*protocol.DirSTR) error {
+	if err := c.beginIngest(); err != nil {
+		return err
+	}
+	defer c.inflight.Done()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.log.InitHistory(addr, signKey, snaps); err != nil {
+		return err
+	}
+	dirInitHash := auditor.ComputeDirectoryIdentity(snaps[0])
+	c.entries[dirInitHash] = c.lru.PushFront(dirInitHash)
+	c.evict()
+	return nil
+}
+
+// Directories returns the identifiers of all directories in the log,
+// including the evicted ones, as ConiksAuditLog.Directories() does.
+func (c *LRUAuditLog) Directories() [][crypto.HashSizeByte]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.log.Directories()
+}
+
+// Addresses returns the known addresses of the directory identified by
+// dirInitHash, as ConiksAuditLog.Addresses() does. The addresses are
+// always in memory, so Addresses() doesn't load an evicted directory.
+func (c *LRUAuditLog) Addresses(dirInitHash [crypto.HashSizeByte]byte) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.log.Addresses(dirInitHash)
+}
+
+// AddAddress adds addr to the known addresses of the directory
+// identified by dirInitHash, as ConiksAuditLog.AddAddress() does.
+func (c *LRUAuditLog) AddAddress(dirInitHash [crypto.HashSizeByte]byte, addr string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.log.AddAddress(dirInitHash, addr)
+}
+
+// RemoveAddress removes addr from the known addresses of the directory
+// identified by dirInitHash, as ConiksAuditLog.RemoveAddress() does.
+func (c *LRUAuditLog) RemoveAddress(dirInitHash [crypto.HashSizeByte]byte, addr string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.log.RemoveAddress(dirInitHash, addr)
+}
+
+// AuditId audits the range of STRs contained in msg for the directory
+// identified by dirInitHash, as ConiksAuditLog.AuditId() does.
+func (c *LRUAuditLog) AuditId(dirInitHash [crypto.HashSizeByte]byte,
+	msg *protocol.Response) error {
+	return c.AuditIdContext(context.Background(), dirInitHash, msg)
+}
+
+// AuditIdContext is like AuditId but aborts the audit with ctx.Err()
+// if ctx is done before the entire range has been verified.
+// AuditIdContext() returns the error returned by the store if the
+// directory's observed STRs can't be loaded, or auditor.ErrClosed once
+// the log is closed (see Close()).
+func (c *LRUAuditLog) AuditIdContext(ctx context.Context,
+	dirInitHash [crypto.HashSizeByte]byte, msg *protocol.Response) error {
+	if err := c.beginIngest(); err != nil {
+		return err
+	}
+	defer c.inflight.Done()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.touch(dirInitHash); err != nil {
+		return err
+	}
+	defer c.evict()
+	return c.log.AuditIdContext(ctx, dirInitHash, msg)
+}
+
+// GetObservedSTRs gets a range of observed STRs for the directory
+// indicated in req, as ConiksAuditLog.GetObservedSTRs() does.
+// If the directory's observed STRs can't be loaded from the store,
+// GetObservedSTRs() returns a message.NewErrorResponse(ErrAuditLog).
+func (c *LRUAuditLog) GetObservedSTRs(req *protocol.AuditingRequest) *protocol.Response {
+	res, _ := c.GetObservedSTRsContext(context.Background(), req)
+	return res
+}
+
+// GetObservedSTRsContext is like GetObservedSTRs but returns ctx.Err()
+// as ConiksAuditLog.GetObservedSTRsContext() does.
+func (c *LRUAuditLog) GetObservedSTRsContext(ctx context.Context,
+	req *protocol.AuditingRequest) (*protocol.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if req != nil {
+		switch err := c.touch(req.DirInitSTRHash); err {
+		case nil:
+			defer c.evict()
+		case auditor.ErrUnknownDirectory:
+			// let the log reject the request
+		default:
+			return protocol.NewErrorResponse(protocol.ErrAuditLog), nil
+		}
+	}
+	return c.log.GetObservedSTRsContext(ctx, req)
+}
+
+// GetLatestSTR returns the latest verified STR of the directory
+// identified by dirInitHash, as ConiksAuditLog.GetLatestSTR() does.
+// The latest verified STR is always in memory, so GetLatestSTR()
+// doesn't load an evicted directory, nor count as an access to it.
+func (c *LRUAuditLog) GetLatestSTR(dirInitHash [crypto.HashSizeByte]byte) *protocol.Response {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.log.GetLatestSTR(dirInitHash)
+}
@@ -0,0 +1,186 @@
+package auditlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+// jsonStore is a HistoryStore which JSON-encodes the stored histories,
+// as a store writing them to disk would.
+type jsonStore struct {
+	saved map[[crypto.HashSizeByte]byte][]byte
+	loads int
+	err   error
+}
+
+func newJSONStore() *jsonStore {
+	return &jsonStore{saved: make(map[[crypto.HashSizeByte]byte][]byte)}
+}
+
+func (s *jsonStore) Save(dirInitHash [crypto.HashSizeByte]byte, h *StoredHistory) error {
+	if s.err != nil {
+		return s.err
+	}
+	msg, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	s.saved[dirInitHash] = msg
+	return nil
+}
+
+func (s *jsonStore) Load(dirInitHash [crypto.HashSizeByte]byte) (*StoredHistory, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.loads++
+	h := new(StoredHistory)
+	if err := json.Unmarshal(s.saved[dirInitHash], h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// A testHistory is a test directory along with all STRs it issued.
+type testHistory struct {
+	d    *directory.ConiksDirectory
+	strs []*protocol.DirSTR
+}
+
+func newTestLRUAuditLog(t *testing.T, numDirs, capacity int) (*LRUAuditLog,
+	*jsonStore, map[[crypto.HashSizeByte]byte]*testHistory) {
+	aud, dirs := newTestAuditLogDirs(t, numDirs, 3)
+	msgs := newTestAuditRanges(dirs)
+	for dirInitHash, err := range aud.AuditAll(context.Background(), msgs, 1) {
+		if err != nil {
+			t.Fatal(dirInitHash, err)
+		}
+	}
+	histories := make(map[[crypto.HashSizeByte]byte]*testHistory, len(dirs))
+	for dirInitHash, d := range dirs {
+		h, _ := aud.get(dirInitHash)
+		histories[dirInitHash] = &testHistory{
+			d: d,
+			strs: append([]*protocol.DirSTR{h.snapshots[0]},
+				msgs[dirInitHash].DirectoryResponse.(*protocol.STRHistoryRange).STR...),
+		}
+	}
+	store := newJSONStore()
+	return NewLRUAuditLog(aud, store, capacity), store, histories
+}
+
+// checkObservedSTRs checks that l returns the entire STR history of h.
+func checkObservedSTRs(t *testing.T, l *LRUAuditLog,
+	dirInitHash [crypto.HashSizeByte]byte, h *testHistory) {
+	want := h.strs
+	res := l.GetObservedSTRs(&protocol.AuditingRequest{
+		DirInitSTRHash: dirInitHash,
+		StartEpoch:     0,
+		EndEpoch:       want[len(want)-1].Epoch,
+	})
+	if err := res.ValidateFor(protocol.AuditType); err != nil {
+		t.Fatal(err)
+	}
+	got := res.DirectoryResponse.(*protocol.STRHistoryRange).STR
+	if len(got) != len(want) {
+		t.Fatal("Expect", len(want), "STRs, got", len(got))
+	}
+	for i := range want {
+		if !bytes.Equal(got[i].Signature, want[i].Signature) {
+			t.Fatal("Unexpected STR for epoch", want[i].Epoch)
+		}
+	}
+}
+
+func TestLRUAuditLogEvictsColdDirectories(t *testing.T) {
+	l, store, dirs := newTestLRUAuditLog(t, 4, 2)
+	if l.InMemory() != 4 {
+		t.Fatal("Expect the directories to be evicted lazily")
+	}
+
+	var order [][crypto.HashSizeByte]byte
+	for dirInitHash, h := range dirs {
+		checkObservedSTRs(t, l, dirInitHash, h)
+		order = append(order, dirInitHash)
+		if n := l.InMemory(); n > 2 {
+			t.Fatal("Expect at most 2 directories in memory, got", n)
+		}
+	}
+	if len(store.saved) < 2 {
+		t.Fatal("Expect the cold directories to be saved, got", len(store.saved))
+	}
+
+	// the least recently queried directory is reloaded from the store
+	loads := store.loads
+	checkObservedSTRs(t, l, order[0], dirs[order[0]])
+	if store.loads != loads+1 {
+		t.Error("Expect", order[0], "to be reloaded")
+	}
+	// while the most recently queried one is still in memory
+	loads = store.loads
+	checkObservedSTRs(t, l, order[0], dirs[order[0]])
+	if store.loads != loads {
+		t.Error("Expect", order[0], "not to be reloaded")
+	}
+	if len(l.Directories()) != 4 {
+		t.Error("Expect evicted directories to be listed")
+	}
+}
+
+func TestLRUAuditLogAuditsEvictedDirectory(t *testing.T) {
+	l, store, dirs := newTestLRUAuditLog(t, 3, 1)
+	for dirInitHash, h := range dirs {
+		checkObservedSTRs(t, l, dirInitHash, h)
+	}
+
+	// audit new STRs of every directory, most of which are evicted
+	for dirInitHash, h := range dirs {
+		h.d.Update()
+		h.strs = append(h.strs, h.d.LatestSTR())
+		res := protocol.NewSTRHistoryRange([]*protocol.DirSTR{h.d.LatestSTR()})
+		if err := l.AuditId(dirInitHash, res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.loads == 0 {
+		t.Fatal("Expect evicted directories to be reloaded for auditing")
+	}
+	for dirInitHash, h := range dirs {
+		checkObservedSTRs(t, l, dirInitHash, h)
+		res := l.GetLatestSTR(dirInitHash)
+		latest := res.DirectoryResponse.(*protocol.STRHistoryRange).STR[0]
+		if latest.Epoch != h.d.LatestSTR().Epoch {
+			t.Error("Expect the latest STR to be audited, got epoch", latest.Epoch)
+		}
+	}
+}
+
+func TestLRUAuditLogStoreFailure(t *testing.T) {
+	l, store, dirs := newTestLRUAuditLog(t, 2, 1)
+	var order [][crypto.HashSizeByte]byte
+	for dirInitHash, h := range dirs {
+		checkObservedSTRs(t, l, dirInitHash, h)
+		order = append(order, dirInitHash)
+	}
+	cold := order[0]
+
+	store.err = errors.New("store unavailable")
+	res := l.GetObservedSTRs(&protocol.AuditingRequest{DirInitSTRHash: cold})
+	if res.Error != protocol.ErrAuditLog {
+		t.Error("Expect", protocol.ErrAuditLog, "got", res.Error)
+	}
+	if err := l.AuditId(cold, res); err != store.err {
+		t.Error("Expect", store.err, "got", err)
+	}
+
+	// the store recovers
+	store.err = nil
+	checkObservedSTRs(t, l, cold, dirs[cold])
+}
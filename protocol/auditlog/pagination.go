@@ -0,0 +1,43 @@
+// This module implements the continuation tokens with which clients
+// page through a long range of observed STRs.
+
+package auditlog
+
+import (
+	"encoding/binary"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+)
+
+// pageTokenLen is the length of a page token: the directory identifier
+// (i.e. the hash of its initial STR), followed by the start and end
+// epochs of the remaining range.
+const pageTokenLen = crypto.HashSizeByte + 8 + 8
+
+// newPageToken encodes the remaining range [start, end] of the
+// directory identified by dirInitHash into an opaque page token.
+func newPageToken(dirInitHash [crypto.HashSizeByte]byte, start, end uint64) []byte {
+	token := make([]byte, pageTokenLen)
+	copy(token, dirInitHash[:])
+	binary.LittleEndian.PutUint64(token[crypto.HashSizeByte:], start)
+	binary.LittleEndian.PutUint64(token[crypto.HashSizeByte+8:], end)
+	return token
+}
+
+// parsePageToken returns the remaining range [start, end] encoded in
+// token. ok is false if token is malformed, or wasn't issued for the
+// directory identified by dirInitHash.
+func parsePageToken(dirInitHash [crypto.HashSizeByte]byte,
+	token []byte) (start, end uint64, ok bool) {
+	if len(token) != pageTokenLen {
+		return 0, 0, false
+	}
+	var dir [crypto.HashSizeByte]byte
+	copy(dir[:], token)
+	if dir != dirInitHash {
+		return 0, 0, false
+	}
+	start = binary.LittleEndian.Uint64(token[crypto.HashSizeByte:])
+	end = binary.LittleEndian.Uint64(token[crypto.HashSizeByte+8:])
+	return start, end, true
+}
@@ -0,0 +1,101 @@
+// This module implements verifying and auditing the histories of
+// several directories in parallel, e.g. when an auditor starts up or
+// ingests a bulk of STRs for many directories at once.
+
+package auditlog
+
+import (
+	"context"
+	"sync"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+// forEachDirectory calls f for each directory identifier in dirs on a
+// pool of at most concurrency workers, and returns a map from each
+// identifier to the error returned by f. Each history is guarded by its
+// own lock, so f may run concurrently for distinct directories, as long
+// as dirs doesn't include any duplicates and no directory is added to
+// or removed from l meanwhile.
+func forEachDirectory(dirs [][crypto.HashSizeByte]byte, concurrency int,
+	f func(dirInitHash [crypto.HashSizeByte]byte) error) map[[crypto.HashSizeByte]byte]error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	results := make(map[[crypto.HashSizeByte]byte]error, len(dirs))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	jobs := make(chan [crypto.HashSizeByte]byte)
+	for i := 0; i < concurrency && i < len(dirs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dirInitHash := range jobs {
+				err := f(dirInitHash)
+				mu.Lock()
+				results[dirInitHash] = err
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, dirInitHash := range dirs {
+		jobs <- dirInitHash
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// verify re-verifies the observed snapshots of h, as
+// InitHistoryVerified() does, e.g. after the auditor restored h from
+// storage.
+func (h *directoryHistory) verify() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return verifySnapshots(h.AudState, h.observedSTRs())
+}
+
+// VerifyAll re-verifies the observed snapshots of every directory in the
+// audit log l, as InitHistoryVerified() does, using at most concurrency
+// workers; concurrency < 1 is treated as 1. VerifyAll() returns a map from
+// each directory's identifier to the *auditor.SnapshotError reporting its
+// first bad snapshot, or to nil if all of its snapshots verify.
+// The results don't depend on concurrency.
+func (l ConiksAuditLog) VerifyAll(concurrency int) map[[crypto.HashSizeByte]byte]error {
+	return forEachDirectory(l.Directories(), concurrency,
+		func(dirInitHash [crypto.HashSizeByte]byte) error {
+			h, _ := l.get(dirInitHash)
+			return h.verify()
+		})
+}
+
+// AuditAll audits the STR ranges in msgs, which maps directory
+// identifiers to the directories' responses, as AuditIdContext() does for
+// each directory, using at most concurrency workers; concurrency < 1 is
+// treated as 1. Each directory's history is audited by a single worker,
+// so audits of distinct directories never race. AuditAll() returns a
+// map from each directory's identifier in msgs to the error returned by
+// its audit, or to auditor.ErrUnknownDirectory if the auditor doesn't
+// have a history for the directory.
+// The results don't depend on concurrency. Each history is locked while
+// it is audited (see AuditContext()), so AuditAll() may be called
+// concurrently with other audits and reads of l, but not with methods
+// adding or removing directories, e.g. InitHistory() or RePin().
+func (l ConiksAuditLog) AuditAll(ctx context.Context,
+	msgs map[[crypto.HashSizeByte]byte]*protocol.Response,
+	concurrency int) map[[crypto.HashSizeByte]byte]error {
+	dirs := make([][crypto.HashSizeByte]byte, 0, len(msgs))
+	for dirInitHash := range msgs {
+		dirs = append(dirs, dirInitHash)
+	}
+	return forEachDirectory(dirs, concurrency,
+		func(dirInitHash [crypto.HashSizeByte]byte) error {
+			h, ok := l.get(dirInitHash)
+			if !ok {
+				return auditor.ErrUnknownDirectory
+			}
+			return h.AuditContext(ctx, msgs[dirInitHash])
+		})
+}
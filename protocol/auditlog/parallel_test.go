@@ -0,0 +1,184 @@
+package auditlog
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+// newTestAuditLogDirs creates numDirs independent directories with
+// numEpochs epochs each, and an audit log with the initial STR of
+// each directory.
+func newTestAuditLogDirs(tb testing.TB, numDirs, numEpochs int) (
+	ConiksAuditLog, map[[crypto.HashSizeByte]byte]*directory.ConiksDirectory) {
+	aud := New()
+	dirs := make(map[[crypto.HashSizeByte]byte]*directory.ConiksDirectory)
+	pk, _ := staticSigningKey.Public()
+	for i := 0; i < numDirs; i++ {
+		d := directory.New(1, crypto.NewStaticTestVRFKey(), staticSigningKey,
+			uint64(numEpochs)+1, true)
+		if err := aud.InitHistory("test-server", pk,
+			[]*protocol.DirSTR{d.LatestSTR()}); err != nil {
+			tb.Fatal(err)
+		}
+		dirs[auditor.ComputeDirectoryIdentity(d.LatestSTR())] = d
+		for ep := 0; ep < numEpochs; ep++ {
+			d.Update()
+		}
+	}
+	return aud, dirs
+}
+
+// newTestAuditRanges returns the full STR history of each directory in
+// dirs, keyed by the directories' identifiers.
+func newTestAuditRanges(
+	dirs map[[crypto.HashSizeByte]byte]*directory.ConiksDirectory) map[[crypto.HashSizeByte]byte]*protocol.Response {
+	msgs := make(map[[crypto.HashSizeByte]byte]*protocol.Response, len(dirs))
+	for dirInitHash, d := range dirs {
+		msgs[dirInitHash] = d.GetSTRHistory(&protocol.STRHistoryRequest{
+			StartEpoch: 1,
+			EndEpoch:   d.LatestSTR().Epoch})
+	}
+	return msgs
+}
+
+func TestAuditAllMatchesSerial(t *testing.T) {
+	aud, dirs := newTestAuditLogDirs(t, 8, 5)
+	msgs := newTestAuditRanges(dirs)
+
+	// break the range of one directory, and add an unknown directory
+	var bad [crypto.HashSizeByte]byte
+	for dirInitHash := range msgs {
+		bad = dirInitHash
+		break
+	}
+	strs := msgs[bad].DirectoryResponse.(*protocol.STRHistoryRange).STR
+	msgs[bad] = protocol.NewSTRHistoryRange(strs[1:])
+	var unknown [crypto.HashSizeByte]byte
+	msgs[unknown] = msgs[bad]
+
+	serial := aud.Snapshot().log
+	want := make(map[[crypto.HashSizeByte]byte]error)
+	for dirInitHash, msg := range msgs {
+		want[dirInitHash] = serial.AuditId(dirInitHash, msg)
+	}
+	got := aud.AuditAll(context.Background(), msgs, 4)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatal("Expect the same results as the serial audits, got", got, "want", want)
+	}
+	if got[bad] != auditor.ErrRangeGap || got[unknown] != auditor.ErrUnknownDirectory {
+		t.Fatal("Unexpected results", got)
+	}
+	for dirInitHash, d := range dirs {
+		h, _ := aud.get(dirInitHash)
+		sh, _ := serial.get(dirInitHash)
+		if h.VerifiedSTR().Epoch != sh.VerifiedSTR().Epoch {
+			t.Fatal("Expect the same verified STRs as the serial audits")
+		}
+		if dirInitHash != bad && h.VerifiedSTR().Epoch != d.LatestSTR().Epoch {
+			t.Fatal("Expect the directory's latest STR to be verified")
+		}
+	}
+}
+
+func TestVerifyAll(t *testing.T) {
+	aud, dirs := newTestAuditLogDirs(t, 8, 5)
+	if errs := aud.AuditAll(context.Background(), newTestAuditRanges(dirs), 4); len(errs) != len(dirs) {
+		t.Fatal("Expect a result for each directory, got", errs)
+	}
+
+	// corrupt a snapshot of one directory
+	var bad [crypto.HashSizeByte]byte
+	for dirInitHash := range dirs {
+		bad = dirInitHash
+		break
+	}
+	h, _ := aud.get(bad)
+	forged := *h.snapshots[3]
+	forged.Signature = append([]byte{}, forged.Signature...)
+	forged.Signature[0] ^= 1
+	h.snapshots[3] = &forged
+
+	serial := aud.VerifyAll(1)
+	for dirInitHash, err := range serial {
+		if dirInitHash == bad {
+			if e, ok := err.(*auditor.SnapshotError); !ok || e.Epoch != 3 {
+				t.Error("Expect a SnapshotError for epoch 3, got", err)
+			}
+		} else if err != nil {
+			t.Error(err)
+		}
+	}
+	if got := aud.VerifyAll(runtime.GOMAXPROCS(0)); !reflect.DeepEqual(got, serial) {
+		t.Fatal("Expect the same results as the serial path, got", got, "want", serial)
+	}
+}
+
+func TestAuditAllConcurrentReads(t *testing.T) {
+	aud, dirs := newTestAuditLogDirs(t, 4, 5)
+	msgs := newTestAuditRanges(dirs)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for dirInitHash, msg := range msgs {
+		wg.Add(1)
+		go func(dirInitHash [crypto.HashSizeByte]byte, msg *protocol.Response) {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				aud.GetLatestSTR(dirInitHash)
+				aud.GetObservedSTRs(&protocol.AuditingRequest{DirInitSTRHash: dirInitHash})
+				aud.ForEachSnapshot(dirInitHash, func(*protocol.DirSTR) bool { return true })
+				aud.StaleDirectories(0)
+				// a concurrent delivery of the same range
+				if err := aud.AuditId(dirInitHash, msg); err != nil &&
+					err != auditor.ErrRangeGap {
+					t.Error(err)
+				}
+			}
+		}(dirInitHash, msg)
+	}
+	errs := aud.AuditAll(context.Background(), msgs, 2)
+	verified := aud.VerifyAll(2)
+	close(done)
+	wg.Wait()
+
+	for dirInitHash, d := range dirs {
+		if errs[dirInitHash] != nil || verified[dirInitHash] != nil {
+			t.Error("Unexpected results", errs[dirInitHash], verified[dirInitHash])
+		}
+		h, _ := aud.get(dirInitHash)
+		if h.VerifiedSTR().Epoch != d.LatestSTR().Epoch {
+			t.Error("Expect the directory's latest STR to be verified")
+		}
+	}
+}
+
+func BenchmarkVerifyAll(b *testing.B) {
+	aud, dirs := newTestAuditLogDirs(b, 64, 20)
+	aud.AuditAll(context.Background(), newTestAuditRanges(dirs), 1)
+	for _, tc := range []struct {
+		name        string
+		concurrency int
+	}{
+		{"serial", 1},
+		{"parallel", runtime.GOMAXPROCS(0)},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				aud.VerifyAll(tc.concurrency)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+// This module implements authenticating the pushes of STRs to an
+// auditor, so that a transport in front of the auditor can make sure
+// that only the directory itself, or a relay trusted by the auditor,
+// pushes STRs for a directory.
+
+package auditlog
+
+import (
+	"context"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+// An IngestAuth authenticates a push of the range of STRs in msg,
+// given the credential which accompanies the push (e.g. a signature,
+// see SignPush()), before the auditor audits the range.
+// It returns a non-nil error if the push isn't authenticated.
+// msg has been validated before an IngestAuth is called.
+type IngestAuth func(msg *protocol.Response, credential []byte) error
+
+// serializePush serializes the push of the range of STRs in msg for
+// the directory identified by dirInitHash for signing.
+func serializePush(dirInitHash [crypto.HashSizeByte]byte, msg *protocol.Response) []byte {
+	var bs []byte
+	bs = append(bs, dirInitHash[:]...)
+	for _, str := range msg.DirectoryResponse.(*protocol.STRHistoryRange).STR {
+		bs = append(bs, str.Serialize()...)
+		bs = append(bs, str.Signature...)
+	}
+	return bs
+}
+
+// SignPush signs the push of the range of STRs in msg for the
+// directory identified by dirInitHash with key, i.e. the directory's
+// signing key or the signing key of a relay, and returns the signature
+// to be sent along with the push as its credential (see AuditPush()).
+// msg must be a valid response to an auditing request.
+func SignPush(dirInitHash [crypto.HashSizeByte]byte, msg *protocol.Response,
+	key sign.PrivateKey) []byte {
+	return key.Sign(serializePush(dirInitHash, msg))
+}
+
+// SignedPushAuth returns an IngestAuth which accepts the pushes for the
+// directory identified by dirInitHash whose credential is a signature
+// (see SignPush()) under any of the given keys, e.g. the keys of the
+// relays the auditor trusts, and which returns
+// auditor.ErrUnauthenticatedPush otherwise.
+func SignedPushAuth(dirInitHash [crypto.HashSizeByte]byte,
+	keys ...sign.PublicKey) IngestAuth {
+	return func(msg *protocol.Response, credential []byte) error {
+		message := serializePush(dirInitHash, msg)
+		for _, key := range keys {
+			if key.Verify(message, credential) {
+				return nil
+			}
+		}
+		return auditor.ErrUnauthenticatedPush
+	}
+}
+
+// SetIngestAuth makes AuditPush() authenticate each push of STRs for
+// the CONIKS directory identified by dirInitHash with auth before
+// auditing the pushed STRs. A nil auth, the default, accepts all pushes.
+// SetIngestAuth() returns auditor.ErrUnknownDirectory if the auditor
+// doesn't have a history for the directory.
+func (l ConiksAuditLog) SetIngestAuth(dirInitHash [crypto.HashSizeByte]byte,
+	auth IngestAuth) error {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return auditor.ErrUnknownDirectory
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ingestAuth = auth
+	return nil
+}
+
+// RequireSignedPushes makes AuditPush() accept only the pushes of STRs
+// for the CONIKS directory identified by dirInitHash which are signed
+// by the directory itself (see SignPush()), under any of the directory's
+// signing keys pinned by the auditor, as SetIngestAuth() does.
+// RequireSignedPushes() returns auditor.ErrUnknownDirectory if the
+// auditor doesn't have a history for the directory.
+func (l ConiksAuditLog) RequireSignedPushes(dirInitHash [crypto.HashSizeByte]byte) error {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return auditor.ErrUnknownDirectory
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ingestAuth = func(msg *protocol.Response, credential []byte) error {
+		if !h.Verify(serializePush(dirInitHash, msg), credential) {
+			return auditor.ErrUnauthenticatedPush
+		}
+		return nil
+	}
+	return nil
+}
+
+// AuditPush audits the range of STRs in msg pushed to the auditor by a
+// transport for the CONIKS directory identified by dirInitHash, as
+// AuditIdContext() does, once the push has been authenticated with the
+// credential accompanying it (see SetIngestAuth()).
+// AuditPush() returns auditor.ErrUnknownDirectory if the auditor
+// doesn't have a history for the directory, ErrMalformedMessage if msg
+// isn't a valid response to an auditing request, and the error
+// returned by the directory's IngestAuth if the push isn't
+// authenticated, in which case the pushed STRs aren't audited at all.
+// Pulling STRs from the directory itself (see CatchUp()) doesn't
+// require any authentication.
+func (l ConiksAuditLog) AuditPush(ctx context.Context,
+	dirInitHash [crypto.HashSizeByte]byte, msg *protocol.Response,
+	credential []byte) error {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return auditor.ErrUnknownDirectory
+	}
+	h.mu.Lock()
+	auth := h.ingestAuth
+	h.mu.Unlock()
+	if auth != nil {
+		if err := msg.ValidateFor(protocol.AuditType); err != nil {
+			return err
+		}
+		if err := auth(msg, credential); err != nil {
+			return err
+		}
+	}
+	return h.AuditContext(ctx, msg)
+}
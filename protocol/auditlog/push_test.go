@@ -0,0 +1,87 @@
+package auditlog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+func TestAuditPushSignedByDirectory(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 0)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	if err := aud.RequireSignedPushes(dirInitHash); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+	d.Update()
+	msg := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 1,
+		EndEpoch:   2})
+
+	// a spoofed push carries valid STRs, but isn't signed by the directory
+	spoofer, _ := sign.GenerateKey(nil)
+	for _, credential := range [][]byte{nil, SignPush(dirInitHash, msg, spoofer)} {
+		if err := aud.AuditPush(context.Background(), dirInitHash, msg,
+			credential); err != auditor.ErrUnauthenticatedPush {
+			t.Fatal("Expect", auditor.ErrUnauthenticatedPush, "got", err)
+		}
+	}
+	h, _ := aud.get(dirInitHash)
+	if h.VerifiedSTR().Epoch != 0 {
+		t.Fatal("Expect the spoofed push not to be audited")
+	}
+
+	// the signature doesn't carry over to another range
+	credential := SignPush(dirInitHash, msg, staticSigningKey)
+	other := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 1,
+		EndEpoch:   1})
+	if err := aud.AuditPush(context.Background(), dirInitHash, other,
+		credential); err != auditor.ErrUnauthenticatedPush {
+		t.Fatal("Expect", auditor.ErrUnauthenticatedPush, "got", err)
+	}
+
+	if err := aud.AuditPush(context.Background(), dirInitHash, msg, credential); err != nil {
+		t.Fatal(err)
+	}
+	if h.VerifiedSTR().Epoch != 2 {
+		t.Fatal("Expect the authenticated push to be audited")
+	}
+}
+
+func TestAuditPushTrustedRelay(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 0)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	relay, _ := sign.GenerateKey(nil)
+	relayPK, _ := relay.Public()
+	if err := aud.SetIngestAuth(dirInitHash, SignedPushAuth(dirInitHash, relayPK)); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+	msg := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 1,
+		EndEpoch:   1})
+
+	// the directory's own signature isn't trusted for this directory
+	if err := aud.AuditPush(context.Background(), dirInitHash, msg,
+		SignPush(dirInitHash, msg, staticSigningKey)); err != auditor.ErrUnauthenticatedPush {
+		t.Fatal("Expect", auditor.ErrUnauthenticatedPush, "got", err)
+	}
+	if err := aud.AuditPush(context.Background(), dirInitHash, msg,
+		SignPush(dirInitHash, msg, relay)); err != nil {
+		t.Fatal(err)
+	}
+
+	// a malformed push is rejected before it's authenticated
+	if err := aud.AuditPush(context.Background(), dirInitHash,
+		protocol.NewErrorResponse(protocol.ErrDirectory), nil); err == nil {
+		t.Fatal("Expect a malformed push to be rejected")
+	}
+	if err := aud.SetIngestAuth([crypto.HashSizeByte]byte{}, nil); err != auditor.ErrUnknownDirectory {
+		t.Fatal("Expect", auditor.ErrUnknownDirectory, "got", err)
+	}
+}
@@ -0,0 +1,104 @@
+// This module implements buffering STRs which an auditor receives out
+// of order, e.g. from an unreliable push transport, until the gap
+// before them closes.
+
+package auditlog
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+// SetReorderBuffer makes Audit() buffer up to size STRs of the CONIKS
+// directory identified by dirInitHash which arrive ahead of the
+// directory's next epoch, rather than rejecting them with
+// auditor.ErrRangeGap. The buffered STRs are audited as soon as the
+// gap before them closes. A size of 0, the default, disables the
+// buffer and drops any STRs buffered so far.
+// SetReorderBuffer() returns auditor.ErrUnknownDirectory if the auditor
+// doesn't have a history for the directory.
+func (l ConiksAuditLog) SetReorderBuffer(dirInitHash [crypto.HashSizeByte]byte,
+	size int) error {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return auditor.ErrUnknownDirectory
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if size < 0 {
+		size = 0
+	}
+	h.maxPending = size
+	if size == 0 || h.pending == nil {
+		h.pending = make(map[uint64]*protocol.DirSTR)
+	}
+	return nil
+}
+
+// buffer buffers the range of STRs snaps, which starts after the
+// epoch following h.verifiedSTR, until the gap before the range closes.
+// buffer() only checks the signature of each STR, so that the buffer
+// can't be filled with forged STRs; the hash chain is verified once
+// the STRs are audited (see drain()).
+// buffer() returns auditor.ErrReorderBufferFull if buffering snaps
+// would exceed the size of h's buffer, and CheckBadSTR if an STR differs
+// from the STR already buffered for the same epoch; in either case,
+// none of the STRs are buffered.
+func (h *directoryHistory) buffer(snaps []*protocol.DirSTR) error {
+	n := len(h.pending)
+	for _, str := range snaps {
+		if err := h.VerifySTR(str); err != nil {
+			return err
+		}
+		if buffered, ok := h.pending[str.Epoch]; ok {
+			if !bytes.Equal(buffered.Signature, str.Signature) ||
+				!bytes.Equal(buffered.Serialize(), str.Serialize()) {
+				h.recordEquivocation(buffered, str)
+				return protocol.CheckBadSTR
+			}
+			continue
+		}
+		n++
+	}
+	if n > h.maxPending {
+		return auditor.ErrReorderBufferFull
+	}
+	for _, str := range snaps {
+		h.pending[str.Epoch] = str
+	}
+	return nil
+}
+
+// drain audits the buffered STRs which follow h.verifiedSTR one at a
+// time, until the buffer doesn't hold the STR for the next epoch.
+// Buffered STRs whose epochs have been verified in the meantime are
+// dropped; if such an STR differs from the observed one, drain() records
+// the equivocation (see recordEquivocation()).
+// drain() returns an *auditor.SnapshotError reporting the first buffered
+// STR which fails the audit; the STR is dropped from the buffer.
+// If ctx is done, drain() stops and leaves the remaining STRs buffered.
+func (h *directoryHistory) drain(ctx context.Context) error {
+	for {
+		for ep, str := range h.pending {
+			if ep > h.VerifiedSTR().Epoch {
+				continue
+			}
+			delete(h.pending, ep)
+			if _, err := h.dedup([]*protocol.DirSTR{str}); err != nil {
+				return &auditor.SnapshotError{Epoch: ep, Err: err}
+			}
+		}
+		str, ok := h.pending[h.VerifiedSTR().Epoch+1]
+		if !ok || ctx.Err() != nil {
+			return nil
+		}
+		delete(h.pending, str.Epoch)
+		if err := h.auditRange(ctx, []*protocol.DirSTR{str}); err != nil {
+			return &auditor.SnapshotError{Epoch: str.Epoch, Err: err}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package auditlog
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+func TestAuditOutOfOrder(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 0)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	if err := aud.SetReorderBuffer(dirInitHash, 4); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		d.Update()
+	}
+	h, _ := aud.get(dirInitHash)
+
+	for _, ep := range []uint64{0, 2, 1, 3} {
+		resp := d.GetSTRHistory(&protocol.STRHistoryRequest{
+			StartEpoch: ep,
+			EndEpoch:   ep})
+		if err := h.Audit(resp); err != nil {
+			t.Fatal("Unexpected error for epoch", ep, err)
+		}
+		if ep == 2 && h.VerifiedSTR().Epoch != 0 {
+			t.Fatal("Expect epoch 2 to be buffered until epoch 1 arrives")
+		}
+	}
+	if h.VerifiedSTR().Epoch != 3 {
+		t.Fatal("Expect the verified tip to be epoch 3, got", h.VerifiedSTR().Epoch)
+	}
+	for ep := uint64(0); ep <= 3; ep++ {
+		if _, ok := h.snapshots[ep]; !ok {
+			t.Error("Expect a snapshot for epoch", ep)
+		}
+	}
+	if len(h.pending) != 0 {
+		t.Error("Expect the buffer to be drained, got", len(h.pending), "STRs")
+	}
+}
+
+func TestAuditOutOfOrderBufferFull(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 0)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	if err := aud.SetReorderBuffer(dirInitHash, 2); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		d.Update()
+	}
+	h, _ := aud.get(dirInitHash)
+
+	resp := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 2,
+		EndEpoch:   4})
+	if err := h.Audit(resp); err != auditor.ErrReorderBufferFull {
+		t.Fatal("Expect", auditor.ErrReorderBufferFull, "got", err)
+	}
+	if len(h.pending) != 0 {
+		t.Fatal("Expect none of the STRs to be buffered")
+	}
+
+	// without a buffer, a gap is rejected as before
+	if err := aud.SetReorderBuffer(dirInitHash, 0); err != nil {
+		t.Fatal(err)
+	}
+	resp = d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 2,
+		EndEpoch:   2})
+	if err := h.Audit(resp); err != auditor.ErrRangeGap {
+		t.Fatal("Expect", auditor.ErrRangeGap, "got", err)
+	}
+}
+
+func TestAuditOutOfOrderForged(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 0)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	if err := aud.SetReorderBuffer(dirInitHash, 4); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+	d.Update()
+	h, _ := aud.get(dirInitHash)
+
+	resp := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 2,
+		EndEpoch:   2})
+	resp.DirectoryResponse.(*protocol.STRHistoryRange).STR[0].Signature[0] ^= 0xff
+	if err := h.Audit(resp); err != protocol.CheckBadSignature {
+		t.Fatal("Expect", protocol.CheckBadSignature, "got", err)
+	}
+	if len(h.pending) != 0 {
+		t.Fatal("Expect the forged STR not to be buffered")
+	}
+}
@@ -0,0 +1,115 @@
+// This module implements read-only snapshots of an audit log, which
+// allow long-running readers (e.g. exports) to walk the log while the
+// auditor keeps auditing new STRs.
+
+package auditlog
+
+import (
+	"time"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+// A Snapshot is an immutable view of an audit log at the time it was
+// taken (see ConiksAuditLog.Snapshot()). Audits of the log after that
+// time don't affect the Snapshot, so a Snapshot is safe for concurrent
+// use, including concurrently with audits of the log it was taken of.
+type Snapshot struct {
+	log ConiksAuditLog
+}
+
+// Snapshot returns a Snapshot of the audit log l. It copies the
+// directories' histories, but not the observed STRs themselves, which
+// the log never modifies once inserted.
+// Snapshot() can be called while l is audited: it only holds the lock
+// of each history while copying it, so each directory's history in the
+// Snapshot is consistent, but the histories of distinct directories may
+// be copied before and after the same concurrent AuditAll(). Reading
+// the Snapshot doesn't take any of l's locks, so a long traversal of
+// the Snapshot never blocks the audits of l.
+func (l ConiksAuditLog) Snapshot() *Snapshot {
+	s := &Snapshot{log: make(ConiksAuditLog, len(l))}
+	for dirInitHash, h := range l {
+		h.mu.Lock()
+		s.log[dirInitHash] = h.copy()
+		h.mu.Unlock()
+	}
+	return s
+}
+
+// copy returns a copy of h whose snapshots and observation times
+// can be read while h is audited further. The caller must hold h's lock.
+func (h *directoryHistory) copy() *directoryHistory {
+	a := *h.AudState
+	c := &directoryHistory{
+		AudState:      &a,
+		id:            h.id,
+		addrs:         append([]string(nil), h.addrs...),
+		snapshots:     make(map[uint64]*protocol.DirSTR, len(h.snapshots)),
+		observedAt:    make(map[uint64]time.Time, len(h.observedAt)),
+		equivocation:  h.equivocation,
+		quarantined:   h.quarantined,
+		ingestAuth:    h.ingestAuth,
+		pending:       make(map[uint64]*protocol.DirSTR, len(h.pending)),
+		maxPending:    h.maxPending,
+		epochInterval: h.epochInterval,
+		keepForks:     h.keepForks,
+	}
+	for _, b := range h.branches {
+		c.branches = append(c.branches, b.copy())
+	}
+	for ep, str := range h.pending {
+		c.pending[ep] = str
+	}
+	for ep, str := range h.snapshots {
+		c.snapshots[ep] = str
+	}
+	for ep, t := range h.observedAt {
+		c.observedAt[ep] = t
+	}
+	return c
+}
+
+// Directories returns the identifiers of all CONIKS directories in the
+// Snapshot, as ConiksAuditLog.Directories() does.
+func (s *Snapshot) Directories() [][crypto.HashSizeByte]byte {
+	return s.log.Directories()
+}
+
+// Addresses returns the known addresses of the CONIKS directory
+// identified by dirInitHash in the Snapshot, as
+// ConiksAuditLog.Addresses() does.
+func (s *Snapshot) Addresses(dirInitHash [crypto.HashSizeByte]byte) ([]string, error) {
+	return s.log.Addresses(dirInitHash)
+}
+
+// ForEachSnapshot calls f for each STR of the CONIKS directory
+// identified by dirInitHash in the Snapshot, as
+// ConiksAuditLog.ForEachSnapshot() does.
+func (s *Snapshot) ForEachSnapshot(dirInitHash [crypto.HashSizeByte]byte,
+	f func(*protocol.DirSTR) bool) error {
+	return s.log.ForEachSnapshot(dirInitHash, f)
+}
+
+// GetObservedSTRs gets a range of STRs in the Snapshot, as
+// ConiksAuditLog.GetObservedSTRs() does.
+func (s *Snapshot) GetObservedSTRs(req *protocol.AuditingRequest) *protocol.Response {
+	return s.log.GetObservedSTRs(req)
+}
+
+// GetLatestSTR gets the latest STR of the CONIKS directory identified
+// by dirInitHash in the Snapshot, as ConiksAuditLog.GetLatestSTR()
+// does.
+func (s *Snapshot) GetLatestSTR(dirInitHash [crypto.HashSizeByte]byte) *protocol.Response {
+	return s.log.GetLatestSTR(dirInitHash)
+}
+
+// EquivocationProof returns the equivocation proof of the CONIKS
+// directory identified by dirInitHash in the Snapshot, as
+// ConiksAuditLog.EquivocationProof() does.
+func (s *Snapshot) EquivocationProof(
+	dirInitHash [crypto.HashSizeByte]byte) (*auditor.EquivocationProof, bool) {
+	return s.log.EquivocationProof(dirInitHash)
+}
@@ -24,7 +24,9 @@ func NewTestAuditLog(t *testing.T, numEpochs int) (
 	var snaps []*protocol.DirSTR
 	for ep := 0; ep < numEpochs; ep++ {
 		snaps = append(snaps, d.LatestSTR())
-		d.Update()
+		if err := d.Update(); err != nil {
+			t.Fatal(err)
+		}
 	}
 	// always include the actual latest STR
 	snaps = append(snaps, d.LatestSTR())
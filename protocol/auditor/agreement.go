@@ -0,0 +1,92 @@
+// Implements agreement proofs, which allow anyone to confirm offline
+// that two independent auditors verified the same STR of a CONIKS
+// directory for an epoch, without trusting either auditor alone.
+
+package auditor
+
+import (
+	"bytes"
+
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// An AgreementProof shows that two auditors agree on the STR of a
+// CONIKS directory for an epoch: it consists of the STR along with the
+// Checkpoints Checkpoint1 and Checkpoint2 of the two auditors, both of
+// which were created for STR.
+type AgreementProof struct {
+	STR         *protocol.DirSTR
+	Checkpoint1 *Checkpoint
+	Checkpoint2 *Checkpoint
+}
+
+// NewAgreementProof combines the checkpoints c1 and c2 of two auditors
+// for the same directory and epoch into an AgreementProof for str.
+// It returns ErrMalformedMessage if str, c1 or c2 is nil or if c1 and
+// c2 weren't created for the same directory and epoch, or CheckBadSTR
+// if the auditors disagree on the STR, or str isn't the STR they
+// agree on.
+// NewAgreementProof() doesn't verify the checkpoints' signatures
+// (see VerifyAgreementProof()).
+func NewAgreementProof(str *protocol.DirSTR, c1, c2 *Checkpoint) (*AgreementProof, error) {
+	proof := &AgreementProof{
+		STR:         str,
+		Checkpoint1: c1,
+		Checkpoint2: c2,
+	}
+	if err := proof.check(); err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// check checks that both checkpoints of proof were created for the
+// same directory and epoch, and for proof.STR.
+func (proof *AgreementProof) check() error {
+	c1, c2 := proof.Checkpoint1, proof.Checkpoint2
+	if proof.STR == nil || proof.STR.SignedTreeRoot == nil ||
+		c1 == nil || c2 == nil ||
+		len(c1.STRHash) == 0 || len(c2.STRHash) == 0 {
+		return protocol.ErrMalformedMessage
+	}
+	if c1.DirInitSTRHash != c2.DirInitSTRHash || c1.Epoch != c2.Epoch {
+		return protocol.ErrMalformedMessage
+	}
+	if !bytes.Equal(c1.STRHash, c2.STRHash) ||
+		proof.STR.Epoch != c1.Epoch ||
+		!bytes.Equal(proof.STR.Hash(), c1.STRHash) {
+		return protocol.CheckBadSTR
+	}
+	return nil
+}
+
+// VerifyAgreementProof verifies the given proof against the directory's
+// public signing key signKey, and the public signing keys auditorKey1
+// and auditorKey2 of the auditors which created proof.Checkpoint1 and
+// proof.Checkpoint2, respectively.
+// It returns ErrMalformedMessage if the proof is malformed or the two
+// auditor keys are the same, i.e. the proof doesn't show the agreement
+// of two different auditors, CheckBadSTR if the checkpoints weren't
+// both created for proof.STR, or CheckBadSignature if proof.STR isn't
+// signed under signKey or either checkpoint isn't signed under the
+// corresponding auditor's key.
+// If VerifyAgreementProof() returns nil, both auditors verified the
+// directory's history up to proof.STR.
+func VerifyAgreementProof(proof *AgreementProof, signKey sign.PublicKey,
+	auditorKey1, auditorKey2 sign.PublicKey) error {
+	if proof == nil || bytes.Equal(auditorKey1, auditorKey2) {
+		return protocol.ErrMalformedMessage
+	}
+	if err := proof.check(); err != nil {
+		return err
+	}
+	// the checkpoints commit to the STR's signature only
+	if !signKey.Verify(proof.STR.Serialize(), proof.STR.Signature) {
+		return protocol.CheckBadSignature
+	}
+	if err := VerifyCheckpoint(proof.Checkpoint1, auditorKey1); err != nil {
+		return err
+	}
+	return VerifyCheckpoint(proof.Checkpoint2, auditorKey2)
+}
@@ -0,0 +1,100 @@
+package auditor
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+func newTestAuditorKeys(t *testing.T) (sign.PrivateKey, sign.PublicKey,
+	sign.PrivateKey, sign.PublicKey) {
+	sk1, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk2, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk1, _ := sk1.Public()
+	pk2, _ := sk2.Public()
+	return sk1, pk1, sk2, pk2
+}
+
+func TestAgreementProof(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	dirInitHash := ComputeDirectoryIdentity(d.LatestSTR())
+	d.Update()
+	str := d.LatestSTR()
+	signKey, _ := staticSigningKey.Public()
+	sk1, pk1, sk2, pk2 := newTestAuditorKeys(t)
+
+	c1 := NewCheckpoint(dirInitHash, str, 1, sk1)
+	c2 := NewCheckpoint(dirInitHash, str, 2, sk2)
+	proof, err := NewAgreementProof(str, c1, c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyAgreementProof(proof, signKey, pk1, pk2); err != nil {
+		t.Fatal(err)
+	}
+
+	// the auditors' keys must match their checkpoints
+	if err := VerifyAgreementProof(proof, signKey, pk2, pk1); err != protocol.CheckBadSignature {
+		t.Error("Expect", protocol.CheckBadSignature, "got", err)
+	}
+	// a single auditor can't agree with itself
+	if err := VerifyAgreementProof(proof, signKey, pk1, pk1); err != protocol.ErrMalformedMessage {
+		t.Error("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+	// the STR must be signed by the directory
+	tampered := *str.SignedTreeRoot
+	tampered.TreeHash = append([]byte{}, str.TreeHash...)
+	tampered.TreeHash[0] ^= 0xff
+	forged := &AgreementProof{STR: protocol.NewDirSTR(&tampered),
+		Checkpoint1: c1, Checkpoint2: c2}
+	if err := VerifyAgreementProof(forged, signKey, pk1, pk2); err != protocol.CheckBadSignature {
+		t.Error("Expect", protocol.CheckBadSignature, "got", err)
+	}
+	// both checkpoints must be for the same epoch
+	d.Update()
+	c3 := NewCheckpoint(dirInitHash, d.LatestSTR(), 3, sk2)
+	if _, err := NewAgreementProof(str, c1, c3); err != protocol.ErrMalformedMessage {
+		t.Error("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+}
+
+func TestAgreementProofDifferentSTRs(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	dirInitHash := ComputeDirectoryIdentity(d.LatestSTR())
+	d.Update()
+	str := d.LatestSTR()
+	signKey, _ := staticSigningKey.Public()
+	sk1, pk1, sk2, pk2 := newTestAuditorKeys(t)
+
+	// the second auditor verified a different STR for the same epoch
+	forked := *str.SignedTreeRoot
+	forked.TreeHash = append([]byte{}, str.TreeHash...)
+	forked.TreeHash[0] ^= 0xff
+	forked.Signature = staticSigningKey.Sign(protocol.NewDirSTR(&forked).Serialize())
+	str2 := protocol.NewDirSTR(&forked)
+
+	c1 := NewCheckpoint(dirInitHash, str, 1, sk1)
+	c2 := NewCheckpoint(dirInitHash, str2, 2, sk2)
+	if _, err := NewAgreementProof(str, c1, c2); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+	// a proof assembled by hand doesn't verify either
+	proof := &AgreementProof{STR: str, Checkpoint1: c1, Checkpoint2: c2}
+	if err := VerifyAgreementProof(proof, signKey, pk1, pk2); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+	// nor does one whose STR isn't the one the auditors agree on
+	proof = &AgreementProof{STR: str2, Checkpoint1: c1,
+		Checkpoint2: NewCheckpoint(dirInitHash, str, 2, sk2)}
+	if err := VerifyAgreementProof(proof, signKey, pk1, pk2); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+}
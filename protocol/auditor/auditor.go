@@ -5,7 +5,8 @@
 package auditor
 
 import (
-	"reflect"
+	"bytes"
+	"time"
 
 	"github.com/coniks-sys/coniks-go/crypto/sign"
 	"github.com/coniks-sys/coniks-go/protocol"
@@ -19,25 +20,114 @@ type Auditor interface {
 
 // AudState verifies the hash chain of a specific directory.
 type AudState struct {
-	signKey     sign.PublicKey
+	signKeys    []sign.PublicKey
+	sigAlgs     map[string]bool
 	verifiedSTR *protocol.DirSTR
+	traceSink   TraceSink
+	clockSkew   time.Duration
+	// the serialized STRs of the verified epoch whose signatures have
+	// been verified, indexed by signature (see CacheSTRSignatures())
+	sigCache map[string][]byte
 }
 
 var _ Auditor = (*AudState)(nil)
 
 // New instantiates a new auditor state from a persistance storage.
 func New(signKey sign.PublicKey, verified *protocol.DirSTR) *AudState {
+	return NewWithKeys([]sign.PublicKey{signKey}, verified)
+}
+
+// NewWithKeys is like New but pins a set of acceptable signing keys
+// signKeys (e.g. a directory's primary and standby keys), any of
+// which may sign the directory's STRs. It panics if signKeys is empty.
+func NewWithKeys(signKeys []sign.PublicKey, verified *protocol.DirSTR) *AudState {
+	if len(signKeys) == 0 {
+		panic("[coniks] Expect at least one signing key")
+	}
 	a := &AudState{
-		signKey:     signKey,
+		signKeys:    append([]sign.PublicKey(nil), signKeys...),
+		sigAlgs:     map[string]bool{sign.Ed25519ID: true},
 		verifiedSTR: verified,
 	}
 	return a
 }
 
+// AllowSignatureAlgorithms sets the signature schemes which the
+// AudState accepts to the schemes with the given IDs
+// (see sign.Algorithm). By default, the AudState only accepts Ed25519.
+func (a *AudState) AllowSignatureAlgorithms(ids ...string) {
+	a.sigAlgs = make(map[string]bool, len(ids))
+	for _, id := range ids {
+		a.sigAlgs[id] = true
+	}
+	if a.sigCache != nil {
+		a.sigCache = make(map[string][]byte)
+	}
+}
+
+// SetClockSkew sets the clock skew which the AudState tolerates
+// between the timestamps of consecutive STRs (see checkTimestamp()),
+// e.g. to accept the jitter of a directory's clock. By default, the
+// AudState doesn't tolerate any clock skew. STR timestamps have a
+// resolution of one second, so a skew which isn't a whole number of
+// seconds is rounded up, and a negative skew is treated as 0.
+func (a *AudState) SetClockSkew(skew time.Duration) {
+	a.clockSkew = skew
+}
+
+// verifyWith verifies a signature sig on message using the signature
+// scheme declared by the policies p and the underlying public-keys of
+// the AudState. The signature is valid if the scheme is allowed and
+// any of the pinned keys verifies it.
+func (a *AudState) verifyWith(p *protocol.Policies, message, sig []byte) bool {
+	alg := p.SignatureAlgorithm()
+	if alg == nil || !a.sigAlgs[alg.ID()] {
+		return false
+	}
+	for _, pk := range a.signKeys {
+		if alg.Verify(pk, message, sig) {
+			return true
+		}
+	}
+	return false
+}
+
 // Verify verifies a signature sig on message using the underlying
-// public-key of the AudState.
+// public-keys of the AudState and the signature scheme declared by the
+// verified STR's policies. The signature is valid if any of
+// the pinned keys verifies it.
 func (a *AudState) Verify(message, sig []byte) bool {
-	return a.signKey.Verify(message, sig)
+	return a.verifyWith(a.verifiedSTR.Policies, message, sig)
+}
+
+// VerifySTR verifies the signature of str using the signature scheme
+// declared by str's own policies. It returns
+// ErrSignatureAlgorithm if the declared scheme is unknown or not
+// allowed (see AllowSignatureAlgorithms()), CheckBadSignature if
+// none of the pinned keys verifies the signature, or nil otherwise.
+func (a *AudState) VerifySTR(str *protocol.DirSTR) error {
+	err := a.verifySTR(str)
+	a.Trace(&TraceEvent{
+		Step:  TraceSTRSignature,
+		Epoch: str.Epoch,
+		Err:   err,
+		Got:   str.Signature,
+	})
+	return err
+}
+
+func (a *AudState) verifySTR(str *protocol.DirSTR) error {
+	if a.cachedSTR(str) {
+		return nil
+	}
+	if alg := str.Policies.SignatureAlgorithm(); alg == nil || !a.sigAlgs[alg.ID()] {
+		return ErrSignatureAlgorithm
+	}
+	if !a.verifyWith(str.Policies, str.Serialize(), str.Signature) {
+		return protocol.CheckBadSignature
+	}
+	a.cacheSTR(str)
+	return nil
 }
 
 // VerifiedSTR returns the newly verified STR.
@@ -47,34 +137,180 @@ func (a *AudState) VerifiedSTR() *protocol.DirSTR {
 
 // Update updates the auditor's verifiedSTR to newSTR
 func (a *AudState) Update(newSTR *protocol.DirSTR) {
+	if a.sigCache != nil && (a.verifiedSTR == nil || a.verifiedSTR.Epoch != newSTR.Epoch) {
+		a.sigCache = make(map[string][]byte)
+	}
 	a.verifiedSTR = newSTR
 }
 
 // compareWithVerified checks whether the received STR is the same as
-// the verified STR in the AudState using reflect.DeepEqual().
+// the verified STR in the AudState, i.e. whether both STRs have the
+// same contents and signature.
 func (a *AudState) compareWithVerified(str *protocol.DirSTR) error {
-	if reflect.DeepEqual(a.verifiedSTR, str) {
+	if bytes.Equal(a.verifiedSTR.Serialize(), str.Serialize()) &&
+		bytes.Equal(a.verifiedSTR.Signature, str.Signature) {
 		return nil
 	}
 	return protocol.CheckBadSTR
 }
 
 // verifySTRConsistency checks the consistency between 2 snapshots.
-// It uses the signing key signKey to verify the STR's signature.
-// The signKey param either comes from a client's
-// pinned signing key in its consistency state,
+// It uses the pinned signing keys and the signature scheme declared
+// by the STR to verify the STR's signature.
+// The keys either come from a client's
+// pinned signing keys in its consistency state,
 // or an auditor's pinned signing key in its history.
 func (a *AudState) verifySTRConsistency(prevSTR, str *protocol.DirSTR) error {
 	// verify STR's signature
-	if !a.signKey.Verify(str.Serialize(), str.Signature) {
-		return protocol.CheckBadSignature
+	if err := a.VerifySTR(str); err != nil {
+		return err
+	}
+	var err error
+	if !str.VerifyHashChain(prevSTR) {
+		// TODO: verify the directory's policies as well. See #115
+		err = protocol.CheckBadSTR
+	}
+	if a.Tracing() {
+		a.Trace(&TraceEvent{
+			Step:  TraceHashChain,
+			Epoch: str.Epoch,
+			Err:   err,
+			Want:  prevSTR.Hash(),
+			Got:   str.PreviousSTRHash,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	if err := checkVRFKeyChange(prevSTR, str); err != nil {
+		return err
+	}
+	if err := checkPolicyChange(prevSTR, str); err != nil {
+		return err
+	}
+	if err := a.checkSigningKeyChange(prevSTR, str); err != nil {
+		return err
 	}
-	if str.VerifyHashChain(prevSTR) {
+	return a.checkTimestamp(prevSTR, str)
+}
+
+// checkTimestamp checks that the timestamp of str isn't older than the
+// timestamp of prevSTR by more than the tolerated clock skew (see
+// SetClockSkew()). STRs without a timestamp aren't checked.
+// It returns ErrTimestampRollback if the directory's clock
+// went backwards.
+func (a *AudState) checkTimestamp(prevSTR, str *protocol.DirSTR) error {
+	if prevSTR.Timestamp == 0 || str.Timestamp == 0 {
 		return nil
 	}
+	var skew uint64
+	if a.clockSkew > 0 {
+		skew = uint64(a.clockSkew / time.Second)
+		if a.clockSkew%time.Second != 0 {
+			skew++
+		}
+	}
+	if str.Timestamp < prevSTR.Timestamp && prevSTR.Timestamp-str.Timestamp > skew {
+		return ErrTimestampRollback
+	}
+	return nil
+}
 
-	// TODO: verify the directory's policies as well. See #115
-	return protocol.CheckBadSTR
+// checkVRFKeyChange checks that str only uses a different VRF key than
+// prevSTR if its policies announce the rotation from prevSTR's
+// VRF key (see protocol.Policies.RotatesVRFKey()).
+// It returns CheckBadVRFKeyChange if the VRF key changes without an
+// announcement, or if str announces a rotation from a different key.
+func checkVRFKeyChange(prevSTR, str *protocol.DirSTR) error {
+	prevKey := prevSTR.Policies.VrfPublicKey
+	p := str.Policies
+	switch {
+	case p.RotatesVRFKey() && !bytes.Equal(p.PreviousVrfPublicKey, prevKey):
+		return protocol.CheckBadVRFKeyChange
+	case !p.RotatesVRFKey() && !bytes.Equal(p.VrfPublicKey, prevKey):
+		return protocol.CheckBadVRFKeyChange
+	}
+	return nil
+}
+
+// checkPolicyChange checks that str only uses a different epoch
+// deadline or hash function than prevSTR if prevSTR announced the
+// change of policies for str's epoch, and that str adopts any such
+// announced change (see protocol.PolicyChange).
+// It returns CheckBadPolicyChange if the policies change without an
+// announcement, if str doesn't adopt an announced change, or if str
+// announces a change for any epoch other than the next one. Since the
+// directory name is part of the directory's identity, it also returns
+// CheckBadPolicyChange if str renames the directory.
+func checkPolicyChange(prevSTR, str *protocol.DirSTR) error {
+	if str.Policies.DirectoryName != prevSTR.Policies.DirectoryName {
+		return protocol.CheckBadPolicyChange
+	}
+	if c := str.Policies.PolicyChange; c != nil && c.Epoch != str.Epoch+1 {
+		return protocol.CheckBadPolicyChange
+	}
+	c := prevSTR.Policies.PolicyChange
+	if c == nil {
+		// the policies of prevSTR remain in effect
+		c = &protocol.PolicyChange{
+			Epoch:         str.Epoch,
+			EpochDeadline: prevSTR.Policies.EpochDeadline,
+			HashID:        prevSTR.Policies.HashID,
+		}
+	}
+	if c.Epoch != str.Epoch || !c.Adopts(str.Policies) {
+		return protocol.CheckBadPolicyChange
+	}
+	return nil
+}
+
+// checkSigningKeyChange checks that str is only signed using a different
+// signature scheme or a different pinned signing key than prevSTR if
+// prevSTR's policies announce the change (see protocol.PolicyChange),
+// and that an announced change takes effect in str. This rejects a
+// switch to another allowed scheme or pinned key which the directory
+// didn't announce under its previous key, even if str's signature is
+// valid. checkSigningKeyChange() must be called after
+// checkPolicyChange(), and returns CheckBadSigningKeyChange if the
+// check fails.
+func (a *AudState) checkSigningKeyChange(prevSTR, str *protocol.DirSTR) error {
+	c := prevSTR.Policies.PolicyChange
+	sigID := prevSTR.Policies.SignatureID
+	if c != nil && c.SignatureID != "" {
+		sigID = c.SignatureID
+	}
+	if str.Policies.SignatureID != sigID {
+		return protocol.CheckBadSigningKeyChange
+	}
+	announced := c != nil && c.SigningKey != nil
+	if len(a.signKeys) == 1 && !announced {
+		// every STR is verified with the only pinned key
+		return nil
+	}
+	want := a.signingKey(prevSTR)
+	if announced {
+		want = c.SigningKey
+	}
+	if alg := str.Policies.SignatureAlgorithm(); want == nil ||
+		!alg.Verify(want, str.Serialize(), str.Signature) {
+		return protocol.CheckBadSigningKeyChange
+	}
+	return nil
+}
+
+// signingKey returns the pinned key which verifies the signature of
+// str, or nil if there is none.
+func (a *AudState) signingKey(str *protocol.DirSTR) sign.PublicKey {
+	alg := str.Policies.SignatureAlgorithm()
+	if alg == nil {
+		return nil
+	}
+	for _, pk := range a.signKeys {
+		if alg.Verify(pk, str.Serialize(), str.Signature) {
+			return pk
+		}
+	}
+	return nil
 }
 
 // CheckSTRAgainstVerified checks an STR str against the a.verifiedSTR.
@@ -85,13 +321,18 @@ func (a *AudState) verifySTRConsistency(prevSTR, str *protocol.DirSTR) error {
 // CheckSTRAgainstVerified() returns nil if the check passes,
 // or the appropriate consistency check error if any of the checks fail,
 // or str's epoch is anything other than the same or one ahead of
-// a.verifiedSTR.
+// a.verifiedSTR. In particular, it returns ErrRollback if str's epoch
+// is older than a.verifiedSTR's epoch, since the verified history
+// must never shrink.
 func (a *AudState) CheckSTRAgainstVerified(str *protocol.DirSTR) error {
 	// FIXME: check whether the STR was issued on time and whatnot.
 	// Maybe it has something to do w/ #81 and client
 	// transitioning between epochs.
 	// Try to verify w/ what's been saved
 	switch {
+	case str.Epoch < a.verifiedSTR.Epoch:
+		// Never roll back to an older epoch
+		return ErrRollback
 	case str.Epoch == a.verifiedSTR.Epoch:
 		// Checking an STR in the same epoch
 		if err := a.compareWithVerified(str); err != nil {
@@ -137,13 +378,20 @@ func (a *AudState) VerifySTRRange(prevSTR *protocol.DirSTR, strs []*protocol.Dir
 // against the verifiedSTR, and verifies the remaining
 // range if the message contains more than one STR.
 // AuditDirectory() returns the appropriate consistency check error
-// if any of the checks fail, or nil if the checks pass.
+// if any of the checks fail, or nil if the checks pass. It returns
+// ErrRollback if the most recent STR in the range is older than
+// the verifiedSTR.
 func (a *AudState) AuditDirectory(strs []*protocol.DirSTR) error {
 	// validate strs
 	if len(strs) == 0 {
 		return protocol.ErrMalformedMessage
 	}
 
+	// the verified history is append-only
+	if last := strs[len(strs)-1]; last != nil && last.Epoch < a.verifiedSTR.Epoch {
+		return ErrRollback
+	}
+
 	// check STR against the latest verified STR
 	if err := a.CheckSTRAgainstVerified(strs[0]); err != nil {
 		return err
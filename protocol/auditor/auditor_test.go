@@ -2,8 +2,11 @@ package auditor
 
 import (
 	"testing"
+	"time"
 
 	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
 	"github.com/coniks-sys/coniks-go/protocol"
 	"github.com/coniks-sys/coniks-go/protocol/directory"
 )
@@ -92,8 +95,8 @@ func TestAuditBadNewSTREpoch(t *testing.T) {
 
 	strs := resp.DirectoryResponse.(*protocol.STRHistoryRange)
 	err = aud.AuditDirectory(strs.STR)
-	if err != protocol.CheckBadSTR {
-		t.Error("str.Epoch < verified.Epoch - Expect", protocol.CheckBadSTR, "got", err)
+	if err != ErrRollback {
+		t.Error("str.Epoch < verified.Epoch - Expect", ErrRollback, "got", err)
 	}
 }
 
@@ -134,3 +137,242 @@ func TestAuditMalformedSTRRange(t *testing.T) {
 		t.Error("Expect", protocol.ErrMalformedMessage, "got", err1)
 	}
 }
+
+func TestAuditEmptySTRRange(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	pk, _ := staticSigningKey.Public()
+	aud := New(pk, d.LatestSTR())
+
+	for _, strs := range [][]*protocol.DirSTR{nil, {}} {
+		if err := aud.AuditDirectory(strs); err != protocol.ErrMalformedMessage {
+			t.Error("Expect", protocol.ErrMalformedMessage, "got", err)
+		}
+	}
+}
+
+func TestAuditRejectsRollback(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	pk, _ := staticSigningKey.Public()
+
+	// create a generic auditor state
+	aud := New(pk, d.LatestSTR())
+
+	// update the auditor to epoch 3
+	for e := 0; e < 3; e++ {
+		d.Update()
+		aud.Update(d.LatestSTR())
+	}
+
+	// a later response with an earlier tip
+	resp := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: uint64(1),
+		EndEpoch:   uint64(2)})
+	strs := resp.DirectoryResponse.(*protocol.STRHistoryRange)
+	if err := aud.AuditDirectory(strs.STR); err != ErrRollback {
+		t.Error("Expect", ErrRollback, "got", err)
+	}
+	if aud.VerifiedSTR().Epoch != 3 {
+		t.Error("Expect the verified STR to be unchanged")
+	}
+}
+
+func TestAuditVRFKeyChange(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	d.Update()
+	pk, _ := staticSigningKey.Public()
+	aud := New(pk, d.LatestSTR())
+
+	newKey, err := vrf.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RotateVRFKey(newKey); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+	resp := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: uint64(2),
+		EndEpoch:   uint64(3)})
+	if err := aud.AuditDirectory(resp.DirectoryResponse.(*protocol.STRHistoryRange).STR); err != nil {
+		t.Fatal("Expect an announced rotation to be accepted, got", err)
+	}
+	aud.Update(d.LatestSTR())
+
+	// the directory changes its VRF key without announcing it
+	otherKey, err := vrf.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.RotateVRFKeyUnannounced(t, otherKey)
+	if err := aud.AuditDirectory([]*protocol.DirSTR{d.LatestSTR()}); err != protocol.CheckBadVRFKeyChange {
+		t.Error("Expect", protocol.CheckBadVRFKeyChange, "got", err)
+	}
+}
+
+func TestAuditPolicyChange(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	d.Update()
+	pk, _ := staticSigningKey.Public()
+	aud := New(pk, d.LatestSTR())
+
+	if err := d.ChangePolicies(&protocol.PolicyChangeRequest{
+		EpochDeadline: 7, HashID: crypto.SHA512_256ID}); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+	d.Update()
+	resp := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: uint64(2),
+		EndEpoch:   uint64(3)})
+	if err := aud.AuditDirectory(resp.DirectoryResponse.(*protocol.STRHistoryRange).STR); err != nil {
+		t.Fatal("Expect an announced policy change to be accepted, got", err)
+	}
+	aud.Update(d.LatestSTR())
+
+	// the directory changes its policies without announcing it
+	d.ChangePoliciesUnannounced(t, 9)
+	if err := aud.AuditDirectory([]*protocol.DirSTR{d.LatestSTR()}); err != protocol.CheckBadPolicyChange {
+		t.Error("Expect", protocol.CheckBadPolicyChange, "got", err)
+	}
+}
+
+func TestAuditSigningKeyChange(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	d.Update()
+	pk, _ := staticSigningKey.Public()
+	newSK, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPK, _ := newSK.Public()
+	aud := NewWithKeys([]sign.PublicKey{pk, newPK}, d.LatestSTR())
+
+	if err := d.RotateSigningKey(sign.KeySigner(newSK), newPK, ""); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+	d.Update()
+	resp := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: uint64(2),
+		EndEpoch:   uint64(3)})
+	if err := aud.AuditDirectory(resp.DirectoryResponse.(*protocol.STRHistoryRange).STR); err != nil {
+		t.Fatal("Expect an announced key rotation to be accepted, got", err)
+	}
+	aud.Update(d.LatestSTR())
+
+	// the directory switches back to the other pinned key without
+	// announcing it, although the STR's signature is valid
+	d.RotateSigningKeyUnannounced(t, sign.KeySigner(staticSigningKey), "")
+	if err := aud.AuditDirectory([]*protocol.DirSTR{d.LatestSTR()}); err != protocol.CheckBadSigningKeyChange {
+		t.Error("Expect", protocol.CheckBadSigningKeyChange, "got", err)
+	}
+}
+
+// testAlgorithm is an Ed25519 variant which is registered under a
+// different ID, standing in for a newly deployed signature scheme.
+type testAlgorithm struct{}
+
+func (testAlgorithm) ID() string { return "Test-Ed25519" }
+
+func (testAlgorithm) Verify(pk sign.PublicKey, message, sig []byte) bool {
+	return pk.Verify(message, sig)
+}
+
+func TestAuditSignatureAlgorithmChange(t *testing.T) {
+	sign.RegisterAlgorithm(testAlgorithm{})
+	d := directory.NewTestDirectory(t)
+	d.Update()
+	pk, _ := staticSigningKey.Public()
+	aud := New(pk, d.LatestSTR())
+	aud.AllowSignatureAlgorithms(sign.Ed25519ID, testAlgorithm{}.ID())
+
+	if err := d.RotateSigningKey(sign.KeySigner(staticSigningKey), pk,
+		testAlgorithm{}.ID()); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+	d.Update()
+	resp := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: uint64(2),
+		EndEpoch:   uint64(3)})
+	if err := aud.AuditDirectory(resp.DirectoryResponse.(*protocol.STRHistoryRange).STR); err != nil {
+		t.Fatal("Expect an announced algorithm switch to be accepted, got", err)
+	}
+	if id := d.LatestSTR().Policies.SignatureID; id != (testAlgorithm{}).ID() {
+		t.Fatal("Expect the new algorithm to be declared, got", id)
+	}
+	aud.Update(d.LatestSTR())
+
+	// the directory switches back to Ed25519 without announcing it
+	d.RotateSigningKeyUnannounced(t, sign.KeySigner(staticSigningKey), sign.Ed25519ID)
+	if err := aud.AuditDirectory([]*protocol.DirSTR{d.LatestSTR()}); err != protocol.CheckBadSigningKeyChange {
+		t.Error("Expect", protocol.CheckBadSigningKeyChange, "got", err)
+	}
+}
+
+func TestAuditTimestamps(t *testing.T) {
+	clock := time.Unix(1500000000, 0)
+	d := directory.NewTestDirectory(t)
+	d.SetClock(func() time.Time { return clock })
+	d.Update()
+	pk, _ := staticSigningKey.Public()
+	aud := New(pk, d.LatestSTR())
+	aud.SetClockSkew(5 * time.Second)
+
+	// an increasing sequence, and a small jitter within the tolerance
+	for _, step := range []time.Duration{time.Minute, time.Minute, -2 * time.Second, time.Minute} {
+		clock = clock.Add(step)
+		d.Update()
+		if err := aud.AuditDirectory([]*protocol.DirSTR{d.LatestSTR()}); err != nil {
+			t.Fatal("Expect the STR to be accepted after a step of", step, "got", err)
+		}
+		aud.Update(d.LatestSTR())
+	}
+
+	// the directory's clock jumps back
+	clock = clock.Add(-time.Hour)
+	d.Update()
+	if err := aud.AuditDirectory([]*protocol.DirSTR{d.LatestSTR()}); err != ErrTimestampRollback {
+		t.Error("Expect", ErrTimestampRollback, "got", err)
+	}
+
+	// the jitter is rejected without a tolerance
+	aud.SetClockSkew(0)
+	clock = clock.Add(time.Hour - 2*time.Second)
+	verified := aud.VerifiedSTR()
+	d2 := d.ForkAt(t, verified.Epoch)
+	d2.Update()
+	if err := aud.AuditDirectory([]*protocol.DirSTR{d2.LatestSTR()}); err != ErrTimestampRollback {
+		t.Error("Expect", ErrTimestampRollback, "got", err)
+	}
+}
+
+func TestAuditSubSecondClockSkew(t *testing.T) {
+	clock := time.Unix(1500000000, 0)
+	d := directory.NewTestDirectory(t)
+	d.SetClock(func() time.Time { return clock })
+	d.Update()
+	pk, _ := staticSigningKey.Public()
+	aud := New(pk, d.LatestSTR())
+
+	// a skew of half a second tolerates a jitter of one second
+	aud.SetClockSkew(500 * time.Millisecond)
+	clock = clock.Add(-time.Second)
+	d.Update()
+	if err := aud.AuditDirectory([]*protocol.DirSTR{d.LatestSTR()}); err != nil {
+		t.Fatal("Expect the jitter to be accepted, got", err)
+	}
+	aud.Update(d.LatestSTR())
+
+	clock = clock.Add(-2 * time.Second)
+	d.Update()
+	if err := aud.AuditDirectory([]*protocol.DirSTR{d.LatestSTR()}); err != ErrTimestampRollback {
+		t.Error("Expect", ErrTimestampRollback, "got", err)
+	}
+
+	// a negative skew doesn't tolerate any jitter
+	aud.SetClockSkew(-time.Hour)
+	if err := aud.AuditDirectory([]*protocol.DirSTR{d.LatestSTR()}); err != ErrTimestampRollback {
+		t.Error("Expect", ErrTimestampRollback, "got", err)
+	}
+}
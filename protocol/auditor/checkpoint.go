@@ -0,0 +1,63 @@
+// Implements auditor checkpoints, which allow an auditor to bootstrap
+// a directory's history from the state verified by an auditor it
+// trusts rather than verifying the directory's entire history.
+
+package auditor
+
+import (
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// A Checkpoint is an auditor's signed statement that, as of Time
+// (in seconds since the Unix epoch), it has verified the history of
+// the directory identified by DirInitSTRHash up to the STR for Epoch,
+// whose hash (see protocol.DirSTR.Hash()) is STRHash.
+type Checkpoint struct {
+	DirInitSTRHash [crypto.HashSizeByte]byte
+	Epoch          uint64
+	STRHash        []byte
+	Time           uint64
+	Signature      []byte
+}
+
+// NewCheckpoint creates the Checkpoint for the verified STR str of the
+// directory identified by dirInitHash at the given time, and signs it
+// with the auditor's signing key auditorKey.
+func NewCheckpoint(dirInitHash [crypto.HashSizeByte]byte, str *protocol.DirSTR,
+	time uint64, auditorKey sign.PrivateKey) *Checkpoint {
+	c := &Checkpoint{
+		DirInitSTRHash: dirInitHash,
+		Epoch:          str.Epoch,
+		STRHash:        str.Hash(),
+		Time:           time,
+	}
+	c.Signature = auditorKey.Sign(c.Serialize())
+	return c
+}
+
+// Serialize serializes the checkpoint for signing.
+func (c *Checkpoint) Serialize() []byte {
+	var bs []byte
+	bs = append(bs, c.DirInitSTRHash[:]...)
+	bs = append(bs, utils.ULongToBytes(c.Epoch)...)
+	bs = append(bs, c.STRHash...)
+	bs = append(bs, utils.ULongToBytes(c.Time)...)
+	return bs
+}
+
+// VerifyCheckpoint verifies the given checkpoint against the public
+// signing key auditorKey of the auditor which created it.
+// It returns ErrMalformedMessage if c is nil or doesn't include an STR
+// hash, or CheckBadSignature if c isn't signed under auditorKey.
+func VerifyCheckpoint(c *Checkpoint, auditorKey sign.PublicKey) error {
+	if c == nil || len(c.STRHash) == 0 {
+		return protocol.ErrMalformedMessage
+	}
+	if !auditorKey.Verify(c.Serialize(), c.Signature) {
+		return protocol.CheckBadSignature
+	}
+	return nil
+}
@@ -9,13 +9,46 @@ import (
 
 // ComputeDirectoryIdentity returns the hash of
 // the directory's initial STR as a byte array.
-// It panics if the STR isn't an initial STR (i.e. str.Epoch != 0).
+// The hash function is the one declared in the STR's policies
+// (see protocol.DirSTR.Hash()), and its preimage is the STR's
+// signature, which covers the serialized STR, including its policies
+// (see protocol.Policies.Serialize()). In particular, the identity
+// covers the directory name declared in the policies, which
+// distinguishes directories that share a signing key. The directory's
+// address isn't part of the identity, since the same directory may be
+// reached at different addresses.
+// Since DirSTR.Hash() caches the hash in str, calling
+// ComputeDirectoryIdentity() repeatedly on the same STR, e.g. in an
+// ingest loop, neither re-serializes nor re-hashes it.
+// It panics if the STR isn't an initial STR (i.e. str.Epoch != 0),
+// or if its hash function is unknown; callers handling an STR which
+// hasn't been validated (see protocol.ValidateGenesisSTR()) should use
+// DirectoryIdentity() instead.
 func ComputeDirectoryIdentity(str *protocol.DirSTR) [crypto.HashSizeByte]byte {
 	if str.Epoch != 0 {
 		panic(fmt.Sprintf("[coniks] Expect epoch 0, got %x", str.Epoch))
 	}
+	id, err := DirectoryIdentity(str)
+	if err != nil {
+		panic(fmt.Sprintf("[coniks] Unknown hash function %s", str.Policies.HashID))
+	}
+	return id
+}
 
+// DirectoryIdentity returns the identity of the directory whose initial
+// STR is str, as ComputeDirectoryIdentity() does, but returns
+// ErrMalformedMessage rather than panicking if str is nil, isn't an
+// initial STR, or declares an unknown hash function.
+func DirectoryIdentity(str *protocol.DirSTR) ([crypto.HashSizeByte]byte, error) {
 	var initSTRHash [crypto.HashSizeByte]byte
-	copy(initSTRHash[:], crypto.Digest(str.Signature))
-	return initSTRHash
+	if str == nil || str.SignedTreeRoot == nil || str.Policies == nil ||
+		str.Epoch != 0 {
+		return initSTRHash, protocol.ErrMalformedMessage
+	}
+	hash := str.Hash()
+	if hash == nil {
+		return initSTRHash, protocol.ErrMalformedMessage
+	}
+	copy(initSTRHash[:], hash)
+	return initSTRHash, nil
 }
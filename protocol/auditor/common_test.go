@@ -5,6 +5,8 @@ import (
 	"encoding/hex"
 	"testing"
 
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/merkletree"
 	"github.com/coniks-sys/coniks-go/protocol"
 	"github.com/coniks-sys/coniks-go/protocol/directory"
 )
@@ -38,6 +40,29 @@ func TestComputeDirectoryIdentity(t *testing.T) {
 	}
 }
 
+func TestDirectoryIdentity(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	str0 := d.LatestSTR()
+	id, err := DirectoryIdentity(str0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != ComputeDirectoryIdentity(str0) {
+		t.Fatal("Expect the same identity as ComputeDirectoryIdentity()")
+	}
+
+	// an unknown hash function is rejected rather than panicking
+	p := *str0.Policies
+	p.HashID = "bogus"
+	bogus := &protocol.DirSTR{SignedTreeRoot: str0.SignedTreeRoot, Policies: &p}
+	d.Update()
+	for _, str := range []*protocol.DirSTR{bogus, d.LatestSTR(), nil} {
+		if _, err := DirectoryIdentity(str); err != protocol.ErrMalformedMessage {
+			t.Error("Expect", protocol.ErrMalformedMessage, "got", err)
+		}
+	}
+}
+
 // decode hex string to byte array
 func hex2bin(h string) []byte {
 	result, err := hex.DecodeString(h)
@@ -46,3 +71,62 @@ func hex2bin(h string) []byte {
 	}
 	return result
 }
+
+func TestComputeDirectoryIdentitySharedKey(t *testing.T) {
+	// unnamed directories with the same key and randomness
+	// have the same initial STR, and thus the same identity
+	d1 := directory.NewTestDirectory(t)
+	d2 := directory.NewTestDirectory(t)
+	if ComputeDirectoryIdentity(d1.LatestSTR()) != ComputeDirectoryIdentity(d2.LatestSTR()) {
+		t.Fatal("Expect identical initial STRs to have the same identity")
+	}
+
+	a := directory.NewNamedTestDirectory(t, "alice.example.com")
+	b := directory.NewNamedTestDirectory(t, "bob.example.com")
+	idA := ComputeDirectoryIdentity(a.LatestSTR())
+	idB := ComputeDirectoryIdentity(b.LatestSTR())
+	if idA == idB {
+		t.Fatal("Expect directories with distinct names to have distinct identities")
+	}
+	if idA == ComputeDirectoryIdentity(d1.LatestSTR()) {
+		t.Fatal("Expect a named directory's identity to differ from an unnamed one's")
+	}
+
+	// both directories verify under the shared key
+	pk, _ := staticSigningKey.Public()
+	audA := New(pk, a.LatestSTR())
+	a.Update()
+	if err := audA.AuditDirectory([]*protocol.DirSTR{a.LatestSTR()}); err != nil {
+		t.Fatal(err)
+	}
+	audB := New(pk, b.LatestSTR())
+	b.Update()
+	if err := audB.AuditDirectory([]*protocol.DirSTR{b.LatestSTR()}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// BenchmarkComputeDirectoryIdentity computes the identity of a
+// directory repeatedly, as an auditor classifying ingested STRs does,
+// for the same initial STR (whose hash is cached) and for a fresh copy
+// of it in each iteration.
+func BenchmarkComputeDirectoryIdentity(b *testing.B) {
+	vrfKey := crypto.NewStaticTestVRFKey()
+	vrfPublicKey, _ := vrfKey.Public()
+	pad, err := merkletree.NewPAD(protocol.NewPolicies(1, vrfPublicKey),
+		staticSigningKey, vrfKey, 1)
+	if err != nil {
+		b.Fatal(err)
+	}
+	str := protocol.NewDirSTR(pad.LatestSTR())
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			ComputeDirectoryIdentity(str)
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			ComputeDirectoryIdentity(protocol.NewDirSTR(str.SignedTreeRoot))
+		}
+	})
+}
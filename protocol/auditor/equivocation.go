@@ -0,0 +1,48 @@
+// Implements equivocation proofs, which allow anyone to confirm that
+// a CONIKS directory equivocated without trusting the auditor that
+// detected the equivocation.
+
+package auditor
+
+import (
+	"bytes"
+
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// An EquivocationProof shows that a CONIKS directory presented
+// different views of its history: it consists of two different
+// STRs STR1 and STR2 for the same epoch, both of which are signed by
+// the directory.
+type EquivocationProof struct {
+	STR1 *protocol.DirSTR
+	STR2 *protocol.DirSTR
+}
+
+// VerifyEquivocationProof verifies the given proof against the
+// directory's public signing key signKey.
+// It returns ErrMalformedMessage if the proof doesn't contain two
+// well-formed STRs for the same epoch whose signed contents differ,
+// or CheckBadSignature if either STR isn't signed under signKey.
+// If VerifyEquivocationProof() returns nil, the directory
+// misbehaved.
+func VerifyEquivocationProof(proof *EquivocationProof, signKey sign.PublicKey) error {
+	if proof == nil {
+		return protocol.ErrMalformedMessage
+	}
+	strs := []*protocol.DirSTR{proof.STR1, proof.STR2}
+	if err := protocol.NewSTRHistoryRange(strs).Validate(); err != nil {
+		return protocol.ErrMalformedMessage
+	}
+	if proof.STR1.Epoch != proof.STR2.Epoch ||
+		bytes.Equal(proof.STR1.Serialize(), proof.STR2.Serialize()) {
+		return protocol.ErrMalformedMessage
+	}
+	for _, str := range strs {
+		if !signKey.Verify(str.Serialize(), str.Signature) {
+			return protocol.CheckBadSignature
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+// Defines the errors that an auditor may return
+// in addition to the protocol's error codes.
+
+package auditor
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUnknownDirectory indicates that the auditor doesn't have
+	// a history for the requested directory.
+	ErrUnknownDirectory = errors.New("[auditor] Unknown directory")
+	// ErrRangeGap indicates that a range of STRs doesn't start at the
+	// epoch immediately following the latest verified epoch, and would
+	// leave a gap in the directory's history.
+	ErrRangeGap = errors.New("[auditor] The STR range leaves a gap in the directory's history")
+	// ErrRollback indicates that an STR's epoch is older than the latest
+	// verified epoch, i.e. that the directory's history appears to
+	// have shrunk.
+	ErrRollback = errors.New("[auditor] The STR is older than the latest verified STR")
+	// ErrTimestampRollback indicates that an STR's timestamp is older
+	// than the timestamp of the previous STR by more than the allowed
+	// clock skew, i.e. that the directory's clock went backwards.
+	ErrTimestampRollback = errors.New("[auditor] The STR's timestamp is older than the previous STR's timestamp")
+	// ErrRePinNotConfirmed indicates that an operator attempted to
+	// re-pin a directory without confirming that its history
+	// will be dropped.
+	ErrRePinNotConfirmed = errors.New("[auditor] Re-pinning a directory requires confirmation")
+	// ErrQuarantined indicates that auditing a directory failed
+	// unexpectedly, and that the directory's history has been
+	// quarantined since its state can't be trusted anymore.
+	ErrQuarantined = errors.New("[auditor] The directory's history is quarantined")
+	// ErrSignatureAlgorithm indicates that an STR declares a signature
+	// scheme which is unknown or not allowed by the auditor.
+	ErrSignatureAlgorithm = errors.New("[auditor] The STR's signature algorithm is not allowed")
+	// ErrUnauthenticatedPush indicates that a push of STRs to the
+	// auditor isn't authenticated as coming from the directory or
+	// a relay trusted by the auditor.
+	ErrUnauthenticatedPush = errors.New("[auditor] The push of STRs isn't authenticated")
+	// ErrSTRMissing indicates that the auditor's history of a directory
+	// doesn't include the STR for an epoch, e.g. since the history was
+	// restored with gaps.
+	ErrSTRMissing = errors.New("[auditor] The STR is missing from the directory's history")
+	// ErrReorderBufferFull indicates that STRs received out of order
+	// can't be buffered until the gap before them closes, since the
+	// directory's buffer is full.
+	ErrReorderBufferFull = errors.New("[auditor] The buffer of out-of-order STRs is full")
+	// ErrClosed indicates that the audit log has been closed and
+	// doesn't accept new STRs anymore.
+	ErrClosed = errors.New("[auditor] The audit log is closed")
+	// ErrSubscriberDropped indicates that a directory dropped the
+	// auditor's subscription to its STRs, since the auditor fell behind.
+	ErrSubscriberDropped = errors.New("[auditor] The subscription to the directory's STRs was dropped")
+)
+
+// A SnapshotError indicates that the snapshot of a directory's history
+// for the given Epoch failed verification with the error Err, e.g. when
+// an auditor restores a directory's history from an untrusted source.
+type SnapshotError struct {
+	Epoch uint64
+	Err   error
+}
+
+// Error returns a human-readable description of the bad snapshot.
+func (e *SnapshotError) Error() string {
+	return fmt.Sprintf("[auditor] Bad snapshot for epoch %d: %v", e.Epoch, e.Err)
+}
+
+// Unwrap returns the error with which the snapshot failed verification.
+func (e *SnapshotError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,48 @@
+// This module implements caching the STR signatures an auditor state
+// has verified, so that a client verifying many proofs against the
+// same epoch verifies the STR's signature only once.
+
+package auditor
+
+import (
+	"bytes"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// CacheSTRSignatures makes the AudState verify the signature of each
+// STR of the verified epoch lazily, i.e. only the first time it sees
+// the STR: VerifySTR() then accepts an STR whose signature and contents
+// are identical to an STR it has already verified without verifying
+// the signature again. The cache only holds the STRs of the epoch of
+// the verifiedSTR, and is cleared whenever the verifiedSTR advances to
+// another epoch (see Update()), as well as when the allowed signature
+// schemes change. If on is false, the default, every signature is
+// verified eagerly.
+func (a *AudState) CacheSTRSignatures(on bool) {
+	if on {
+		a.sigCache = make(map[string][]byte)
+	} else {
+		a.sigCache = nil
+	}
+}
+
+// cachedSTR returns whether the signature of str has been verified
+// already, i.e. whether the cache holds an STR with the same signature
+// and the same contents as str.
+func (a *AudState) cachedSTR(str *protocol.DirSTR) bool {
+	if a.sigCache == nil || a.verifiedSTR == nil || str.Epoch != a.verifiedSTR.Epoch {
+		return false
+	}
+	serialized, ok := a.sigCache[string(str.Signature)]
+	return ok && bytes.Equal(serialized, str.Serialize())
+}
+
+// cacheSTR records that the signature of str has been verified, if str
+// is for the epoch of the verifiedSTR.
+func (a *AudState) cacheSTR(str *protocol.DirSTR) {
+	if a.sigCache == nil || a.verifiedSTR == nil || str.Epoch != a.verifiedSTR.Epoch {
+		return
+	}
+	a.sigCache[string(str.Signature)] = str.Serialize()
+}
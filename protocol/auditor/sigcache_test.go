@@ -0,0 +1,47 @@
+package auditor
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+func TestCacheSTRSignatures(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	d.Update()
+	pk, _ := staticSigningKey.Public()
+	aud := New(pk, d.LatestSTR())
+	aud.CacheSTRSignatures(true)
+
+	str := d.LatestSTR()
+	if err := aud.VerifySTR(str); err != nil {
+		t.Fatal(err)
+	}
+	if !aud.cachedSTR(str) {
+		t.Fatal("Expect the verified STR to be cached")
+	}
+
+	// an STR reusing the cached signature for other contents is
+	// verified, and rejected
+	tampered := *str.SignedTreeRoot
+	tampered.TreeHash = append([]byte{}, str.TreeHash...)
+	tampered.TreeHash[0] ^= 0xff
+	forged := protocol.NewDirSTR(&tampered)
+	if aud.cachedSTR(forged) {
+		t.Fatal("Expect an STR with other contents not to hit the cache")
+	}
+	if err := aud.VerifySTR(forged); err != protocol.CheckBadSignature {
+		t.Fatal("Expect", protocol.CheckBadSignature, "got", err)
+	}
+
+	// the cache is cleared once the epoch advances
+	d.Update()
+	if err := aud.AuditDirectory([]*protocol.DirSTR{d.LatestSTR()}); err != nil {
+		t.Fatal(err)
+	}
+	aud.Update(d.LatestSTR())
+	if aud.cachedSTR(str) || len(aud.sigCache) != 0 {
+		t.Error("Expect the cache to be cleared")
+	}
+}
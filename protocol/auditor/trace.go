@@ -0,0 +1,123 @@
+// This module implements an optional trace of the verification steps
+// performed by an AudState (and the clients embedding it), which
+// helps applications report precisely why a check failed.
+
+package auditor
+
+// A TraceStep identifies a single verification step.
+type TraceStep int
+
+// The verification steps recorded in a trace.
+const (
+	// TraceSTRSignature is the verification of an STR's signature.
+	TraceSTRSignature TraceStep = iota
+	// TraceHashChain is the verification that an STR directly follows
+	// the previous STR, i.e. the linkage of their epochs and hashes.
+	TraceHashChain
+	// TraceVRFProof is the verification of a lookup index's VRF proof.
+	TraceVRFProof
+	// TraceAuthPath is the verification of an authentication path,
+	// including recomputing the tree's root node.
+	TraceAuthPath
+	// TraceRePin is an operator's replacement of a directory's pinned
+	// history (see auditlog.ConiksAuditLog.RePin()).
+	TraceRePin
+)
+
+var traceStepNames = map[TraceStep]string{
+	TraceSTRSignature: "STR signature",
+	TraceHashChain:    "STR hash chain",
+	TraceVRFProof:     "VRF proof",
+	TraceAuthPath:     "authentication path",
+	TraceRePin:        "re-pin",
+}
+
+// String returns a human-readable name of the step s.
+func (s TraceStep) String() string {
+	if name, ok := traceStepNames[s]; ok {
+		return name
+	}
+	return "unknown step"
+}
+
+// A TraceEvent records the outcome of a single verification Step for
+// the STR with the given Epoch and, for the VRF proof and
+// authentication path steps, for the given Username.
+// Err is nil if the step passed, and the error it failed with
+// otherwise. Want and Got hold the values compared by the step, if any:
+//   - TraceSTRSignature: Got is the STR's signature.
+//   - TraceHashChain: Want is the hash of the previous STR, and Got is
+//     the previous STR hash included in the STR.
+//   - TraceVRFProof: Got is the lookup index.
+//   - TraceAuthPath: Want is the STR's tree hash, and Got is the root
+//     node recomputed from the authentication path, unless the path's
+//     sibling hashes are part of a multiproof.
+//   - TraceRePin: Want is the identity of the dropped history, and Got
+//     the identity of the new one; Epoch is the latest verified epoch
+//     of the dropped history.
+type TraceEvent struct {
+	Step     TraceStep
+	Epoch    uint64
+	Username string
+	Err      error
+	Want     []byte
+	Got      []byte
+}
+
+// A TraceSink receives the TraceEvents of all verification steps,
+// in the order in which they're performed.
+type TraceSink interface {
+	Record(e *TraceEvent)
+}
+
+// A TraceLog is a TraceSink which keeps all recorded Events in memory.
+type TraceLog struct {
+	Events []*TraceEvent
+}
+
+var _ TraceSink = (*TraceLog)(nil)
+
+// Record appends e to the l.Events.
+func (l *TraceLog) Record(e *TraceEvent) {
+	l.Events = append(l.Events, e)
+}
+
+// Failed returns the first recorded event of a step which failed,
+// or nil if all steps passed.
+func (l *TraceLog) Failed() *TraceEvent {
+	for _, e := range l.Events {
+		if e.Err != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// SetTraceSink sets the sink which receives a TraceEvent for each
+// verification step the AudState performs. A nil sink (the default)
+// disables tracing.
+func (a *AudState) SetTraceSink(sink TraceSink) {
+	a.traceSink = sink
+}
+
+// TraceSink returns the AudState's trace sink, or nil if tracing
+// is disabled.
+func (a *AudState) TraceSink() TraceSink {
+	return a.traceSink
+}
+
+// Trace records e in the AudState's trace sink, if any.
+// This allows the clients embedding an AudState to record their own
+// verification steps in the same trace.
+func (a *AudState) Trace(e *TraceEvent) {
+	if a.traceSink != nil {
+		a.traceSink.Record(e)
+	}
+}
+
+// Tracing returns whether the AudState has a trace sink, so that
+// callers can skip computing values which are only recorded in
+// the trace.
+func (a *AudState) Tracing() bool {
+	return a.traceSink != nil
+}
@@ -0,0 +1,80 @@
+// Implements restricting the lookups a client accepts to STRs which an
+// auditor has audited, i.e. served to the client in a range of STRs
+// which passed CheckEquivocation().
+
+package client
+
+import (
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// RequireAudited sets whether cc rejects lookups against STRs which
+// haven't been audited. If on is true, HandleResponse(),
+// HandleBatchResponse(), HandleBatchMultiProof() and
+// VerifyHistoricalProof() reject a lookup with CheckUnconfirmedSTR,
+// unless its STR is one of the STRs of a range which an auditor served
+// to cc and which passed CheckEquivocation(). Unlike
+// RequireAuditorConfirmation(), which only accepts the latest
+// confirmed STR, RequireAudited() accepts any audited STR, e.g. the
+// STR of an archived proof.
+// By default, cc accepts lookups against unaudited STRs.
+func (cc *ConsistencyChecks) RequireAudited(on bool) {
+	cc.requireAudited = on
+}
+
+// DefaultAuditedWindow is the number of epochs up to the latest
+// audited epoch for which a new ConsistencyChecks keeps the audited
+// STRs (see SetAuditedWindow()).
+const DefaultAuditedWindow = 1024
+
+// SetAuditedWindow makes cc keep the audited STRs only for the latest
+// epochs epochs up to the latest audited epoch, so that the memory used
+// by recordAudited() is bounded. If cc requires lookups to be against
+// audited STRs (see RequireAudited()), lookups against an older STR are
+// rejected, even if it has been audited.
+// Windows below 1 are treated as 1.
+func (cc *ConsistencyChecks) SetAuditedWindow(epochs uint64) {
+	if epochs < 1 {
+		epochs = 1
+	}
+	cc.auditedWindow = epochs
+	cc.pruneAudited()
+}
+
+// recordAudited records the STRs strs, which passed
+// CheckEquivocation(), as audited, and drops the audited STRs which
+// are no longer in the audited window (see SetAuditedWindow()).
+func (cc *ConsistencyChecks) recordAudited(strs []*protocol.DirSTR) {
+	for _, str := range strs {
+		cc.audited[str.Epoch] = str
+		if str.Epoch > cc.latestAudited {
+			cc.latestAudited = str.Epoch
+		}
+	}
+	cc.pruneAudited()
+}
+
+func (cc *ConsistencyChecks) pruneAudited() {
+	if cc.latestAudited < cc.auditedWindow {
+		return
+	}
+	oldest := cc.latestAudited - cc.auditedWindow + 1
+	for ep := range cc.audited {
+		if ep < oldest {
+			delete(cc.audited, ep)
+		}
+	}
+}
+
+// checkAudited returns CheckUnconfirmedSTR if cc requires lookups to be
+// against audited STRs (see RequireAudited()), and str hasn't been
+// audited.
+func (cc *ConsistencyChecks) checkAudited(str *protocol.DirSTR) error {
+	if !cc.requireAudited {
+		return nil
+	}
+	if audited, ok := cc.audited[str.Epoch]; !ok || !sameSTR(audited, str) {
+		return protocol.CheckUnconfirmedSTR
+	}
+	return nil
+}
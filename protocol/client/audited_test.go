@@ -0,0 +1,129 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+func TestRequireAudited(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.Update()
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+
+	cc.RequireAudited(true)
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != protocol.CheckUnconfirmedSTR {
+		t.Fatal("Expect", protocol.CheckUnconfirmedSTR, "got", err)
+	}
+	if cc.VerifiedSTR().Epoch != 0 || cc.Bindings[alice] != nil {
+		t.Fatal("Expect an unaudited lookup to leave the state unchanged")
+	}
+
+	// the lookup's STR has been audited
+	if err := cc.CheckEquivocation(getSTRHistory(d)); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Fatal("Expect an audited lookup to be accepted, got", err)
+	}
+
+	// the directory's next STR hasn't been audited yet
+	d.Update()
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != protocol.CheckUnconfirmedSTR {
+		t.Fatal("Expect", protocol.CheckUnconfirmedSTR, "got", err)
+	}
+	batch := d.BatchKeyLookup(&protocol.BatchKeyLookupRequest{Usernames: []string{alice}})
+	if _, err := cc.HandleBatchResponse(batch, []string{alice}, nil); err != protocol.CheckUnconfirmedSTR {
+		t.Fatal("Expect", protocol.CheckUnconfirmedSTR, "got", err)
+	}
+	if cc.VerifiedSTR().Epoch != 1 {
+		t.Fatal("Expect the verified epoch to stay 1")
+	}
+
+	// unless the requirement is lifted
+	cc.RequireAudited(false)
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequireAuditedHistoricalProof(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.Update()
+	d.Update()
+
+	// archive alice's proofs from epochs 2 and 3
+	archived := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice}).
+		DirectoryResponse.(*protocol.DirectoryProof)
+	d.Update()
+	unaudited := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice}).
+		DirectoryResponse.(*protocol.DirectoryProof)
+
+	// the auditor has audited up to epoch 2
+	cc.RequireAudited(true)
+	for epoch := uint64(1); epoch <= 2; epoch++ {
+		if err := cc.CheckEquivocation(d.GetSTRHistory(&protocol.STRHistoryRequest{
+			StartEpoch: 0,
+			EndEpoch:   epoch})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := cc.VerifyHistoricalProof(archived, alice, key, archived.STR[0]); err != nil {
+		t.Fatal("Expect a proof against an audited STR to verify, got", err)
+	}
+	if _, err := cc.VerifyHistoricalProof(unaudited, alice, key,
+		unaudited.STR[0]); err != protocol.CheckUnconfirmedSTR {
+		t.Error("Expect", protocol.CheckUnconfirmedSTR, "got", err)
+	}
+
+	// an older audited STR is accepted even after later ones are audited
+	if err := cc.CheckEquivocation(getSTRHistory(d)); err != nil {
+		t.Fatal(err)
+	}
+	for _, df := range []*protocol.DirectoryProof{archived, unaudited} {
+		if _, err := cc.VerifyHistoricalProof(df, alice, key, df.STR[0]); err != nil {
+			t.Error("Expect the proof for epoch", df.STR[0].Epoch, "to verify, got", err)
+		}
+	}
+}
+
+func TestAuditedWindow(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.Update()
+	archived := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice}).
+		DirectoryResponse.(*protocol.DirectoryProof)
+	d.Update()
+	d.Update()
+
+	cc.RequireAudited(true)
+	cc.SetAuditedWindow(2)
+	for epoch := uint64(1); epoch <= 3; epoch++ {
+		if err := cc.CheckEquivocation(d.GetSTRHistory(&protocol.STRHistoryRequest{
+			StartEpoch: 0,
+			EndEpoch:   epoch})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(cc.audited) != 2 {
+		t.Fatal("Expect only the audited STRs in the window to be kept, got", len(cc.audited))
+	}
+	// epoch 1 dropped out of the window
+	if _, err := cc.VerifyHistoricalProof(archived, alice, key,
+		archived.STR[0]); err != protocol.CheckUnconfirmedSTR {
+		t.Error("Expect", protocol.CheckUnconfirmedSTR, "got", err)
+	}
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Error("Expect a lookup against the latest audited STR to verify, got", err)
+	}
+
+	// shrinking the window prunes the audited STRs right away
+	cc.SetAuditedWindow(1)
+	if _, ok := cc.audited[d.LatestSTR().Epoch]; !ok || len(cc.audited) != 1 {
+		t.Error("Expect only the latest audited STR to be kept")
+	}
+}
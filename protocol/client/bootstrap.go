@@ -0,0 +1,62 @@
+// Implements bootstrapping a CONIKS client's consistency state
+// from the STR history observed by a CONIKS auditor.
+
+package client
+
+import (
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+// BootstrapFromAuditor creates an instance of ConsistencyChecks from
+// a CONIKS auditor's response audResp to an AuditingRequest for the
+// directory's full STR history, instead of pinning the directory's
+// initial STR on first use. This narrows the client's trust-on-first-use
+// window to the auditor rather than the directory.
+//
+// BootstrapFromAuditor() expects the STRHistoryRange in audResp to start
+// at the directory's initial STR, whose hash must match expectedInitHash
+// (see auditor.ComputeDirectoryIdentity()). It then validates the
+// initial STR under the directory's signing key signKey (see
+// protocol.ValidateGenesisSTR()), verifies the hash chain of the
+// remaining STRs in the range, and pins the newest STR in the range if
+// all checks pass.
+// BootstrapFromAuditor() returns ErrMalformedMessage if the response is
+// malformed or doesn't start at epoch 0, CheckBadSTR if the range's
+// initial STR doesn't match expectedInitHash, or the appropriate
+// consistency check error if any of the other checks fail.
+func BootstrapFromAuditor(audResp *protocol.Response,
+	expectedInitHash [crypto.HashSizeByte]byte,
+	useTBs bool, signKey sign.PublicKey) (*ConsistencyChecks, error) {
+	if err := audResp.ValidateFor(protocol.AuditType); err != nil {
+		return nil, err
+	}
+	strs := audResp.DirectoryResponse.(*protocol.STRHistoryRange)
+	if strs.STR[0].Epoch != 0 {
+		return nil, protocol.ErrMalformedMessage
+	}
+
+	initSTR := strs.STR[0]
+	dirInitHash, err := auditor.DirectoryIdentity(initSTR)
+	if err != nil {
+		return nil, err
+	}
+	if dirInitHash != expectedInitHash {
+		return nil, protocol.CheckBadSTR
+	}
+
+	if err := protocol.ValidateGenesisSTR(initSTR, signKey); err != nil {
+		return nil, err
+	}
+	a := auditor.New(signKey, initSTR)
+	if err := a.VerifySTR(initSTR); err != nil {
+		return nil, err
+	}
+	if err := a.VerifySTRRange(initSTR, strs.STR[1:]); err != nil {
+		return nil, err
+	}
+
+	return New(strs.STR[len(strs.STR)-1], useTBs, signKey), nil
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditlog"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+func TestBootstrapFromAuditor(t *testing.T) {
+	d, aud, hist := auditlog.NewTestAuditLog(t, 5)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	res := aud.GetObservedSTRs(&protocol.AuditingRequest{
+		DirInitSTRHash: dirInitHash,
+		StartEpoch:     0,
+		EndEpoch:       d.LatestSTR().Epoch})
+
+	pk, _ := staticSigningKey.Public()
+	cc, err := BootstrapFromAuditor(res, dirInitHash, true, pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cc.VerifiedSTR().Epoch != d.LatestSTR().Epoch {
+		t.Fatal("Expect the newest STR to be pinned, got epoch", cc.VerifiedSTR().Epoch)
+	}
+
+	// the bootstrapped client can verify subsequent lookups
+	d.Update()
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBootstrapFromAuditorBadGenesis(t *testing.T) {
+	d, aud, hist := auditlog.NewTestAuditLog(t, 5)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	pk, _ := staticSigningKey.Public()
+
+	res := aud.GetObservedSTRs(&protocol.AuditingRequest{
+		DirInitSTRHash: dirInitHash,
+		StartEpoch:     0,
+		EndEpoch:       d.LatestSTR().Epoch})
+
+	// the range's genesis STR doesn't match the expected directory
+	var otherHash = dirInitHash
+	otherHash[0]++
+	if _, err := BootstrapFromAuditor(res, otherHash, true, pk); err != protocol.CheckBadSTR {
+		t.Error("Expect", protocol.CheckBadSTR, "got", err)
+	}
+
+	// the range doesn't start at the genesis STR
+	res = aud.GetObservedSTRs(&protocol.AuditingRequest{
+		DirInitSTRHash: dirInitHash,
+		StartEpoch:     1,
+		EndEpoch:       d.LatestSTR().Epoch})
+	if _, err := BootstrapFromAuditor(res, dirInitHash, true, pk); err != protocol.ErrMalformedMessage {
+		t.Error("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+}
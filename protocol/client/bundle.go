@@ -0,0 +1,85 @@
+// Implements proof bundles, which allow a CONIKS client's key lookup
+// and equivocation check to be re-verified offline, e.g. on a
+// different machine.
+
+package client
+
+import (
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// A ProofBundle collects everything needed to re-verify a key lookup
+// for Username offline: the STR SavedSTR the client had verified before
+// the lookup, the directory's response to the lookup (i.e. its error
+// code LookupError and its DirectoryProof Lookup, which includes the
+// VRF proof, the authentication path and the STR), the Key the client
+// expected, and the STRs Observed returned by an auditor in response
+// to an AuditingRequest.
+// A ProofBundle only contains concrete types, so it can be serialized
+// with encoding/json and verified with VerifyBundle().
+type ProofBundle struct {
+	Username    string
+	Key         []byte
+	SavedSTR    *protocol.DirSTR
+	LookupError protocol.ErrorCode
+	Lookup      *protocol.DirectoryProof
+	Observed    []*protocol.DirSTR
+}
+
+// NewProofBundle creates a ProofBundle from the client's verified STR
+// savedSTR at the time of the lookup, the directory's response lookup
+// to a KeyLookupRequest for uname, the expected key, and the auditor's
+// response audit to an AuditingRequest.
+// It returns ErrMalformedMessage if either response is malformed or
+// of the wrong type.
+func NewProofBundle(savedSTR *protocol.DirSTR, uname string, key []byte,
+	lookup, audit *protocol.Response) (*ProofBundle, error) {
+	if err := lookup.ValidateFor(protocol.KeyLookupType); err != nil {
+		return nil, protocol.ErrMalformedMessage
+	}
+	if err := audit.ValidateFor(protocol.AuditType); err != nil {
+		return nil, protocol.ErrMalformedMessage
+	}
+	return &ProofBundle{
+		Username:    uname,
+		Key:         key,
+		SavedSTR:    savedSTR,
+		LookupError: lookup.Error,
+		Lookup:      lookup.DirectoryResponse.(*protocol.DirectoryProof),
+		Observed:    audit.DirectoryResponse.(*protocol.STRHistoryRange).STR,
+	}, nil
+}
+
+// VerifyBundle verifies the given bundle offline as if a client that
+// pinned the directory's signing key pinnedKey and had verified
+// bundle.SavedSTR received the bundled responses:
+// it verifies the signature of bundle.SavedSTR, then performs the
+// full lookup verification (see HandleResponse()), and finally checks
+// the auditor's observed STRs for equivocation (see CheckEquivocation()).
+// VerifyBundle() returns ErrMalformedMessage if the bundle is incomplete,
+// CheckBadSignature if bundle.SavedSTR isn't signed under pinnedKey,
+// or the first error returned by the verification steps.
+func VerifyBundle(bundle *ProofBundle, pinnedKey sign.PublicKey) error {
+	if bundle == nil || bundle.Lookup == nil {
+		return protocol.ErrMalformedMessage
+	}
+	if err := protocol.NewSTRHistoryRange([]*protocol.DirSTR{bundle.SavedSTR}).
+		Validate(); err != nil {
+		return protocol.ErrMalformedMessage
+	}
+	if !pinnedKey.Verify(bundle.SavedSTR.Serialize(), bundle.SavedSTR.Signature) {
+		return protocol.CheckBadSignature
+	}
+
+	cc := New(bundle.SavedSTR, true, pinnedKey)
+	lookup := &protocol.Response{
+		Error:             bundle.LookupError,
+		DirectoryResponse: bundle.Lookup,
+	}
+	if err := cc.HandleResponse(protocol.KeyLookupType, lookup,
+		bundle.Username, bundle.Key); err != nil {
+		return err
+	}
+	return cc.CheckEquivocation(protocol.NewSTRHistoryRange(bundle.Observed))
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+func newTestBundle(t *testing.T) *ProofBundle {
+	d, cc := newTestClient(t)
+	saved := cc.VerifiedSTR()
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.Update()
+
+	lookup := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	audit := d.GetSTRHistory(&protocol.STRHistoryRequest{StartEpoch: 0, EndEpoch: 1})
+	bundle, err := NewProofBundle(saved, alice, key, lookup, audit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// bundles are verified on a different machine
+	bs, err := json.Marshal(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle = new(ProofBundle)
+	if err := json.Unmarshal(bs, bundle); err != nil {
+		t.Fatal(err)
+	}
+	return bundle
+}
+
+func TestVerifyBundle(t *testing.T) {
+	bundle := newTestBundle(t)
+	pk, _ := staticSigningKey.Public()
+	if err := VerifyBundle(bundle, pk); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyBundleTamperedAuthPath(t *testing.T) {
+	bundle := newTestBundle(t)
+	pk, _ := staticSigningKey.Public()
+	bundle.Lookup.AP[0].PrunedTree[0][0] ^= 0xff
+	if err := VerifyBundle(bundle, pk); err != protocol.CheckBadAuthPath {
+		t.Fatal("Expect", protocol.CheckBadAuthPath, "got", err)
+	}
+}
+
+func TestVerifyBundleMalformed(t *testing.T) {
+	pk, _ := staticSigningKey.Public()
+	if err := VerifyBundle(&ProofBundle{}, pk); err != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+}
@@ -0,0 +1,51 @@
+// Implements a sanity check of the rate at which a directory advances
+// its epochs, so that a directory racing through epochs, e.g. to outrun
+// its auditors, is flagged.
+
+package client
+
+import (
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// SetCadenceTolerance makes cc check that the directory's epochs
+// advance at the rate declared by its epoch deadline (see
+// protocol.Policies.EpochDeadline) whenever cc adopts a newer STR,
+// by comparing the timestamps of the cc.verifiedSTR and the new STR.
+// The check fails with a *CadenceError if the epochs took less than
+// 1/factor or more than factor times the expected time. A factor of 0,
+// the default, disables the check; factors between 0 and 1 are treated
+// as 1. STRs without a timestamp aren't checked.
+func (cc *ConsistencyChecks) SetCadenceTolerance(factor float64) {
+	if factor > 0 && factor < 1 {
+		factor = 1
+	}
+	cc.cadenceTolerance = factor
+}
+
+// checkCadence checks that the time between the timestamps of the STRs
+// prev and str is plausible for the number of epochs between them,
+// given the epoch deadline declared by prev's policies (see
+// SetCadenceTolerance()).
+func (cc *ConsistencyChecks) checkCadence(prev, str *protocol.DirSTR) error {
+	if cc.cadenceTolerance == 0 || str.Epoch <= prev.Epoch ||
+		prev.Timestamp == 0 || str.Timestamp == 0 ||
+		prev.Policies.EpochDeadline == 0 {
+		return nil
+	}
+	var elapsed uint64
+	if str.Timestamp > prev.Timestamp {
+		elapsed = str.Timestamp - prev.Timestamp
+	}
+	expected := (str.Epoch - prev.Epoch) * uint64(prev.Policies.EpochDeadline)
+	if float64(elapsed)*cc.cadenceTolerance < float64(expected) ||
+		float64(elapsed) > float64(expected)*cc.cadenceTolerance {
+		return &CadenceError{
+			FromEpoch: prev.Epoch,
+			ToEpoch:   str.Epoch,
+			Elapsed:   elapsed,
+			Expected:  expected,
+		}
+	}
+	return nil
+}
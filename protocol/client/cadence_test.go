@@ -0,0 +1,98 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+// newTestClockedClient returns a test directory whose STRs are
+// timestamped by the returned clock, and a client pinning its latest STR.
+func newTestClockedClient(t *testing.T) (*directory.ConiksDirectory,
+	*ConsistencyChecks, *time.Time) {
+	now := time.Unix(1000, 0)
+	d := directory.NewTestDirectory(t)
+	d.SetClock(func() time.Time { return now })
+	if err := d.Update(); err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := staticSigningKey.Public()
+	cc := New(d.LatestSTR(), true, pk)
+	cc.SetCadenceTolerance(2)
+	return d, cc, &now
+}
+
+func TestCadenceNormal(t *testing.T) {
+	d, cc, now := newTestClockedClient(t)
+	for i := 0; i < 3; i++ {
+		// the test directory's epoch deadline is 1s
+		*now = now.Add(time.Second)
+		d.Update()
+		res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+		if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, nil); err != nil {
+			t.Fatal("Expect a normal cadence to pass, got", err)
+		}
+	}
+
+	// the same holds for STRs adopted from an auditor
+	for i := 0; i < 3; i++ {
+		*now = now.Add(time.Second)
+		d.Update()
+		if err := cc.CheckEquivocation(nextSTRRange(d, cc)); err != nil {
+			t.Fatal("Expect a normal cadence to pass, got", err)
+		}
+	}
+}
+
+// nextSTRRange returns the range of d's STRs from the cc.verifiedSTR to
+// the STR for the following epoch.
+func nextSTRRange(d *directory.ConiksDirectory, cc *ConsistencyChecks) *protocol.Response {
+	ep := cc.VerifiedSTR().Epoch
+	return d.GetSTRHistory(&protocol.STRHistoryRequest{StartEpoch: ep, EndEpoch: ep + 1})
+}
+
+func TestCadenceTooFast(t *testing.T) {
+	d, cc, now := newTestClockedClient(t)
+	start := cc.VerifiedSTR().Epoch
+
+	// 5 epochs in a single second
+	*now = now.Add(time.Second)
+	for i := 0; i < 5; i++ {
+		d.Update()
+	}
+	if err := cc.CheckEquivocation(nextSTRRange(d, cc)); err != nil {
+		t.Fatal(err)
+	}
+	res := nextSTRRange(d, cc)
+	err := cc.CheckEquivocation(res)
+	var e *CadenceError
+	if !errors.As(err, &e) || !errors.Is(err, protocol.CheckImplausibleCadence) {
+		t.Fatal("Expect a *CadenceError, got", err)
+	}
+	if e.FromEpoch != start+1 || e.ToEpoch != start+2 || e.Elapsed != 0 || e.Expected != 1 {
+		t.Error("Unexpected cadence error", e)
+	}
+	if cc.VerifiedSTR().Epoch != start+1 {
+		t.Error("Expect the flagged STR not to be adopted")
+	}
+
+	// the check is disabled by a tolerance of 0
+	cc.SetCadenceTolerance(0)
+	if err := cc.CheckEquivocation(res); err != nil {
+		t.Error("Expect no cadence check, got", err)
+	}
+}
+
+func TestCadenceTooSlow(t *testing.T) {
+	d, cc, now := newTestClockedClient(t)
+	*now = now.Add(time.Minute)
+	d.Update()
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice,
+		nil); !errors.Is(err, protocol.CheckImplausibleCadence) {
+		t.Error("Expect", protocol.CheckImplausibleCadence, "got", err)
+	}
+}
@@ -0,0 +1,38 @@
+// Implements the client-side verification of STR challenges, which
+// allow a CONIKS client to check the freshness of a directory's STR.
+
+package client
+
+import (
+	"bytes"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// VerifySTRChallenge verifies the directory's response msg to an
+// STRChallengeRequest with the given nonce, i.e. that the directory
+// signed the returned STR together with nonce, and that the STR is
+// consistent with the cc.verifiedSTR (see HandleResponse()).
+// If the checks pass, VerifySTRChallenge() adopts the STR as the
+// cc.verifiedSTR, which the client then knows to be fresh.
+// VerifySTRChallenge() returns CheckBadChallenge if msg is for a
+// different nonce, CheckBadSignature if the directory's signature on
+// the challenge response is invalid, or the error returned by the
+// consistency checks.
+func (cc *ConsistencyChecks) VerifySTRChallenge(nonce []byte, msg *protocol.Response) error {
+	if err := msg.ValidateFor(protocol.STRChallengeType); err != nil {
+		return err
+	}
+	c := msg.DirectoryResponse.(*protocol.STRChallengeResponse)
+	if !bytes.Equal(c.Nonce, nonce) {
+		return protocol.CheckBadChallenge
+	}
+	if !cc.Verify(c.Serialize(), c.Signature) {
+		return protocol.CheckBadSignature
+	}
+	if err := cc.AuditDirectory([]*protocol.DirSTR{c.STR}); err != nil {
+		return err
+	}
+	cc.Update(c.STR)
+	return nil
+}
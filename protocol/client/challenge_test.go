@@ -0,0 +1,41 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+func TestVerifySTRChallenge(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.Update()
+
+	nonce := []byte("nonce")
+	res := d.STRChallenge(&protocol.STRChallengeRequest{Nonce: nonce})
+	if err := cc.VerifySTRChallenge(nonce, res); err != nil {
+		t.Fatal(err)
+	}
+	if cc.VerifiedSTR().Epoch != 1 {
+		t.Fatal("Expect the fresh STR to be adopted")
+	}
+}
+
+func TestVerifySTRChallengeMismatchedNonce(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.Update()
+
+	// a replayed response to an earlier challenge
+	res := d.STRChallenge(&protocol.STRChallengeRequest{Nonce: []byte("old nonce")})
+	if err := cc.VerifySTRChallenge([]byte("nonce"), res); err != protocol.CheckBadChallenge {
+		t.Fatal("Expect", protocol.CheckBadChallenge, "got", err)
+	}
+
+	// a response whose nonce was replaced
+	res.DirectoryResponse.(*protocol.STRChallengeResponse).Nonce = []byte("nonce")
+	if err := cc.VerifySTRChallenge([]byte("nonce"), res); err != protocol.CheckBadSignature {
+		t.Fatal("Expect", protocol.CheckBadSignature, "got", err)
+	}
+	if cc.VerifiedSTR().Epoch != 0 {
+		t.Fatal("Expect the verified STR to be unchanged")
+	}
+}
@@ -25,6 +25,10 @@ import (
 // This ConsistencyChecks instance will then be used to verify
 // subsequent responses from the ConiksDirectory to any
 // client request.
+//
+// A ConsistencyChecks isn't safe for concurrent use; clients verifying
+// responses from several goroutines should wrap it in a
+// SyncConsistencyChecks.
 type ConsistencyChecks struct {
 	// the auditor state stores the latest verified signed tree root
 	// as well as the server's signing key
@@ -34,22 +38,65 @@ type ConsistencyChecks struct {
 	// extensions settings
 	useTBs bool
 	TBs    map[string]*protocol.TemporaryBinding
+
+	// the latest STR confirmed by an auditor (see CheckEquivocation())
+	confirmedSTR        *protocol.DirSTR
+	requireConfirmation bool
+
+	// the STRs served by auditors which passed CheckEquivocation(),
+	// indexed by epoch, for the epochs of the audited window
+	// (see RequireAudited())
+	audited        map[uint64]*protocol.DirSTR
+	latestAudited  uint64
+	auditedWindow  uint64
+	requireAudited bool
+
+	// the tolerated deviation from the directory's declared epoch
+	// cadence (see SetCadenceTolerance())
+	cadenceTolerance float64
+
+	// the type of the proofs verified for each username in each epoch
+	// of the exclusivity window (see checkExclusivity())
+	proofTypes        map[string]map[uint64]merkletree.ProofType
+	exclusivityWindow uint64
+
+	// the latest lookup index verified for each username
+	// (see checkIndexStability())
+	indices map[string]*verifiedIndex
 }
 
 // New creates an instance of ConsistencyChecks using
 // a CONIKS directory's pinned STR at epoch 0, or
 // the consistency state read from persistent storage.
+// New() doesn't validate an initial savedSTR; callers pinning an initial
+// STR obtained from an untrusted source should use NewChecked().
 func New(savedSTR *protocol.DirSTR, useTBs bool, signKey sign.PublicKey) *ConsistencyChecks {
+	return NewWithKeys(savedSTR, useTBs, []sign.PublicKey{signKey})
+}
+
+// NewWithKeys is like New but pins a set of acceptable directory
+// signing keys signKeys (e.g. a primary and a standby key), so that the
+// directory can rotate its signing key without a flag day.
+// The client accepts STRs and TBs signed by any of the pinned keys,
+// and rejects those signed by any other key.
+func NewWithKeys(savedSTR *protocol.DirSTR, useTBs bool,
+	signKeys []sign.PublicKey) *ConsistencyChecks {
 	// TODO: see #110
 	if !useTBs {
 		panic("[coniks] Currently the server is forced to use TBs")
 	}
-	a := auditor.New(signKey, savedSTR)
+	a := auditor.NewWithKeys(signKeys, savedSTR)
 	cc := &ConsistencyChecks{
-		AudState: a,
-		Bindings: make(map[string][]byte),
-		useTBs:   useTBs,
-		TBs:      nil,
+		AudState:   a,
+		Bindings:   make(map[string][]byte),
+		useTBs:     useTBs,
+		TBs:        nil,
+		proofTypes: make(map[string]map[uint64]merkletree.ProofType),
+		indices:    make(map[string]*verifiedIndex),
+		audited:    make(map[uint64]*protocol.DirSTR),
+
+		auditedWindow:     DefaultAuditedWindow,
+		exclusivityWindow: DefaultExclusivityWindow,
 	}
 	if useTBs {
 		cc.TBs = make(map[string]*protocol.TemporaryBinding)
@@ -57,18 +104,160 @@ func New(savedSTR *protocol.DirSTR, useTBs bool, signKey sign.PublicKey) *Consis
 	return cc
 }
 
+// NewChecked is like New, but first validates savedSTR under signKey if
+// it is an initial STR (see protocol.ValidateGenesisSTR()). It returns
+// ErrMalformedMessage if savedSTR is nil, or the error of the failed
+// validation.
+func NewChecked(savedSTR *protocol.DirSTR, useTBs bool,
+	signKey sign.PublicKey) (*ConsistencyChecks, error) {
+	return NewWithKeysChecked(savedSTR, useTBs, []sign.PublicKey{signKey})
+}
+
+// NewWithKeysChecked is like NewWithKeys, but first validates savedSTR
+// under any of the pinned keys signKeys if it is an initial STR, as
+// NewChecked() does.
+func NewWithKeysChecked(savedSTR *protocol.DirSTR, useTBs bool,
+	signKeys []sign.PublicKey) (*ConsistencyChecks, error) {
+	if savedSTR == nil || savedSTR.SignedTreeRoot == nil {
+		return nil, protocol.ErrMalformedMessage
+	}
+	if savedSTR.Epoch == 0 {
+		if err := validateGenesisSTR(savedSTR, signKeys); err != nil {
+			return nil, err
+		}
+	}
+	return NewWithKeys(savedSTR, useTBs, signKeys), nil
+}
+
+// validateGenesisSTR validates the initial STR str under any of the
+// signing keys signKeys (see protocol.ValidateGenesisSTR()), and returns
+// the error for the first key if none of them validates str.
+func validateGenesisSTR(str *protocol.DirSTR, signKeys []sign.PublicKey) error {
+	var first error
+	for i, pk := range signKeys {
+		err := protocol.ValidateGenesisSTR(str, pk)
+		if err == nil {
+			return nil
+		}
+		if i == 0 {
+			first = err
+		}
+	}
+	return first
+}
+
+// Update updates the cc.verifiedSTR to newSTR, as
+// auditor.AudState.Update() does. If newSTR uses a different VRF key
+// than the cc.verifiedSTR, i.e. the directory has rotated its VRF key
+// (see protocol.Policies.RotatesVRFKey()), the lookup indices promised
+// by the pending TBs in cc.TBs are void, since the bindings moved to
+// their indices under the new key. Update() then clears the Index of
+// these TBs, so that the client only requires the directory to fulfill
+// them with the promised keys, at the indices proven by the lookups'
+// VRF proofs under the new key.
+func (cc *ConsistencyChecks) Update(newSTR *protocol.DirSTR) {
+	if old := cc.VerifiedSTR(); old != nil &&
+		!bytes.Equal(old.Policies.VrfPublicKey, newSTR.Policies.VrfPublicKey) {
+		for uname, tb := range cc.TBs {
+			rekeyed := *tb
+			rekeyed.Index = nil
+			cc.TBs[uname] = &rekeyed
+		}
+	}
+	cc.AudState.Update(newSTR)
+}
+
+// VerifiedPolicies returns the directory's policies included in the
+// cc.verifiedSTR (e.g. its epoch deadline and public VRF key), i.e.
+// the policies of the most recent STR the client has verified.
+// This allows an application to display or enforce the policies
+// the client currently trusts.
+// Note that the tree height isn't part of a directory's policies.
+func (cc *ConsistencyChecks) VerifiedPolicies() *protocol.Policies {
+	return cc.VerifiedSTR().Policies
+}
+
+// DirectoryMetadata returns the metadata declared in the directory's
+// policies of the cc.verifiedSTR (e.g. its official auditors, see
+// directory.SetMetadata()), or nil if the directory doesn't declare
+// any. Since the metadata is signed along with the STR, a client can
+// rely on it rather than on out-of-band configuration.
+func (cc *ConsistencyChecks) DirectoryMetadata() *protocol.DirectoryMetadata {
+	return cc.VerifiedPolicies().Metadata
+}
+
 // CheckEquivocation checks for possible equivocation between
 // an auditors' observed STRs and the client's own view.
 // CheckEquivocation() first verifies the STR range received
 // in msg if msg contains more than 1 STR, and
 // then checks the most recent STR in msg against
 // the cc.verifiedSTR.
+// If the range in msg includes an STR for the epoch of the
+// cc.verifiedSTR that differs from it, CheckEquivocation() returns
+// an *EquivocationError reporting the diverging epoch and both
+// conflicting STR signatures. CheckEquivocation() returns
+// auditor.ErrRollback if the most recent STR in msg is older than
+// the cc.verifiedSTR.
+// If the checks pass and the auditor has confirmed a more recent STR,
+// CheckEquivocation() adopts it as the cc.verifiedSTR, so that any
+// subsequent lookup must be against the auditor-confirmed STR or one of
+// its verified successors.
 // CheckEquivocation() is called when a client receives a response to a
 // message.AuditingRequest from an auditor.
 func (cc *ConsistencyChecks) CheckEquivocation(msg *protocol.Response) error {
-	if err := msg.Validate(); err != nil {
+	latest, err := cc.checkEquivocation(msg)
+	if err != nil {
 		return err
 	}
+	if latest.Epoch > cc.VerifiedSTR().Epoch {
+		cc.Update(latest)
+	}
+	cc.confirmedSTR = latest
+	cc.recordAudited(msg.DirectoryResponse.(*protocol.STRHistoryRange).STR)
+	return nil
+}
+
+// RequireAuditorConfirmation sets whether cc fails closed on lookups
+// which haven't been confirmed by an auditor. If on is true,
+// HandleResponse() and HandleBatchResponse() reject the response to
+// a key lookup with CheckUnconfirmedSTR, unless its STR is the latest
+// STR confirmed by a successful CheckEquivocation(). This prevents
+// a client from trusting a key on the directory's word alone, e.g.
+// while the directory presents it with a stale fork.
+// By default, cc accepts lookups without an auditor's confirmation.
+func (cc *ConsistencyChecks) RequireAuditorConfirmation(on bool) {
+	cc.requireConfirmation = on
+}
+
+// checkConfirmed returns CheckUnconfirmedSTR if cc requires an auditor's
+// confirmation of the STR str of a response to a request of type
+// requestType, and str isn't the cc.confirmedSTR, or if cc requires
+// str to be audited and it hasn't been (see checkAudited()).
+func (cc *ConsistencyChecks) checkConfirmed(requestType int, str *protocol.DirSTR) error {
+	if requestType != protocol.KeyLookupType {
+		return nil
+	}
+	if err := cc.checkAudited(str); err != nil {
+		return err
+	}
+	if !cc.requireConfirmation {
+		return nil
+	}
+	if c := cc.confirmedSTR; c == nil || str.Epoch != c.Epoch ||
+		!bytes.Equal(str.Signature, c.Signature) ||
+		!bytes.Equal(str.Serialize(), c.Serialize()) {
+		return protocol.CheckUnconfirmedSTR
+	}
+	return nil
+}
+
+// checkEquivocation performs the checks of CheckEquivocation() without
+// updating the cc.verifiedSTR, and returns the most recent STR in msg
+// if the checks pass.
+func (cc *ConsistencyChecks) checkEquivocation(msg *protocol.Response) (*protocol.DirSTR, error) {
+	if err := msg.ValidateFor(protocol.AuditType); err != nil {
+		return nil, err
+	}
 
 	strs := msg.DirectoryResponse.(*protocol.STRHistoryRange)
 
@@ -76,14 +265,113 @@ func (cc *ConsistencyChecks) CheckEquivocation(msg *protocol.Response) error {
 	// if we get more than 1 in our range
 	if len(strs.STR) > 1 {
 		if err := cc.VerifySTRRange(strs.STR[0], strs.STR[1:]); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	// TODO: if the auditor has returned a more recent STR,
-	// should the client update its savedSTR? Should this
+	// never accept a range that would shrink our verified history
+	latest := strs.STR[len(strs.STR)-1]
+	if latest.Epoch < cc.VerifiedSTR().Epoch {
+		return nil, auditor.ErrRollback
+	}
+
+	if err := cc.findDivergence(strs.STR); err != nil {
+		return nil, err
+	}
+
+	// TODO: should adopting a more recent STR
 	// force a new round of monitoring?
-	return cc.CheckSTRAgainstVerified(strs.STR[len(strs.STR)-1])
+	if err := cc.CheckSTRAgainstVerified(latest); err != nil {
+		return nil, err
+	}
+	if err := cc.checkCadence(cc.VerifiedSTR(), latest); err != nil {
+		return nil, err
+	}
+	return latest, nil
+}
+
+// findDivergence returns an *EquivocationError if the auditor's range
+// of STRs strs includes an STR for the epoch of cc.verifiedSTR that
+// differs from cc.verifiedSTR, and nil otherwise.
+func (cc *ConsistencyChecks) findDivergence(strs []*protocol.DirSTR) error {
+	verified := cc.VerifiedSTR()
+	for _, str := range strs {
+		if str.Epoch != verified.Epoch {
+			continue
+		}
+		if !bytes.Equal(str.Signature, verified.Signature) ||
+			!bytes.Equal(str.Serialize(), verified.Serialize()) {
+			return &EquivocationError{
+				Epoch:            verified.Epoch,
+				ClientSignature:  verified.Signature,
+				AuditorSignature: str.Signature,
+			}
+		}
+	}
+	return nil
+}
+
+// QuorumCheck checks for possible equivocation between the STRs
+// observed by several auditors and the client's own view.
+// QuorumCheck() performs the checks of CheckEquivocation() on each
+// auditor response in msgs, groups the auditors whose responses pass
+// by the latest STR they observed, and passes only if at least
+// threshold auditors agree on the same STR. Auditors on different forks
+// which are each consistent with the client's view thus never add up to
+// a quorum.
+// Unlike CheckEquivocation(), QuorumCheck() never adopts an STR, so that
+// the order of msgs doesn't affect the result.
+//
+// QuorumCheck() returns a map from the index in msgs of each auditor that
+// dissented to the error returned by its equivocation check, or to
+// CheckBadSTR if it observed an STR other than the one most auditors
+// agree on, regardless of whether the quorum was reached. It returns
+// CheckNoQuorum if fewer than threshold auditors agree, and nil
+// otherwise.
+func (cc *ConsistencyChecks) QuorumCheck(msgs []*protocol.Response,
+	threshold int) (map[int]error, error) {
+	dissenters := make(map[int]error)
+	// the indices of the auditors which observed each latest STR,
+	// in the order in which the STRs first occur in msgs
+	var groups [][]int
+	var latest []*protocol.DirSTR
+	for i, msg := range msgs {
+		str, err := cc.checkEquivocation(msg)
+		if err != nil {
+			dissenters[i] = err
+			continue
+		}
+		found := false
+		for j, l := range latest {
+			if sameSTR(l, str) {
+				groups[j] = append(groups[j], i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			latest = append(latest, str)
+			groups = append(groups, []int{i})
+		}
+	}
+	agreed := 0
+	for j, g := range groups {
+		if len(g) > len(groups[agreed]) {
+			agreed = j
+		}
+	}
+	for j, g := range groups {
+		if j == agreed {
+			continue
+		}
+		for _, i := range g {
+			dissenters[i] = protocol.CheckBadSTR
+		}
+	}
+	if len(groups) == 0 || len(groups[agreed]) < threshold {
+		return dissenters, protocol.CheckNoQuorum
+	}
+	return dissenters, nil
 }
 
 // HandleResponse verifies the directory's response for a request.
@@ -99,26 +387,207 @@ func (cc *ConsistencyChecks) CheckEquivocation(msg *protocol.Response) error {
 // Note that the consistency state will be updated regardless of
 // whether the checks pass / fail, since a response message contains
 // cryptographic proof of having been issued nonetheless.
+// The only exception is a lookup which is rejected since it hasn't
+// been confirmed by an auditor (see RequireAuditorConfirmation()).
 func (cc *ConsistencyChecks) HandleResponse(requestType int, msg *protocol.Response,
 	uname string, key []byte) error {
+	_, err := cc.HandleResponseResult(requestType, msg, uname, key)
+	return err
+}
+
+// HandleResponseResult verifies the directory's response for a request
+// as HandleResponse() does, and if the checks pass, also returns the
+// VerificationResult describing the verified binding for uname.
+func (cc *ConsistencyChecks) HandleResponseResult(requestType int, msg *protocol.Response,
+	uname string, key []byte) (*VerificationResult, error) {
 	if err := msg.Validate(); err != nil {
-		return err
+		return nil, err
 	}
 	switch requestType {
 	case protocol.RegistrationType, protocol.KeyLookupType, protocol.KeyLookupInEpochType, protocol.MonitoringType:
 		if _, ok := msg.DirectoryResponse.(*protocol.DirectoryProof); !ok {
-			return protocol.ErrMalformedMessage
+			return nil, protocol.ErrMalformedMessage
 		}
 	default:
 		panic("[coniks] Unknown request type")
 	}
+	df := msg.DirectoryResponse.(*protocol.DirectoryProof)
+	if err := cc.checkConfirmed(requestType, df.STR[0]); err != nil {
+		return nil, err
+	}
 	if err := cc.updateSTR(requestType, msg); err != nil {
-		return err
+		return nil, err
 	}
 	if err := cc.checkConsistency(requestType, msg, uname, key); err != nil {
-		return err
+		return nil, err
 	}
 	if err := cc.updateTBs(requestType, msg, uname, key); err != nil {
+		return nil, err
+	}
+	recvKey, _ := msg.GetKey()
+	cc.Bindings[uname] = recvKey
+	return newVerificationResult(df), nil
+}
+
+// HandleResponseWithSTR verifies the directory's response msg for a
+// request as HandleResponse() does, but additionally requires the proof
+// in msg to be against the externally trusted STR trusted, e.g. the
+// latest STR confirmed by an auditor (see CheckEquivocation()).
+// This allows a client to learn the current STR from an auditor rather
+// than on the directory's freshness path, and to only fetch the proof
+// for a username from the directory.
+// HandleResponseWithSTR() returns CheckBadSTR if the STR in msg
+// differs from trusted, even if it would otherwise be a valid
+// successor of the cc.verifiedSTR.
+func (cc *ConsistencyChecks) HandleResponseWithSTR(requestType int, msg *protocol.Response,
+	uname string, key []byte, trusted *protocol.DirSTR) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	df, ok := msg.DirectoryResponse.(*protocol.DirectoryProof)
+	if !ok {
+		return protocol.ErrMalformedMessage
+	}
+	if trusted == nil || trusted.SignedTreeRoot == nil ||
+		!bytes.Equal(df.STR[0].Signature, trusted.Signature) ||
+		!bytes.Equal(df.STR[0].Serialize(), trusted.Serialize()) {
+		return protocol.CheckBadSTR
+	}
+	return cc.HandleResponse(requestType, msg, uname, key)
+}
+
+// HandleBatchResponse verifies the directory's response for a
+// BatchKeyLookupRequest for the usernames unames.
+// keys optionally maps a username to the key the client expects to be
+// bound to it; a username without an entry is accepted as TOFU.
+//
+// HandleBatchResponse() first validates msg and checks the consistency
+// of the STR shared by all proofs in the response, and returns an error
+// if any of these checks fail. It then verifies the proof for each
+// username against that single STR, and returns a map from each
+// username to the result of its consistency checks, so that a failure in
+// one proof doesn't prevent the other bindings from being verified.
+func (cc *ConsistencyChecks) HandleBatchResponse(msg *protocol.Response,
+	unames []string, keys map[string][]byte) (map[string]error, error) {
+	if err := msg.ValidateFor(protocol.BatchKeyLookupType); err != nil {
+		return nil, err
+	}
+	batch := msg.DirectoryResponse.(*protocol.BatchDirectoryProof)
+	if len(batch.Proofs) != len(unames) {
+		return nil, protocol.ErrMalformedMessage
+	}
+
+	// all proofs share the same STR, so verify it only once
+	str := batch.Proofs[0].DirectoryResponse.(*protocol.DirectoryProof).STR[0]
+	if err := cc.checkConfirmed(protocol.KeyLookupType, str); err != nil {
+		return nil, err
+	}
+	if err := cc.updateSTR(protocol.KeyLookupType, batch.Proofs[0]); err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]error, len(unames))
+	for i, uname := range unames {
+		results[uname] = cc.handleBatchProof(batch.Proofs[i], uname, keys[uname])
+	}
+	return results, nil
+}
+
+// HandleBatchMultiProof verifies the directory's response for a
+// BatchKeyLookupRequest of type BatchMultiProofType for the usernames
+// unames, as HandleBatchResponse() does for a BatchDirectoryProof.
+//
+// HandleBatchMultiProof() first validates msg, checks the consistency
+// of its STR, and recomputes the tree's root node from the multiproof
+// once for all usernames. It returns CheckBadAuthPath if the root
+// doesn't match the STR's tree hash, or any other error that occurs
+// while performing these checks. It then verifies the VRF index,
+// binding and TB for each username, and returns a map from each
+// username to the result of these checks.
+func (cc *ConsistencyChecks) HandleBatchMultiProof(msg *protocol.Response,
+	unames []string, keys map[string][]byte) (map[string]error, error) {
+	if err := msg.ValidateFor(protocol.BatchMultiProofType); err != nil {
+		return nil, err
+	}
+	batch := msg.DirectoryResponse.(*protocol.BatchMultiProof)
+	if len(batch.Errors) != len(unames) {
+		return nil, protocol.ErrMalformedMessage
+	}
+	proofs := batch.Responses()
+
+	if err := cc.checkConfirmed(protocol.KeyLookupType, batch.STR); err != nil {
+		return nil, err
+	}
+	if err := cc.updateSTR(protocol.KeyLookupType, proofs[0]); err != nil {
+		return nil, err
+	}
+	err := protocol.AuthPathError(batch.Proof.VerifyRoot(batch.STR.TreeHash))
+	cc.Trace(&auditor.TraceEvent{
+		Step:  auditor.TraceAuthPath,
+		Epoch: batch.STR.Epoch,
+		Err:   err,
+		Want:  batch.STR.TreeHash,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]error, len(unames))
+	for i, uname := range unames {
+		results[uname] = cc.handleMultiProofPath(proofs[i], uname, keys[uname])
+	}
+	return results, nil
+}
+
+// handleMultiProofPath performs the checks of handleBatchProof() on the
+// response msg for uname expanded from a BatchMultiProof, except for
+// recomputing the tree's root node from the authentication path.
+func (cc *ConsistencyChecks) handleMultiProofPath(msg *protocol.Response,
+	uname string, key []byte) error {
+	df := msg.DirectoryResponse.(*protocol.DirectoryProof)
+	ap := df.AP[0]
+	if err := cc.checkLookupProofType(msg.Error, ap); err != nil {
+		return err
+	}
+	if err := cc.verifyLookupIndex(uname, ap, df.STR[0]); err != nil {
+		return err
+	}
+	if err := cc.checkIndexStability(uname, ap, df.STR[0]); err != nil {
+		return err
+	}
+	if isTombstone(ap) {
+		return cc.verifyTombstone(uname, ap, df.STR[0])
+	}
+	value := key
+	if value == nil {
+		// accept the received key as TOFU
+		value = ap.Leaf.Value
+	}
+	err := protocol.AuthPathError(ap.VerifyBinding([]byte(uname), value))
+	cc.Trace(&auditor.TraceEvent{
+		Step:     auditor.TraceAuthPath,
+		Epoch:    df.STR[0].Epoch,
+		Username: uname,
+		Err:      err,
+		Want:     df.STR[0].TreeHash,
+	})
+	if err != nil {
+		return err
+	}
+	if err := cc.updateTBs(protocol.KeyLookupType, msg, uname, key); err != nil {
+		return err
+	}
+	recvKey, _ := msg.GetKey()
+	cc.Bindings[uname] = recvKey
+	return nil
+}
+
+func (cc *ConsistencyChecks) handleBatchProof(msg *protocol.Response,
+	uname string, key []byte) error {
+	if err := cc.checkConsistency(protocol.KeyLookupType, msg, uname, key); err != nil {
+		return err
+	}
+	if err := cc.updateTBs(protocol.KeyLookupType, msg, uname, key); err != nil {
 		return err
 	}
 	recvKey, _ := msg.GetKey()
@@ -134,9 +603,15 @@ func (cc *ConsistencyChecks) updateSTR(requestType int, msg *protocol.Response)
 		// The initial STR is pinned in the client
 		// so cc.verifiedSTR should never be nil
 		// FIXME: use STR slice from Response msg
+		if err := cc.checkLinkage(str); err != nil {
+			return err
+		}
 		if err := cc.AuditDirectory([]*protocol.DirSTR{str}); err != nil {
 			return err
 		}
+		if err := cc.checkCadence(cc.VerifiedSTR(), str); err != nil {
+			return err
+		}
 
 	default:
 		panic("[coniks] Unknown request type")
@@ -148,6 +623,30 @@ func (cc *ConsistencyChecks) updateSTR(requestType int, msg *protocol.Response)
 	return nil
 }
 
+// checkLinkage returns CheckBadSTR if str is the STR for the epoch
+// following the cc.verifiedSTR, but doesn't commit to the hash the next
+// STR must commit to (see protocol.ExpectedPreviousHash()). This
+// rejects an STR which doesn't chain to the cc.verifiedSTR before
+// its signature is verified.
+func (cc *ConsistencyChecks) checkLinkage(str *protocol.DirSTR) error {
+	verified := cc.VerifiedSTR()
+	if str.Epoch != verified.Epoch+1 {
+		return nil
+	}
+	want := protocol.ExpectedPreviousHash(verified)
+	if bytes.Equal(str.PreviousSTRHash, want[:]) {
+		return nil
+	}
+	cc.Trace(&auditor.TraceEvent{
+		Step:  auditor.TraceHashChain,
+		Epoch: str.Epoch,
+		Err:   protocol.CheckBadSTR,
+		Want:  want[:],
+		Got:   str.PreviousSTRHash,
+	})
+	return protocol.CheckBadSTR
+}
+
 func (cc *ConsistencyChecks) checkConsistency(requestType int, msg *protocol.Response,
 	uname string, key []byte) error {
 	var err error
@@ -162,6 +661,14 @@ func (cc *ConsistencyChecks) checkConsistency(requestType int, msg *protocol.Res
 	return err
 }
 
+// verifyRegistration verifies the proof in the directory's response
+// msg to a registration of uname to key.
+// If the directory rejects the registration with ReqNameExisted, the
+// proof of inclusion of the existing binding is verified on its own,
+// so that a fabricated "already registered" response is rejected with
+// the error of the failed check, rather than with CheckBindingsDiffer.
+// Only once the existing binding is proven, verifyRegistration()
+// returns CheckBindingsDiffer if it binds uname to a key other than key.
 func (cc *ConsistencyChecks) verifyRegistration(msg *protocol.Response,
 	uname string, key []byte) error {
 	df := msg.DirectoryResponse.(*protocol.DirectoryProof)
@@ -173,13 +680,20 @@ func (cc *ConsistencyChecks) verifyRegistration(msg *protocol.Response,
 	proofType := ap.ProofType()
 	switch {
 	case msg.Error == protocol.ReqNameExisted && proofType == merkletree.ProofOfInclusion:
+		if err := cc.verifyAuthPath(uname, ap.Leaf.Value, ap, str); err != nil {
+			return err
+		}
+		if key != nil && !bytes.Equal(ap.Leaf.Value, key) {
+			return protocol.CheckBindingsDiffer
+		}
+		return nil
 	case msg.Error == protocol.ReqNameExisted && proofType == merkletree.ProofOfAbsence && cc.useTBs:
 	case msg.Error == protocol.ReqSuccess && proofType == merkletree.ProofOfAbsence:
 	default:
 		return protocol.ErrMalformedMessage
 	}
 
-	return verifyAuthPath(uname, key, ap, str)
+	return cc.verifyAuthPath(uname, key, ap, str)
 }
 
 func (cc *ConsistencyChecks) verifyKeyLookup(msg *protocol.Response,
@@ -190,46 +704,129 @@ func (cc *ConsistencyChecks) verifyKeyLookup(msg *protocol.Response,
 	ap := df.AP[0]
 	str := df.STR[0]
 
+	if err := cc.checkLookupProofType(msg.Error, ap); err != nil {
+		return err
+	}
+	if isTombstone(ap) {
+		return cc.verifyTombstone(uname, ap, str)
+	}
+	return cc.verifyAuthPath(uname, key, ap, str)
+}
+
+// isTombstone returns whether the authentication path ap proves
+// the inclusion of a tombstone.
+func isTombstone(ap *merkletree.AuthenticationPath) bool {
+	if ap.ProofType() != merkletree.ProofOfInclusion {
+		return false
+	}
+	reason, _ := protocol.ParseTombstone(ap.Leaf.Value)
+	return reason != protocol.NoTombstone
+}
+
+// verifyTombstone verifies that the authentication path ap binds uname
+// to a tombstone in str. It returns CheckBindingsDiffer if the tombstone
+// claims to have been created after str's epoch, and a *TombstoneError
+// otherwise, so that a tombstone is never accepted as uname's key.
+func (cc *ConsistencyChecks) verifyTombstone(uname string,
+	ap *merkletree.AuthenticationPath, str *protocol.DirSTR) error {
+	reason, epoch := protocol.ParseTombstone(ap.Leaf.Value)
+	if epoch > str.Epoch {
+		return protocol.CheckBindingsDiffer
+	}
+	if err := cc.verifyAuthPath(uname, ap.Leaf.Value, ap, str); err != nil {
+		return err
+	}
+	return &TombstoneError{Username: uname, Reason: reason, Epoch: epoch}
+}
+
+// checkLookupProofType returns ErrMalformedMessage if the type of the
+// authentication path ap doesn't match the error code e of a response
+// to a key lookup.
+func (cc *ConsistencyChecks) checkLookupProofType(e protocol.ErrorCode,
+	ap *merkletree.AuthenticationPath) error {
 	proofType := ap.ProofType()
 	switch {
-	case msg.Error == protocol.ReqNameNotFound && proofType == merkletree.ProofOfAbsence:
+	case e == protocol.ReqNameNotFound && proofType == merkletree.ProofOfAbsence:
 	// FIXME: This would be changed when we support key changes
-	case msg.Error == protocol.ReqSuccess && proofType == merkletree.ProofOfInclusion:
-	case msg.Error == protocol.ReqSuccess && proofType == merkletree.ProofOfAbsence && cc.useTBs:
+	case e == protocol.ReqSuccess && proofType == merkletree.ProofOfInclusion:
+	case e == protocol.ReqSuccess && proofType == merkletree.ProofOfAbsence && cc.useTBs:
 	default:
 		return protocol.ErrMalformedMessage
 	}
-
-	return verifyAuthPath(uname, key, ap, str)
+	return nil
 }
 
-func verifyAuthPath(uname string, key []byte, ap *merkletree.AuthenticationPath, str *protocol.DirSTR) error {
-	// verify VRF Index
-	vrfKey := str.Policies.VrfPublicKey
-	if !vrfKey.Verify([]byte(uname), ap.LookupIndex, ap.VrfProof) {
-		return protocol.CheckBadVRFProof
+// verifyAuthPath verifies the authentication path ap for uname against
+// str as protocol.VerifyDirectoryProof() does, using the VRF public key
+// in the policies of str. If key isn't nil and ap proves the inclusion
+// of uname, verifyAuthPath() also checks that uname is bound to key;
+// otherwise, the received key is accepted as TOFU.
+func (cc *ConsistencyChecks) verifyAuthPath(uname string, key []byte,
+	ap *merkletree.AuthenticationPath, str *protocol.DirSTR) error {
+	df := &protocol.DirectoryProof{
+		AP:  []*merkletree.AuthenticationPath{ap},
+		STR: []*protocol.DirSTR{str},
 	}
-
-	if key == nil {
-		// key is nil when the user does lookup for the first time.
-		// Accept the received key as TOFU
-		key = ap.Leaf.Value
+	err := protocol.VerifyDirectoryProof(df, uname, str, str.Policies.VrfPublicKey)
+	if err == nil && key != nil && ap.ProofType() == merkletree.ProofOfInclusion &&
+		!bytes.Equal(ap.Leaf.Value, key) {
+		err = protocol.CheckBindingsDiffer
+	}
+	if cc.Tracing() {
+		cc.traceAuthPath(uname, ap, str, err)
+	}
+	if err != nil {
+		return err
+	}
+	if err := cc.checkExclusivity(uname, str.Epoch, ap.ProofType()); err != nil {
+		return err
 	}
+	return cc.checkIndexStability(uname, ap, str)
+}
 
-	switch err := ap.Verify([]byte(uname), key, str.TreeHash); err {
-	case merkletree.ErrBindingsDiffer:
-		return protocol.CheckBindingsDiffer
-	case merkletree.ErrUnverifiableCommitment:
-		return protocol.CheckBadCommitment
-	case merkletree.ErrIndicesMismatch:
-		return protocol.CheckBadLookupIndex
-	case merkletree.ErrUnequalTreeHashes:
-		return protocol.CheckBadAuthPath
-	case nil:
-		return nil
-	default:
-		panic("[coniks] Unknown error: " + err.Error())
+// traceAuthPath traces the steps of verifyAuthPath() for uname, ap and
+// str, given the error err it returned: the verification of the lookup
+// index, and unless it failed, the verification of the path itself.
+func (cc *ConsistencyChecks) traceAuthPath(uname string,
+	ap *merkletree.AuthenticationPath, str *protocol.DirSTR, err error) {
+	var vrfErr error
+	if err == protocol.CheckBadVRFProof {
+		vrfErr = err
+	}
+	cc.Trace(&auditor.TraceEvent{
+		Step:     auditor.TraceVRFProof,
+		Epoch:    str.Epoch,
+		Username: uname,
+		Err:      vrfErr,
+		Got:      ap.LookupIndex,
+	})
+	if vrfErr != nil {
+		return
 	}
+	cc.Trace(&auditor.TraceEvent{
+		Step:     auditor.TraceAuthPath,
+		Epoch:    str.Epoch,
+		Username: uname,
+		Err:      err,
+		Want:     str.TreeHash,
+		Got:      ap.RootHash(),
+	})
+}
+
+// verifyLookupIndex verifies the VRF index of ap for uname using the
+// VRF public key in the policies of str, and returns CheckBadVRFProof
+// if the verification fails.
+func (cc *ConsistencyChecks) verifyLookupIndex(uname string,
+	ap *merkletree.AuthenticationPath, str *protocol.DirSTR) error {
+	err := protocol.VerifyLookupIndex(uname, ap, str.Policies.VrfPublicKey)
+	cc.Trace(&auditor.TraceEvent{
+		Step:     auditor.TraceVRFProof,
+		Epoch:    str.Epoch,
+		Username: uname,
+		Err:      err,
+		Got:      ap.LookupIndex,
+	})
+	return err
 }
 
 func (cc *ConsistencyChecks) updateTBs(requestType int, msg *protocol.Response,
@@ -279,7 +876,9 @@ func (cc *ConsistencyChecks) verifyFulfilledPromise(uname string, str *protocol.
 	ap *merkletree.AuthenticationPath) error {
 	// FIXME: Which epoch did this lookup happen in?
 	if tb, ok := cc.TBs[uname]; ok {
-		if !bytes.Equal(ap.LookupIndex, tb.Index) ||
+		// the promised index is void after a VRF key rotation
+		// (see Update())
+		if (tb.Index != nil && !bytes.Equal(ap.LookupIndex, tb.Index)) ||
 			!bytes.Equal(ap.Leaf.Value, tb.Value) {
 			return protocol.CheckBrokenPromise
 		}
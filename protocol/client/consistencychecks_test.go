@@ -1 +1,969 @@
 package client
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+var (
+	alice = "alice"
+	bob   = "bob"
+	carol = "carol"
+	key   = []byte("key")
+)
+
+var staticSigningKey = crypto.NewStaticTestSigningKey()
+
+func newTestClient(t *testing.T) (*directory.ConiksDirectory, *ConsistencyChecks) {
+	d := directory.NewTestDirectory(t)
+	pk, _ := staticSigningKey.Public()
+	return d, New(d.LatestSTR(), true, pk)
+}
+
+func TestHandleBatchResponse(t *testing.T) {
+	d, cc := newTestClient(t)
+
+	// alice is included in the next snapshot, bob is pending (TB),
+	// and carol is never registered
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.Update()
+	d.Register(&protocol.RegistrationRequest{Username: bob, Key: key})
+
+	unames := []string{alice, bob, carol}
+	res := d.BatchKeyLookup(&protocol.BatchKeyLookupRequest{Usernames: unames})
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Unexpected batch lookup error", res.Error)
+	}
+
+	results, err := cc.HandleBatchResponse(res, unames,
+		map[string][]byte{alice: key, bob: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, uname := range unames {
+		if results[uname] != nil {
+			t.Error("Unexpected error for", uname, "got", results[uname])
+		}
+	}
+	if cc.VerifiedSTR().Epoch != d.LatestSTR().Epoch {
+		t.Error("Expect the shared STR to be the verified STR")
+	}
+	if _, ok := cc.TBs[bob]; !ok {
+		t.Error("Expect a TB for the pending registration")
+	}
+}
+
+func TestHandleBatchResponsePerNameFailure(t *testing.T) {
+	d, cc := newTestClient(t)
+
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.Register(&protocol.RegistrationRequest{Username: bob, Key: key})
+	d.Update()
+
+	unames := []string{alice, bob, carol}
+	res := d.BatchKeyLookup(&protocol.BatchKeyLookupRequest{Usernames: unames})
+
+	// expect a different key for bob only
+	results, err := cc.HandleBatchResponse(res, unames,
+		map[string][]byte{alice: key, bob: []byte("other")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[alice] != nil || results[carol] != nil {
+		t.Error("Expect alice and carol to verify, got", results[alice], results[carol])
+	}
+	if results[bob] != protocol.CheckBindingsDiffer {
+		t.Error("Expect", protocol.CheckBindingsDiffer, "for bob, got", results[bob])
+	}
+}
+
+func TestHandleBatchResponseMalformed(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.Update()
+
+	res := d.BatchKeyLookup(&protocol.BatchKeyLookupRequest{
+		Usernames: []string{alice, bob}})
+
+	// the number of proofs doesn't match the number of usernames
+	if _, err := cc.HandleBatchResponse(res, []string{alice}, nil); err != protocol.ErrMalformedMessage {
+		t.Error("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+
+	// the proofs don't share the same STR
+	d.Update()
+	other := d.KeyLookup(&protocol.KeyLookupRequest{Username: bob})
+	res.DirectoryResponse.(*protocol.BatchDirectoryProof).Proofs[1] = other
+	if _, err := cc.HandleBatchResponse(res, []string{alice, bob}, nil); err != protocol.ErrMalformedMessage {
+		t.Error("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+}
+
+func TestHandleBatchResponseForgedSTR(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.Update()
+
+	unames := []string{alice, bob}
+	res := d.BatchKeyLookup(&protocol.BatchKeyLookupRequest{Usernames: unames})
+	// the second proof carries the signature of the shared STR
+	// but a forged tree hash
+	proof := res.DirectoryResponse.(*protocol.BatchDirectoryProof).Proofs[1].
+		DirectoryResponse.(*protocol.DirectoryProof)
+	forged := *proof.STR[0].SignedTreeRoot
+	forged.TreeHash = append([]byte{}, forged.TreeHash...)
+	forged.TreeHash[0] ^= 1
+	proof.STR = []*protocol.DirSTR{{SignedTreeRoot: &forged, Policies: proof.STR[0].Policies}}
+
+	if _, err := cc.HandleBatchResponse(res, unames, nil); err != protocol.ErrMalformedMessage {
+		t.Error("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+	if cc.VerifiedSTR().Epoch == d.LatestSTR().Epoch {
+		t.Error("Expect the verified STR to be unchanged")
+	}
+}
+
+func TestHandleBatchMultiProof(t *testing.T) {
+	d, cc := newTestClient(t)
+
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.Update()
+	d.Register(&protocol.RegistrationRequest{Username: bob, Key: key})
+
+	unames := []string{alice, bob, carol}
+	res := d.BatchKeyLookupMultiProof(&protocol.BatchKeyLookupRequest{Usernames: unames})
+	results, err := cc.HandleBatchMultiProof(res, unames,
+		map[string][]byte{alice: key, bob: []byte("other")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[alice] != nil || results[carol] != nil {
+		t.Error("Expect alice and carol to verify, got", results[alice], results[carol])
+	}
+	if results[bob] != protocol.CheckBindingsDiffer {
+		t.Error("Expect", protocol.CheckBindingsDiffer, "for bob, got", results[bob])
+	}
+	if cc.VerifiedSTR().Epoch != d.LatestSTR().Epoch {
+		t.Error("Expect the shared STR to be the verified STR")
+	}
+
+	// the multiproof doesn't recompute the STR's tree hash
+	res = d.BatchKeyLookupMultiProof(&protocol.BatchKeyLookupRequest{Usernames: unames})
+	res.DirectoryResponse.(*protocol.BatchMultiProof).Proof.Paths[0].Leaf.Commitment.Value[0] ^= 1
+	if _, err := cc.HandleBatchMultiProof(res, unames, nil); err != protocol.CheckBadAuthPath {
+		t.Error("Expect", protocol.CheckBadAuthPath, "got", err)
+	}
+
+	// the number of paths doesn't match the number of usernames
+	if _, err := cc.HandleBatchMultiProof(res, unames[:1], nil); err != protocol.ErrMalformedMessage {
+		t.Error("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+}
+
+func TestTraceForgedAuthPath(t *testing.T) {
+	d, cc := newTestClient(t)
+	trace := &auditor.TraceLog{}
+	cc.SetTraceSink(trace)
+
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.Update()
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	df := res.DirectoryResponse.(*protocol.DirectoryProof)
+	df.AP[0].PrunedTree[0][0] ^= 0xff
+
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != protocol.CheckBadAuthPath {
+		t.Fatal("Expect", protocol.CheckBadAuthPath, "got", err)
+	}
+
+	var steps []auditor.TraceStep
+	for _, e := range trace.Events {
+		steps = append(steps, e.Step)
+	}
+	want := []auditor.TraceStep{auditor.TraceSTRSignature, auditor.TraceHashChain,
+		auditor.TraceVRFProof, auditor.TraceAuthPath}
+	if !reflect.DeepEqual(steps, want) {
+		t.Fatal("Unexpected trace", steps, "want", want)
+	}
+
+	failed := trace.Failed()
+	if failed == nil || failed.Step != auditor.TraceAuthPath {
+		t.Fatal("Expect the authentication path step to fail, got", failed)
+	}
+	if failed.Username != alice || failed.Epoch != df.STR[0].Epoch ||
+		failed.Err != protocol.CheckBadAuthPath {
+		t.Error("Unexpected failed event", failed)
+	}
+	if !bytes.Equal(failed.Want, df.STR[0].TreeHash) ||
+		failed.Got == nil || bytes.Equal(failed.Got, failed.Want) {
+		t.Error("Expect the trace to record the mismatching root hashes")
+	}
+}
+
+func TestCompressedKeyLookup(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.Register(&protocol.RegistrationRequest{Username: bob, Key: key})
+	d.Update()
+
+	for _, name := range []string{alice, bob, carol} {
+		res := d.KeyLookup(&protocol.KeyLookupRequest{Username: name, CompressProof: true})
+		if res.DirectoryResponse.(*protocol.DirectoryProof).AP[0].EmptySiblings == nil {
+			t.Fatal("Expect a compressed proof for", name)
+		}
+		var k []byte
+		if name != carol {
+			k = key
+		}
+		if err := cc.HandleResponse(protocol.KeyLookupType, res, name, k); err != nil {
+			t.Fatal(name, err)
+		}
+	}
+
+	// a corrupted position marker is rejected
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice, CompressProof: true})
+	ap := res.DirectoryResponse.(*protocol.DirectoryProof).AP[0]
+	ap.EmptySiblings[0] ^= 0x80
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != protocol.CheckBadAuthPath {
+		t.Fatal("Expect", protocol.CheckBadAuthPath, "got", err)
+	}
+}
+
+func TestEarlyLinkageRejection(t *testing.T) {
+	d, cc := newTestClient(t)
+	trace := &auditor.TraceLog{}
+	cc.SetTraceSink(trace)
+
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.Update()
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	str := res.DirectoryResponse.(*protocol.DirectoryProof).STR[0]
+	want := protocol.ExpectedPreviousHash(cc.VerifiedSTR())
+	if !bytes.Equal(str.PreviousSTRHash, want[:]) {
+		t.Fatal("Expect the genuine STR to commit to the expected hash")
+	}
+
+	// an STR which doesn't chain is rejected before its signature is checked
+	root := *str.SignedTreeRoot
+	root.PreviousSTRHash = append([]byte{}, root.PreviousSTRHash...)
+	root.PreviousSTRHash[0] ^= 1
+	str.SignedTreeRoot = &root
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+	if len(trace.Events) != 1 || trace.Events[0].Step != auditor.TraceHashChain {
+		t.Fatal("Expect only the hash chain to be checked, got", trace.Events)
+	}
+}
+
+func TestVRFKeyRotation(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.Update()
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+	oldIndex := res.DirectoryResponse.(*protocol.DirectoryProof).AP[0].LookupIndex
+
+	// bob's registration is pending when the directory rotates its VRF key
+	res = d.Register(&protocol.RegistrationRequest{Username: bob, Key: key})
+	if err := cc.HandleResponse(protocol.RegistrationType, res, bob, key); err != nil {
+		t.Fatal(err)
+	}
+
+	newKey, err := vrf.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RotateVRFKey(newKey); err != nil {
+		t.Fatal(err)
+	}
+
+	// alice's binding moved to a new index, but still holds the same key
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(res.DirectoryResponse.(*protocol.DirectoryProof).AP[0].LookupIndex, oldIndex) {
+		t.Fatal("Expect a new lookup index after the rotation")
+	}
+	newPK, _ := newKey.Public()
+	if !bytes.Equal(cc.VerifiedPolicies().VrfPublicKey, newPK) {
+		t.Fatal("Expect the client to adopt the new VRF key")
+	}
+
+	// bob's TB is fulfilled at the index under the new VRF key
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: bob})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, bob, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cc.TBs[bob]; ok {
+		t.Error("Expect bob's TB to be fulfilled")
+	}
+
+	// the binding's key must stay the same
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, []byte("other")); err != protocol.CheckBindingsDiffer {
+		t.Error("Expect", protocol.CheckBindingsDiffer, "got", err)
+	}
+}
+
+func TestPolicyChange(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.Update()
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.ChangePolicies(&protocol.PolicyChangeRequest{EpochDeadline: 7}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		d.Update()
+		res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+		if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cc.VerifiedPolicies().EpochDeadline != 7 {
+		t.Fatal("Expect the client to adopt the announced policies")
+	}
+
+	// the directory changes its policies without announcing it
+	d.ChangePoliciesUnannounced(t, 9)
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != protocol.CheckBadPolicyChange {
+		t.Error("Expect", protocol.CheckBadPolicyChange, "got", err)
+	}
+	if cc.VerifiedPolicies().EpochDeadline != 7 {
+		t.Error("Expect the verified policies to be unchanged")
+	}
+}
+
+// newTestFork creates two directories sharing the same initial STR
+// whose histories diverge at epoch 1, and a client pinned to the
+// first directory's STR at epoch 1.
+func newTestFork(t *testing.T) (*directory.ConiksDirectory,
+	*directory.ConiksDirectory, *ConsistencyChecks) {
+	d, cc := newTestClient(t)
+	fork := directory.NewTestDirectory(t)
+
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	fork.Register(&protocol.RegistrationRequest{Username: alice, Key: []byte("evil")})
+	d.Update()
+	fork.Update()
+
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+	return d, fork, cc
+}
+
+func getSTRHistory(d *directory.ConiksDirectory) *protocol.Response {
+	return d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 0,
+		EndEpoch:   d.LatestSTR().Epoch})
+}
+
+func TestQuorumCheck(t *testing.T) {
+	d, fork, cc := newTestFork(t)
+	msgs := []*protocol.Response{
+		getSTRHistory(d),
+		getSTRHistory(fork),
+		getSTRHistory(d),
+	}
+
+	// a minority of auditors is on the fork
+	dissenters, err := cc.QuorumCheck(msgs, 2)
+	if err != nil {
+		t.Fatal("Expect the quorum to hold, got", err)
+	}
+	if len(dissenters) != 1 || !errors.Is(dissenters[1], protocol.CheckBadSTR) {
+		t.Fatal("Expect auditor 1 to dissent, got", dissenters)
+	}
+
+	// require all auditors to agree
+	dissenters, err = cc.QuorumCheck(msgs, 3)
+	if err != protocol.CheckNoQuorum {
+		t.Fatal("Expect", protocol.CheckNoQuorum, "got", err)
+	}
+	if len(dissenters) != 1 || dissenters[1] == nil {
+		t.Fatal("Expect auditor 1 to dissent, got", dissenters)
+	}
+}
+
+func TestQuorumCheckMajorityOnFork(t *testing.T) {
+	d, fork, cc := newTestFork(t)
+	msgs := []*protocol.Response{
+		getSTRHistory(fork),
+		getSTRHistory(d),
+		getSTRHistory(fork),
+	}
+
+	dissenters, err := cc.QuorumCheck(msgs, 2)
+	if err != protocol.CheckNoQuorum {
+		t.Fatal("Expect", protocol.CheckNoQuorum, "got", err)
+	}
+	if len(dissenters) != 2 || dissenters[0] == nil || dissenters[2] == nil {
+		t.Fatal("Expect auditors 0 and 2 to dissent, got", dissenters)
+	}
+}
+
+func TestQuorumCheckAuditorsOnDifferentForks(t *testing.T) {
+	d, cc := newTestClient(t)
+	fork := directory.NewTestDirectory(t)
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	fork.Register(&protocol.RegistrationRequest{Username: alice, Key: []byte("evil")})
+	d.Update()
+	fork.Update()
+
+	// both auditors' ranges extend the client's pinned STR,
+	// but they disagree on the STR for epoch 1
+	msgs := []*protocol.Response{getSTRHistory(d), getSTRHistory(fork)}
+	dissenters, err := cc.QuorumCheck(msgs, 2)
+	if err != protocol.CheckNoQuorum {
+		t.Fatal("Expect", protocol.CheckNoQuorum, "got", err)
+	}
+	if len(dissenters) != 1 || dissenters[1] != protocol.CheckBadSTR {
+		t.Fatal("Expect auditor 1 to dissent, got", dissenters)
+	}
+
+	// a third auditor agreeing with the first makes a quorum
+	msgs = append(msgs, getSTRHistory(d))
+	dissenters, err = cc.QuorumCheck(msgs, 2)
+	if err != nil {
+		t.Fatal("Expect the quorum to hold, got", err)
+	}
+	if len(dissenters) != 1 || dissenters[1] != protocol.CheckBadSTR {
+		t.Fatal("Expect auditor 1 to dissent, got", dissenters)
+	}
+	if cc.VerifiedSTR().Epoch != 0 {
+		t.Error("Expect QuorumCheck not to adopt an STR")
+	}
+}
+
+func TestCheckEquivocationReportsDivergence(t *testing.T) {
+	d, cc := newTestClient(t)
+
+	// the client follows the directory up to epoch 3
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	for i := 0; i < 3; i++ {
+		d.Update()
+		res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+		if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the auditor observed a fork diverging at epoch 3
+	fork := d.ForkAt(t, 2)
+	fork.Register(&protocol.RegistrationRequest{Username: bob, Key: key})
+	fork.Update()
+	fork.Update()
+
+	err := cc.CheckEquivocation(getSTRHistory(fork))
+	e, ok := err.(*EquivocationError)
+	if !ok {
+		t.Fatal("Expect an EquivocationError, got", err)
+	}
+	if e.Epoch != 3 {
+		t.Fatal("Expect divergence at epoch 3, got", e.Epoch)
+	}
+	if !bytes.Equal(e.ClientSignature, d.LatestSTR().Signature) ||
+		bytes.Equal(e.ClientSignature, e.AuditorSignature) {
+		t.Fatal("Unexpected conflicting STR signatures")
+	}
+	if !errors.Is(err, protocol.CheckBadSTR) {
+		t.Fatal("Expect the EquivocationError to wrap", protocol.CheckBadSTR)
+	}
+
+	// no divergence is reported for the client's own directory
+	if err := cc.CheckEquivocation(getSTRHistory(d)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientRejectsRollback(t *testing.T) {
+	d, cc := newTestClient(t)
+
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	for i := 0; i < 3; i++ {
+		d.Update()
+		res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+		if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// an auditor response with an earlier tip
+	res := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 0,
+		EndEpoch:   2})
+	if err := cc.CheckEquivocation(res); err != auditor.ErrRollback {
+		t.Error("Expect", auditor.ErrRollback, "got", err)
+	}
+
+	// a lookup response with an earlier STR
+	fork := d.ForkAt(t, 2)
+	res = fork.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != auditor.ErrRollback {
+		t.Error("Expect", auditor.ErrRollback, "got", err)
+	}
+	if cc.VerifiedSTR().Epoch != 3 {
+		t.Error("Expect the verified STR to be unchanged")
+	}
+}
+
+func TestVerifiedPolicies(t *testing.T) {
+	d, cc := newTestClient(t)
+	vrfKey, _ := crypto.NewStaticTestVRFKey().Public()
+
+	// the new policies take effect in the STR of the epoch after next
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.SetPolicies(5)
+	for i := 0; i < 2; i++ {
+		d.Update()
+		res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+		if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := cc.VerifiedPolicies()
+	if p.EpochDeadline != d.EpochDeadline() || p.EpochDeadline != 5 {
+		t.Error("Expect epoch deadline", d.EpochDeadline(), "got", p.EpochDeadline)
+	}
+	if !bytes.Equal(p.VrfPublicKey, vrfKey) {
+		t.Error("Unexpected VRF public key")
+	}
+	if p.Version != protocol.Version || p.HashID != crypto.HashID {
+		t.Error("Unexpected version or hash algorithm", p.Version, p.HashID)
+	}
+}
+
+func TestLookupMustMatchAuditedSTR(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.Update()
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+
+	// the directory equivocates at epoch 2
+	fork := d.ForkAt(t, 1)
+	fork.Register(&protocol.RegistrationRequest{Username: bob, Key: key})
+	d.Update()
+	fork.Update()
+
+	// the auditor confirms the honest STR for epoch 2
+	if err := cc.CheckEquivocation(getSTRHistory(d)); err != nil {
+		t.Fatal(err)
+	}
+	if cc.VerifiedSTR().Epoch != 2 {
+		t.Fatal("Expect the auditor-confirmed STR to be adopted")
+	}
+
+	// a lookup against the forked STR for epoch 2 is rejected,
+	// even though it extends the previously verified STR
+	res = fork.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPinnedKeySet(t *testing.T) {
+	standbySK, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := staticSigningKey.Public()
+	standbyPK, _ := standbySK.Public()
+
+	// a directory that rotated to its standby key
+	d := directory.New(1, crypto.NewStaticTestVRFKey(), standbySK, 10, true)
+	cc := NewWithKeys(d.LatestSTR(), true, []sign.PublicKey{pk, standbyPK})
+	unpinned := New(d.LatestSTR(), true, pk)
+
+	res := d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	if err := cc.HandleResponse(protocol.RegistrationType, res, alice, key); err != nil {
+		t.Fatal("Expect an STR signed by the standby key to be accepted, got", err)
+	}
+	if err := unpinned.HandleResponse(protocol.RegistrationType, res, alice,
+		key); err != protocol.CheckBadSignature {
+		t.Fatal("Expect", protocol.CheckBadSignature, "got", err)
+	}
+
+	d.Update()
+	res = d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 0,
+		EndEpoch:   1})
+	if err := cc.CheckEquivocation(res); err != nil {
+		t.Fatal("Expect an STR signed by the standby key to be accepted, got", err)
+	}
+	if err := unpinned.CheckEquivocation(res); err != protocol.CheckBadSignature {
+		t.Fatal("Expect", protocol.CheckBadSignature, "got", err)
+	}
+}
+
+func TestNewChecked(t *testing.T) {
+	standbySK, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := staticSigningKey.Public()
+	standbyPK, _ := standbySK.Public()
+	d := directory.New(1, crypto.NewStaticTestVRFKey(), standbySK, 10, true)
+
+	// the initial STR is validated under the pinned keys
+	if _, err := NewChecked(d.LatestSTR(), true, pk); err != protocol.CheckBadSignature {
+		t.Error("Expect", protocol.CheckBadSignature, "got", err)
+	}
+	if _, err := NewWithKeysChecked(d.LatestSTR(), true,
+		[]sign.PublicKey{pk, standbyPK}); err != nil {
+		t.Error("Expect the initial STR to be valid under the standby key, got", err)
+	}
+	if _, err := NewChecked(nil, true, pk); err != protocol.ErrMalformedMessage {
+		t.Error("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+
+	// a malformed initial STR is rejected rather than panicking
+	str := *d.LatestSTR().SignedTreeRoot
+	str.PreviousEpoch = 1
+	if _, err := NewChecked(protocol.NewDirSTR(&str), true, standbyPK); err != protocol.ErrMalformedMessage {
+		t.Error("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+
+	// a saved STR of a later epoch is pinned as is
+	d.Update()
+	cc, err := NewChecked(d.LatestSTR(), true, standbyPK)
+	if err != nil || cc.VerifiedSTR().Epoch != 1 {
+		t.Error("Expect the saved STR to be pinned, got", err)
+	}
+}
+
+func TestHandleResponseWithAuditorSTR(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.Update()
+
+	res := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 0,
+		EndEpoch:   1})
+	if err := cc.CheckEquivocation(res); err != nil {
+		t.Fatal(err)
+	}
+	strs := res.DirectoryResponse.(*protocol.STRHistoryRange).STR
+	trusted := strs[len(strs)-1]
+
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponseWithSTR(protocol.KeyLookupType, res, alice, key,
+		trusted); err != nil {
+		t.Fatal("Expect a proof against the auditor's STR to be accepted, got", err)
+	}
+
+	// a proof against a newer (and otherwise valid) STR
+	d.Update()
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponseWithSTR(protocol.KeyLookupType, res, alice, key,
+		trusted); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+	if cc.VerifiedSTR().Epoch != 1 {
+		t.Fatal("Expect the verified STR to be unchanged")
+	}
+}
+
+func TestRequireAuditorConfirmation(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.Update()
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+
+	// by default, a lookup is accepted without an auditor's confirmation
+	_, lenient := newTestClient(t)
+	if err := lenient.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+
+	cc.RequireAuditorConfirmation(true)
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != protocol.CheckUnconfirmedSTR {
+		t.Fatal("Expect", protocol.CheckUnconfirmedSTR, "got", err)
+	}
+	if cc.VerifiedSTR().Epoch != 0 || cc.Bindings[alice] != nil {
+		t.Fatal("Expect an unconfirmed lookup to leave the state unchanged")
+	}
+	batch := d.BatchKeyLookup(&protocol.BatchKeyLookupRequest{Usernames: []string{alice}})
+	if _, err := cc.HandleBatchResponse(batch, []string{alice}, nil); err != protocol.CheckUnconfirmedSTR {
+		t.Fatal("Expect", protocol.CheckUnconfirmedSTR, "got", err)
+	}
+
+	if err := cc.CheckEquivocation(getSTRHistory(d)); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Fatal("Expect a confirmed lookup to be accepted, got", err)
+	}
+
+	// the directory's next STR hasn't been confirmed yet
+	d.Update()
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != protocol.CheckUnconfirmedSTR {
+		t.Fatal("Expect", protocol.CheckUnconfirmedSTR, "got", err)
+	}
+}
+
+// testAlgorithm is an Ed25519 variant which is registered under a
+// different ID, standing in for a newly deployed signature scheme.
+type testAlgorithm struct{}
+
+func (testAlgorithm) ID() string { return "Test-Ed25519" }
+
+func (testAlgorithm) Verify(pk sign.PublicKey, message, sig []byte) bool {
+	return pk.Verify(message, sig)
+}
+
+func TestSignatureAlgorithmAllowlist(t *testing.T) {
+	sign.RegisterAlgorithm(testAlgorithm{})
+	d, cc := newTestClient(t)
+	d.Update()
+
+	// re-sign the latest STR declaring the test algorithm
+	str := d.LatestSTR()
+	sr := *str.SignedTreeRoot
+	p := *str.Policies
+	p.SignatureID = testAlgorithm{}.ID()
+	forged := &protocol.DirSTR{SignedTreeRoot: &sr, Policies: &p}
+	forged.Signature = staticSigningKey.Sign(forged.Serialize())
+
+	if err := cc.AuditDirectory([]*protocol.DirSTR{forged}); err != auditor.ErrSignatureAlgorithm {
+		t.Fatal("Expect", auditor.ErrSignatureAlgorithm, "got", err)
+	}
+	if cc.VerifiedSTR().Epoch != 0 {
+		t.Fatal("Expect the verified STR to remain unchanged")
+	}
+
+	// an STR declaring an unknown algorithm is rejected even if allowed
+	p.SignatureID = "unknown"
+	cc.AllowSignatureAlgorithms(sign.Ed25519ID, "unknown")
+	if err := cc.AuditDirectory([]*protocol.DirSTR{forged}); err != auditor.ErrSignatureAlgorithm {
+		t.Fatal("Expect", auditor.ErrSignatureAlgorithm, "got", err)
+	}
+
+	// once allowed, the algorithm passes the allowlist, but the
+	// directory didn't announce the switch to it
+	p.SignatureID = testAlgorithm{}.ID()
+	cc.AllowSignatureAlgorithms(sign.Ed25519ID, testAlgorithm{}.ID())
+	if err := cc.AuditDirectory([]*protocol.DirSTR{forged}); err != protocol.CheckBadSigningKeyChange {
+		t.Fatal("Expect", protocol.CheckBadSigningKeyChange, "got", err)
+	}
+}
+
+func TestRegisterExistingName(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.Update()
+
+	// the client learns the existing binding even if it asked for another key
+	res := d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	if err := cc.HandleResponse(protocol.RegistrationType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+	if res.Error != protocol.ReqNameExisted {
+		t.Fatal("Expect", protocol.ReqNameExisted, "got", res.Error)
+	}
+	res = d.Register(&protocol.RegistrationRequest{Username: alice, Key: []byte("other")})
+	if err := cc.HandleResponse(protocol.RegistrationType, res, alice, []byte("other")); err != protocol.CheckBindingsDiffer {
+		t.Fatal("Expect", protocol.CheckBindingsDiffer, "got", err)
+	}
+}
+
+func TestRegisterFabricatedNameExisted(t *testing.T) {
+	d, _ := newTestClient(t)
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.Update()
+	pk, _ := staticSigningKey.Public()
+
+	tests := []struct {
+		name   string
+		tamper func(df *protocol.DirectoryProof)
+		want   error
+	}{
+		{"forged auth path", func(df *protocol.DirectoryProof) {
+			df.AP[0].PrunedTree[0][0] ^= 0xff
+		}, protocol.CheckBadAuthPath},
+		{"forged key", func(df *protocol.DirectoryProof) {
+			df.AP[0].Leaf.Value = []byte("other")
+		}, protocol.CheckBadCommitment},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cc := New(d.LatestSTR(), true, pk)
+			res := d.Register(&protocol.RegistrationRequest{Username: alice, Key: []byte("other")})
+			tt.tamper(res.DirectoryResponse.(*protocol.DirectoryProof))
+			if err := cc.HandleResponse(protocol.RegistrationType, res, alice, []byte("other")); err != tt.want {
+				t.Error("Expect", tt.want, "got", err)
+			}
+		})
+	}
+
+	// a name which isn't registered can't be claimed to be taken
+	cc := New(d.LatestSTR(), true, pk)
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: bob})
+	res.Error = protocol.ReqNameExisted
+	if err := cc.HandleResponse(protocol.RegistrationType, res, bob, key); err != protocol.CheckBadPromise {
+		t.Error("Expect", protocol.CheckBadPromise, "got", err)
+	}
+}
+
+func TestHandleResponseResult(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.Update()
+
+	res := d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	r, err := cc.HandleResponseResult(protocol.RegistrationType, res, alice, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Epoch != d.LatestSTR().Epoch || !bytes.Equal(r.STR.Signature, d.LatestSTR().Signature) ||
+		!bytes.Equal(r.Value, key) || !r.IsTemporaryBinding {
+		t.Fatal("Expect a TB for the pending registration, got", r)
+	}
+
+	d.Update()
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	r, err = cc.HandleResponseResult(protocol.KeyLookupType, res, alice, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Epoch != d.LatestSTR().Epoch || r.STR.Epoch != d.LatestSTR().Epoch ||
+		!bytes.Equal(r.Value, key) || r.IsTemporaryBinding {
+		t.Fatal("Expect the included binding, got", r)
+	}
+
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: bob})
+	r, err = cc.HandleResponseResult(protocol.KeyLookupType, res, bob, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Value != nil || r.IsTemporaryBinding {
+		t.Fatal("Expect no binding for an unregistered name, got", r)
+	}
+
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if r, err := cc.HandleResponseResult(protocol.KeyLookupType, res, alice,
+		[]byte("other")); err != protocol.CheckBindingsDiffer || r != nil {
+		t.Fatal("Expect", protocol.CheckBindingsDiffer, "and no result, got", err, r)
+	}
+}
+
+func TestHandleResponseExpired(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.Update()
+
+	res := d.Register(&protocol.RegistrationRequest{Username: alice, Key: key, TTL: 1})
+	if err := cc.HandleResponse(protocol.RegistrationType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	r, err := cc.HandleResponseResult(protocol.KeyLookupType, res, alice, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r.Value, key) {
+		t.Fatal("Expect the binding before its TTL runs out, got", r)
+	}
+
+	d.Update()
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	r, err = cc.HandleResponseResult(protocol.KeyLookupType, res, alice, key)
+	var te *TombstoneError
+	if !errors.As(err, &te) || !errors.Is(err, protocol.CheckTombstonedBinding) || r != nil {
+		t.Fatal("Expect a TombstoneError and no result, got", err, r)
+	}
+	if te.Username != alice || te.Reason != protocol.TombstoneExpired ||
+		te.Epoch != d.LatestSTR().Epoch {
+		t.Fatal("Unexpected tombstone", te)
+	}
+	// a client which doesn't know the key doesn't accept the tombstone either
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, nil); !errors.As(err, &te) {
+		t.Fatal("Expect a TombstoneError, got", err)
+	}
+
+	// an absent binding is reported differently
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: bob})
+	r, err = cc.HandleResponseResult(protocol.KeyLookupType, res, bob, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Value != nil {
+		t.Fatal("Expect no binding for an unregistered name, got", r)
+	}
+}
+
+func TestHandleResponseTombstoneFromFuture(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.SetKey(t, alice, protocol.NewTombstone(protocol.TombstoneDeleted, 100))
+	d.Update()
+
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, nil); err != protocol.CheckBindingsDiffer {
+		t.Fatal("Expect", protocol.CheckBindingsDiffer, "got", err)
+	}
+}
+
+func TestDirectoryMetadata(t *testing.T) {
+	d, cc := newTestClient(t)
+	if cc.DirectoryMetadata() != nil {
+		t.Fatal("Expect no metadata by default")
+	}
+	m := &protocol.DirectoryMetadata{
+		Auditors: []string{"tcp://auditor1.example:3000", "tcp://auditor2.example:3000"},
+		Contact:  "ops@example.org",
+	}
+	d.SetMetadata(m)
+	d.Update()
+	d.Update()
+	for ep := uint64(1); ep <= d.LatestSTR().Epoch; ep++ {
+		res := d.GetSTRHistory(&protocol.STRHistoryRequest{
+			StartEpoch: ep - 1,
+			EndEpoch:   ep})
+		if err := cc.CheckEquivocation(res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := cc.DirectoryMetadata(); !reflect.DeepEqual(got, m) {
+		t.Fatal("Expect the signed metadata", m, "got", got)
+	}
+
+	// tampering with the metadata invalidates the STR
+	str := d.LatestSTR()
+	policies := *str.Policies
+	policies.Metadata = &protocol.DirectoryMetadata{
+		Auditors: []string{"tcp://evil.example:3000"},
+		Contact:  m.Contact,
+	}
+	tampered := *str
+	tampered.Policies = &policies
+	pk, _ := staticSigningKey.Public()
+	if err := auditor.New(pk, str).VerifySTR(&tampered); err != protocol.CheckBadSignature {
+		t.Error("Expect", protocol.CheckBadSignature, "got", err)
+	}
+}
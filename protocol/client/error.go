@@ -0,0 +1,160 @@
+// Defines the errors that a client may return
+// in addition to the protocol's error codes.
+
+package client
+
+import (
+	"fmt"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+// An EquivocationError indicates that the client's view of a directory's
+// STR history and an auditor's view diverge. It reports the earliest
+// epoch at which the two views are known to differ, along with the
+// signatures of the client's and the auditor's conflicting STRs for
+// that epoch. An EquivocationError wraps protocol.CheckBadSTR.
+type EquivocationError struct {
+	Epoch            uint64
+	ClientSignature  []byte
+	AuditorSignature []byte
+}
+
+// Error returns a human-readable description of the divergence.
+func (e *EquivocationError) Error() string {
+	return fmt.Sprintf("[coniks] The client's view and the auditor's view diverge at epoch %d", e.Epoch)
+}
+
+// Unwrap returns protocol.CheckBadSTR, so that callers can check
+// for an EquivocationError using errors.Is(err, protocol.CheckBadSTR).
+func (e *EquivocationError) Unwrap() error {
+	return protocol.CheckBadSTR
+}
+
+// A RollbackError indicates that the STRs a directory presented to
+// the client after it reconnected aren't a forward continuation of
+// the client's pinned STR for PinnedEpoch (see CheckRollback()):
+// either the directory's most recent STR, for TipEpoch, is older than
+// the pinned STR, or the directory's history Forked from the pinned STR.
+// A RollbackError wraps protocol.CheckBadSTR if the history forked,
+// and auditor.ErrRollback otherwise.
+type RollbackError struct {
+	PinnedEpoch uint64
+	TipEpoch    uint64
+	Forked      bool
+}
+
+// Error returns a human-readable description of the rollback.
+func (e *RollbackError) Error() string {
+	if e.Forked {
+		return fmt.Sprintf("[coniks] The directory's history forked from the pinned STR for epoch %d", e.PinnedEpoch)
+	}
+	return fmt.Sprintf("[coniks] The directory's latest epoch %d is older than the pinned epoch %d",
+		e.TipEpoch, e.PinnedEpoch)
+}
+
+// Unwrap returns protocol.CheckBadSTR if e reports a fork, and
+// auditor.ErrRollback otherwise, so that callers can check for a
+// RollbackError using errors.Is().
+func (e *RollbackError) Unwrap() error {
+	if e.Forked {
+		return protocol.CheckBadSTR
+	}
+	return auditor.ErrRollback
+}
+
+// A CadenceError indicates that the directory issued the STRs from
+// FromEpoch to ToEpoch in an implausible time for its declared epoch
+// deadline (see SetCadenceTolerance()): Elapsed is the number of
+// seconds between the STRs' timestamps, and Expected the number of
+// seconds the epochs should have taken. A CadenceError wraps
+// protocol.CheckImplausibleCadence.
+type CadenceError struct {
+	FromEpoch uint64
+	ToEpoch   uint64
+	Elapsed   uint64
+	Expected  uint64
+}
+
+// Error returns a human-readable description of the implausible cadence.
+func (e *CadenceError) Error() string {
+	return fmt.Sprintf("[coniks] The directory advanced from epoch %d to %d in %ds, expected about %ds",
+		e.FromEpoch, e.ToEpoch, e.Elapsed, e.Expected)
+}
+
+// Unwrap returns protocol.CheckImplausibleCadence, so that callers can
+// check for a CadenceError using errors.Is().
+func (e *CadenceError) Unwrap() error {
+	return protocol.CheckImplausibleCadence
+}
+
+// A ContradictionError indicates that the client verified both a proof
+// of inclusion and a proof of absence for Username in Epoch (see
+// checkExclusivity()), i.e. the directory presented at least two
+// different trees for that epoch. A ContradictionError wraps
+// protocol.CheckContradictoryProofs.
+type ContradictionError struct {
+	Username string
+	Epoch    uint64
+}
+
+// Error returns a human-readable description of the contradiction.
+func (e *ContradictionError) Error() string {
+	return fmt.Sprintf("[coniks] The directory proved both the presence and the absence of %q in epoch %d",
+		e.Username, e.Epoch)
+}
+
+// Unwrap returns protocol.CheckContradictoryProofs, so that callers can
+// check for a ContradictionError using errors.Is().
+func (e *ContradictionError) Unwrap() error {
+	return protocol.CheckContradictoryProofs
+}
+
+// An IndexMovedError indicates that the lookup index of Username proven
+// in Epoch differs from the one the client verified for it in the
+// earlier PreviousEpoch, although the directory didn't announce a
+// rotation of its VRF key in between (see checkIndexStability()).
+// An IndexMovedError wraps protocol.CheckIndexMoved.
+type IndexMovedError struct {
+	Username      string
+	PreviousEpoch uint64
+	Epoch         uint64
+}
+
+// Error returns a human-readable description of the moved index.
+func (e *IndexMovedError) Error() string {
+	return fmt.Sprintf("[coniks] The lookup index of %q changed between epochs %d and %d without an announced VRF key rotation",
+		e.Username, e.PreviousEpoch, e.Epoch)
+}
+
+// Unwrap returns protocol.CheckIndexMoved, so that callers can check
+// for an IndexMovedError using errors.Is().
+func (e *IndexMovedError) Unwrap() error {
+	return protocol.CheckIndexMoved
+}
+
+// A TombstoneError indicates that the directory proved that the binding
+// of Username was replaced with a tombstone (see protocol.NewTombstone())
+// in Epoch, because of Reason, e.g. protocol.TombstoneExpired, so that
+// an expired or deleted binding can be told apart from an absent one.
+// A TombstoneError wraps protocol.CheckTombstonedBinding.
+type TombstoneError struct {
+	Username string
+	Reason   protocol.TombstoneReason
+	Epoch    uint64
+}
+
+// Error returns a human-readable description of the tombstone.
+func (e *TombstoneError) Error() string {
+	if e.Reason == protocol.TombstoneExpired {
+		return fmt.Sprintf("[coniks] The binding of %q expired in epoch %d", e.Username, e.Epoch)
+	}
+	return fmt.Sprintf("[coniks] The binding of %q was deleted in epoch %d", e.Username, e.Epoch)
+}
+
+// Unwrap returns protocol.CheckTombstonedBinding, so that callers can
+// check for a TombstoneError using errors.Is().
+func (e *TombstoneError) Unwrap() error {
+	return protocol.CheckTombstonedBinding
+}
@@ -0,0 +1,66 @@
+// Implements a cross-proof consistency check which ensures that the
+// client never accepts both a proof of inclusion and a proof of absence
+// for the same name in the same epoch.
+
+package client
+
+import (
+	"github.com/coniks-sys/coniks-go/merkletree"
+)
+
+// DefaultExclusivityWindow is the number of epochs up to the verified
+// STR's epoch for which a new ConsistencyChecks keeps the proof types
+// it verified (see SetExclusivityWindow()).
+const DefaultExclusivityWindow = 1024
+
+// SetExclusivityWindow makes cc keep the type of the proofs it verified
+// for each username only for the latest epochs epochs up to the epoch
+// of cc.verifiedSTR, so that the memory used by checkExclusivity() is
+// bounded by the number of looked up usernames times epochs.
+// A contradiction between proofs for an older epoch isn't detected.
+// Windows below 1 are treated as 1.
+func (cc *ConsistencyChecks) SetExclusivityWindow(epochs uint64) {
+	if epochs < 1 {
+		epochs = 1
+	}
+	cc.exclusivityWindow = epochs
+}
+
+// checkExclusivity records that the client verified a proof of type
+// proofType for uname in epoch, and returns a *ContradictionError if it
+// has verified a proof of the other type for uname in the same epoch
+// before.
+// A single tree can't prove both the presence and the absence of a
+// name, so a contradiction means that the directory signed at least
+// two different trees for epoch, e.g. one presented to the client on
+// its freshness path and another one presented along with an archived
+// proof (see VerifyHistoricalProof()).
+//
+// The recorded proof types are kept only for the epochs in the
+// exclusivity window (see SetExclusivityWindow()); proofs for older
+// epochs are neither checked nor recorded.
+func (cc *ConsistencyChecks) checkExclusivity(uname string, epoch uint64,
+	proofType merkletree.ProofType) error {
+	var oldest uint64
+	if latest := cc.VerifiedSTR().Epoch; latest >= cc.exclusivityWindow {
+		oldest = latest - cc.exclusivityWindow + 1
+	}
+	if epoch < oldest {
+		return nil
+	}
+	epochs, ok := cc.proofTypes[uname]
+	if !ok {
+		epochs = make(map[uint64]merkletree.ProofType)
+		cc.proofTypes[uname] = epochs
+	}
+	for ep := range epochs {
+		if ep < oldest {
+			delete(epochs, ep)
+		}
+	}
+	if seen, ok := epochs[epoch]; ok && seen != proofType {
+		return &ContradictionError{Username: uname, Epoch: epoch}
+	}
+	epochs[epoch] = proofType
+	return nil
+}
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+func TestContradictoryProofs(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.Update()
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, nil); err != nil {
+		t.Fatal(err)
+	}
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.Update()
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+	present := res.DirectoryResponse.(*protocol.DirectoryProof)
+	epoch := present.STR[0].Epoch
+
+	// a forged tree for the same epoch, which doesn't include alice
+	fork := d.ForkAt(t, epoch-1)
+	fork.Register(&protocol.RegistrationRequest{Username: bob, Key: key})
+	fork.Update()
+	res = fork.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if res.Error != protocol.ReqNameNotFound {
+		t.Fatal("Expect a proof of absence, got", res.Error)
+	}
+	absent := res.DirectoryResponse.(*protocol.DirectoryProof)
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: bob})
+	bobAbsent := res.DirectoryResponse.(*protocol.DirectoryProof)
+	res = fork.KeyLookup(&protocol.KeyLookupRequest{Username: bob})
+	bobPresent := res.DirectoryResponse.(*protocol.DirectoryProof)
+
+	// the client has moved on, so the forged STR is only checked
+	// against the directory's signature
+	d.Update()
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+
+	// a proof of the same type for the same epoch is fine
+	if _, err := cc.VerifyHistoricalProof(present, alice, key, present.STR[0]); err != nil {
+		t.Fatal(err)
+	}
+	_, err := cc.VerifyHistoricalProof(absent, alice, nil, absent.STR[0])
+	var e *ContradictionError
+	if !errors.As(err, &e) || !errors.Is(err, protocol.CheckContradictoryProofs) {
+		t.Fatal("Expect a *ContradictionError, got", err)
+	}
+	if e.Username != alice || e.Epoch != epoch {
+		t.Error("Unexpected contradiction", e)
+	}
+
+	// the same holds if the proof of absence is verified first
+	if _, err := cc.VerifyHistoricalProof(bobAbsent, bob, nil, bobAbsent.STR[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cc.VerifyHistoricalProof(bobPresent, bob, nil,
+		bobPresent.STR[0]); !errors.Is(err, protocol.CheckContradictoryProofs) {
+		t.Error("Expect", protocol.CheckContradictoryProofs, "got", err)
+	}
+}
+
+func TestExclusivityWindow(t *testing.T) {
+	d, cc := newTestClient(t)
+	cc.SetExclusivityWindow(2)
+	lookup := func() {
+		res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+		if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		d.Update()
+		lookup()
+	}
+	epoch := cc.VerifiedSTR().Epoch
+
+	if err := cc.checkExclusivity(bob, epoch, merkletree.ProofOfInclusion); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.checkExclusivity(bob, epoch, merkletree.ProofOfAbsence); !errors.Is(err, protocol.CheckContradictoryProofs) {
+		t.Error("Expect", protocol.CheckContradictoryProofs, "got", err)
+	}
+	// epochs before the window are neither checked nor recorded
+	old := epoch - 2
+	if err := cc.checkExclusivity(bob, old, merkletree.ProofOfInclusion); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.checkExclusivity(bob, old, merkletree.ProofOfAbsence); err != nil {
+		t.Error("Expect no check outside the window, got", err)
+	}
+	if _, ok := cc.proofTypes[bob][old]; ok {
+		t.Error("Expect no proof type to be recorded outside the window")
+	}
+
+	// epochs which drop out of the window are pruned
+	for i := 0; i < 2; i++ {
+		d.Update()
+		lookup()
+	}
+	if err := cc.checkExclusivity(bob, cc.VerifiedSTR().Epoch, merkletree.ProofOfInclusion); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cc.proofTypes[bob][epoch]; ok || len(cc.proofTypes[bob]) != 1 {
+		t.Error("Expect the proof types to be pruned, got", cc.proofTypes[bob])
+	}
+}
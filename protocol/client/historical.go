@@ -0,0 +1,74 @@
+// Implements re-verifying an archived directory proof against the STR
+// of the epoch it was issued in, e.g. one fetched from an auditor.
+
+package client
+
+import (
+	"bytes"
+
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// VerifyHistoricalProof verifies the archived proof df for the username
+// uname against the explicitly given STR str, e.g. the STR for the
+// proof's epoch which the client fetched from an auditor, rather than
+// against the cc.verifiedSTR.
+// VerifyHistoricalProof() checks that str is signed by the directory and
+// is the STR the proof was issued with, and, if str is for the epoch of
+// the cc.verifiedSTR, that the two are identical. It then verifies the
+// proof's authentication path against str as HandleResponse() does,
+// accepting the proven key as TOFU if key is nil.
+// VerifyHistoricalProof() returns the VerificationResult for the binding
+// of uname in str's epoch; the result never reports a TB, since the
+// promise of a past epoch can't be checked against str.
+// VerifyHistoricalProof() doesn't update the consistency state of cc.
+// It returns ErrMalformedMessage if df or str is malformed, CheckBadSTR
+// if str isn't the proof's STR, CheckUnconfirmedSTR if cc requires
+// audited STRs and str hasn't been audited (see RequireAudited()), or
+// the error of the failed check.
+func (cc *ConsistencyChecks) VerifyHistoricalProof(df *protocol.DirectoryProof,
+	uname string, key []byte, str *protocol.DirSTR) (*VerificationResult, error) {
+	msg := &protocol.Response{Error: protocol.ReqSuccess, DirectoryResponse: df}
+	if err := msg.Validate(); err != nil {
+		return nil, protocol.ErrMalformedMessage
+	}
+	if err := protocol.NewSTRHistoryRange([]*protocol.DirSTR{str}).Validate(); err != nil {
+		return nil, protocol.ErrMalformedMessage
+	}
+	if err := cc.VerifySTR(str); err != nil {
+		return nil, err
+	}
+	if !sameSTR(df.STR[0], str) {
+		return nil, protocol.CheckBadSTR
+	}
+	if v := cc.VerifiedSTR(); v.Epoch == str.Epoch && !sameSTR(v, str) {
+		return nil, protocol.CheckBadSTR
+	}
+	if err := cc.checkAudited(str); err != nil {
+		return nil, err
+	}
+
+	ap := df.AP[0]
+	if isTombstone(ap) {
+		return nil, cc.verifyTombstone(uname, ap, str)
+	}
+	if err := cc.verifyAuthPath(uname, key, ap, str); err != nil {
+		return nil, err
+	}
+	r := &VerificationResult{
+		Epoch: str.Epoch,
+		STR:   str,
+	}
+	if ap.ProofType() == merkletree.ProofOfInclusion {
+		r.Value = ap.Leaf.Value
+	}
+	return r, nil
+}
+
+// sameSTR returns whether the STRs str1 and str2 are identical,
+// including their signatures.
+func sameSTR(str1, str2 *protocol.DirSTR) bool {
+	return bytes.Equal(str1.Signature, str2.Signature) &&
+		bytes.Equal(str1.Serialize(), str2.Serialize())
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+func TestVerifyHistoricalProof(t *testing.T) {
+	d, _ := newTestClient(t)
+	d.Update()
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.Update()
+
+	// archive alice's proof from epoch 2
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	archived := res.DirectoryResponse.(*protocol.DirectoryProof)
+	epoch := archived.STR[0].Epoch
+
+	// the client has moved on to a later epoch since
+	d.Update()
+	d.Update()
+	pk, _ := staticSigningKey.Public()
+	cc := New(d.LatestSTR(), true, pk)
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+	pinned := cc.VerifiedSTR()
+
+	strs := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: epoch,
+		EndEpoch:   epoch + 1}).DirectoryResponse.(*protocol.STRHistoryRange).STR
+
+	r, err := cc.VerifyHistoricalProof(archived, alice, key, strs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Epoch != epoch || !bytes.Equal(r.Value, key) || r.IsTemporaryBinding {
+		t.Error("Unexpected result", r)
+	}
+	if cc.VerifiedSTR() != pinned {
+		t.Error("Expect the pinned STR to be unchanged")
+	}
+
+	// the proof doesn't verify against another epoch's STR
+	if _, err := cc.VerifyHistoricalProof(archived, alice, key,
+		strs[1]); err != protocol.CheckBadSTR {
+		t.Error("Expect", protocol.CheckBadSTR, "got", err)
+	}
+	if _, err := cc.VerifyHistoricalProof(archived, alice, []byte("other"),
+		strs[0]); err != protocol.CheckBindingsDiffer {
+		t.Error("Expect", protocol.CheckBindingsDiffer, "got", err)
+	}
+}
+
+func TestVerifyHistoricalProofForgedSTR(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.Update()
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.Update()
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	archived := res.DirectoryResponse.(*protocol.DirectoryProof)
+
+	forged := *archived.STR[0]
+	forged.Signature = append([]byte{}, forged.Signature...)
+	forged.Signature[0] ^= 0xff
+	if _, err := cc.VerifyHistoricalProof(archived, alice, key,
+		&forged); err != protocol.CheckBadSignature {
+		t.Error("Expect", protocol.CheckBadSignature, "got", err)
+	}
+	if _, err := cc.VerifyHistoricalProof(nil, alice, key,
+		archived.STR[0]); err != protocol.ErrMalformedMessage {
+		t.Error("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+}
+
+// BenchmarkVerifyProofsSameEpoch verifies many proofs against the STR
+// of one epoch, with and without caching the STR's signature.
+func BenchmarkVerifyProofsSameEpoch(b *testing.B) {
+	const numLookups = 100
+	signKey := crypto.NewStaticTestSigningKey()
+	pk, _ := signKey.Public()
+	d := directory.New(1, crypto.NewStaticTestVRFKey(), signKey, 10, true)
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.Update()
+	df := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice}).
+		DirectoryResponse.(*protocol.DirectoryProof)
+
+	for _, cached := range []bool{false, true} {
+		name := "eager"
+		if cached {
+			name = "cached"
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				cc := New(d.LatestSTR(), true, pk)
+				cc.CacheSTRSignatures(cached)
+				for j := 0; j < numLookups; j++ {
+					if _, err := cc.VerifyHistoricalProof(df, alice, key,
+						df.STR[0]); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
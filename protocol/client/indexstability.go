@@ -0,0 +1,68 @@
+// Implements a check which ensures that the lookup index of a name
+// stays the same across epochs unless the directory announces a
+// rotation of its VRF key, so that a directory can't hide a key swap
+// by moving a name's leaf and proving its absence at the old index.
+
+package client
+
+import (
+	"bytes"
+
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// A verifiedIndex is the lookup index of a name which the client
+// verified in an epoch, along with the VRF public key it was
+// computed with.
+type verifiedIndex struct {
+	epoch  uint64
+	vrfKey vrf.PublicKey
+	index  []byte
+}
+
+// checkIndexStability checks that the lookup index of the verified
+// authentication path ap for uname in the STR str is the index the
+// client verified for uname in an earlier epoch, and records it as
+// uname's latest index. The index may only change along with the VRF
+// key: str must use the VRF key of the cc.verifiedSTR, whose rotations
+// the client verified along its hash chain, or announce the rotation
+// from the earlier VRF key itself (see
+// protocol.Policies.RotatesVRFKey()).
+// checkIndexStability() returns an *IndexMovedError if the index moved
+// without an announced rotation. Proofs for epochs older than the
+// latest recorded one, e.g. historical proofs, aren't checked.
+//
+// Since the VRF is deterministic, the index can't move as long as the
+// VRF proofs verify under the same key; this check makes sure that
+// the key doesn't change silently between the epochs the client
+// verified proofs for.
+func (cc *ConsistencyChecks) checkIndexStability(uname string,
+	ap *merkletree.AuthenticationPath, str *protocol.DirSTR) error {
+	vrfKey := str.Policies.VrfPublicKey
+	prev, ok := cc.indices[uname]
+	if ok && str.Epoch < prev.epoch {
+		return nil
+	}
+	if ok {
+		moved := !bytes.Equal(prev.index, ap.LookupIndex)
+		rotated := !bytes.Equal(prev.vrfKey, vrfKey)
+		announced := bytes.Equal(vrfKey, cc.VerifiedSTR().Policies.VrfPublicKey) ||
+			(str.Policies.RotatesVRFKey() &&
+				bytes.Equal(str.Policies.PreviousVrfPublicKey, prev.vrfKey))
+		if moved && (!rotated || !announced) {
+			return &IndexMovedError{
+				Username:      uname,
+				PreviousEpoch: prev.epoch,
+				Epoch:         str.Epoch,
+			}
+		}
+	}
+	cc.indices[uname] = &verifiedIndex{
+		epoch:  str.Epoch,
+		vrfKey: vrfKey,
+		index:  append([]byte(nil), ap.LookupIndex...),
+	}
+	return nil
+}
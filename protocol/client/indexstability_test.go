@@ -0,0 +1,83 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+func TestIndexStability(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	var index []byte
+	for i := 0; i < 3; i++ {
+		d.Update()
+		res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+		if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			index = res.DirectoryResponse.(*protocol.DirectoryProof).AP[0].LookupIndex
+		}
+	}
+	if got := cc.indices[alice]; got.epoch != d.LatestSTR().Epoch ||
+		!bytes.Equal(got.index, index) {
+		t.Fatal("Expect alice's index to be stable")
+	}
+}
+
+func TestIndexStabilityMovedIndex(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.Update()
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+	df := res.DirectoryResponse.(*protocol.DirectoryProof)
+	str := df.STR[0]
+
+	// the directory moves alice's leaf in the next epoch
+	moved := *df.AP[0]
+	moved.LookupIndex = append([]byte(nil), moved.LookupIndex...)
+	moved.LookupIndex[0] ^= 0xff
+	next := *str.SignedTreeRoot
+	next.Epoch++
+	nextSTR := protocol.NewDirSTR(&next)
+
+	err := cc.checkIndexStability(alice, &moved, nextSTR)
+	var e *IndexMovedError
+	if !errors.As(err, &e) || e.PreviousEpoch != str.Epoch || e.Epoch != str.Epoch+1 ||
+		!errors.Is(err, protocol.CheckIndexMoved) {
+		t.Fatal("Expect an IndexMovedError, got", err)
+	}
+
+	// even along with an unannounced VRF key change
+	newKey, err := vrf.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPK, _ := newKey.Public()
+	rekeyed := *nextSTR.Policies
+	rekeyed.VrfPublicKey = newPK
+	nextSTR.Policies = &rekeyed
+	if err := cc.checkIndexStability(alice, &moved, nextSTR); !errors.Is(err, protocol.CheckIndexMoved) {
+		t.Fatal("Expect", protocol.CheckIndexMoved, "got", err)
+	}
+
+	// but not along with an announced rotation
+	rekeyed.PreviousVrfPublicKey = str.Policies.VrfPublicKey
+	if err := cc.checkIndexStability(alice, &moved, nextSTR); err != nil {
+		t.Fatal(err)
+	}
+
+	// proofs for older epochs aren't checked
+	older := moved
+	older.LookupIndex = []byte("older")
+	if err := cc.checkIndexStability(alice, &older, str); err != nil {
+		t.Fatal(err)
+	}
+}
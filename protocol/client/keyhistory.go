@@ -0,0 +1,153 @@
+// Implements the verification of a user's key history
+// returned by a CONIKS directory.
+
+package client
+
+import (
+	"bytes"
+
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// A KeyHistoryEntry is the key bound to a username in the given Epoch.
+// Key is nil if the username had no binding in that epoch.
+type KeyHistoryEntry struct {
+	Epoch uint64
+	Key   []byte
+}
+
+// VerifyKeyHistory verifies the directory's response msg to a
+// KeyHistoryRequest for the username uname, and returns the key bound
+// to uname in each epoch of the returned range, in chronological order.
+//
+// VerifyKeyHistory() checks that the response contains one auth path
+// and one STR for each epoch of a contiguous range, that the STRs are
+// signed by the directory and form a valid hash chain, and that the
+// range is consistent with the cc.verifiedSTR if it covers its epoch.
+// It then verifies the auth path of each epoch against the
+// corresponding STR.
+// VerifyKeyHistory() doesn't update the consistency state of cc.
+func (cc *ConsistencyChecks) VerifyKeyHistory(msg *protocol.Response,
+	uname string) ([]KeyHistoryEntry, error) {
+	if err := msg.ValidateFor(protocol.KeyHistoryType); err != nil {
+		return nil, err
+	}
+	df := msg.DirectoryResponse.(*protocol.DirectoryProof)
+	if len(df.AP) != len(df.STR) {
+		return nil, protocol.ErrMalformedMessage
+	}
+	if err := cc.verifySTRHistory(df.STR); err != nil {
+		return nil, err
+	}
+
+	// verify the binding in each epoch
+	entries := make([]KeyHistoryEntry, len(df.AP))
+	for i, ap := range df.AP {
+		if err := cc.verifyAuthPath(uname, nil, ap, df.STR[i]); err != nil {
+			return nil, err
+		}
+		entries[i].Epoch = df.STR[i].Epoch
+		if ap.ProofType() == merkletree.ProofOfInclusion {
+			entries[i].Key = ap.Leaf.Value
+		}
+	}
+	return entries, nil
+}
+
+// verifySTRHistory checks that strs is a contiguous range of STRs which
+// are signed by the directory and form a valid hash chain, and that the
+// range is consistent with the cc.verifiedSTR if it covers its epoch.
+func (cc *ConsistencyChecks) verifySTRHistory(strs []*protocol.DirSTR) error {
+	start := strs[0].Epoch
+	for i, str := range strs {
+		if str.Epoch != start+uint64(i) {
+			return protocol.ErrMalformedMessage
+		}
+	}
+
+	if err := cc.VerifySTR(strs[0]); err != nil {
+		return err
+	}
+	if err := cc.VerifySTRRange(strs[0], strs[1:]); err != nil {
+		return err
+	}
+	if v := cc.VerifiedSTR(); v.Epoch >= start && v.Epoch-start < uint64(len(strs)) {
+		if !bytes.Equal(strs[v.Epoch-start].Signature, v.Signature) {
+			return protocol.CheckBadSTR
+		}
+	}
+	return nil
+}
+
+// A KeyDelta reports whether the binding of a username changed
+// between the epochs StartEpoch and EndEpoch, along with the keys
+// StartKey and EndKey bound to it in these epochs. A key is nil if the
+// username had no binding in that epoch.
+type KeyDelta struct {
+	StartEpoch uint64
+	EndEpoch   uint64
+	StartKey   []byte
+	EndKey     []byte
+	Changed    bool
+}
+
+// VerifyKeyDelta verifies the directory's response msg to a
+// KeyDeltaRequest for the username uname, and returns whether uname's
+// binding changed over the returned range.
+//
+// VerifyKeyDelta() checks the returned STRs as VerifyKeyHistory() does,
+// and verifies the auth paths for the start and end epochs against the
+// first and last STR of the range. The binding is unchanged if both auth
+// paths are proofs of absence, or if both are proofs of inclusion of
+// the same leaf, i.e. with the same key and commitment. Since the
+// directory commits to a key with a fresh salt whenever it sets a
+// binding, an unchanged commitment shows that the directory didn't
+// set the binding again during the range, unless it reused the salt
+// on purpose; a client that needs to rule this out must verify the
+// full history (see VerifyKeyHistory()).
+// VerifyKeyDelta() doesn't update the consistency state of cc.
+func (cc *ConsistencyChecks) VerifyKeyDelta(msg *protocol.Response,
+	uname string) (*KeyDelta, error) {
+	if err := msg.ValidateFor(protocol.KeyDeltaType); err != nil {
+		return nil, err
+	}
+	df := msg.DirectoryResponse.(*protocol.DirectoryProof)
+	if len(df.AP) != 2 {
+		return nil, protocol.ErrMalformedMessage
+	}
+	if err := cc.verifySTRHistory(df.STR); err != nil {
+		return nil, err
+	}
+
+	startSTR, endSTR := df.STR[0], df.STR[len(df.STR)-1]
+	startAP, endAP := df.AP[0], df.AP[1]
+	if err := cc.verifyAuthPath(uname, nil, startAP, startSTR); err != nil {
+		return nil, err
+	}
+	if err := cc.verifyAuthPath(uname, nil, endAP, endSTR); err != nil {
+		return nil, err
+	}
+
+	delta := &KeyDelta{
+		StartEpoch: startSTR.Epoch,
+		EndEpoch:   endSTR.Epoch,
+	}
+	startIncluded := startAP.ProofType() == merkletree.ProofOfInclusion
+	endIncluded := endAP.ProofType() == merkletree.ProofOfInclusion
+	if startIncluded {
+		delta.StartKey = startAP.Leaf.Value
+	}
+	if endIncluded {
+		delta.EndKey = endAP.Leaf.Value
+	}
+	switch {
+	case startIncluded && endIncluded:
+		delta.Changed = !bytes.Equal(startAP.Leaf.Value, endAP.Leaf.Value) ||
+			!bytes.Equal(startAP.Leaf.Commitment.Salt, endAP.Leaf.Commitment.Salt) ||
+			!bytes.Equal(startAP.Leaf.Commitment.Value, endAP.Leaf.Commitment.Value)
+	default:
+		delta.Changed = startIncluded != endIncluded
+	}
+	return delta, nil
+}
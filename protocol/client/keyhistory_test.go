@@ -0,0 +1,152 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+// newTestKeyHistory returns a directory in which alice has no key in
+// epochs 0-1, key in epoch 2, and key2 in epochs 3-4.
+// The test directory's initial tree is static and has no auth paths,
+// so key histories can only be verified from epoch 1 on.
+func newTestKeyHistory(t *testing.T) (*directory.ConiksDirectory, *ConsistencyChecks) {
+	d, cc := newTestClient(t)
+	d.Update()
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.Update()
+	d.SetKey(t, alice, []byte("key2"))
+	d.Update()
+	d.Update()
+	return d, cc
+}
+
+func TestVerifyKeyHistory(t *testing.T) {
+	d, cc := newTestKeyHistory(t)
+
+	res := d.KeyHistory(&protocol.KeyHistoryRequest{Username: alice, StartEpoch: 1, EndEpoch: 10})
+	entries, err := cc.VerifyKeyHistory(res, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]byte{nil, key, []byte("key2"), []byte("key2")}
+	if len(entries) != len(expected) {
+		t.Fatal("Expect", len(expected), "entries, got", len(entries))
+	}
+	for i, e := range entries {
+		if e.Epoch != uint64(i+1) || !bytes.Equal(e.Key, expected[i]) {
+			t.Errorf("Epoch %d: expect key %q, got %q", i+1, expected[i], e.Key)
+		}
+	}
+
+	// a name that was never registered
+	res = d.KeyHistory(&protocol.KeyHistoryRequest{Username: bob, StartEpoch: 1, EndEpoch: 3})
+	entries, err = cc.VerifyKeyHistory(res, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Key != nil {
+			t.Error("Expect no key for bob in epoch", e.Epoch)
+		}
+	}
+}
+
+func TestVerifyKeyHistoryTampered(t *testing.T) {
+	d, cc := newTestKeyHistory(t)
+	req := &protocol.KeyHistoryRequest{Username: alice, StartEpoch: 1, EndEpoch: 4}
+
+	// an auth path from another epoch
+	res := d.KeyHistory(req)
+	df := res.DirectoryResponse.(*protocol.DirectoryProof)
+	df.AP[1] = df.AP[2]
+	if _, err := cc.VerifyKeyHistory(res, alice); err != protocol.CheckBadAuthPath {
+		t.Error("Expect", protocol.CheckBadAuthPath, "got", err)
+	}
+
+	// a gap in the range
+	res = d.KeyHistory(req)
+	df = res.DirectoryResponse.(*protocol.DirectoryProof)
+	df.AP = append(df.AP[:2], df.AP[3:]...)
+	df.STR = append(df.STR[:2], df.STR[3:]...)
+	if _, err := cc.VerifyKeyHistory(res, alice); err != protocol.ErrMalformedMessage {
+		t.Error("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+
+	// a range inconsistent with the client's view
+	_, fork, cc := newTestFork(t)
+	fork.Update()
+	res = fork.KeyHistory(&protocol.KeyHistoryRequest{Username: alice, StartEpoch: 1, EndEpoch: 2})
+	if _, err := cc.VerifyKeyHistory(res, alice); err != protocol.CheckBadSTR {
+		t.Error("Expect", protocol.CheckBadSTR, "got", err)
+	}
+}
+
+func TestVerifyKeyDelta(t *testing.T) {
+	d, cc := newTestKeyHistory(t)
+
+	// alice's binding is unchanged in epochs 3-4
+	res := d.KeyDelta(&protocol.KeyDeltaRequest{Username: alice, StartEpoch: 3, EndEpoch: 4})
+	delta, err := cc.VerifyKeyDelta(res, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delta.Changed || delta.StartEpoch != 3 || delta.EndEpoch != 4 ||
+		!bytes.Equal(delta.StartKey, []byte("key2")) ||
+		!bytes.Equal(delta.EndKey, []byte("key2")) {
+		t.Error("Unexpected key delta", delta)
+	}
+
+	// alice's key changed in epoch 3
+	res = d.KeyDelta(&protocol.KeyDeltaRequest{Username: alice, StartEpoch: 2, EndEpoch: 4})
+	delta, err = cc.VerifyKeyDelta(res, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !delta.Changed || !bytes.Equal(delta.StartKey, key) ||
+		!bytes.Equal(delta.EndKey, []byte("key2")) {
+		t.Error("Expect a changed binding", delta)
+	}
+
+	// alice registered in epoch 2
+	res = d.KeyDelta(&protocol.KeyDeltaRequest{Username: alice, StartEpoch: 1, EndEpoch: 2})
+	delta, err = cc.VerifyKeyDelta(res, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !delta.Changed || delta.StartKey != nil || !bytes.Equal(delta.EndKey, key) {
+		t.Error("Expect a new binding", delta)
+	}
+
+	// bob was never registered
+	res = d.KeyDelta(&protocol.KeyDeltaRequest{Username: bob, StartEpoch: 1, EndEpoch: 4})
+	delta, err = cc.VerifyKeyDelta(res, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delta.Changed || delta.StartKey != nil || delta.EndKey != nil {
+		t.Error("Expect no binding", delta)
+	}
+}
+
+func TestVerifyKeyDeltaTampered(t *testing.T) {
+	d, cc := newTestKeyHistory(t)
+
+	// hide the change by presenting the start auth path twice
+	res := d.KeyDelta(&protocol.KeyDeltaRequest{Username: alice, StartEpoch: 2, EndEpoch: 4})
+	df := res.DirectoryResponse.(*protocol.DirectoryProof)
+	df.AP[1] = df.AP[0]
+	if _, err := cc.VerifyKeyDelta(res, alice); err == nil {
+		t.Error("Expect a tampered delta proof to be rejected")
+	}
+
+	// drop an intermediate STR
+	res = d.KeyDelta(&protocol.KeyDeltaRequest{Username: alice, StartEpoch: 2, EndEpoch: 4})
+	df = res.DirectoryResponse.(*protocol.DirectoryProof)
+	df.STR = append(df.STR[:1], df.STR[2:]...)
+	if _, err := cc.VerifyKeyDelta(res, alice); err != protocol.ErrMalformedMessage {
+		t.Error("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+}
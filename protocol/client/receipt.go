@@ -0,0 +1,119 @@
+// Implements audit receipts, which allow a CONIKS client to archive
+// the result of a successful equivocation check against an auditor.
+
+package client
+
+import (
+	"encoding/json"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// An AuditReceipt records that at the given Epoch, a CONIKS client and
+// the auditor identified by Auditor (e.g. its address) agreed on the STR
+// of the directory identified by DirInitSTRHash.
+//
+// The STR is authenticated by the directory's signature. Auditors don't
+// sign their responses, so the recording client signs the whole receipt
+// (see Serialize()), which binds Auditor and DirInitSTRHash to the STR.
+// A receipt can be checked offline against the directory's initial STR,
+// the directory's public signing key and the client's public key (see
+// VerifyAuditReceipt()).
+type AuditReceipt struct {
+	DirInitSTRHash [crypto.HashSizeByte]byte
+	Auditor        string
+	Epoch          uint64
+	STR            *protocol.DirSTR
+	Signature      []byte
+}
+
+// receiptPrefix separates the client's signatures on audit receipts
+// from any other signature under the client's key.
+var receiptPrefix = []byte("coniks-audit-receipt")
+
+// Serialize serializes the receipt r, except for its signature, into
+// the message the recording client signs. r.STR must not be nil.
+func (r *AuditReceipt) Serialize() []byte {
+	var bs []byte
+	bs = append(bs, receiptPrefix...)
+	bs = append(bs, r.DirInitSTRHash[:]...)
+	bs = append(bs, utils.ULongToBytes(uint64(len(r.Auditor)))...)
+	bs = append(bs, r.Auditor...)
+	bs = append(bs, utils.ULongToBytes(r.Epoch)...)
+	ser := r.STR.Serialize()
+	bs = append(bs, utils.ULongToBytes(uint64(len(ser)))...)
+	bs = append(bs, ser...)
+	bs = append(bs, r.STR.Signature...)
+	return bs
+}
+
+// AuditReceipt checks the auditor's response audResp to the
+// AuditingRequest req for equivocation (see CheckEquivocation()), and
+// returns a serialized AuditReceipt for the most recent STR in audResp,
+// signed by signer, if the check passes.
+// auditor identifies the auditor that returned audResp.
+// AuditReceipt() returns the error returned by CheckEquivocation()
+// if the check fails, or the signer's error if the receipt couldn't
+// be signed.
+func (cc *ConsistencyChecks) AuditReceipt(auditor string,
+	req *protocol.AuditingRequest, audResp *protocol.Response,
+	signer sign.Signer) ([]byte, error) {
+	if err := cc.CheckEquivocation(audResp); err != nil {
+		return nil, err
+	}
+	strs := audResp.DirectoryResponse.(*protocol.STRHistoryRange).STR
+	str := strs[len(strs)-1]
+	r := &AuditReceipt{
+		DirInitSTRHash: req.DirInitSTRHash,
+		Auditor:        auditor,
+		Epoch:          str.Epoch,
+		STR:            str,
+	}
+	sig, err := signer.Sign(r.Serialize())
+	if err != nil {
+		return nil, err
+	}
+	r.Signature = sig
+	return json.Marshal(r)
+}
+
+// VerifyAuditReceipt parses the given serialized receipt, and verifies
+// that it was recorded for the directory whose initial STR is initSTR,
+// that the receipt is signed under receiptKey, the public key of the
+// recording client, and that the receipt's STR is signed under signKey,
+// the directory's public signing key.
+// VerifyAuditReceipt() returns the parsed AuditReceipt if the checks
+// pass, ErrMalformedMessage if the receipt can't be parsed, its epoch
+// doesn't match its STR's epoch or initSTR is malformed, CheckBadSTR
+// if the receipt is for another directory, or CheckBadSignature if
+// initSTR's, the receipt's or the STR's signature is invalid.
+func VerifyAuditReceipt(receipt []byte, initSTR *protocol.DirSTR,
+	signKey, receiptKey sign.PublicKey) (*AuditReceipt, error) {
+	if err := protocol.ValidateGenesisSTR(initSTR, signKey); err != nil {
+		return nil, err
+	}
+	r := new(AuditReceipt)
+	if err := json.Unmarshal(receipt, r); err != nil {
+		return nil, protocol.ErrMalformedMessage
+	}
+	if err := protocol.NewSTRHistoryRange([]*protocol.DirSTR{r.STR}).Validate(); err != nil {
+		return nil, err
+	}
+	if r.Epoch != r.STR.Epoch {
+		return nil, protocol.ErrMalformedMessage
+	}
+	if auditor.ComputeDirectoryIdentity(initSTR) != r.DirInitSTRHash {
+		return nil, protocol.CheckBadSTR
+	}
+	if !receiptKey.Verify(r.Serialize(), r.Signature) {
+		return nil, protocol.CheckBadSignature
+	}
+	if !signKey.Verify(r.STR.Serialize(), r.STR.Signature) {
+		return nil, protocol.CheckBadSignature
+	}
+	return r, nil
+}
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+type failingSigner struct{}
+
+func (failingSigner) Sign([]byte) ([]byte, error) {
+	return nil, errors.New("signer unreachable")
+}
+
+func newTestReceipt(t *testing.T) (receipt []byte, initSTR, str *protocol.DirSTR,
+	receiptKey sign.PublicKey) {
+	d, cc := newTestClient(t)
+	initSTR = d.LatestSTR()
+	d.Update()
+
+	sk, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiptKey, _ = sk.Public()
+	req := &protocol.AuditingRequest{
+		DirInitSTRHash: auditor.ComputeDirectoryIdentity(initSTR),
+		StartEpoch:     0,
+		EndEpoch:       1}
+	res := d.GetSTRHistory(&protocol.STRHistoryRequest{StartEpoch: 0, EndEpoch: 1})
+	receipt, err = cc.AuditReceipt("test-auditor", req, res, sign.KeySigner(sk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return receipt, initSTR, d.LatestSTR(), receiptKey
+}
+
+func TestAuditReceiptRoundTrip(t *testing.T) {
+	receipt, initSTR, str, receiptKey := newTestReceipt(t)
+	pk, _ := staticSigningKey.Public()
+
+	r, err := VerifyAuditReceipt(receipt, initSTR, pk, receiptKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Auditor != "test-auditor" || r.Epoch != 1 {
+		t.Error("Unexpected receipt", r.Auditor, r.Epoch)
+	}
+	if !bytes.Equal(r.STR.Signature, str.Signature) {
+		t.Error("Expect the receipt to contain the agreed STR")
+	}
+}
+
+func TestAuditReceiptTampered(t *testing.T) {
+	receipt, initSTR, _, receiptKey := newTestReceipt(t)
+	pk, _ := staticSigningKey.Public()
+
+	tamper := func(f func(r *AuditReceipt)) []byte {
+		r := new(AuditReceipt)
+		if err := json.Unmarshal(receipt, r); err != nil {
+			t.Fatal(err)
+		}
+		f(r)
+		bs, _ := json.Marshal(r)
+		return bs
+	}
+
+	for _, tc := range []struct {
+		name    string
+		receipt []byte
+		want    error
+	}{
+		{"garbage", []byte("{"), protocol.ErrMalformedMessage},
+		{"no STR", tamper(func(r *AuditReceipt) { r.STR = nil }), protocol.ErrMalformedMessage},
+		{"epoch", tamper(func(r *AuditReceipt) { r.Epoch = 0 }), protocol.ErrMalformedMessage},
+		{"STR epoch", tamper(func(r *AuditReceipt) {
+			r.Epoch++
+			r.STR.Epoch++
+		}), protocol.CheckBadSignature},
+		{"tree hash", tamper(func(r *AuditReceipt) { r.STR.TreeHash[0] ^= 1 }), protocol.CheckBadSignature},
+		{"policies", tamper(func(r *AuditReceipt) { r.STR.Policies.EpochDeadline++ }), protocol.CheckBadSignature},
+		{"auditor", tamper(func(r *AuditReceipt) { r.Auditor = "other-auditor" }), protocol.CheckBadSignature},
+		{"directory", tamper(func(r *AuditReceipt) { r.DirInitSTRHash[0] ^= 1 }), protocol.CheckBadSTR},
+		{"signature", tamper(func(r *AuditReceipt) { r.Signature = nil }), protocol.CheckBadSignature},
+	} {
+		if _, err := VerifyAuditReceipt(tc.receipt, initSTR, pk, receiptKey); err != tc.want {
+			t.Error(tc.name, "- Expect", tc.want, "got", err)
+		}
+	}
+}
+
+func TestAuditReceiptWrongKeys(t *testing.T) {
+	receipt, initSTR, _, receiptKey := newTestReceipt(t)
+	pk, _ := staticSigningKey.Public()
+
+	// a receipt recorded by another client
+	if _, err := VerifyAuditReceipt(receipt, initSTR, pk, pk); err != protocol.CheckBadSignature {
+		t.Error("Expect", protocol.CheckBadSignature, "got", err)
+	}
+	// a receipt checked against another directory
+	other := directory.NewNamedTestDirectory(t, "other").LatestSTR()
+	if _, err := VerifyAuditReceipt(receipt, other, pk, receiptKey); err != protocol.CheckBadSTR {
+		t.Error("Expect", protocol.CheckBadSTR, "got", err)
+	}
+	if _, err := VerifyAuditReceipt(receipt, nil, pk, receiptKey); err != protocol.ErrMalformedMessage {
+		t.Error("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+}
+
+func TestAuditReceiptEquivocation(t *testing.T) {
+	_, fork, cc := newTestFork(t)
+	req := &protocol.AuditingRequest{StartEpoch: 0, EndEpoch: 1}
+	sk, _ := sign.GenerateKey(nil)
+	if _, err := cc.AuditReceipt("test-auditor", req, getSTRHistory(fork), sign.KeySigner(sk)); err == nil {
+		t.Fatal("Expect no receipt for an equivocating auditor")
+	}
+}
+
+func TestAuditReceiptSignerError(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.Update()
+	req := &protocol.AuditingRequest{StartEpoch: 0, EndEpoch: 1}
+	res := d.GetSTRHistory(&protocol.STRHistoryRequest{StartEpoch: 0, EndEpoch: 1})
+	if _, err := cc.AuditReceipt("test-auditor", req, res, failingSigner{}); err == nil {
+		t.Fatal("Expect the signer's error")
+	}
+}
@@ -0,0 +1,42 @@
+// Defines the result of a successful verification of a directory's
+// response, so that callers don't have to re-inspect the response.
+
+package client
+
+import (
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// A VerificationResult describes the binding which a CONIKS client
+// verified in a directory's response (see HandleResponseResult()).
+// Epoch is the epoch of the STR the proof was verified against.
+// Value is the key bound to the username, or nil if the username isn't
+// registered. IsTemporaryBinding is set if Value comes from the
+// directory's promise to include the binding in the next snapshot
+// (a TB), rather than from a binding included in STR's tree.
+type VerificationResult struct {
+	Epoch              uint64
+	Value              []byte
+	IsTemporaryBinding bool
+	STR                *protocol.DirSTR
+}
+
+// newVerificationResult returns the VerificationResult for the verified
+// directory proof df.
+func newVerificationResult(df *protocol.DirectoryProof) *VerificationResult {
+	ap := df.AP[0]
+	str := df.STR[0]
+	r := &VerificationResult{
+		Epoch: str.Epoch,
+		STR:   str,
+	}
+	switch {
+	case ap.ProofType() == merkletree.ProofOfInclusion:
+		r.Value = ap.Leaf.Value
+	case df.TB != nil:
+		r.Value = df.TB.Value
+		r.IsTemporaryBinding = true
+	}
+	return r
+}
@@ -0,0 +1,68 @@
+// Implements the rollback check a CONIKS client performs after it
+// reconnects to a directory.
+
+package client
+
+import (
+	"bytes"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+// CheckRollback checks that the range of STRs in msg, e.g. the
+// directory's response to a protocol.STRHistoryRequest sent after the
+// client reconnects, is a forward continuation of the cc.verifiedSTR,
+// i.e. the STR pinned by the client before it went offline.
+// The range must include the pinned epoch or the epoch directly
+// following it; any STRs for earlier epochs are ignored. This
+// complements checking the freshness of the directory's STRs: a fresh
+// STR may still belong to a history which doesn't include the pinned STR.
+//
+// CheckRollback() returns a *RollbackError if the range's most recent
+// STR (its tip) is older than the pinned STR, or if the range forks from
+// the pinned STR. It returns auditor.ErrRangeGap if the range starts
+// after the epoch following the pinned epoch, and otherwise the
+// appropriate consistency check error if the range doesn't verify
+// (see auditor.AudState.AuditDirectory()). If the checks pass,
+// CheckRollback() adopts the tip as the cc.verifiedSTR.
+func (cc *ConsistencyChecks) CheckRollback(msg *protocol.Response) error {
+	if err := msg.ValidateFor(protocol.STRType); err != nil {
+		return err
+	}
+	strs := msg.DirectoryResponse.(*protocol.STRHistoryRange).STR
+	pinned := cc.VerifiedSTR()
+	tip := strs[len(strs)-1]
+	if tip.Epoch < pinned.Epoch {
+		return &RollbackError{PinnedEpoch: pinned.Epoch, TipEpoch: tip.Epoch}
+	}
+	for strs[0].Epoch < pinned.Epoch {
+		strs = strs[1:]
+	}
+
+	first := strs[0]
+	if first.Epoch > pinned.Epoch+1 {
+		return auditor.ErrRangeGap
+	}
+	// only report a fork for STRs actually signed by the directory
+	if err := cc.VerifySTR(first); err != nil {
+		return err
+	}
+	var forked bool
+	if first.Epoch == pinned.Epoch {
+		forked = !bytes.Equal(first.Signature, pinned.Signature) ||
+			!bytes.Equal(first.Serialize(), pinned.Serialize())
+	} else {
+		forked = !first.VerifyHashChain(pinned)
+	}
+	if forked {
+		return &RollbackError{PinnedEpoch: pinned.Epoch, TipEpoch: tip.Epoch,
+			Forked: true}
+	}
+
+	if err := cc.AuditDirectory(strs); err != nil {
+		return err
+	}
+	cc.Update(tip)
+	return nil
+}
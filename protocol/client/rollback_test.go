@@ -0,0 +1,145 @@
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+// newTestPinnedClient returns a client which pinned the STR of
+// d for epoch 2 before going offline.
+func newTestPinnedClient(t *testing.T) (*directory.ConiksDirectory, *ConsistencyChecks) {
+	d, cc := newTestClient(t)
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	for i := 0; i < 2; i++ {
+		d.Update()
+		res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+		if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return d, cc
+}
+
+func TestCheckRollbackForward(t *testing.T) {
+	d, cc := newTestPinnedClient(t)
+	d.Update()
+	d.Update()
+
+	// a range starting at the pinned epoch
+	res := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 2,
+		EndEpoch:   3})
+	if err := cc.CheckRollback(res); err != nil {
+		t.Fatal(err)
+	}
+	if cc.VerifiedSTR().Epoch != 3 {
+		t.Fatal("Expect the tip to be adopted, got epoch", cc.VerifiedSTR().Epoch)
+	}
+
+	// the entire history, starting before the pinned epoch
+	if err := cc.CheckRollback(getSTRHistory(d)); err != nil {
+		t.Fatal(err)
+	}
+	if cc.VerifiedSTR().Epoch != 4 {
+		t.Fatal("Expect the tip to be adopted, got epoch", cc.VerifiedSTR().Epoch)
+	}
+}
+
+func TestCheckRollbackEarlierTip(t *testing.T) {
+	d, cc := newTestPinnedClient(t)
+
+	res := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 0,
+		EndEpoch:   1})
+	err := cc.CheckRollback(res)
+	e, ok := err.(*RollbackError)
+	if !ok {
+		t.Fatal("Expect a RollbackError, got", err)
+	}
+	if e.Forked || e.PinnedEpoch != 2 || e.TipEpoch != 1 {
+		t.Fatal("Unexpected rollback error", e)
+	}
+	if !errors.Is(err, auditor.ErrRollback) {
+		t.Fatal("Expect the RollbackError to wrap", auditor.ErrRollback)
+	}
+	if cc.VerifiedSTR().Epoch != 2 {
+		t.Fatal("Expect the pinned STR to be unchanged")
+	}
+}
+
+func TestCheckRollbackForkedTip(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		start uint64
+	}{
+		{"range from pinned epoch", 2},
+		{"range after pinned epoch", 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, cc := newTestPinnedClient(t)
+			// the fork diverges at the pinned epoch
+			fork := d.ForkAt(t, 1)
+			fork.Register(&protocol.RegistrationRequest{Username: bob, Key: key})
+			for fork.LatestSTR().Epoch < 4 {
+				fork.Update()
+			}
+
+			res := fork.GetSTRHistory(&protocol.STRHistoryRequest{
+				StartEpoch: tc.start,
+				EndEpoch:   4})
+			err := cc.CheckRollback(res)
+			e, ok := err.(*RollbackError)
+			if !ok {
+				t.Fatal("Expect a RollbackError, got", err)
+			}
+			if !e.Forked || e.PinnedEpoch != 2 || e.TipEpoch != 4 {
+				t.Fatal("Unexpected rollback error", e)
+			}
+			if !errors.Is(err, protocol.CheckBadSTR) {
+				t.Fatal("Expect the RollbackError to wrap", protocol.CheckBadSTR)
+			}
+			if cc.VerifiedSTR().Epoch != 2 {
+				t.Fatal("Expect the pinned STR to be unchanged")
+			}
+		})
+	}
+}
+
+func TestCheckRollbackGap(t *testing.T) {
+	d, cc := newTestPinnedClient(t)
+	for i := 0; i < 3; i++ {
+		d.Update()
+	}
+	res := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 4,
+		EndEpoch:   5})
+	if err := cc.CheckRollback(res); err != auditor.ErrRangeGap {
+		t.Fatal("Expect", auditor.ErrRangeGap, "got", err)
+	}
+}
+
+func TestCatchUpFromDirectory(t *testing.T) {
+	d, cc := newTestPinnedClient(t)
+	for i := 0; i < 5; i++ {
+		d.Update()
+	}
+
+	// a client without an auditor pulls the STRs since its pinned epoch
+	res := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: cc.VerifiedSTR().Epoch,
+		EndEpoch:   d.LatestSTR().Epoch})
+	if err := cc.CheckRollback(res); err != nil {
+		t.Fatal(err)
+	}
+	if cc.VerifiedSTR().Epoch != d.LatestSTR().Epoch {
+		t.Fatal("Expect the client to catch up with the directory")
+	}
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,133 @@
+// Implements a lightweight audit of a directory's STR history, in which
+// a client only verifies a random sample of past epochs as observed by
+// a CONIKS auditor.
+
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"math/big"
+	"sort"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+// An AuditorClient fetches the STRs which a CONIKS auditor observed for
+// the directory a client audits, e.g. by sending an AuditingRequest for
+// the directory's identity to a remote auditor (see
+// auditlog.ConiksAuditLog.GetObservedSTRs()).
+type AuditorClient interface {
+	GetObservedSTRs(startEpoch, endEpoch uint64) *protocol.Response
+}
+
+// A SampleReport describes the coverage of a SampleAudit(): Epochs are
+// the sampled epochs in increasing order, out of the RangeSize epochs
+// from epoch 1 up to the cc.verifiedSTR's epoch.
+type SampleReport struct {
+	Epochs    []uint64
+	RangeSize uint64
+}
+
+// Coverage returns the fraction of the range which was sampled,
+// or 1 if the range is empty.
+func (r *SampleReport) Coverage() float64 {
+	if r.RangeSize == 0 {
+		return 1
+	}
+	return float64(len(r.Epochs)) / float64(r.RangeSize)
+}
+
+// SampleAudit verifies k epochs picked at random from the directory's
+// history up to the cc.verifiedSTR, as observed by the auditor aud,
+// rather than the entire history. For each sampled epoch e, it fetches
+// the STRs for e-1 and e from aud, and verifies their signatures, that
+// the STR for e chains to the STR for e-1 and consistently changes the
+// directory's policies, and, if e is the cc.verifiedSTR's epoch, that
+// the auditor's STR is the cc.verifiedSTR. If k is at least the size
+// of the range, every epoch is verified.
+//
+// Sampling trades security for cost: a fork which the auditor's view
+// of the history reveals only at a single epoch (e.g. an STR spliced
+// into the history) is detected with probability k/n per call, where
+// n is the RangeSize of the returned SampleReport, so that repeated
+// calls detect it with increasing probability. SampleAudit() doesn't
+// detect a fork which is consistent at every epoch, e.g. one the
+// directory presented to both the client and the auditor; only
+// CheckEquivocation() against independent auditors does.
+//
+// SampleAudit() returns the SampleReport and an *auditor.SnapshotError
+// reporting the first sampled epoch which fails verification, or
+// ErrMalformedMessage if aud's response for an epoch doesn't consist of
+// the requested STRs.
+func (cc *ConsistencyChecks) SampleAudit(aud AuditorClient, k int) (*SampleReport, error) {
+	verified := cc.VerifiedSTR()
+	r := &SampleReport{RangeSize: verified.Epoch}
+	epochs, err := sampleEpochs(r.RangeSize, k)
+	if err != nil {
+		return r, err
+	}
+	r.Epochs = epochs
+	for _, ep := range epochs {
+		if err := cc.verifySample(aud, ep); err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+// verifySample fetches the STRs for the epochs ep-1 and ep from aud
+// and verifies them as described in SampleAudit().
+func (cc *ConsistencyChecks) verifySample(aud AuditorClient, ep uint64) error {
+	res := aud.GetObservedSTRs(ep-1, ep)
+	if err := res.ValidateFor(protocol.AuditType); err != nil {
+		return err
+	}
+	strs := res.DirectoryResponse.(*protocol.STRHistoryRange).STR
+	if len(strs) != 2 || strs[0].Epoch != ep-1 || strs[1].Epoch != ep {
+		return protocol.ErrMalformedMessage
+	}
+	if err := cc.VerifySTR(strs[0]); err != nil {
+		return &auditor.SnapshotError{Epoch: ep - 1, Err: err}
+	}
+	if err := cc.VerifySTRRange(strs[0], strs[1:]); err != nil {
+		return &auditor.SnapshotError{Epoch: ep, Err: err}
+	}
+	if verified := cc.VerifiedSTR(); ep == verified.Epoch &&
+		(!bytes.Equal(strs[1].Signature, verified.Signature) ||
+			!bytes.Equal(strs[1].Serialize(), verified.Serialize())) {
+		return &auditor.SnapshotError{Epoch: ep, Err: protocol.CheckBadSTR}
+	}
+	return nil
+}
+
+// sampleEpochs returns min(k, n) distinct epochs picked uniformly at
+// random from [1, n], in increasing order.
+func sampleEpochs(n uint64, k int) ([]uint64, error) {
+	if k <= 0 {
+		return nil, nil
+	}
+	if uint64(k) >= n {
+		epochs := make([]uint64, 0, n)
+		for ep := uint64(1); ep <= n; ep++ {
+			epochs = append(epochs, ep)
+		}
+		return epochs, nil
+	}
+	picked := make(map[uint64]bool, k)
+	epochs := make([]uint64, 0, k)
+	max := new(big.Int).SetUint64(n)
+	for len(epochs) < k {
+		i, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return nil, err
+		}
+		if ep := i.Uint64() + 1; !picked[ep] {
+			picked[ep] = true
+			epochs = append(epochs, ep)
+		}
+	}
+	sort.Slice(epochs, func(i, j int) bool { return epochs[i] < epochs[j] })
+	return epochs, nil
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+// staticAuditor serves a fixed list of STRs, indexed by epoch.
+type staticAuditor []*protocol.DirSTR
+
+func (a staticAuditor) GetObservedSTRs(start, end uint64) *protocol.Response {
+	return protocol.NewSTRHistoryRange(a[start : end+1])
+}
+
+func newSampledHistory(t *testing.T, n int) (*directory.ConiksDirectory,
+	*ConsistencyChecks, staticAuditor) {
+	d := directory.NewTestDirectory(t)
+	for i := 0; i < n; i++ {
+		d.Update()
+	}
+	res := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 0, EndEpoch: d.LatestSTR().Epoch})
+	strs := res.DirectoryResponse.(*protocol.STRHistoryRange).STR
+	pk, _ := staticSigningKey.Public()
+	return d, New(d.LatestSTR(), true, pk), strs
+}
+
+func TestSampleAudit(t *testing.T) {
+	_, cc, aud := newSampledHistory(t, 8)
+	r, err := cc.SampleAudit(aud, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.RangeSize != 8 || len(r.Epochs) != 3 || r.Coverage() != 3.0/8 {
+		t.Fatal("Unexpected coverage", r)
+	}
+	for i, ep := range r.Epochs {
+		if ep < 1 || ep > 8 || i > 0 && ep <= r.Epochs[i-1] {
+			t.Fatal("Expect distinct sorted epochs in [1, 8], got", r.Epochs)
+		}
+	}
+
+	r, err = cc.SampleAudit(aud, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Epochs) != 8 || r.Coverage() != 1 {
+		t.Error("Expect the entire range to be verified, got", r)
+	}
+}
+
+func TestSampleAuditPlantedFork(t *testing.T) {
+	d, cc, aud := newSampledHistory(t, 8)
+
+	// the auditor's view includes an STR of a fork at epoch 5
+	fork := d.ForkAt(t, 4)
+	fork.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	fork.Update()
+	aud[5] = fork.LatestSTR()
+
+	r, err := cc.SampleAudit(aud, 8)
+	var e *auditor.SnapshotError
+	if !errors.As(err, &e) || e.Epoch != 6 || !errors.Is(err, protocol.CheckBadSTR) {
+		t.Fatal("Expect the fork to be detected at epoch 6, got", err)
+	}
+	if r.Coverage() != 1 {
+		t.Error("Expect the entire range to be sampled, got", r)
+	}
+}
+
+func TestSampleAuditDivergentTip(t *testing.T) {
+	d, cc, aud := newSampledHistory(t, 3)
+	fork := d.ForkAt(t, 2)
+	fork.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	fork.Update()
+	aud[3] = fork.LatestSTR()
+
+	_, err := cc.SampleAudit(aud, 3)
+	var e *auditor.SnapshotError
+	if !errors.As(err, &e) || e.Epoch != 3 || !errors.Is(err, protocol.CheckBadSTR) {
+		t.Fatal("Expect the divergent verified STR to be detected, got", err)
+	}
+}
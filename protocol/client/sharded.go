@@ -0,0 +1,130 @@
+// Implements the consistency checks a CONIKS client performs
+// on the responses of a sharded CONIKS directory.
+
+package client
+
+import (
+	"bytes"
+
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+// ShardedConsistencyChecks stores the consistency state of a client
+// of a sharded CONIKS directory (see directory.ShardedDirectory).
+// It includes the latest verified protocol.ShardRoot of the directory,
+// and a ConsistencyChecks for each of the directory's shards.
+type ShardedConsistencyChecks struct {
+	root    *protocol.ShardRoot
+	shards  []*ConsistencyChecks
+	signKey sign.PublicKey
+}
+
+// NewSharded creates an instance of ShardedConsistencyChecks from the
+// sharded directory's pinned ShardRoot savedRoot and the shard STRs
+// savedSTRs it commits to, in shard order.
+// NewSharded() returns CheckBadSignature if savedRoot isn't signed under
+// signKey, ErrMalformedMessage if savedRoot doesn't commit to as many
+// STRs as there are in savedSTRs, and CheckBadSTR if it commits to
+// different ones.
+func NewSharded(savedRoot *protocol.ShardRoot, savedSTRs []*protocol.DirSTR,
+	useTBs bool, signKey sign.PublicKey) (*ShardedConsistencyChecks, error) {
+	if !signKey.Verify(savedRoot.Serialize(), savedRoot.Signature) {
+		return nil, protocol.CheckBadSignature
+	}
+	if len(savedSTRs) != len(savedRoot.ShardSTRs) ||
+		!protocol.ValidShardCount(len(savedSTRs)) {
+		return nil, protocol.ErrMalformedMessage
+	}
+	scc := &ShardedConsistencyChecks{
+		root:    savedRoot,
+		shards:  make([]*ConsistencyChecks, len(savedSTRs)),
+		signKey: signKey,
+	}
+	for i, str := range savedSTRs {
+		if !bytes.Equal(protocol.ShardSTRHash(str), savedRoot.ShardSTRs[i]) {
+			return nil, protocol.CheckBadSTR
+		}
+		scc.shards[i] = New(str, useTBs, signKey)
+	}
+	return scc, nil
+}
+
+// VerifiedRoot returns the latest verified ShardRoot of the directory.
+func (scc *ShardedConsistencyChecks) VerifiedRoot() *protocol.ShardRoot {
+	return scc.root
+}
+
+// Shard returns the consistency state of the directory's shard
+// with the given index.
+func (scc *ShardedConsistencyChecks) Shard(i int) *ConsistencyChecks {
+	return scc.shards[i]
+}
+
+// HandleResponse verifies the sharded directory's response for a
+// RegistrationRequest or KeyLookupRequest (see
+// ConsistencyChecks.HandleResponse()).
+// HandleResponse() first verifies the ShardRoot in the response against
+// the scc.root, and that the root commits to the STR in the shard's
+// proof. It then checks that the proof's VRF index is valid for uname,
+// and that uname belongs to the shard that returned the proof, before
+// passing the shard's proof on to the shard's ConsistencyChecks.
+// HandleResponse() returns CheckWrongShard if uname doesn't belong to
+// the shard that returned the proof.
+func (scc *ShardedConsistencyChecks) HandleResponse(requestType int,
+	msg *protocol.Response, uname string, key []byte) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	df, ok := msg.DirectoryResponse.(*protocol.ShardedDirectoryProof)
+	if !ok {
+		return protocol.ErrMalformedMessage
+	}
+	if err := scc.verifyRoot(df.Root); err != nil {
+		return err
+	}
+
+	proof := df.Proof.DirectoryResponse.(*protocol.DirectoryProof)
+	ap := proof.AP[0]
+	str := proof.STR[0]
+	if !bytes.Equal(protocol.ShardSTRHash(str), df.Root.ShardSTRs[df.Shard]) {
+		return protocol.CheckBadSTR
+	}
+
+	// make sure the name was looked up in the right shard
+	if !str.Policies.VrfPublicKey.Verify([]byte(uname), ap.LookupIndex, ap.VrfProof) {
+		return protocol.CheckBadVRFProof
+	}
+	if protocol.ShardIndex(ap.LookupIndex, len(scc.shards)) != df.Shard {
+		return protocol.CheckWrongShard
+	}
+
+	if err := scc.shards[df.Shard].HandleResponse(requestType, df.Proof, uname, key); err != nil {
+		return err
+	}
+	scc.root = df.Root
+	return nil
+}
+
+// verifyRoot checks the signature on root, and that root is consistent
+// with the scc.root, i.e. that it commits to the same number of shards,
+// is not older than scc.root, and is identical to it if both are for
+// the same epoch.
+func (scc *ShardedConsistencyChecks) verifyRoot(root *protocol.ShardRoot) error {
+	if !scc.signKey.Verify(root.Serialize(), root.Signature) {
+		return protocol.CheckBadSignature
+	}
+	if len(root.ShardSTRs) != len(scc.shards) {
+		return protocol.ErrMalformedMessage
+	}
+	switch {
+	case root.Epoch < scc.root.Epoch:
+		return auditor.ErrRollback
+	case root.Epoch == scc.root.Epoch:
+		if !bytes.Equal(root.Signature, scc.root.Signature) {
+			return protocol.CheckBadSTR
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+func newTestShardedClient(t *testing.T, numShards int) (*directory.ShardedDirectory,
+	*ShardedConsistencyChecks) {
+	sd := directory.NewTestShardedDirectory(t, numShards)
+	pk, _ := staticSigningKey.Public()
+	scc, err := NewSharded(sd.LatestRoot(), sd.LatestShardSTRs(), true, pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sd, scc
+}
+
+// namesInShards returns a username for each of sd's shards.
+func namesInShards(sd *directory.ShardedDirectory) []string {
+	names := make([]string, sd.NumShards())
+	found := 0
+	for i := 0; found < len(names); i++ {
+		name := fmt.Sprintf("user%d", i)
+		if s := sd.ShardFor(name); names[s] == "" {
+			names[s] = name
+			found++
+		}
+	}
+	return names
+}
+
+func TestShardedLookupsVerify(t *testing.T) {
+	sd, scc := newTestShardedClient(t, 4)
+	names := namesInShards(sd)
+
+	for _, name := range names {
+		res := sd.Register(&protocol.RegistrationRequest{Username: name, Key: key})
+		if err := scc.HandleResponse(protocol.RegistrationType, res, name, key); err != nil {
+			t.Fatal(name, err)
+		}
+	}
+	sd.Update()
+	for _, name := range names {
+		res := sd.KeyLookup(&protocol.KeyLookupRequest{Username: name})
+		if err := scc.HandleResponse(protocol.KeyLookupType, res, name, key); err != nil {
+			t.Fatal(name, err)
+		}
+	}
+	if scc.VerifiedRoot().Epoch != 1 {
+		t.Fatal("Expect the verified root to be updated")
+	}
+	for i := range names {
+		if scc.Shard(i).VerifiedSTR().Epoch != 1 {
+			t.Error("Expect shard", i, "to be verified at epoch 1")
+		}
+	}
+}
+
+func TestShardedLookupMisrouted(t *testing.T) {
+	sd, scc := newTestShardedClient(t, 4)
+	names := namesInShards(sd)
+	alice := names[0]
+	sd.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	sd.Update()
+
+	// shard 1 returns a proof of absence for alice
+	lookup := &protocol.KeyLookupRequest{Username: alice}
+	res := protocol.NewShardedProof(1, sd.LatestRoot(), sd.Shard(1).KeyLookup(lookup))
+	if err := scc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != protocol.CheckWrongShard {
+		t.Fatal("Expect", protocol.CheckWrongShard, "got", err)
+	}
+
+	// shard 0's proof labeled as shard 1's
+	res = protocol.NewShardedProof(1, sd.LatestRoot(), sd.Shard(0).KeyLookup(lookup))
+	if err := scc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+
+	// the correctly routed proof still verifies
+	res = sd.KeyLookup(lookup)
+	if err := scc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShardedBadRoot(t *testing.T) {
+	sd, scc := newTestShardedClient(t, 2)
+	name := namesInShards(sd)[0]
+
+	res := sd.KeyLookup(&protocol.KeyLookupRequest{Username: name})
+	df := res.DirectoryResponse.(*protocol.ShardedDirectoryProof)
+	root := *df.Root
+	root.Epoch++
+	df.Root = &root
+	if err := scc.HandleResponse(protocol.KeyLookupType, res, name, nil); err != protocol.CheckBadSignature {
+		t.Fatal("Expect", protocol.CheckBadSignature, "got", err)
+	}
+
+	pk, _ := staticSigningKey.Public()
+	if _, err := NewSharded(sd.LatestRoot(), sd.LatestShardSTRs()[:1], true, pk); err != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+	strs := sd.LatestShardSTRs()
+	strs[0], strs[1] = strs[1], strs[0]
+	if _, err := NewSharded(sd.LatestRoot(), strs, true, pk); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+}
@@ -0,0 +1,80 @@
+// Implements serializing the consistency checks of a CONIKS client
+// which verifies responses from several goroutines.
+
+package client
+
+import (
+	"sync"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// A SyncConsistencyChecks wraps the consistency state of a CONIKS client
+// (see ConsistencyChecks) for clients which verify the directory's
+// responses from several goroutines, e.g. to run lookups concurrently.
+// Unlike a ConsistencyChecks, a SyncConsistencyChecks is safe for
+// concurrent use; its methods are serialized, so that concurrent
+// verifications can't corrupt the pinned STR or the per-user state.
+type SyncConsistencyChecks struct {
+	mu sync.Mutex
+	cc *ConsistencyChecks
+}
+
+// NewSync constructs a SyncConsistencyChecks which takes ownership of
+// the consistency state cc. cc must not be used directly anymore.
+func NewSync(cc *ConsistencyChecks) *SyncConsistencyChecks {
+	return &SyncConsistencyChecks{cc: cc}
+}
+
+// HandleResponse verifies the directory's response for a request as
+// ConsistencyChecks.HandleResponse() does.
+func (s *SyncConsistencyChecks) HandleResponse(requestType int, msg *protocol.Response,
+	uname string, key []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cc.HandleResponse(requestType, msg, uname, key)
+}
+
+// HandleResponseResult verifies the directory's response for a request
+// as ConsistencyChecks.HandleResponseResult() does.
+func (s *SyncConsistencyChecks) HandleResponseResult(requestType int, msg *protocol.Response,
+	uname string, key []byte) (*VerificationResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cc.HandleResponseResult(requestType, msg, uname, key)
+}
+
+// HandleBatchResponse verifies the directory's response for a batch
+// lookup as ConsistencyChecks.HandleBatchResponse() does.
+func (s *SyncConsistencyChecks) HandleBatchResponse(msg *protocol.Response,
+	unames []string, keys map[string][]byte) (map[string]error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cc.HandleBatchResponse(msg, unames, keys)
+}
+
+// CheckEquivocation verifies an auditor's response as
+// ConsistencyChecks.CheckEquivocation() does.
+func (s *SyncConsistencyChecks) CheckEquivocation(msg *protocol.Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cc.CheckEquivocation(msg)
+}
+
+// VerifiedSTR returns the client's latest verified STR.
+func (s *SyncConsistencyChecks) VerifiedSTR() *protocol.DirSTR {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cc.VerifiedSTR()
+}
+
+// Do calls f with the wrapped ConsistencyChecks, serialized with all
+// other calls on s, and returns the error f returns. This allows
+// performing any other operation of ConsistencyChecks, or several
+// operations atomically. f must not retain the ConsistencyChecks, nor
+// call any method of s.
+func (s *SyncConsistencyChecks) Do(f func(cc *ConsistencyChecks) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return f(s.cc)
+}
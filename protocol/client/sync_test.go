@@ -0,0 +1,80 @@
+package client
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+func TestSyncConsistencyChecks(t *testing.T) {
+	d, cc := newTestClient(t)
+	unames := []string{alice, bob, carol}
+	for _, uname := range unames {
+		d.Register(&protocol.RegistrationRequest{Username: uname, Key: key})
+	}
+	d.Update()
+	if err := cc.CheckEquivocation(getSTRHistory(d)); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+	s := NewSync(cc)
+
+	// the directory isn't safe for concurrent use,
+	// so the responses are fetched upfront
+	const rounds = 20
+	var lookups []*protocol.Response
+	var histories []*protocol.Response
+	for i := 0; i < rounds; i++ {
+		for _, uname := range unames {
+			lookups = append(lookups, d.KeyLookup(&protocol.KeyLookupRequest{Username: uname}))
+		}
+		histories = append(histories, getSTRHistory(d))
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(lookups)+2*len(histories))
+	for i, res := range lookups {
+		wg.Add(1)
+		go func(uname string, res *protocol.Response) {
+			defer wg.Done()
+			errs <- s.HandleResponse(protocol.KeyLookupType, res, uname, key)
+		}(unames[i%len(unames)], res)
+	}
+	for _, res := range histories {
+		wg.Add(2)
+		go func(res *protocol.Response) {
+			defer wg.Done()
+			errs <- s.CheckEquivocation(res)
+		}(res)
+		go func() {
+			defer wg.Done()
+			if s.VerifiedSTR() == nil {
+				errs <- protocol.ErrMalformedMessage
+				return
+			}
+			errs <- nil
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	if got, want := s.VerifiedSTR().Epoch, d.LatestSTR().Epoch; got != want {
+		t.Error("Expect the verified epoch", want, "got", got)
+	}
+	if err := s.Do(func(cc *ConsistencyChecks) error {
+		for _, uname := range unames {
+			if _, ok := cc.Bindings[uname]; !ok {
+				t.Error("Expect a verified binding for", uname)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
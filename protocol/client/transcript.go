@@ -0,0 +1,48 @@
+// Implements replaying a transcript of the responses a CONIKS client
+// received, e.g. to reproduce a reported inconsistency deterministically.
+
+package client
+
+import (
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// A TranscriptEntry records a Response a client received for a request
+// of the given RequestType: a registration or a key lookup for Username
+// and the expected Key sent to the directory (see HandleResponse()), or
+// an AuditingRequest sent to an auditor (see CheckEquivocation()), in
+// which case Username and Key are ignored.
+type TranscriptEntry struct {
+	RequestType int
+	Username    string
+	Key         []byte
+	Response    *protocol.Response
+}
+
+// ReplayTranscript replays the transcript entries, in order, through a
+// fresh ConsistencyChecks which pins the directory's initial STR
+// initialSTR and signing key pk, and returns the error with which the
+// client rejects each entry, or nil if it accepts the entry. Like a
+// live client, the replayed client keeps the consistency state updated
+// by earlier entries, so the results show the exact step at which a
+// client should have rejected a response.
+// An entry of any request type other than protocol.RegistrationType,
+// protocol.KeyLookupType and protocol.AuditType is rejected with
+// ErrMalformedMessage.
+func ReplayTranscript(initialSTR *protocol.DirSTR, pk sign.PublicKey,
+	entries []TranscriptEntry) []error {
+	cc := New(initialSTR, true, pk)
+	errs := make([]error, len(entries))
+	for i, e := range entries {
+		switch e.RequestType {
+		case protocol.RegistrationType, protocol.KeyLookupType:
+			errs[i] = cc.HandleResponse(e.RequestType, e.Response, e.Username, e.Key)
+		case protocol.AuditType:
+			errs[i] = cc.CheckEquivocation(e.Response)
+		default:
+			errs[i] = protocol.ErrMalformedMessage
+		}
+	}
+	return errs
+}
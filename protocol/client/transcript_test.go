@@ -0,0 +1,77 @@
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+func TestReplayTranscriptFork(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	fork := directory.NewTestDirectory(t)
+	initialSTR := d.LatestSTR()
+
+	// the same sequence as newTestFork(), followed by the responses of an
+	// honest auditor and of an auditor on the fork
+	var entries []TranscriptEntry
+	d.Update()
+	fork.Update()
+	entries = append(entries, TranscriptEntry{
+		RequestType: protocol.RegistrationType,
+		Username:    alice,
+		Key:         key,
+		Response:    d.Register(&protocol.RegistrationRequest{Username: alice, Key: key}),
+	})
+	fork.Register(&protocol.RegistrationRequest{Username: alice, Key: []byte("evil")})
+	d.Update()
+	fork.Update()
+	entries = append(entries, TranscriptEntry{
+		RequestType: protocol.KeyLookupType,
+		Username:    alice,
+		Key:         key,
+		Response:    d.KeyLookup(&protocol.KeyLookupRequest{Username: alice}),
+	}, TranscriptEntry{
+		RequestType: protocol.AuditType,
+		Response:    getSTRHistory(d),
+	}, TranscriptEntry{
+		RequestType: protocol.AuditType,
+		Response:    getSTRHistory(fork),
+	})
+
+	pk, _ := staticSigningKey.Public()
+	errs := ReplayTranscript(initialSTR, pk, entries)
+	if len(errs) != len(entries) {
+		t.Fatal("Expect one result per entry, got", len(errs))
+	}
+	for i, err := range errs[:3] {
+		if err != nil {
+			t.Fatal("Expect entry", i, "to be accepted, got", err)
+		}
+	}
+	var equivocation *EquivocationError
+	if !errors.As(errs[3], &equivocation) {
+		t.Fatal("Expect the fork to be rejected at the last step, got", errs[3])
+	}
+
+	// replaying is deterministic
+	again := ReplayTranscript(initialSTR, pk, entries)
+	for i := range errs {
+		if errs[i] != nil && again[i] == nil || errs[i] == nil && again[i] != nil {
+			t.Error("Expect the same result for entry", i)
+		}
+	}
+}
+
+func TestReplayTranscriptUnknownRequest(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	pk, _ := staticSigningKey.Public()
+	errs := ReplayTranscript(d.LatestSTR(), pk, []TranscriptEntry{{
+		RequestType: protocol.MonitoringType,
+		Response:    getSTRHistory(d),
+	}})
+	if errs[0] != protocol.ErrMalformedMessage {
+		t.Error("Expect", protocol.ErrMalformedMessage, "got", errs[0])
+	}
+}
@@ -0,0 +1,105 @@
+// Defines a compressed encoding of STR history ranges
+
+package protocol
+
+import (
+	"bytes"
+
+	"github.com/coniks-sys/coniks-go/merkletree"
+)
+
+// A CompressedSTRHistoryRange is a compact representation of
+// an STRHistoryRange with contiguous epochs, e.g. for bootstrapping
+// a client or a peer auditor from a directory's initial STR.
+// Rather than repeating the fields that can be derived or that rarely
+// change between consecutive STRs, it only includes the epoch of the
+// first STR in the range, and omits the policies of every STR
+// whose policies are identical to those of the preceding STR.
+type CompressedSTRHistoryRange struct {
+	StartEpoch uint64
+	STR        []*CompressedSTR
+}
+
+// A CompressedSTR is a DirSTR without its epochs, which are
+// derived from the position of the STR in a CompressedSTRHistoryRange.
+// Policies is nil if the policies are unchanged from the previous STR
+// in the range.
+type CompressedSTR struct {
+	TreeHash        []byte
+	PreviousSTRHash []byte
+	Timestamp       uint64 `json:",omitempty"`
+	Signature       []byte
+	Policies        *Policies `json:",omitempty"`
+}
+
+// Compress returns the compressed representation of the STR range r.
+// It returns ErrMalformedMessage if r is empty, or if its STRs don't
+// have contiguous, ascending epochs.
+func (r *STRHistoryRange) Compress() (*CompressedSTRHistoryRange, error) {
+	if err := validateSTRs(r.STR); err != nil || len(r.STR) == 0 {
+		return nil, ErrMalformedMessage
+	}
+	c := &CompressedSTRHistoryRange{
+		StartEpoch: r.STR[0].Epoch,
+		STR:        make([]*CompressedSTR, 0, len(r.STR)),
+	}
+	var prev *DirSTR
+	for _, str := range r.STR {
+		cstr := &CompressedSTR{
+			TreeHash:        str.TreeHash,
+			PreviousSTRHash: str.PreviousSTRHash,
+			Timestamp:       str.Timestamp,
+			Signature:       str.Signature,
+		}
+		if prev != nil && str.Epoch != prev.Epoch+1 {
+			return nil, ErrMalformedMessage
+		}
+		if prev == nil || !bytes.Equal(prev.Policies.Serialize(), str.Policies.Serialize()) {
+			cstr.Policies = str.Policies
+		}
+		c.STR = append(c.STR, cstr)
+		prev = str
+	}
+	return c, nil
+}
+
+// Decompress reconstructs the full STRHistoryRange from c.
+// The reconstructed STRs still need to be verified by the caller,
+// exactly as if they were received in an STRHistoryRange.
+// Decompress returns ErrMalformedMessage if c is empty, or if the
+// first STR in the range doesn't include its policies.
+func (c *CompressedSTRHistoryRange) Decompress() (*STRHistoryRange, error) {
+	if len(c.STR) == 0 || c.STR[0] == nil || c.STR[0].Policies == nil {
+		return nil, ErrMalformedMessage
+	}
+	r := &STRHistoryRange{
+		STR: make([]*DirSTR, 0, len(c.STR)),
+	}
+	var policies *Policies
+	for i, cstr := range c.STR {
+		if cstr == nil {
+			return nil, ErrMalformedMessage
+		}
+		if cstr.Policies != nil {
+			policies = cstr.Policies
+		}
+		epoch := c.StartEpoch + uint64(i)
+		prevEpoch := epoch - 1
+		if epoch == 0 {
+			prevEpoch = 0
+		}
+		r.STR = append(r.STR, &DirSTR{
+			SignedTreeRoot: &merkletree.SignedTreeRoot{
+				TreeHash:        cstr.TreeHash,
+				Epoch:           epoch,
+				PreviousEpoch:   prevEpoch,
+				PreviousSTRHash: cstr.PreviousSTRHash,
+				Timestamp:       cstr.Timestamp,
+				Signature:       cstr.Signature,
+				Ad:              policies,
+			},
+			Policies: policies,
+		})
+	}
+	return r, nil
+}
@@ -0,0 +1,130 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/merkletree"
+)
+
+// newTestHistory returns the STR history of a PAD updated numEpochs
+// times. The PAD's policies change at epoch numEpochs/2.
+func newTestHistory(tb testing.TB, numEpochs int) []*DirSTR {
+	vrfKey := crypto.NewStaticTestVRFKey()
+	signKey := crypto.NewStaticTestSigningKey()
+	vrfPublicKey, _ := vrfKey.Public()
+	pad, err := merkletree.NewPAD(NewPolicies(1, vrfPublicKey), signKey, vrfKey, 1)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	strs := []*DirSTR{NewDirSTR(pad.LatestSTR())}
+	for i := 1; i <= numEpochs; i++ {
+		var ad merkletree.AssocData
+		if i == numEpochs/2 {
+			ad = NewPolicies(2, vrfPublicKey)
+		}
+		pad.Update(ad)
+		strs = append(strs, NewDirSTR(pad.LatestSTR()))
+	}
+	return strs
+}
+
+func TestCompressSTRHistoryRange(t *testing.T) {
+	strs := newTestHistory(t, 10)
+	r := &STRHistoryRange{STR: strs}
+
+	c, err := r.Compress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// policies are only included when they change
+	var n int
+	for _, cstr := range c.STR {
+		if cstr.Policies != nil {
+			n++
+		}
+	}
+	if n != 2 {
+		t.Fatal("Expect 2 STRs with policies, got", n)
+	}
+
+	// round-trip through the wire encoding
+	buf, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded CompressedSTRHistoryRange
+	if err := json.Unmarshal(buf, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	got, err := decoded.Decompress()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.STR) != len(strs) {
+		t.Fatal("Expect", len(strs), "STRs, got", len(got.STR))
+	}
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+	for i, str := range got.STR {
+		if str.Epoch != strs[i].Epoch || str.PreviousEpoch != strs[i].PreviousEpoch ||
+			!bytes.Equal(str.Serialize(), strs[i].Serialize()) ||
+			!bytes.Equal(str.Signature, strs[i].Signature) {
+			t.Fatal("Reconstructed STR differs from the original at epoch", i)
+		}
+		if !pk.Verify(str.Serialize(), str.Signature) {
+			t.Fatal("Invalid signature on reconstructed STR at epoch", i)
+		}
+		if i > 0 && !str.VerifyHashChain(got.STR[i-1]) {
+			t.Fatal("Broken hash chain on reconstructed STR at epoch", i)
+		}
+	}
+}
+
+func TestCompressMalformedSTRHistoryRange(t *testing.T) {
+	strs := newTestHistory(t, 3)
+
+	for _, tc := range []struct {
+		name string
+		strs []*DirSTR
+	}{
+		{"empty range", nil},
+		{"gap", []*DirSTR{strs[0], strs[2]}},
+		{"descending", []*DirSTR{strs[1], strs[0]}},
+		{"nil STR", []*DirSTR{strs[0], nil}},
+	} {
+		r := &STRHistoryRange{STR: tc.strs}
+		if _, err := r.Compress(); err != ErrMalformedMessage {
+			t.Errorf("Expect ErrMalformedMessage for %s, got %v", tc.name, err)
+		}
+	}
+
+	c := &CompressedSTRHistoryRange{STR: []*CompressedSTR{{}}}
+	if _, err := c.Decompress(); err != ErrMalformedMessage {
+		t.Error("Expect ErrMalformedMessage for missing initial policies, got", err)
+	}
+}
+
+func BenchmarkCompressSTRHistoryRange(b *testing.B) {
+	r := &STRHistoryRange{STR: newTestHistory(b, 10000)}
+	full, err := json.Marshal(r)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	var compressed []byte
+	for i := 0; i < b.N; i++ {
+		c, err := r.Compress()
+		if err != nil {
+			b.Fatal(err)
+		}
+		compressed, err = json.Marshal(c)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(len(full)), "full-bytes")
+	b.ReportMetric(float64(len(compressed)), "compressed-bytes")
+}
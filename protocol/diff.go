@@ -0,0 +1,96 @@
+// Defines a field-by-field comparison of STRs, e.g. to report
+// how two forks of a directory's history differ
+
+package protocol
+
+import (
+	"bytes"
+	"strings"
+)
+
+// An STRDiff reports which fields of two STRs differ, e.g. when
+// investigating two STRs a directory signed for the same epoch.
+type STRDiff struct {
+	Epoch           bool
+	PreviousEpoch   bool
+	TreeHash        bool
+	PreviousSTRHash bool
+	Timestamp       bool
+	Policies        bool
+	Signature       bool
+}
+
+// DiffSTR compares the STRs a and b field by field, and returns the
+// STRDiff reporting the fields that differ. a and b must not be nil.
+func DiffSTR(a, b *DirSTR) *STRDiff {
+	return &STRDiff{
+		Epoch:           a.Epoch != b.Epoch,
+		PreviousEpoch:   a.PreviousEpoch != b.PreviousEpoch,
+		TreeHash:        !bytes.Equal(a.TreeHash, b.TreeHash),
+		PreviousSTRHash: !bytes.Equal(a.PreviousSTRHash, b.PreviousSTRHash),
+		Timestamp:       a.Timestamp != b.Timestamp,
+		Policies:        !bytes.Equal(a.Policies.Serialize(), b.Policies.Serialize()),
+		Signature:       !bytes.Equal(a.Signature, b.Signature),
+	}
+}
+
+// Identical returns whether none of the compared fields differ.
+func (d *STRDiff) Identical() bool {
+	return *d == STRDiff{}
+}
+
+// String returns a human-readable summary of d, e.g.
+// "roots differ, signatures differ, policies identical".
+func (d *STRDiff) String() string {
+	fields := []struct {
+		name   string
+		differ bool
+	}{
+		{"epochs", d.Epoch},
+		{"previous epochs", d.PreviousEpoch},
+		{"roots", d.TreeHash},
+		{"previous STR hashes", d.PreviousSTRHash},
+		{"timestamps", d.Timestamp},
+		{"policies", d.Policies},
+		{"signatures", d.Signature},
+	}
+	var differ, identical []string
+	for _, f := range fields {
+		if f.differ {
+			differ = append(differ, f.name+" differ")
+		} else {
+			identical = append(identical, f.name)
+		}
+	}
+	if len(differ) == 0 {
+		return "identical"
+	}
+	if len(identical) == 0 {
+		return strings.Join(differ, ", ")
+	}
+	return strings.Join(differ, ", ") + ", " + strings.Join(identical, ", ") + " identical"
+}
+
+// FirstDivergence compares the STRs of the ranges a and b for the
+// epochs included in both ranges in chronological order, and returns
+// the STRDiff of the first pair of STRs that differ, along with their
+// epoch. The ranges may have different lengths and start at different
+// epochs, but each must be in chronological order.
+// ok is false if the ranges agree on all common epochs, or if they
+// don't have any epochs in common.
+func FirstDivergence(a, b *STRHistoryRange) (epoch uint64, diff *STRDiff, ok bool) {
+	byEpoch := make(map[uint64]*DirSTR, len(b.STR))
+	for _, str := range b.STR {
+		byEpoch[str.Epoch] = str
+	}
+	for _, str := range a.STR {
+		other, found := byEpoch[str.Epoch]
+		if !found {
+			continue
+		}
+		if d := DiffSTR(str, other); !d.Identical() {
+			return str.Epoch, d, true
+		}
+	}
+	return 0, nil, false
+}
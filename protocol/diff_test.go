@@ -0,0 +1,74 @@
+package protocol
+
+import "testing"
+
+// copySTR returns a copy of str whose fields can be modified
+// without affecting str.
+func copySTR(str *DirSTR) *DirSTR {
+	root := *str.SignedTreeRoot
+	root.TreeHash = append([]byte{}, root.TreeHash...)
+	root.PreviousSTRHash = append([]byte{}, root.PreviousSTRHash...)
+	root.Signature = append([]byte{}, root.Signature...)
+	policies := *str.Policies
+	return &DirSTR{SignedTreeRoot: &root, Policies: &policies}
+}
+
+func TestDiffSTR(t *testing.T) {
+	str := newTestHistory(t, 2)[1]
+	if d := DiffSTR(str, copySTR(str)); !d.Identical() || d.String() != "identical" {
+		t.Fatal("Expect identical STRs, got", d)
+	}
+
+	for _, tc := range []struct {
+		modify func(*DirSTR)
+		want   STRDiff
+	}{
+		{func(s *DirSTR) { s.Epoch++ }, STRDiff{Epoch: true}},
+		{func(s *DirSTR) { s.PreviousEpoch++ }, STRDiff{PreviousEpoch: true}},
+		{func(s *DirSTR) { s.TreeHash[0] ^= 1 }, STRDiff{TreeHash: true}},
+		{func(s *DirSTR) { s.PreviousSTRHash[0] ^= 1 }, STRDiff{PreviousSTRHash: true}},
+		{func(s *DirSTR) { s.Timestamp++ }, STRDiff{Timestamp: true}},
+		{func(s *DirSTR) { s.Policies.EpochDeadline++ }, STRDiff{Policies: true}},
+		{func(s *DirSTR) { s.Signature[0] ^= 1 }, STRDiff{Signature: true}},
+	} {
+		other := copySTR(str)
+		tc.modify(other)
+		if d := DiffSTR(str, other); *d != tc.want {
+			t.Error("Expect diff", tc.want, "got", *d)
+		}
+	}
+
+	other := copySTR(str)
+	other.TreeHash[0] ^= 1
+	other.Signature[0] ^= 1
+	want := "roots differ, signatures differ, " +
+		"epochs, previous epochs, previous STR hashes, timestamps, policies identical"
+	if s := DiffSTR(str, other).String(); s != want {
+		t.Error("Unexpected summary", s)
+	}
+}
+
+func TestFirstDivergence(t *testing.T) {
+	strs := newTestHistory(t, 6)
+	fork := append([]*DirSTR{}, strs...)
+	fork[4] = copySTR(strs[4])
+	fork[4].TreeHash[0] ^= 1
+	fork[5] = copySTR(strs[5])
+	fork[5].PreviousSTRHash[0] ^= 1
+
+	// the ranges only overlap in epochs 2 to 5
+	ep, d, ok := FirstDivergence(&STRHistoryRange{STR: strs[:6]},
+		&STRHistoryRange{STR: fork[2:]})
+	if !ok || ep != 4 || *d != (STRDiff{TreeHash: true}) {
+		t.Fatal("Expect the ranges to diverge at epoch 4, got", ep, d, ok)
+	}
+
+	if _, _, ok := FirstDivergence(&STRHistoryRange{STR: strs[:4]},
+		&STRHistoryRange{STR: fork}); ok {
+		t.Error("Expect the ranges to agree up to epoch 3")
+	}
+	if _, _, ok := FirstDivergence(&STRHistoryRange{STR: strs[:2]},
+		&STRHistoryRange{STR: fork[4:]}); ok {
+		t.Error("Expect disjoint ranges not to diverge")
+	}
+}
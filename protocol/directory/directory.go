@@ -10,7 +10,13 @@ package directory
 
 import (
 	"bytes"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
 
+	"github.com/coniks-sys/coniks-go/crypto"
 	"github.com/coniks-sys/coniks-go/crypto/sign"
 	"github.com/coniks-sys/coniks-go/crypto/vrf"
 	"github.com/coniks-sys/coniks-go/merkletree"
@@ -29,6 +35,21 @@ type ConiksDirectory struct {
 	useTBs   bool
 	tbs      map[string]*protocol.TemporaryBinding
 	policies *protocol.Policies
+	// policyChange is the change of policies which the next STR
+	// announces, if any (see ChangePolicies())
+	policyChange *protocol.PolicyChangeRequest
+	// signerChange is the change of the signing key which the next
+	// STR announces, if any (see RotateSigningKey())
+	signerChange *signerChange
+	// expiry maps each username registered with a TTL to the epoch
+	// of the first STR in which its binding is expired
+	expiry map[string]uint64
+
+	// guards subscribers, so that subscribers can come and go
+	// while d is updated (see Subscribe())
+	subMu       sync.Mutex
+	subscribers []*subscriber
+	events      *eventRecorder
 }
 
 // New constructs a new ConiksDirectory given the key server's PAD
@@ -41,6 +62,58 @@ type ConiksDirectory struct {
 // registration.
 func New(epDeadline protocol.Timestamp, vrfKey vrf.PrivateKey,
 	signKey sign.PrivateKey, dirSize uint64, useTBs bool) *ConiksDirectory {
+	return NewWithHasher(epDeadline, vrfKey, signKey, dirSize, useTBs,
+		crypto.DefaultHasher)
+}
+
+// NewWithHasher is like New but links the directory's STRs using
+// the hash function h, which is declared in the directory's policies
+// (see protocol.NewPoliciesWithHasher()).
+func NewWithHasher(epDeadline protocol.Timestamp, vrfKey vrf.PrivateKey,
+	signKey sign.PrivateKey, dirSize uint64, useTBs bool,
+	h crypto.Hasher) *ConiksDirectory {
+	d, err := newDirectory("", epDeadline, vrfKey, sign.KeySigner(signKey),
+		dirSize, useTBs, h, nil)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// NewWithSigner is like NewWithHasher but signs the directory's STRs,
+// TBs and STR challenges with signer rather than with an in-memory
+// signing key, e.g. to keep the signing key in an HSM or a cloud KMS.
+// Since signer may fail, NewWithSigner() returns
+// merkletree.ErrSTRSigning if the initial STR can't be signed, rather
+// than panicking.
+func NewWithSigner(epDeadline protocol.Timestamp, vrfKey vrf.PrivateKey,
+	signer sign.Signer, dirSize uint64, useTBs bool,
+	h crypto.Hasher) (*ConiksDirectory, error) {
+	return newDirectory("", epDeadline, vrfKey, signer, dirSize, useTBs, h, nil)
+}
+
+// NewNamed is like NewWithHasher but names the directory in its
+// policies (see protocol.Policies.DirectoryName). Deployments running
+// several directories under the same signing key should give each
+// directory a distinct name, so that the directories' identities are
+// guaranteed to differ (see auditor.ComputeDirectoryIdentity()).
+func NewNamed(name string, epDeadline protocol.Timestamp, vrfKey vrf.PrivateKey,
+	signKey sign.PrivateKey, dirSize uint64, useTBs bool,
+	h crypto.Hasher) *ConiksDirectory {
+	d, err := newDirectory(name, epDeadline, vrfKey, sign.KeySigner(signKey),
+		dirSize, useTBs, h, nil)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// newDirectory constructs a new ConiksDirectory named name as NewNamed()
+// does, which signs using signer and whose PAD uses rnd as its source of
+// randomness (see merkletree.NewPADWithSigner()).
+func newDirectory(name string, epDeadline protocol.Timestamp, vrfKey vrf.PrivateKey,
+	signer sign.Signer, dirSize uint64, useTBs bool,
+	h crypto.Hasher, rnd io.Reader) (*ConiksDirectory, error) {
 	// FIXME: see #110
 	if !useTBs {
 		panic("Currently the server is forced to use TBs")
@@ -50,35 +123,308 @@ func New(epDeadline protocol.Timestamp, vrfKey vrf.PrivateKey,
 	if !ok {
 		panic(vrf.ErrGetPubKey)
 	}
-	d.policies = protocol.NewPolicies(epDeadline, vrfPublicKey)
-	pad, err := merkletree.NewPAD(d.policies, signKey, vrfKey, dirSize)
+	d.policies = protocol.NewPoliciesWithHasher(epDeadline, vrfPublicKey, h)
+	d.policies.DirectoryName = name
+	pad, err := merkletree.NewPADWithSigner(d.policies, signer, vrfKey, dirSize, rnd)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	d.pad = pad
 	d.useTBs = useTBs
 	if useTBs {
 		d.tbs = make(map[string]*protocol.TemporaryBinding)
 	}
-	return d
+	d.expiry = make(map[string]uint64)
+	return d, nil
 }
 
 // Update creates a new PAD snapshot updating this ConiksDirectory.
 // Update() is called at the end of a CONIKS epoch. This implementation
 // also deletes all issued TBs for the ending epoch as their
 // corresponding mappings will have been inserted into the PAD.
-func (d *ConiksDirectory) Update() {
-	d.pad.Update(d.policies)
+// Bindings whose TTL runs out in the new snapshot are replaced with a
+// protocol.TombstoneExpired tombstone (see
+// protocol.RegistrationRequest.TTL).
+// Update() then notifies d's subscribers of the new STR
+// (see Subscribe()).
+// Update() is atomic: if the new STR can't be issued, it returns the
+// error (e.g. merkletree.ErrSTRSigning) and leaves d unchanged, i.e.
+// the latest STR and the issued TBs remain the same and the pending
+// registrations will be included in the next successful Update(). The
+// tombstones of expired bindings are removed from the tree again, and
+// bound anew by the next Update().
+func (d *ConiksDirectory) Update() (err error) {
+	epoch := d.pad.LatestSTR().Epoch + 1
+	expired := d.expiring(epoch)
+	if len(expired) > 0 {
+		rollback := d.pad.Stage()
+		defer func() {
+			if err != nil {
+				rollback()
+			}
+		}()
+	}
+	if err = d.expire(expired, epoch); err != nil {
+		d.events.discard()
+		return err
+	}
+	if d.policyChange == nil && d.signerChange == nil {
+		err = d.pad.Update(d.policies)
+	} else {
+		next := *d.policies
+		if pc := d.policyChange; pc != nil {
+			next.EpochDeadline = pc.EpochDeadline
+			if pc.HashID != "" {
+				next.HashID = pc.HashID
+			}
+		}
+		change := &protocol.PolicyChange{
+			Epoch:         d.pad.LatestSTR().Epoch + 2,
+			EpochDeadline: next.EpochDeadline,
+			HashID:        next.HashID,
+		}
+		if sc := d.signerChange; sc != nil {
+			if sc.signatureID != "" {
+				next.SignatureID = sc.signatureID
+				change.SignatureID = sc.signatureID
+			}
+			change.SigningKey = sc.publicKey
+		}
+		announced := *d.policies
+		announced.PolicyChange = change
+		if err = d.pad.UpdateAnnounced(&announced, &next); err == nil {
+			d.policies = &next
+			d.policyChange = nil
+			if sc := d.signerChange; sc != nil {
+				// the announcing STR is signed with the previous
+				// key, the following STRs with the new one
+				d.pad.SetSigner(sc.signer)
+				d.signerChange = nil
+			}
+		}
+	}
+	if err != nil {
+		d.events.discard()
+		return err
+	}
+	d.events.record(&Event{Type: UpdateEvent, Timestamp: d.pad.LatestSTR().Timestamp})
 	// clear issued temporary bindings
 	for key := range d.tbs {
 		delete(d.tbs, key)
 	}
+	for _, name := range expired {
+		delete(d.expiry, name)
+	}
+	d.notify(d.LatestSTR())
+	return nil
+}
+
+// expiring returns the usernames whose bindings expire in the given
+// epoch, in sorted order, so that replaying the directory's event log
+// consumes its randomness in the same order (see expire()).
+func (d *ConiksDirectory) expiring(epoch uint64) []string {
+	var expired []string
+	for name, ep := range d.expiry {
+		if ep <= epoch {
+			expired = append(expired, name)
+		}
+	}
+	sort.Strings(expired)
+	return expired
+}
+
+// expire binds a protocol.TombstoneExpired tombstone for the given
+// epoch to each username in expired, so that the tombstones are
+// included in the next snapshot.
+func (d *ConiksDirectory) expire(expired []string, epoch uint64) error {
+	for _, name := range expired {
+		if err := d.pad.Set(name, protocol.NewTombstone(protocol.TombstoneExpired, epoch)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RotateVRFKey replaces the directory's VRF private key with vrfKey,
+// which changes the private index of every username, and immediately
+// issues a new STR in which all bindings are moved to their indices
+// under vrfKey. The policies of this STR announce the rotation from the
+// previous VRF public key (see protocol.Policies.PreviousVrfPublicKey),
+// so that clients and auditors can verify the transition.
+// Like Update(), RotateVRFKey() clears the issued TBs, whose bindings
+// are included in the new STR, and notifies d's subscribers.
+// RotateVRFKey() is atomic: if the new STR can't be issued, it returns
+// the error and leaves d unchanged.
+func (d *ConiksDirectory) RotateVRFKey(vrfKey vrf.PrivateKey) error {
+	vrfPublicKey, ok := vrfKey.Public()
+	if !ok {
+		return vrf.ErrGetPubKey
+	}
+	announced := *d.policies
+	announced.PreviousVrfPublicKey = d.policies.VrfPublicKey
+	announced.VrfPublicKey = vrfPublicKey
+	next := announced
+	next.PreviousVrfPublicKey = nil
+	if err := d.pad.RotateVRFKey(vrfKey, &announced, &next); err != nil {
+		d.events.discard()
+		return err
+	}
+	d.policies = &next
+	d.events.record(&Event{Type: VRFKeyRotationEvent, Key: vrfPublicKey,
+		Timestamp: d.pad.LatestSTR().Timestamp})
+	for key := range d.tbs {
+		delete(d.tbs, key)
+	}
+	d.notify(d.LatestSTR())
+	return nil
+}
+
+// Bindings returns all name-to-key bindings included in the
+// directory's latest snapshot, along with the commitments needed to
+// rebuild the snapshot (see RebuildFrom()).
+func (d *ConiksDirectory) Bindings() []*merkletree.Binding {
+	return d.pad.Bindings()
+}
+
+// Stats reports growth metrics of a directory's snapshot at the
+// given Epoch, without exposing any usernames: NumBindings is the
+// number of name-to-key bindings included in the snapshot.
+type Stats struct {
+	Epoch       uint64
+	NumBindings int
+}
+
+// Stats returns the Stats of the directory's snapshot at epoch, e.g.
+// for operators to chart the directory's growth. Registrations pending
+// since the latest Update() aren't counted.
+// Stats() returns ErrMalformedMessage if epoch is later than the latest
+// epoch, or merkletree.ErrSTRNotFound if the snapshot of epoch is no
+// longer kept in memory.
+func (d *ConiksDirectory) Stats(epoch uint64) (*Stats, error) {
+	if epoch > d.LatestSTR().Epoch {
+		return nil, protocol.ErrMalformedMessage
+	}
+	n, err := d.pad.NumBindings(epoch)
+	if err != nil {
+		return nil, err
+	}
+	return &Stats{Epoch: epoch, NumBindings: n}, nil
+}
+
+// RebuildFrom is a maintenance operation which reconstructs the tree of
+// the directory's latest snapshot from the authoritative set of
+// bindings, e.g. restored from a backup after the directory's storage
+// was corrupted, and checks that the rebuilt tree's root matches the
+// latest STR.
+// RebuildFrom() returns merkletree.ErrUnverifiableCommitment if a
+// binding doesn't match its commitment, or a
+// *merkletree.RootMismatchError reporting both roots if the bindings
+// don't reproduce the latest STR's root. In either case, d remains
+// unchanged. Otherwise, d serves lookups from the rebuilt tree, and
+// the registrations pending since the latest Update() (i.e. those with
+// an issued TB) are kept for the next snapshot.
+func (d *ConiksDirectory) RebuildFrom(bindings []*merkletree.Binding) error {
+	var pending []string
+	for name := range d.tbs {
+		pending = append(pending, name)
+	}
+	return d.pad.RebuildFrom(bindings, pending)
+}
+
+// ChangePolicies changes this ConiksDirectory's epoch deadline and
+// hash function as requested in req. The STR issued by the next
+// Update() announces the change in its policies
+// (see protocol.PolicyChange), and the new policies take effect in
+// the STR of the epoch after next, so that clients and auditors can
+// verify that the directory announced the change under its signing
+// key before applying it.
+// A later call before the next Update() replaces req, and a request
+// for the current policies cancels a pending change.
+// ChangePolicies() returns ErrMalformedMessage if req is nil or
+// requests an unknown hash function (see crypto.GetHasher()), and
+// nil otherwise.
+func (d *ConiksDirectory) ChangePolicies(req *protocol.PolicyChangeRequest) error {
+	if req == nil || req.HashID != "" && crypto.GetHasher(req.HashID) == nil {
+		return protocol.ErrMalformedMessage
+	}
+	change := *req
+	d.policyChange = &change
+	if req.EpochDeadline == d.policies.EpochDeadline &&
+		(req.HashID == "" || req.HashID == d.policies.HashID) {
+		d.policyChange = nil
+	}
+	d.events.record(&Event{Type: PoliciesEvent,
+		EpochDeadline: req.EpochDeadline, HashID: req.HashID})
+	return nil
+}
+
+// A signerChange is a change of a directory's signing key requested
+// with RotateSigningKey().
+type signerChange struct {
+	signer      sign.Signer
+	publicKey   sign.PublicKey
+	signatureID string
+}
+
+// RotateSigningKey replaces the directory's STR signing key with the
+// key of signer, whose public key is publicKey, and optionally switches
+// to the signature scheme signatureID (see sign.Algorithm). Like a
+// change of the directory's policies (see ChangePolicies()), the STR
+// issued by the next Update() announces the new key and scheme (see
+// protocol.PolicyChange) under the current key, and the STRs from the
+// epoch after next on are signed by signer, so that clients and
+// auditors pinning both keys can verify the transition.
+// An empty signatureID keeps the directory's signature scheme.
+// A later call before the next Update() replaces the requested key.
+// RotateSigningKey() returns ErrMalformedMessage if signer or publicKey
+// is missing, or signatureID is unknown (see sign.GetAlgorithm()).
+// The rotation isn't recorded in the directory's EventLog.
+func (d *ConiksDirectory) RotateSigningKey(signer sign.Signer,
+	publicKey sign.PublicKey, signatureID string) error {
+	if signer == nil || len(publicKey) == 0 ||
+		signatureID != "" && sign.GetAlgorithm(signatureID) == nil {
+		return protocol.ErrMalformedMessage
+	}
+	d.signerChange = &signerChange{
+		signer:      signer,
+		publicKey:   publicKey,
+		signatureID: signatureID,
+	}
+	return nil
+}
+
+// SetMetadata sets the auditor-relevant metadata m (e.g. the directory's
+// official auditors) which this ConiksDirectory declares in its policies.
+// Like the directory's other policies, m is included in the STRs from
+// the epoch after next on, and thus signed by the directory.
+// A nil m removes the metadata.
+func (d *ConiksDirectory) SetMetadata(m *protocol.DirectoryMetadata) {
+	// the current policies may be shared with issued STRs
+	next := *d.policies
+	next.Metadata = nil
+	if m != nil {
+		next.Metadata = &protocol.DirectoryMetadata{
+			Auditors: append([]string(nil), m.Auditors...),
+			Contact:  m.Contact,
+		}
+	}
+	d.policies = &next
 }
 
 // SetPolicies sets this ConiksDirectory's epoch deadline, which will be used
-// in the next epoch.
+// in the STR of the epoch after next (see ChangePolicies()).
+// The directory keeps its hash function.
 func (d *ConiksDirectory) SetPolicies(epDeadline protocol.Timestamp) {
-	d.policies = protocol.NewPolicies(epDeadline, d.policies.VrfPublicKey)
+	d.ChangePolicies(&protocol.PolicyChangeRequest{EpochDeadline: epDeadline})
+}
+
+// SetClock makes this ConiksDirectory include the time returned by
+// clock in each STR it issues from now on (see
+// merkletree.SignedTreeRoot.Timestamp), so that clients and auditors
+// can check that the directory's clock never goes backwards.
+// A nil clock, the default, disables timestamps.
+func (d *ConiksDirectory) SetClock(clock func() time.Time) {
+	d.pad.SetClock(clock)
 }
 
 // EpochDeadline returns this ConiksDirectory's latest epoch deadline
@@ -92,16 +438,29 @@ func (d *ConiksDirectory) LatestSTR() *protocol.DirSTR {
 	return protocol.NewDirSTR(d.pad.LatestSTR())
 }
 
+// AnchorDigest returns the digest of this ConiksDirectory's latest STR
+// to post to an external transparency anchor (see
+// protocol.AnchorDigest()).
+func (d *ConiksDirectory) AnchorDigest() []byte {
+	return protocol.AnchorDigest(d.LatestSTR())
+}
+
 // NewTB creates a new temporary binding for the given name-to-key mapping.
 // NewTB() computes the private index for the name, and
 // digitally signs the (index, key, latest STR signature) tuple.
-func (d *ConiksDirectory) NewTB(name string, key []byte) *protocol.TemporaryBinding {
+// It returns the error of d's signer if the tuple couldn't be signed
+// (see NewWithSigner()).
+func (d *ConiksDirectory) NewTB(name string, key []byte) (*protocol.TemporaryBinding, error) {
 	index := d.pad.Index(name)
+	sig, err := d.pad.Sign(d.LatestSTR().Signature, index, key)
+	if err != nil {
+		return nil, err
+	}
 	return &protocol.TemporaryBinding{
 		Index:     index,
 		Value:     key,
-		Signature: d.pad.Sign(d.LatestSTR().Signature, index, key),
-	}
+		Signature: sig,
+	}, nil
 }
 
 // Register inserts the username-to-key mapping contained in a
@@ -128,7 +487,8 @@ func (d *ConiksDirectory) NewTB(name string, key []byte) *protocol.TemporaryBind
 // a message.NewErrorResponse(ErrDirectory).
 func (d *ConiksDirectory) Register(req *protocol.RegistrationRequest) *protocol.Response {
 	// make sure the request is well-formed
-	if len(req.Username) <= 0 || len(req.Key) <= 0 {
+	if len(req.Username) <= 0 || len(req.Key) <= 0 ||
+		protocol.IsTombstoneKey(req.Key) {
 		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
 	}
 
@@ -150,19 +510,43 @@ func (d *ConiksDirectory) Register(req *protocol.RegistrationRequest) *protocol.
 		if tb = d.tbs[req.Username]; tb != nil {
 			return protocol.NewRegistrationProof(ap, d.LatestSTR(), tb, protocol.ReqNameExisted)
 		}
-		tb = d.NewTB(req.Username, req.Key)
+		if tb, err = d.NewTB(req.Username, req.Key); err != nil {
+			return protocol.NewErrorResponse(protocol.ErrDirectory)
+		}
 	}
 
 	if err = d.pad.Set(req.Username, req.Key); err != nil {
+		d.events.discard()
 		return protocol.NewErrorResponse(protocol.ErrDirectory)
 	}
+	d.events.record(&Event{Type: RegistrationEvent,
+		Username: req.Username, Key: req.Key, TTL: req.TTL})
 
 	if tb != nil {
 		d.tbs[req.Username] = tb
 	}
+	// the binding is included in the next STR, and never expires
+	// if its expiry epoch overflows
+	if included := d.LatestSTR().Epoch + 1; req.TTL > 0 &&
+		req.TTL <= math.MaxUint64-included {
+		d.expiry[req.Username] = included + req.TTL
+	}
 	return protocol.NewRegistrationProof(ap, d.LatestSTR(), tb, protocol.ReqSuccess)
 }
 
+// setKey binds name to key in the next snapshot of d regardless of
+// whether name is already registered. The directory doesn't support
+// key changes yet, so setKey is only used by tests and to replay
+// logged key changes (see ReplayEvents()).
+func (d *ConiksDirectory) setKey(name string, key []byte) error {
+	if err := d.pad.Set(name, key); err != nil {
+		d.events.discard()
+		return err
+	}
+	d.events.record(&Event{Type: KeyChangeEvent, Username: name, Key: key})
+	return nil
+}
+
 // KeyLookup gets the public key for the username indicated in the
 // KeyLookupRequest req received from a CONIKS client from the latest
 // snapshot of this ConiksDirectory, and returns a protocol.Response.
@@ -181,7 +565,9 @@ func (d *ConiksDirectory) Register(req *protocol.RegistrationRequest) *protocol.
 // the username, but there isn't an entry in the directory yet, and a
 // a message.NewKeyLookupProof(ap=proof of inclusion, str, nil, ReqSuccess)
 // if there is.
-// In any case, str is the signed tree root for the latest epoch.
+// In any case, str is the signed tree root for the latest epoch, and
+// ap is compressed (see merkletree.AuthenticationPath.Compress()) if
+// req.CompressProof is set.
 // If KeyLookup() encounters an internal error at any point, it returns
 // a message.NewErrorResponse(ErrDirectory).
 func (d *ConiksDirectory) KeyLookup(req *protocol.KeyLookupRequest) *protocol.Response {
@@ -195,6 +581,9 @@ func (d *ConiksDirectory) KeyLookup(req *protocol.KeyLookupRequest) *protocol.Re
 	if err != nil {
 		return protocol.NewErrorResponse(protocol.ErrDirectory)
 	}
+	if req.CompressProof {
+		ap = ap.Compress()
+	}
 
 	if bytes.Equal(ap.LookupIndex, ap.Leaf.Index) {
 		return protocol.NewKeyLookupProof(ap, d.LatestSTR(), nil, protocol.ReqSuccess)
@@ -208,6 +597,57 @@ func (d *ConiksDirectory) KeyLookup(req *protocol.KeyLookupRequest) *protocol.Re
 	return protocol.NewKeyLookupProof(ap, d.LatestSTR(), nil, protocol.ReqNameNotFound)
 }
 
+// BatchKeyLookup gets the public keys for all usernames indicated in the
+// BatchKeyLookupRequest req received from a CONIKS client from the latest
+// snapshot of this ConiksDirectory, and returns a protocol.Response.
+// The response (which also includes the error code) is supposed to
+// be sent back to the client.
+//
+// A request without any usernames, or with an empty username
+// is considered malformed, and causes BatchKeyLookup() to return a
+// message.NewErrorResponse(ErrMalformedMessage).
+// Otherwise, BatchKeyLookup() returns a
+// message.NewBatchKeyLookupProof(proofs), where proofs contains,
+// in request order, the response KeyLookup() returns for each username.
+// All proofs share the signed tree root for the latest epoch.
+// If BatchKeyLookup() encounters an internal error at any point, it returns
+// a message.NewErrorResponse(ErrDirectory).
+func (d *ConiksDirectory) BatchKeyLookup(req *protocol.BatchKeyLookupRequest) *protocol.Response {
+	// make sure the request is well-formed
+	if len(req.Usernames) <= 0 {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+
+	var proofs []*protocol.Response
+	for _, name := range req.Usernames {
+		res := d.KeyLookup(&protocol.KeyLookupRequest{Username: name})
+		switch res.Error {
+		case protocol.ReqSuccess, protocol.ReqNameNotFound:
+			proofs = append(proofs, res)
+		default:
+			return protocol.NewErrorResponse(res.Error)
+		}
+	}
+
+	return protocol.NewBatchKeyLookupProof(proofs)
+}
+
+// BatchKeyLookupMultiProof handles a BatchKeyLookupRequest req as
+// BatchKeyLookup() does, but combines the authentication paths of all
+// usernames into a single multiproof, which omits the sibling hashes
+// shared by several paths.
+// It returns the error response BatchKeyLookup() would return for req,
+// if any. Otherwise, it returns a
+// message.NewBatchKeyLookupMultiProof(proofs), where proofs contains,
+// in request order, the response KeyLookup() returns for each username.
+func (d *ConiksDirectory) BatchKeyLookupMultiProof(req *protocol.BatchKeyLookupRequest) *protocol.Response {
+	res := d.BatchKeyLookup(req)
+	if res.Error != protocol.ReqSuccess {
+		return res
+	}
+	return protocol.NewBatchKeyLookupMultiProof(res.DirectoryResponse.(*protocol.BatchDirectoryProof).Proofs)
+}
+
 // KeyLookupInEpoch gets the public key for the username for a prior
 // epoch in the directory history indicated in the
 // KeyLookupInEpochRequest req received from a CONIKS client,
@@ -307,10 +747,137 @@ func (d *ConiksDirectory) Monitor(req *protocol.MonitoringRequest) *protocol.Res
 	return protocol.NewMonitoringProof(aps, strs)
 }
 
-// GetSTRHistory gets the directory snapshots for the epoch range
-// indicated in the STRHistoryRequest req received from a CONIKS auditor.
+// KeyHistory gets the directory proofs for the username for each epoch
+// in the range indicated in the KeyHistoryRequest req received from a
+// CONIKS client, and returns a protocol.Response.
 // The response (which also includes the error code) is supposed to
-// be sent back to the auditor.
+// be sent back to the client.
+//
+// A request without a username, with a start epoch greater than the
+// latest epoch of this directory, or a start epoch greater than the
+// end epoch is considered malformed, and causes KeyHistory() to return a
+// message.NewErrorResponse(ErrMalformedMessage).
+// KeyHistory() returns a message.NewKeyHistoryProof(ap, str).
+// ap is a list of proofs of inclusion for the epochs in which the
+// username was bound to a key, and proofs of absence for the other
+// epochs, and str is a list of STRs for the epoch range
+// [startEpoch, endEpoch], where startEpoch and endEpoch are the epoch
+// range endpoints indicated in the client's request.
+// If req.endEpoch is greater than d.LatestSTR().Epoch,
+// the end of the range will be set to d.LatestSTR().Epoch.
+// If KeyHistory() encounters an internal error at any point (e.g. if
+// the range includes epochs that are no longer kept in memory),
+// it returns a message.NewErrorResponse(ErrDirectory).
+func (d *ConiksDirectory) KeyHistory(req *protocol.KeyHistoryRequest) *protocol.Response {
+	// make sure the request is well-formed
+	if len(req.Username) <= 0 ||
+		req.StartEpoch > d.LatestSTR().Epoch ||
+		req.StartEpoch > req.EndEpoch {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+
+	var strs []*protocol.DirSTR
+	var aps []*merkletree.AuthenticationPath
+	endEp := req.EndEpoch
+	if endEp > d.LatestSTR().Epoch {
+		endEp = d.LatestSTR().Epoch
+	}
+	for ep := req.StartEpoch; ep <= endEp; ep++ {
+		ap, err := d.pad.LookupInEpoch(req.Username, ep)
+		if err != nil {
+			return protocol.NewErrorResponse(protocol.ErrDirectory)
+		}
+		aps = append(aps, ap)
+		strs = append(strs, protocol.NewDirSTR(d.pad.GetSTR(ep)))
+	}
+
+	return protocol.NewKeyHistoryProof(aps, strs)
+}
+
+// KeyDelta gets the directory proofs for the username for the start and
+// end epochs of the range indicated in the KeyDeltaRequest req received
+// from a CONIKS client, as well as the STRs for the entire range, and
+// returns a protocol.Response.
+// The response (which also includes the error code) is supposed to
+// be sent back to the client.
+//
+// A request without a username, with a start epoch greater than the
+// latest epoch of this directory, or a start epoch greater than the
+// end epoch is considered malformed, and causes KeyDelta() to return a
+// message.NewErrorResponse(ErrMalformedMessage).
+// KeyDelta() returns a message.NewKeyDeltaProof(startAP, endAP, str).
+// startAP and endAP are the proofs of inclusion or absence of the
+// username in the start and end epochs of the range, and str is a
+// list of STRs for the epoch range [startEpoch, endEpoch].
+// If req.endEpoch is greater than d.LatestSTR().Epoch,
+// the end of the range will be set to d.LatestSTR().Epoch.
+// If KeyDelta() encounters an internal error at any point (e.g. if
+// the range includes epochs that are no longer kept in memory),
+// it returns a message.NewErrorResponse(ErrDirectory).
+func (d *ConiksDirectory) KeyDelta(req *protocol.KeyDeltaRequest) *protocol.Response {
+	// make sure the request is well-formed
+	if len(req.Username) <= 0 ||
+		req.StartEpoch > d.LatestSTR().Epoch ||
+		req.StartEpoch > req.EndEpoch {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+
+	endEp := req.EndEpoch
+	if endEp > d.LatestSTR().Epoch {
+		endEp = d.LatestSTR().Epoch
+	}
+	startAP, err := d.pad.LookupInEpoch(req.Username, req.StartEpoch)
+	if err != nil {
+		return protocol.NewErrorResponse(protocol.ErrDirectory)
+	}
+	endAP, err := d.pad.LookupInEpoch(req.Username, endEp)
+	if err != nil {
+		return protocol.NewErrorResponse(protocol.ErrDirectory)
+	}
+	var strs []*protocol.DirSTR
+	for ep := req.StartEpoch; ep <= endEp; ep++ {
+		str := d.pad.GetSTR(ep)
+		if str == nil || str.Epoch != ep {
+			return protocol.NewErrorResponse(protocol.ErrDirectory)
+		}
+		strs = append(strs, protocol.NewDirSTR(str))
+	}
+
+	return protocol.NewKeyDeltaProof(startAP, endAP, strs)
+}
+
+// STRChallenge gets the latest STR of d and signs it along with the
+// nonce in the client's STRChallengeRequest req, allowing the client to
+// check the STR's freshness.
+// A request with an empty nonce is considered malformed, and causes
+// STRChallenge() to return a message.NewErrorResponse(ErrMalformedMessage).
+// STRChallenge() returns a message.NewSTRChallengeResponse(str, nonce, sig),
+// where str is d.LatestSTR() and sig is d's signature on the serialized
+// protocol.STRChallengeResponse.
+// If the response can't be signed (see NewWithSigner()), STRChallenge()
+// returns a message.NewErrorResponse(ErrDirectory).
+func (d *ConiksDirectory) STRChallenge(req *protocol.STRChallengeRequest) *protocol.Response {
+	if len(req.Nonce) == 0 {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+	c := &protocol.STRChallengeResponse{
+		STR:   d.LatestSTR(),
+		Nonce: req.Nonce,
+	}
+	sig, err := d.pad.Sign(c.Serialize())
+	if err != nil {
+		return protocol.NewErrorResponse(protocol.ErrDirectory)
+	}
+	return protocol.NewSTRChallengeResponse(c.STR, c.Nonce, sig)
+}
+
+// GetSTRHistory gets the directory snapshots for the epoch range
+// indicated in the STRHistoryRequest req received from a CONIKS auditor
+// or client. The response (which also includes the error code) is
+// supposed to be sent back to the auditor or client.
+// A client which was offline can use the returned range to catch up
+// with the directory without an auditor, and verify that the range
+// continues its pinned STR (see client.ConsistencyChecks.CheckRollback()).
 //
 // A request with a start epoch greater than the
 // latest epoch of this directory, or a start epoch greater than the
@@ -323,6 +890,8 @@ func (d *ConiksDirectory) Monitor(req *protocol.MonitoringRequest) *protocol.Res
 // and endEpoch are the epoch range endpoints indicated in the client's
 // request. If req.endEpoch is greater than d.LatestSTR().Epoch,
 // the end of the range will be set to d.LatestSTR().Epoch.
+// If the range includes epochs that are no longer kept in memory,
+// GetSTRHistory() returns a message.NewErrorResponse(ErrDirectory).
 func (d *ConiksDirectory) GetSTRHistory(req *protocol.STRHistoryRequest) *protocol.Response {
 	// make sure the request is well-formed
 	if req.StartEpoch > d.LatestSTR().Epoch ||
@@ -337,8 +906,11 @@ func (d *ConiksDirectory) GetSTRHistory(req *protocol.STRHistoryRequest) *protoc
 
 	var strs []*protocol.DirSTR
 	for ep := req.StartEpoch; ep <= endEp; ep++ {
-		str := protocol.NewDirSTR(d.pad.GetSTR(ep))
-		strs = append(strs, str)
+		str := d.pad.GetSTR(ep)
+		if str == nil || str.Epoch != ep {
+			return protocol.NewErrorResponse(protocol.ErrDirectory)
+		}
+		strs = append(strs, protocol.NewDirSTR(str))
 	}
 
 	return protocol.NewSTRHistoryRange(strs)
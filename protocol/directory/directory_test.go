@@ -1,8 +1,16 @@
 package directory
 
 import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
 	"testing"
 
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
+	"github.com/coniks-sys/coniks-go/merkletree"
 	"github.com/coniks-sys/coniks-go/protocol"
 )
 
@@ -101,3 +109,725 @@ func TestBadRequestGetSTRHistory(t *testing.T) {
 		}
 	}
 }
+
+func TestBatchKeyLookup(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	d.Update()
+
+	res := d.BatchKeyLookup(&protocol.BatchKeyLookupRequest{
+		Usernames: []string{"alice", "bob"}})
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Unexpected error", res.Error)
+	}
+	proofs := res.DirectoryResponse.(*protocol.BatchDirectoryProof).Proofs
+	if len(proofs) != 2 {
+		t.Fatal("Expect 2 proofs, got", len(proofs))
+	}
+	if proofs[0].Error != protocol.ReqSuccess || proofs[1].Error != protocol.ReqNameNotFound {
+		t.Error("Unexpected error codes", proofs[0].Error, proofs[1].Error)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		usernames []string
+	}{
+		{"no usernames", nil},
+		{"invalid username", []string{"alice", ""}},
+	} {
+		res := d.BatchKeyLookup(&protocol.BatchKeyLookupRequest{Usernames: tc.usernames})
+		if res.Error != protocol.ErrMalformedMessage {
+			t.Errorf("Expect ErrMalformedMessage for %s", tc.name)
+		}
+	}
+}
+
+func TestBatchKeyLookupMultiProof(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	d.Update()
+
+	res := d.BatchKeyLookupMultiProof(&protocol.BatchKeyLookupRequest{
+		Usernames: []string{"alice", "bob"}})
+	if err := res.ValidateFor(protocol.BatchMultiProofType); err != nil {
+		t.Fatal(err)
+	}
+	b := res.DirectoryResponse.(*protocol.BatchMultiProof)
+	if len(b.Errors) != 2 || b.Errors[0] != protocol.ReqSuccess ||
+		b.Errors[1] != protocol.ReqNameNotFound {
+		t.Fatal("Unexpected error codes", b.Errors)
+	}
+	if err := b.Proof.VerifyRoot(b.STR.TreeHash); err != nil {
+		t.Fatal(err)
+	}
+
+	res = d.BatchKeyLookupMultiProof(&protocol.BatchKeyLookupRequest{})
+	if res.Error != protocol.ErrMalformedMessage {
+		t.Error("Expect ErrMalformedMessage, got", res.Error)
+	}
+}
+
+func TestForkAt(t *testing.T) {
+	d := NewTestDirectory(t)
+	for i := 0; i < 4; i++ {
+		d.Update()
+	}
+
+	fork := d.ForkAt(t, 2)
+	if !bytes.Equal(fork.LatestSTR().Signature, d.pad.GetSTR(2).Signature) {
+		t.Fatal("Expect the fork to start at epoch 2")
+	}
+
+	// register different bindings in the two directories
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	fork.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("evil")})
+	d.Update()
+	fork.Update()
+
+	if fork.LatestSTR().Epoch != 3 {
+		t.Fatal("Unexpected epoch for the fork", fork.LatestSTR().Epoch)
+	}
+	if !fork.LatestSTR().VerifyHashChain(protocol.NewDirSTR(d.pad.GetSTR(2))) {
+		t.Fatal("Expect the fork to extend the shared history")
+	}
+	if bytes.Equal(fork.LatestSTR().TreeHash, d.pad.GetSTR(3).TreeHash) {
+		t.Fatal("Expect the histories to diverge at epoch 3")
+	}
+}
+
+func TestSeededDirectoryIsDeterministic(t *testing.T) {
+	history := func(seed int64) []*protocol.DirSTR {
+		d := NewSeededTestDirectory(t, seed)
+		strs := []*protocol.DirSTR{d.LatestSTR()}
+		for _, name := range []string{"alice", "bob", "carol"} {
+			d.Register(&protocol.RegistrationRequest{Username: name, Key: []byte(name)})
+			d.Update()
+			strs = append(strs, d.LatestSTR())
+		}
+		return strs
+	}
+
+	h1, h2 := history(42), history(42)
+	for i := range h1 {
+		if !bytes.Equal(h1[i].Signature, h2[i].Signature) ||
+			!bytes.Equal(h1[i].Serialize(), h2[i].Serialize()) {
+			t.Fatal("Expect identical STRs for epoch", i)
+		}
+	}
+
+	h3 := history(43)
+	if bytes.Equal(h1[len(h1)-1].Signature, h3[len(h3)-1].Signature) {
+		t.Fatal("Expect different seeds to produce different STRs")
+	}
+}
+
+func TestKeyHistory(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.Update()
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	d.Update()
+	d.SetKey(t, "alice", []byte("key2"))
+	d.Update()
+
+	res := d.KeyHistory(&protocol.KeyHistoryRequest{Username: "alice", StartEpoch: 1, EndEpoch: 5})
+	if err := res.ValidateFor(protocol.KeyHistoryType); err != nil {
+		t.Fatal(err)
+	}
+	df := res.DirectoryResponse.(*protocol.DirectoryProof)
+	if len(df.AP) != 3 || len(df.STR) != 3 {
+		t.Fatal("Expect a proof for each of epochs 1-3")
+	}
+	if df.AP[0].ProofType() != merkletree.ProofOfAbsence ||
+		!bytes.Equal(df.AP[1].Leaf.Value, []byte("key")) ||
+		!bytes.Equal(df.AP[2].Leaf.Value, []byte("key2")) {
+		t.Fatal("Unexpected key history")
+	}
+
+	for _, req := range []*protocol.KeyHistoryRequest{
+		{Username: "", StartEpoch: 0, EndEpoch: 1},
+		{Username: "alice", StartEpoch: 4, EndEpoch: 5},
+		{Username: "alice", StartEpoch: 2, EndEpoch: 1},
+	} {
+		if res := d.KeyHistory(req); res.Error != protocol.ErrMalformedMessage {
+			t.Error("Expect", protocol.ErrMalformedMessage, "for", req)
+		}
+	}
+}
+
+func TestKeyDelta(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.Update()
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	d.Update()
+	d.Update()
+
+	res := d.KeyDelta(&protocol.KeyDeltaRequest{Username: "alice", StartEpoch: 1, EndEpoch: 5})
+	if err := res.ValidateFor(protocol.KeyDeltaType); err != nil {
+		t.Fatal(err)
+	}
+	df := res.DirectoryResponse.(*protocol.DirectoryProof)
+	if len(df.AP) != 2 || len(df.STR) != 3 {
+		t.Fatal("Expect proofs for epochs 1 and 3, and the STRs of epochs 1-3")
+	}
+	if df.AP[0].ProofType() != merkletree.ProofOfAbsence ||
+		!bytes.Equal(df.AP[1].Leaf.Value, []byte("key")) {
+		t.Fatal("Unexpected key delta")
+	}
+
+	for _, req := range []*protocol.KeyDeltaRequest{
+		{Username: "", StartEpoch: 0, EndEpoch: 1},
+		{Username: "alice", StartEpoch: 4, EndEpoch: 5},
+		{Username: "alice", StartEpoch: 2, EndEpoch: 1},
+	} {
+		if res := d.KeyDelta(req); res.Error != protocol.ErrMalformedMessage {
+			t.Error("Expect", protocol.ErrMalformedMessage, "for", req)
+		}
+	}
+}
+
+func TestUpdateSigningFailureLeavesStateUnchanged(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.Update()
+	signKey := crypto.NewStaticTestSigningKey()
+
+	res := d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Unable to register")
+	}
+	prev := d.LatestSTR()
+
+	merkletree.SetSignKey(t, d.pad, signKey[:1])
+	if err := d.Update(); err != merkletree.ErrSTRSigning {
+		t.Fatal("Expect", merkletree.ErrSTRSigning, "got", err)
+	}
+	if !reflect.DeepEqual(d.LatestSTR(), prev) || d.pad.LatestSTR() != prev.SignedTreeRoot {
+		t.Fatal("Expect the latest STR to be unchanged")
+	}
+	if _, ok := d.tbs["alice"]; !ok {
+		t.Fatal("Expect the issued TB to be kept")
+	}
+
+	// the pending registration is included in the next successful update
+	merkletree.SetSignKey(t, d.pad, signKey)
+	if err := d.Update(); err != nil {
+		t.Fatal(err)
+	}
+	str := d.LatestSTR()
+	if str.Epoch != prev.Epoch+1 || !str.VerifyHashChain(prev) {
+		t.Fatal("Expect the directory to advance by one epoch")
+	}
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"})
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect the registration to be included, got", res.Error)
+	}
+}
+
+func TestUpdateSigningFailureDiscardsTombstones(t *testing.T) {
+	d := NewTestDirectory(t)
+	signKey := crypto.NewStaticTestSigningKey()
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key"), TTL: 1})
+	d.Update()
+
+	// alice expires in the failed update
+	merkletree.SetSignKey(t, d.pad, signKey[:1])
+	if err := d.Update(); err != merkletree.ErrSTRSigning {
+		t.Fatal("Expect", merkletree.ErrSTRSigning, "got", err)
+	}
+	if _, ok := d.expiry["alice"]; !ok {
+		t.Fatal("Expect the binding to still expire")
+	}
+
+	// the tombstone isn't left in the tree if the binding
+	// doesn't expire anymore
+	delete(d.expiry, "alice")
+	merkletree.SetSignKey(t, d.pad, signKey)
+	if err := d.Update(); err != nil {
+		t.Fatal(err)
+	}
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"})
+	ap := res.DirectoryResponse.(*protocol.DirectoryProof).AP[0]
+	if !bytes.Equal(ap.Leaf.Value, []byte("key")) {
+		t.Fatal("Expect the tombstone to be discarded, got", ap.Leaf.Value)
+	}
+}
+
+func TestSTRChallenge(t *testing.T) {
+	d := NewTestDirectory(t)
+	res := d.STRChallenge(&protocol.STRChallengeRequest{Nonce: []byte("nonce")})
+	if err := res.ValidateFor(protocol.STRChallengeType); err != nil {
+		t.Fatal(err)
+	}
+	c := res.DirectoryResponse.(*protocol.STRChallengeResponse)
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+	if !pk.Verify(c.Serialize(), c.Signature) ||
+		!bytes.Equal(c.STR.Signature, d.LatestSTR().Signature) {
+		t.Fatal("Expect a signed challenge for the latest STR")
+	}
+
+	res = d.STRChallenge(&protocol.STRChallengeRequest{})
+	if res.Error != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", res.Error)
+	}
+}
+
+func TestRebuildFrom(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	d.Register(&protocol.RegistrationRequest{Username: "bob", Key: []byte("key")})
+	d.Update()
+	// carol is pending inclusion in the next snapshot
+	d.Register(&protocol.RegistrationRequest{Username: "carol", Key: []byte("key")})
+
+	bindings := d.Bindings()
+	if len(bindings) != 2 {
+		t.Fatal("Expect 2 bindings, got", len(bindings))
+	}
+	if err := d.RebuildFrom(bindings); err != nil {
+		t.Fatal(err)
+	}
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"})
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Unexpected lookup error", res.Error)
+	}
+	ap := res.DirectoryResponse.(*protocol.DirectoryProof).AP[0]
+	if err := ap.Verify([]byte("alice"), []byte("key"), d.LatestSTR().TreeHash); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pending registration is included in the next snapshot
+	d.Update()
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: "carol"})
+	if res.Error != protocol.ReqSuccess ||
+		res.DirectoryResponse.(*protocol.DirectoryProof).AP[0].ProofType() != merkletree.ProofOfInclusion {
+		t.Fatal("Expect carol to be included after the rebuild")
+	}
+}
+
+func TestRebuildFromTampered(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	d.Register(&protocol.RegistrationRequest{Username: "bob", Key: []byte("key")})
+	d.Update()
+	str := d.LatestSTR()
+
+	// the value doesn't match the commitment
+	bindings := d.Bindings()
+	bindings[0].Value = []byte("other")
+	if err := d.RebuildFrom(bindings); err != merkletree.ErrUnverifiableCommitment {
+		t.Fatal("Expect error", merkletree.ErrUnverifiableCommitment, "got", err)
+	}
+
+	// a consistent binding which isn't the one committed in the STR
+	bindings = d.Bindings()
+	commit, err := crypto.NewCommit([]byte(bindings[0].Key), []byte("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bindings[0].Value = []byte("other")
+	bindings[0].Commitment = commit
+	err = d.RebuildFrom(bindings)
+	mismatch, ok := err.(*merkletree.RootMismatchError)
+	if !ok {
+		t.Fatal("Expect a *RootMismatchError, got", err)
+	}
+	if mismatch.Epoch != str.Epoch || !bytes.Equal(mismatch.Want, str.TreeHash) ||
+		bytes.Equal(mismatch.Got, str.TreeHash) {
+		t.Error("Unexpected mismatch", mismatch)
+	}
+
+	// a missing binding
+	if _, ok := d.RebuildFrom(d.Bindings()[1:]).(*merkletree.RootMismatchError); !ok {
+		t.Fatal("Expect a *RootMismatchError for a missing binding")
+	}
+
+	// the directory is unchanged
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"})
+	ap := res.DirectoryResponse.(*protocol.DirectoryProof).AP[0]
+	if err := ap.Verify([]byte("alice"), []byte("key"), str.TreeHash); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRotateVRFKey(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	d.Update()
+	oldSTR := d.LatestSTR()
+
+	newKey, err := vrf.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPK, _ := newKey.Public()
+	if err := d.RotateVRFKey(newKey); err != nil {
+		t.Fatal(err)
+	}
+
+	str := d.LatestSTR()
+	if str.Epoch != oldSTR.Epoch+1 || !str.Policies.RotatesVRFKey() ||
+		!bytes.Equal(str.Policies.PreviousVrfPublicKey, oldSTR.Policies.VrfPublicKey) ||
+		!bytes.Equal(str.Policies.VrfPublicKey, newPK) {
+		t.Fatal("Expect the new STR to announce the VRF key rotation")
+	}
+
+	// lookups verify under the new VRF key
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"})
+	ap := res.DirectoryResponse.(*protocol.DirectoryProof).AP[0]
+	if !newPK.Verify([]byte("alice"), ap.LookupIndex, ap.VrfProof) {
+		t.Fatal("Expect the lookup index to be computed with the new VRF key")
+	}
+	if err := ap.Verify([]byte("alice"), []byte("key"), str.TreeHash); err != nil {
+		t.Fatal(err)
+	}
+
+	// lookups in earlier epochs still use the previous VRF key
+	res = d.KeyLookupInEpoch(&protocol.KeyLookupInEpochRequest{
+		Username: "alice", Epoch: oldSTR.Epoch})
+	ap = res.DirectoryResponse.(*protocol.DirectoryProof).AP[0]
+	if !oldSTR.Policies.VrfPublicKey.Verify([]byte("alice"), ap.LookupIndex, ap.VrfProof) {
+		t.Fatal("Expect the lookup index to be computed with the previous VRF key")
+	}
+
+	// the rotation is only announced once
+	d.Update()
+	if p := d.LatestSTR().Policies; p.RotatesVRFKey() || !bytes.Equal(p.VrfPublicKey, newPK) {
+		t.Fatal("Unexpected policies after the rotation", p)
+	}
+}
+
+func TestChangePolicies(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.Update()
+	oldSTR := d.LatestSTR()
+
+	if err := d.ChangePolicies(&protocol.PolicyChangeRequest{
+		EpochDeadline: 7, HashID: "unknown"}); err != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+	if err := d.ChangePolicies(&protocol.PolicyChangeRequest{
+		EpochDeadline: 7, HashID: crypto.SHA512_256ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the next STR announces the change under the current policies
+	d.Update()
+	str := d.LatestSTR()
+	c := str.Policies.PolicyChange
+	if c == nil || c.Epoch != oldSTR.Epoch+2 || c.EpochDeadline != 7 ||
+		c.HashID != crypto.SHA512_256ID {
+		t.Fatal("Expect the new STR to announce the policy change, got", c)
+	}
+	if str.Policies.EpochDeadline != oldSTR.Policies.EpochDeadline ||
+		str.Policies.HashID != oldSTR.Policies.HashID {
+		t.Fatal("Expect the announcing STR to keep the current policies")
+	}
+
+	// the STR of the announced epoch uses the new policies
+	d.Update()
+	str = d.LatestSTR()
+	if str.Epoch != c.Epoch || !c.Adopts(str.Policies) || str.Policies.PolicyChange != nil {
+		t.Fatal("Expect the STR to adopt the announced policies", str.Policies)
+	}
+	if d.EpochDeadline() != 7 {
+		t.Error("Expect epoch deadline 7, got", d.EpochDeadline())
+	}
+	if !str.VerifyHashChain(d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: c.Epoch - 1, EndEpoch: c.Epoch - 1}).DirectoryResponse.(*protocol.STRHistoryRange).STR[0]) {
+		t.Error("Expect the hash chain to verify across the change of hash function")
+	}
+
+	// a request for the current policies cancels a pending change
+	d.SetPolicies(9)
+	d.SetPolicies(7)
+	d.Update()
+	if d.LatestSTR().Policies.PolicyChange != nil {
+		t.Error("Expect the pending change to be canceled")
+	}
+}
+
+func TestGetSTRHistory(t *testing.T) {
+	d := NewTestDirectory(t)
+	for i := 0; i < 3; i++ {
+		d.Update()
+	}
+
+	res := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 1,
+		EndEpoch:   5})
+	if err := res.ValidateFor(protocol.STRType); err != nil {
+		t.Fatal(err)
+	}
+	strs := res.DirectoryResponse.(*protocol.STRHistoryRange).STR
+	if len(strs) != 3 || strs[0].Epoch != 1 || strs[2].Epoch != 3 {
+		t.Fatal("Expect the STRs for epochs 1 to 3, got", len(strs), "STRs")
+	}
+	for i := 1; i < len(strs); i++ {
+		if !strs[i].VerifyHashChain(strs[i-1]) {
+			t.Fatal("Expect a linear range of STRs")
+		}
+	}
+
+	// the directory no longer keeps the oldest epochs in memory
+	for i := 0; i < 10; i++ {
+		d.Update()
+	}
+	res = d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 0,
+		EndEpoch:   d.LatestSTR().Epoch})
+	if res.Error != protocol.ErrDirectory {
+		t.Error("Expect", protocol.ErrDirectory, "got", res.Error)
+	}
+}
+
+func TestRegisterExistingName(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	d.Update()
+
+	res := d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("evil")})
+	if res.Error != protocol.ReqNameExisted {
+		t.Fatal("Expect", protocol.ReqNameExisted, "got", res.Error)
+	}
+	df := res.DirectoryResponse.(*protocol.DirectoryProof)
+	ap := df.AP[0]
+	if ap.ProofType() != merkletree.ProofOfInclusion || df.TB != nil {
+		t.Fatal("Expect a proof of inclusion of the existing binding")
+	}
+	if err := ap.Verify([]byte("alice"), []byte("key"), df.STR[0].TreeHash); err != nil {
+		t.Fatal("Expect the existing binding to verify, got", err)
+	}
+
+	// the existing binding is left unchanged
+	d.Update()
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"})
+	ap = res.DirectoryResponse.(*protocol.DirectoryProof).AP[0]
+	if !bytes.Equal(ap.Leaf.Value, []byte("key")) {
+		t.Error("Expect the existing key, got", ap.Leaf.Value)
+	}
+}
+
+func TestStats(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.Update()
+	base, err := d.Stats(d.LatestSTR().Epoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, name := range []string{"alice", "bob", "carol"} {
+		d.Register(&protocol.RegistrationRequest{Username: name, Key: []byte("key")})
+		// the pending registration isn't counted yet
+		if s, _ := d.Stats(d.LatestSTR().Epoch); s.NumBindings != base.NumBindings+i {
+			t.Fatal("Expect", base.NumBindings+i, "bindings, got", s.NumBindings)
+		}
+		d.Update()
+		s, err := d.Stats(d.LatestSTR().Epoch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Epoch != d.LatestSTR().Epoch || s.NumBindings != base.NumBindings+i+1 {
+			t.Fatal("Expect", base.NumBindings+i+1, "bindings, got", s)
+		}
+	}
+
+	// historical epochs are still available
+	s, err := d.Stats(base.Epoch + 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.NumBindings != base.NumBindings+1 {
+		t.Error("Expect", base.NumBindings+1, "bindings, got", s.NumBindings)
+	}
+
+	if _, err := d.Stats(d.LatestSTR().Epoch + 1); err != protocol.ErrMalformedMessage {
+		t.Error("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+	for i := 0; i < 10; i++ {
+		d.Update()
+	}
+	if _, err := d.Stats(base.Epoch); err != merkletree.ErrSTRNotFound {
+		t.Error("Expect", merkletree.ErrSTRNotFound, "got", err)
+	}
+}
+
+func TestRegisterWithTTL(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key"), TTL: 2})
+	d.Register(&protocol.RegistrationRequest{Username: "bob", Key: []byte("key")})
+	included := d.LatestSTR().Epoch + 1
+
+	lookup := func(name string) *merkletree.AuthenticationPath {
+		res := d.KeyLookup(&protocol.KeyLookupRequest{Username: name})
+		df := res.DirectoryResponse.(*protocol.DirectoryProof)
+		ap := df.AP[0]
+		if ap.ProofType() != merkletree.ProofOfInclusion {
+			t.Fatal("Expect a proof of inclusion for", name)
+		}
+		if err := ap.Verify([]byte(name), ap.Leaf.Value, df.STR[0].TreeHash); err != nil {
+			t.Fatal("Expect the binding of", name, "to verify, got", err)
+		}
+		return ap
+	}
+
+	// the binding is valid for TTL epochs after its inclusion
+	for i := 0; i < 2; i++ {
+		d.Update()
+		if ap := lookup("alice"); !bytes.Equal(ap.Leaf.Value, []byte("key")) {
+			t.Fatal("Expect the key at epoch", d.LatestSTR().Epoch, "got", ap.Leaf.Value)
+		}
+	}
+
+	d.Update()
+	reason, epoch := protocol.ParseTombstone(lookup("alice").Leaf.Value)
+	if reason != protocol.TombstoneExpired || epoch != included+2 ||
+		epoch != d.LatestSTR().Epoch {
+		t.Fatal("Expect an expiry tombstone for epoch", included+2, "got", reason, epoch)
+	}
+	// bindings without a TTL don't expire
+	if ap := lookup("bob"); !bytes.Equal(ap.Leaf.Value, []byte("key")) {
+		t.Error("Expect bob's key, got", ap.Leaf.Value)
+	}
+
+	// the tombstone stays in place
+	d.Update()
+	if reason, e := protocol.ParseTombstone(lookup("alice").Leaf.Value); reason != protocol.TombstoneExpired || e != epoch {
+		t.Error("Expect the same tombstone, got", reason, e)
+	}
+	res := d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	if res.Error != protocol.ReqNameExisted {
+		t.Error("Expect", protocol.ReqNameExisted, "got", res.Error)
+	}
+}
+
+func TestRegisterWithOverflowingTTL(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.Update()
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key"),
+		TTL: math.MaxUint64})
+	if len(d.expiry) != 0 {
+		t.Fatal("Expect a binding with an overflowing TTL to never expire, got", d.expiry)
+	}
+	d.Update()
+	d.Update()
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"})
+	ap := res.DirectoryResponse.(*protocol.DirectoryProof).AP[0]
+	if !bytes.Equal(ap.Leaf.Value, []byte("key")) {
+		t.Fatal("Expect the key, got", ap.Leaf.Value)
+	}
+}
+
+func TestRegisterTombstoneKey(t *testing.T) {
+	d := NewTestDirectory(t)
+	res := d.Register(&protocol.RegistrationRequest{Username: "alice",
+		Key: protocol.NewTombstone(protocol.TombstoneExpired, 5)})
+	if res.Error != protocol.ErrMalformedMessage {
+		t.Error("Expect", protocol.ErrMalformedMessage, "got", res.Error)
+	}
+}
+
+// mockSigner is a sign.Signer which records the messages it signs
+// with an in-memory key, standing in for an HSM or a cloud KMS.
+type mockSigner struct {
+	key    sign.PrivateKey
+	signed [][]byte
+	down   bool
+}
+
+func (s *mockSigner) Sign(message []byte) ([]byte, error) {
+	if s.down {
+		return nil, errors.New("signer unavailable")
+	}
+	s.signed = append(s.signed, message)
+	return s.key.Sign(message), nil
+}
+
+func TestNewWithSigner(t *testing.T) {
+	signer := &mockSigner{key: crypto.NewStaticTestSigningKey()}
+	pk, _ := signer.key.Public()
+	d, err := NewWithSigner(1, crypto.NewStaticTestVRFKey(), signer, 10, true,
+		crypto.DefaultHasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signer.signed) != 1 {
+		t.Fatal("Expect the initial STR to be signed by the signer")
+	}
+
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	if err := d.Update(); err != nil {
+		t.Fatal(err)
+	}
+	// the TB and the new STR
+	if len(signer.signed) != 3 {
+		t.Fatal("Expect 3 signed messages, got", len(signer.signed))
+	}
+	str := d.LatestSTR()
+	if !bytes.Equal(signer.signed[2], str.Serialize()) ||
+		!pk.Verify(str.Serialize(), str.Signature) {
+		t.Fatal("Expect the STR to be signed by the signer")
+	}
+
+	// the signer's failure leaves d unchanged
+	signer.down = true
+	if err := d.Update(); err != merkletree.ErrSTRSigning {
+		t.Fatal("Expect", merkletree.ErrSTRSigning, "got", err)
+	}
+	if !bytes.Equal(d.LatestSTR().Signature, str.Signature) {
+		t.Fatal("Expect the latest STR to be unchanged")
+	}
+	res := d.Register(&protocol.RegistrationRequest{Username: "bob", Key: []byte("key")})
+	if res.Error != protocol.ErrDirectory {
+		t.Error("Expect", protocol.ErrDirectory, "got", res.Error)
+	}
+	res = d.STRChallenge(&protocol.STRChallengeRequest{Nonce: []byte("nonce")})
+	if res.Error != protocol.ErrDirectory {
+		t.Error("Expect", protocol.ErrDirectory, "got", res.Error)
+	}
+
+	signer.down = false
+	if err := d.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if str := d.LatestSTR(); !pk.Verify(str.Serialize(), str.Signature) {
+		t.Error("Expect the STR to verify")
+	}
+}
+
+func TestNewWithSignerUnavailable(t *testing.T) {
+	signer := &mockSigner{key: crypto.NewStaticTestSigningKey(), down: true}
+	if _, err := NewWithSigner(1, crypto.NewStaticTestVRFKey(), signer, 10, true,
+		crypto.DefaultHasher); err != merkletree.ErrSTRSigning {
+		t.Error("Expect", merkletree.ErrSTRSigning, "got", err)
+	}
+}
+
+func TestAnchorDigest(t *testing.T) {
+	d := NewSeededTestDirectory(t, 42)
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	d.Update()
+	digest := d.AnchorDigest()
+
+	// the same STR always has the same digest
+	other := NewSeededTestDirectory(t, 42)
+	other.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	other.Update()
+	if !bytes.Equal(digest, other.AnchorDigest()) {
+		t.Fatal("Expect identical STRs to have the same digest")
+	}
+	str := d.LatestSTR()
+	if err := protocol.VerifyAnchor(digest, str); err != nil {
+		t.Fatal(err)
+	}
+
+	// the digest doesn't verify against the next STR
+	d.Update()
+	if bytes.Equal(digest, d.AnchorDigest()) {
+		t.Fatal("Expect a new digest for a new STR")
+	}
+	if err := protocol.VerifyAnchor(digest, d.LatestSTR()); err != protocol.CheckBadSTR {
+		t.Error("Expect", protocol.CheckBadSTR, "got", err)
+	}
+	if err := protocol.VerifyAnchor(d.AnchorDigest(), str); err != protocol.CheckBadSTR {
+		t.Error("Expect", protocol.CheckBadSTR, "got", err)
+	}
+}
@@ -0,0 +1,251 @@
+// This module implements an optional log of the mutations of a CONIKS
+// key directory, which allows regenerating the directory's exact STR
+// history on another instance, e.g. to reproduce an inconsistency
+// reported by a client.
+
+package directory
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// ErrReplayDiverged indicates that replaying an EventLog didn't
+// reproduce the logged directory, e.g. because the log was replayed
+// with different keys.
+var ErrReplayDiverged = errors.New("[directory] Replaying the event log diverged from the logged directory")
+
+// An EventType identifies the mutation of a directory an Event records.
+type EventType int
+
+const (
+	RegistrationEvent EventType = iota
+	KeyChangeEvent
+	PoliciesEvent
+	UpdateEvent
+	VRFKeyRotationEvent
+)
+
+// An Event records a mutation of a ConiksDirectory. Username and Key
+// are set for RegistrationEvent and KeyChangeEvent, TTL is set for a
+// RegistrationEvent of a binding which expires, EpochDeadline
+// and HashID are set for PoliciesEvent, and Key is set to the new VRF public key
+// for VRFKeyRotationEvent. Timestamp is the timestamp of the STR
+// issued by an UpdateEvent or a VRFKeyRotationEvent, if the directory
+// timestamps its STRs (see SetClock()). Rand is the randomness the directory
+// consumed while processing the event (e.g. the salt of a new
+// commitment).
+type Event struct {
+	Type          EventType
+	Username      string
+	Key           []byte
+	TTL           uint64
+	EpochDeadline protocol.Timestamp
+	HashID        string
+	Timestamp     uint64
+	Rand          []byte
+}
+
+// An EventLog is an append-only record of the mutations of a
+// ConiksDirectory in the order the directory processed them, as well
+// as the parameters and the randomness Rand used to create the
+// directory. It doesn't include the directory's private keys, which
+// must be passed to ReplayEvents() separately.
+type EventLog struct {
+	EpochDeadline protocol.Timestamp
+	HashID        string
+	UseTBs        bool
+	Rand          []byte
+	Events        []*Event
+}
+
+// An eventRecorder records the randomness a directory reads from src,
+// and appends each mutation of the directory along with the randomness
+// read while processing it to log.
+// A nil eventRecorder records nothing.
+type eventRecorder struct {
+	log  *EventLog
+	src  io.Reader
+	rand []byte // read since the last recorded event
+}
+
+var _ io.Reader = (*eventRecorder)(nil)
+
+func (r *eventRecorder) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	r.rand = append(r.rand, p[:n]...)
+	return n, err
+}
+
+func (r *eventRecorder) take() []byte {
+	rand := r.rand
+	r.rand = nil
+	return rand
+}
+
+func (r *eventRecorder) record(e *Event) {
+	if r == nil {
+		return
+	}
+	e.Rand = r.take()
+	r.log.Events = append(r.log.Events, e)
+}
+
+// discard drops the randomness read while processing a mutation
+// which failed, and thus won't be recorded.
+func (r *eventRecorder) discard() {
+	if r == nil {
+		return
+	}
+	r.take()
+}
+
+// NewWithEventLog is like NewWithHasher but also records the
+// directory's mutations in an EventLog (see EventLog()).
+func NewWithEventLog(epDeadline protocol.Timestamp, vrfKey vrf.PrivateKey,
+	signKey sign.PrivateKey, dirSize uint64, useTBs bool,
+	h crypto.Hasher) *ConiksDirectory {
+	d, err := newLoggedDirectory(epDeadline, vrfKey, signKey, dirSize,
+		useTBs, h, rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// newLoggedDirectory constructs a new ConiksDirectory which records
+// its mutations, and whose randomness is read from src.
+func newLoggedDirectory(epDeadline protocol.Timestamp, vrfKey vrf.PrivateKey,
+	signKey sign.PrivateKey, dirSize uint64, useTBs bool,
+	h crypto.Hasher, src io.Reader) (*ConiksDirectory, error) {
+	rec := &eventRecorder{src: src}
+	d, err := newDirectory("", epDeadline, vrfKey, sign.KeySigner(signKey),
+		dirSize, useTBs, h, rec)
+	if err != nil {
+		return nil, err
+	}
+	rec.log = &EventLog{
+		EpochDeadline: epDeadline,
+		HashID:        h.ID(),
+		UseTBs:        useTBs,
+		Rand:          rec.take(),
+	}
+	d.events = rec
+	return d, nil
+}
+
+// EventLog returns a copy of the log of d's mutations,
+// or nil if d doesn't record its mutations (see NewWithEventLog()).
+func (d *ConiksDirectory) EventLog() *EventLog {
+	if d.events == nil {
+		return nil
+	}
+	log := *d.events.log
+	log.Events = make([]*Event, len(d.events.log.Events))
+	for i, e := range d.events.log.Events {
+		ev := *e
+		log.Events[i] = &ev
+	}
+	return &log
+}
+
+// ReplayEvents reconstructs the directory whose mutations were recorded
+// in log, using the directory's VRF key vrfKey and signing key signKey,
+// and keeping dirSize snapshots in memory. The reconstructed directory
+// issues the same STRs as the logged directory, and records its own
+// mutations, so that it can continue from where the logged directory
+// stopped. If the logged directory rotated its VRF key, rotatedKeys
+// must include the VRF keys it rotated to, in order. The
+// reconstructed directory's STRs have the logged timestamps, but it
+// doesn't timestamp the STRs it issues after the replay unless a new
+// clock is set (see SetClock()).
+// ReplayEvents() returns ErrMalformedMessage if log is malformed,
+// the error with which a logged mutation failed, or ErrReplayDiverged
+// if the replayed directory consumed different randomness than the
+// logged directory, or rotated to a different VRF key.
+func ReplayEvents(log *EventLog, vrfKey vrf.PrivateKey,
+	signKey sign.PrivateKey, dirSize uint64,
+	rotatedKeys ...vrf.PrivateKey) (*ConiksDirectory, error) {
+	if log == nil {
+		return nil, protocol.ErrMalformedMessage
+	}
+	h := crypto.GetHasher(log.HashID)
+	if h == nil {
+		return nil, protocol.ErrMalformedMessage
+	}
+	rands := [][]byte{log.Rand}
+	for _, e := range log.Events {
+		rands = append(rands, e.Rand)
+	}
+	src := io.MultiReader(bytes.NewReader(bytes.Join(rands, nil)), rand.Reader)
+	d, err := newLoggedDirectory(log.EpochDeadline, vrfKey, signKey, dirSize,
+		log.UseTBs, h, src)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(d.events.log.Rand, log.Rand) {
+		return nil, ErrReplayDiverged
+	}
+
+	for _, e := range log.Events {
+		switch e.Type {
+		case RegistrationEvent:
+			res := d.Register(&protocol.RegistrationRequest{
+				Username: e.Username, Key: e.Key, TTL: e.TTL})
+			if res.Error != protocol.ReqSuccess {
+				return nil, res.Error
+			}
+		case KeyChangeEvent:
+			if err := d.setKey(e.Username, e.Key); err != nil {
+				return nil, err
+			}
+		case PoliciesEvent:
+			if err := d.ChangePolicies(&protocol.PolicyChangeRequest{
+				EpochDeadline: e.EpochDeadline, HashID: e.HashID}); err != nil {
+				return nil, err
+			}
+		case UpdateEvent:
+			d.replayTimestamp(e.Timestamp)
+			if err := d.Update(); err != nil {
+				return nil, err
+			}
+		case VRFKeyRotationEvent:
+			if len(rotatedKeys) == 0 {
+				return nil, ErrReplayDiverged
+			}
+			d.replayTimestamp(e.Timestamp)
+			if err := d.RotateVRFKey(rotatedKeys[0]); err != nil {
+				return nil, err
+			}
+			rotatedKeys = rotatedKeys[1:]
+			if !bytes.Equal(d.policies.VrfPublicKey, e.Key) {
+				return nil, ErrReplayDiverged
+			}
+		default:
+			return nil, protocol.ErrMalformedMessage
+		}
+		events := d.events.log.Events
+		if !bytes.Equal(events[len(events)-1].Rand, e.Rand) {
+			return nil, ErrReplayDiverged
+		}
+	}
+	return d, nil
+}
+
+// replayTimestamp makes d issue its next STR with the given logged
+// timestamp, or without a timestamp if it is 0.
+func (d *ConiksDirectory) replayTimestamp(timestamp uint64) {
+	var clock func() time.Time
+	if timestamp != 0 {
+		clock = func() time.Time { return time.Unix(int64(timestamp), 0) }
+	}
+	d.SetClock(clock)
+}
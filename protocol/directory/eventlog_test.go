@@ -0,0 +1,143 @@
+package directory
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+func newTestLoggedDirectory(t *testing.T) *ConiksDirectory {
+	vrfKey := crypto.NewStaticTestVRFKey()
+	signKey := crypto.NewStaticTestSigningKey()
+	d := NewWithEventLog(1, vrfKey, signKey, 10, true, crypto.DefaultHasher)
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	d.Update()
+	d.SetPolicies(2)
+	d.Register(&protocol.RegistrationRequest{Username: "bob", Key: []byte("key")})
+	d.Register(&protocol.RegistrationRequest{Username: "bob", Key: []byte("key")}) // not logged
+	d.Register(&protocol.RegistrationRequest{Username: "carol", Key: []byte("key"), TTL: 1})
+	d.Update()
+	d.SetKey(t, "alice", []byte("key2"))
+	d.Update() // carol expires
+	return d
+}
+
+func strSignatures(t *testing.T, d *ConiksDirectory) [][]byte {
+	res := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 0, EndEpoch: d.LatestSTR().Epoch})
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Unexpected STR history error", res.Error)
+	}
+	var sigs [][]byte
+	for _, str := range res.DirectoryResponse.(*protocol.STRHistoryRange).STR {
+		sigs = append(sigs, str.Signature)
+	}
+	return sigs
+}
+
+func TestReplayEvents(t *testing.T) {
+	d := newTestLoggedDirectory(t)
+	log := d.EventLog()
+	if len(log.Events) != 8 {
+		t.Fatal("Expect 8 logged events, got", len(log.Events))
+	}
+
+	replayed, err := ReplayEvents(log, crypto.NewStaticTestVRFKey(),
+		crypto.NewStaticTestSigningKey(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, got := strSignatures(t, d), strSignatures(t, replayed)
+	if len(got) != len(expected) {
+		t.Fatal("Expect", len(expected), "STRs, got", len(got))
+	}
+	for i := range expected {
+		if !bytes.Equal(got[i], expected[i]) {
+			t.Error("Expect the same STR signature for epoch", i)
+		}
+	}
+
+	// the replayed directory continues the logged history
+	if len(replayed.EventLog().Events) != len(log.Events) {
+		t.Error("Expect the replayed directory to record the replayed events")
+	}
+	if err := replayed.Update(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayEventsDiverged(t *testing.T) {
+	d := newTestLoggedDirectory(t)
+	log := d.EventLog()
+	log.Events[0].Rand = log.Events[0].Rand[1:]
+	if _, err := ReplayEvents(log, crypto.NewStaticTestVRFKey(),
+		crypto.NewStaticTestSigningKey(), 10); err != ErrReplayDiverged {
+		t.Error("Expect", ErrReplayDiverged, "got", err)
+	}
+
+	log = d.EventLog()
+	log.HashID = "unknown"
+	if _, err := ReplayEvents(log, crypto.NewStaticTestVRFKey(),
+		crypto.NewStaticTestSigningKey(), 10); err != protocol.ErrMalformedMessage {
+		t.Error("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+
+	if NewTestDirectory(t).EventLog() != nil {
+		t.Error("Expect no event log by default")
+	}
+}
+
+func TestReplayEventsVRFKeyRotation(t *testing.T) {
+	d := newTestLoggedDirectory(t)
+	newKey, err := vrf.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RotateVRFKey(newKey); err != nil {
+		t.Fatal(err)
+	}
+	log := d.EventLog()
+
+	replayed, err := ReplayEvents(log, crypto.NewStaticTestVRFKey(),
+		crypto.NewStaticTestSigningKey(), 10, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(replayed.LatestSTR().Signature, d.LatestSTR().Signature) {
+		t.Error("Expect the same latest STR")
+	}
+
+	if _, err := ReplayEvents(log, crypto.NewStaticTestVRFKey(),
+		crypto.NewStaticTestSigningKey(), 10); err != ErrReplayDiverged {
+		t.Error("Expect", ErrReplayDiverged, "got", err)
+	}
+}
+
+func TestReplayEventsTimestamps(t *testing.T) {
+	d := newTestLoggedDirectory(t)
+	clock := time.Unix(1500000000, 0)
+	d.SetClock(func() time.Time { return clock })
+	for i := 0; i < 3; i++ {
+		clock = clock.Add(time.Minute)
+		d.Update()
+	}
+	if d.LatestSTR().Timestamp != uint64(clock.Unix()) {
+		t.Fatal("Expect the latest STR to be timestamped")
+	}
+
+	replayed, err := ReplayEvents(d.EventLog(), crypto.NewStaticTestVRFKey(),
+		crypto.NewStaticTestSigningKey(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, got := strSignatures(t, d), strSignatures(t, replayed)
+	for i := range expected {
+		if !bytes.Equal(got[i], expected[i]) {
+			t.Error("Expect the same STR signature for epoch", i)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// This module implements a sharded CONIKS key directory, which splits
+// the directory's mappings across several independent PADs so that
+// a single directory can scale to a very large number of users.
+
+package directory
+
+import (
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// A ShardedDirectory is a CONIKS key directory whose mappings are split
+// across a number of shards, each of which is a ConiksDirectory that
+// maintains its own PAD and STR history.
+// A username belongs to the shard given by the prefix of its VRF index
+// (see protocol.ShardIndex()), so all shards use the same VRF key.
+// At every epoch, the ShardedDirectory signs a protocol.ShardRoot
+// committing to the latest STR of each shard.
+type ShardedDirectory struct {
+	shards  []*ConiksDirectory
+	vrfKey  vrf.PrivateKey
+	signKey sign.PrivateKey
+	root    *protocol.ShardRoot
+}
+
+// NewSharded constructs a new ShardedDirectory with numShards shards,
+// each of which is constructed as in New() with the given parameters.
+// NewSharded() panics if numShards isn't a power of two no greater than
+// protocol.MaxShards.
+func NewSharded(numShards int, epDeadline protocol.Timestamp,
+	vrfKey vrf.PrivateKey, signKey sign.PrivateKey, dirSize uint64,
+	useTBs bool) *ShardedDirectory {
+	if !protocol.ValidShardCount(numShards) {
+		panic("[coniks] Invalid number of shards")
+	}
+	sd := &ShardedDirectory{
+		shards:  make([]*ConiksDirectory, numShards),
+		vrfKey:  vrfKey,
+		signKey: signKey,
+	}
+	for i := range sd.shards {
+		sd.shards[i] = New(epDeadline, vrfKey, signKey, dirSize, useTBs)
+	}
+	sd.signRoot()
+	return sd
+}
+
+// signRoot signs a new ShardRoot for the latest STRs of sd's shards.
+func (sd *ShardedDirectory) signRoot() {
+	root := &protocol.ShardRoot{
+		Epoch:     sd.shards[0].LatestSTR().Epoch,
+		ShardSTRs: make([][]byte, len(sd.shards)),
+	}
+	for i, d := range sd.shards {
+		root.ShardSTRs[i] = protocol.ShardSTRHash(d.LatestSTR())
+	}
+	root.Signature = sd.signKey.Sign(root.Serialize())
+	sd.root = root
+}
+
+// Update creates a new snapshot of each of sd's shards (see
+// ConiksDirectory.Update()), and signs a new ShardRoot for the new epoch.
+// If a shard fails to update, Update() returns the error without
+// signing a new ShardRoot; the shards updated before the failing shard
+// can't be rolled back, so sd must not be used afterwards.
+func (sd *ShardedDirectory) Update() error {
+	for _, d := range sd.shards {
+		if err := d.Update(); err != nil {
+			return err
+		}
+	}
+	sd.signRoot()
+	return nil
+}
+
+// NumShards returns the number of shards of sd.
+func (sd *ShardedDirectory) NumShards() int {
+	return len(sd.shards)
+}
+
+// Shard returns sd's shard with the given index.
+func (sd *ShardedDirectory) Shard(i int) *ConiksDirectory {
+	return sd.shards[i]
+}
+
+// LatestRoot returns sd's ShardRoot for the latest epoch.
+func (sd *ShardedDirectory) LatestRoot() *protocol.ShardRoot {
+	return sd.root
+}
+
+// LatestShardSTRs returns the latest STR of each of sd's shards,
+// in shard order.
+func (sd *ShardedDirectory) LatestShardSTRs() []*protocol.DirSTR {
+	strs := make([]*protocol.DirSTR, len(sd.shards))
+	for i, d := range sd.shards {
+		strs[i] = d.LatestSTR()
+	}
+	return strs
+}
+
+// ShardFor returns the index of the shard that the given username
+// belongs to.
+func (sd *ShardedDirectory) ShardFor(name string) int {
+	return protocol.ShardIndex(sd.vrfKey.Compute([]byte(name)), len(sd.shards))
+}
+
+// Register passes the RegistrationRequest req to the shard that the
+// requested username belongs to (see ConiksDirectory.Register()), and
+// returns the shard's response wrapped in a
+// message.NewShardedProof(shard, root, response).
+func (sd *ShardedDirectory) Register(req *protocol.RegistrationRequest) *protocol.Response {
+	shard := sd.ShardFor(req.Username)
+	return protocol.NewShardedProof(shard, sd.root, sd.shards[shard].Register(req))
+}
+
+// KeyLookup passes the KeyLookupRequest req to the shard that the
+// requested username belongs to (see ConiksDirectory.KeyLookup()), and
+// returns the shard's response wrapped in a
+// message.NewShardedProof(shard, root, response).
+func (sd *ShardedDirectory) KeyLookup(req *protocol.KeyLookupRequest) *protocol.Response {
+	shard := sd.ShardFor(req.Username)
+	return protocol.NewShardedProof(shard, sd.root, sd.shards[shard].KeyLookup(req))
+}
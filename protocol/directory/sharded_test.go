@@ -0,0 +1,65 @@
+package directory
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+func TestShardedDirectoryRouting(t *testing.T) {
+	sd := NewTestShardedDirectory(t, 4)
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+
+	for _, name := range []string{"alice", "bob", "carol", "dave"} {
+		res := sd.Register(&protocol.RegistrationRequest{Username: name, Key: []byte("key")})
+		if res.Error != protocol.ReqSuccess {
+			t.Fatal("Unable to register", name)
+		}
+		df := res.DirectoryResponse.(*protocol.ShardedDirectoryProof)
+		if df.Shard != sd.ShardFor(name) {
+			t.Error("Expect", name, "to be registered in shard", sd.ShardFor(name))
+		}
+		ap := df.Proof.DirectoryResponse.(*protocol.DirectoryProof).AP[0]
+		if protocol.ShardIndex(ap.LookupIndex, sd.NumShards()) != df.Shard {
+			t.Error("Expect the index of", name, "to map to its shard")
+		}
+	}
+
+	root := sd.LatestRoot()
+	sd.Update()
+	if sd.LatestRoot().Epoch != root.Epoch+1 {
+		t.Fatal("Expect a new root for the next epoch")
+	}
+	root = sd.LatestRoot()
+	if !pk.Verify(root.Serialize(), root.Signature) {
+		t.Fatal("Bad root signature")
+	}
+	for i, str := range sd.LatestShardSTRs() {
+		if !bytes.Equal(protocol.ShardSTRHash(str), root.ShardSTRs[i]) {
+			t.Error("Expect the root to commit to shard", i)
+		}
+	}
+
+	res := sd.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"})
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Unable to look up alice")
+	}
+	if err := res.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShardedDirectoryBadShardCount(t *testing.T) {
+	for _, n := range []int{0, 3, 512} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Error("Expect a panic for", n, "shards")
+				}
+			}()
+			NewTestShardedDirectory(t, n)
+		}()
+	}
+}
@@ -0,0 +1,83 @@
+// This module implements subscriptions to a CONIKS key directory's
+// STRs, which allow a directory to push its new STRs to auditors
+// instead of having them poll the directory.
+
+package directory
+
+import "github.com/coniks-sys/coniks-go/protocol"
+
+// A subscriber receives d's new STRs on strs. Its dropped channel is
+// closed if d drops the subscriber for falling behind.
+type subscriber struct {
+	strs    chan *protocol.DirSTR
+	dropped chan struct{}
+}
+
+// Subscribe registers a new subscriber to d's STRs, and returns the
+// channel strs on which the subscriber receives the new STR each time
+// Update() creates a new snapshot of d, in chronological order.
+//
+// Notifications never block d: the channel buffers up to bufSize STRs
+// that the subscriber hasn't received yet, and if the buffer is full
+// when d issues a new STR, the subscriber is dropped: d closes the
+// returned channel dropped, and then strs. So once strs is closed, a
+// subscriber can tell whether it was dropped or unsubscribed (see
+// Unsubscribe()) by whether dropped is closed. A dropped subscriber
+// can fetch the STRs it missed with a STRHistoryRequest
+// (see auditlog.CatchUp()) and subscribe again.
+// A bufSize < 1 is treated as 1, since a subscriber without a buffer
+// would be dropped by the first notification it isn't already waiting
+// for.
+// Subscribe() and Unsubscribe() may be called concurrently with
+// Update().
+func (d *ConiksDirectory) Subscribe(bufSize int) (strs <-chan *protocol.DirSTR,
+	dropped <-chan struct{}) {
+	if bufSize < 1 {
+		bufSize = 1
+	}
+	sub := &subscriber{
+		strs:    make(chan *protocol.DirSTR, bufSize),
+		dropped: make(chan struct{}),
+	}
+	d.subMu.Lock()
+	defer d.subMu.Unlock()
+	d.subscribers = append(d.subscribers, sub)
+	return sub.strs, sub.dropped
+}
+
+// Unsubscribe removes the subscriber with the given channel from d's
+// subscribers, and closes the channel. Unsubscribe() does nothing if
+// the subscriber has already been dropped.
+func (d *ConiksDirectory) Unsubscribe(strs <-chan *protocol.DirSTR) {
+	d.subMu.Lock()
+	defer d.subMu.Unlock()
+	for i, sub := range d.subscribers {
+		if sub.strs == strs {
+			d.removeSubscriber(i)
+			return
+		}
+	}
+}
+
+// removeSubscriber closes the channel of the i-th subscriber of d and
+// removes the subscriber. The caller must hold d.subMu.
+func (d *ConiksDirectory) removeSubscriber(i int) {
+	close(d.subscribers[i].strs)
+	d.subscribers = append(d.subscribers[:i], d.subscribers[i+1:]...)
+}
+
+// notify sends the STR str to each of d's subscribers, dropping any
+// subscriber whose buffer is full.
+func (d *ConiksDirectory) notify(str *protocol.DirSTR) {
+	d.subMu.Lock()
+	defer d.subMu.Unlock()
+	for i := 0; i < len(d.subscribers); {
+		select {
+		case d.subscribers[i].strs <- str:
+			i++
+		default:
+			close(d.subscribers[i].dropped)
+			d.removeSubscriber(i)
+		}
+	}
+}
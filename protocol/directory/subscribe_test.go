@@ -0,0 +1,98 @@
+package directory
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSubscribersReceiveSTRsInOrder(t *testing.T) {
+	d := NewTestDirectory(t)
+	sub1, dropped := d.Subscribe(5)
+	sub2, _ := d.Subscribe(5)
+
+	for i := 0; i < 5; i++ {
+		d.Update()
+	}
+	d.Unsubscribe(sub1)
+
+	for ep := uint64(1); ep <= 5; ep++ {
+		str := <-sub1
+		if str.Epoch != ep || !bytes.Equal(str.Signature, d.pad.GetSTR(ep).Signature) {
+			t.Fatal("Expect the STR for epoch", ep, "got", str.Epoch)
+		}
+		if str := <-sub2; str.Epoch != ep {
+			t.Fatal("Expect the STR for epoch", ep, "got", str.Epoch)
+		}
+	}
+	if _, ok := <-sub1; ok {
+		t.Fatal("Expect the channel to be closed after unsubscribing")
+	}
+	select {
+	case <-dropped:
+		t.Fatal("Expect an unsubscribed subscriber not to be dropped")
+	default:
+	}
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	d := NewTestDirectory(t)
+	slow, dropped := d.Subscribe(1)
+	fast, _ := d.Subscribe(1)
+
+	for i := 0; i < 3; i++ {
+		d.Update()
+		if str := <-fast; str.Epoch != uint64(i+1) {
+			t.Fatal("Expect the STR for epoch", i+1, "got", str.Epoch)
+		}
+	}
+
+	// the slow subscriber only received the buffered STR
+	if str, ok := <-slow; !ok || str.Epoch != 1 {
+		t.Fatal("Expect the buffered STR for epoch 1")
+	}
+	if _, ok := <-slow; ok {
+		t.Fatal("Expect the slow subscriber to be dropped")
+	}
+	select {
+	case <-dropped:
+	default:
+		t.Fatal("Expect the dropped channel to be closed")
+	}
+	if len(d.subscribers) != 1 {
+		t.Fatal("Expect only the fast subscriber to remain")
+	}
+
+	// unsubscribing a dropped subscriber does nothing
+	d.Unsubscribe(slow)
+	if len(d.subscribers) != 1 {
+		t.Fatal("Expect only the fast subscriber to remain")
+	}
+}
+
+func TestSubscribeWithoutBuffer(t *testing.T) {
+	d := NewTestDirectory(t)
+	sub, _ := d.Subscribe(0)
+	d.Update()
+	if str, ok := <-sub; !ok || str.Epoch != 1 {
+		t.Fatal("Expect an unbuffered subscriber to receive the STR for epoch 1")
+	}
+}
+
+func TestSubscribeConcurrentWithUpdate(t *testing.T) {
+	d := NewTestDirectory(t)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			d.Update()
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		sub, _ := d.Subscribe(1)
+		d.Unsubscribe(sub)
+	}
+	<-done
+	if len(d.subscribers) != 0 {
+		t.Fatal("Expect all subscribers to be removed")
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"testing"
 
 	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
 	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
 )
 
 // NewTestDirectory creates a ConiksDirectory used for testing server-side
@@ -16,3 +19,113 @@ func NewTestDirectory(t *testing.T) *ConiksDirectory {
 	d.pad = merkletree.StaticPAD(t, d.policies)
 	return d
 }
+
+// NewTestDirectoryWithHasher creates a ConiksDirectory like
+// NewTestDirectory() which links its STRs using the hash function h.
+func NewTestDirectoryWithHasher(t *testing.T, h crypto.Hasher) *ConiksDirectory {
+	vrfKey := crypto.NewStaticTestVRFKey()
+	signKey := crypto.NewStaticTestSigningKey()
+	d := NewWithHasher(1, vrfKey, signKey, 10, true, h)
+	d.pad = merkletree.StaticPAD(t, d.policies)
+	return d
+}
+
+// NewNamedTestDirectory creates a ConiksDirectory like
+// NewTestDirectory() which is named name (see NewNamed()).
+func NewNamedTestDirectory(t *testing.T, name string) *ConiksDirectory {
+	vrfKey := crypto.NewStaticTestVRFKey()
+	signKey := crypto.NewStaticTestSigningKey()
+	d := NewNamed(name, 1, vrfKey, signKey, 10, true, crypto.DefaultHasher)
+	d.pad = merkletree.StaticPAD(t, d.policies)
+	return d
+}
+
+// NewSeededTestDirectory creates a ConiksDirectory like
+// NewTestDirectory() whose randomness (i.e. the tree nonces and
+// commitment salts) is seeded with seed, so that the same sequence of
+// operations on two directories created with the same seed produces
+// byte-identical STRs. STRs don't include any timestamps, so they only
+// depend on the directory's keys, policies and randomness.
+func NewSeededTestDirectory(t *testing.T, seed int64) *ConiksDirectory {
+	d := NewTestDirectory(t)
+	d.pad = merkletree.SeededPAD(t, d.policies, seed)
+	return d
+}
+
+// ForkAt creates a ConiksDirectory for _tests_ whose history is
+// identical to the history of d up to and including the given epoch,
+// and which branches from d's state at that epoch. This allows testing
+// equivocation that occurs deep in a directory's history rather than
+// only at its latest epoch.
+// The forked directory doesn't inherit any TBs issued by d, nor the
+// expiries of d's bindings.
+func (d *ConiksDirectory) ForkAt(t *testing.T, epoch uint64) *ConiksDirectory {
+	pad := merkletree.ForkPAD(t, d.pad, epoch)
+	return &ConiksDirectory{
+		pad:      pad,
+		useTBs:   d.useTBs,
+		tbs:      make(map[string]*protocol.TemporaryBinding),
+		policies: protocol.GetPolicies(pad.LatestSTR()),
+		expiry:   make(map[string]uint64),
+	}
+}
+
+// NewTestShardedDirectory creates a ShardedDirectory with numShards
+// shards used for testing sharded server-side CONIKS operations.
+func NewTestShardedDirectory(t *testing.T, numShards int) *ShardedDirectory {
+	vrfKey := crypto.NewStaticTestVRFKey()
+	signKey := crypto.NewStaticTestSigningKey()
+	return NewSharded(numShards, 1, vrfKey, signKey, 10, true)
+}
+
+// SetKey binds name to key in the next snapshot of d for _tests_,
+// regardless of whether name is already registered. This allows testing
+// key histories, since the directory doesn't support key changes yet.
+func (d *ConiksDirectory) SetKey(t *testing.T, name string, key []byte) {
+	if err := d.setKey(name, key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// RotateVRFKeyUnannounced rotates d's VRF key to vrfKey like
+// RotateVRFKey() for _tests_, but doesn't announce the rotation in the
+// new STR's policies, as a misbehaving directory would.
+func (d *ConiksDirectory) RotateVRFKeyUnannounced(t *testing.T, vrfKey vrf.PrivateKey) {
+	vrfPublicKey, _ := vrfKey.Public()
+	p := *d.policies
+	p.VrfPublicKey = vrfPublicKey
+	if err := d.pad.RotateVRFKey(vrfKey, &p, &p); err != nil {
+		t.Fatal(err)
+	}
+	d.policies = &p
+}
+
+// RotateSigningKeyUnannounced issues a new STR which is signed with
+// signer and declares the signature scheme signatureID for _tests_,
+// without announcing the change in the previous STR's policies, as a
+// misbehaving directory would. An empty signatureID keeps the
+// directory's signature scheme.
+func (d *ConiksDirectory) RotateSigningKeyUnannounced(t *testing.T,
+	signer sign.Signer, signatureID string) {
+	p := *d.policies
+	if signatureID != "" {
+		p.SignatureID = signatureID
+	}
+	d.pad.SetSigner(signer)
+	if err := d.pad.UpdateAnnounced(&p, &p); err != nil {
+		t.Fatal(err)
+	}
+	d.policies = &p
+}
+
+// ChangePoliciesUnannounced issues a new STR whose policies use the
+// epoch deadline epDeadline for _tests_, without announcing the change
+// in the previous STR's policies, as a misbehaving directory would.
+func (d *ConiksDirectory) ChangePoliciesUnannounced(t *testing.T, epDeadline protocol.Timestamp) {
+	p := *d.policies
+	p.EpochDeadline = epDeadline
+	if err := d.pad.UpdateAnnounced(&p, &p); err != nil {
+		t.Fatal(err)
+	}
+	d.policies = &p
+}
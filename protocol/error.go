@@ -38,6 +38,17 @@ const (
 	CheckBadSTR
 	CheckBadPromise
 	CheckBrokenPromise
+	CheckNoQuorum
+	CheckWrongShard
+	CheckBadChallenge
+	CheckUnconfirmedSTR
+	CheckBadVRFKeyChange
+	CheckBadPolicyChange
+	CheckImplausibleCadence
+	CheckBadSigningKeyChange
+	CheckContradictoryProofs
+	CheckIndexMoved
+	CheckTombstonedBinding
 )
 
 // errors contains codes indicating the client
@@ -61,15 +72,26 @@ var (
 		ErrDirectory:        "[coniks] Directory error",
 		ErrAuditLog:         "[coniks] Audit log error",
 
-		CheckBadSignature:   "[coniks] Directory's signature on STR or TB is invalid",
-		CheckBadVRFProof:    "[coniks] Returned index is not valid for the given name",
-		CheckBindingsDiffer: "[coniks] The key in the binding is inconsistent with our expectation",
-		CheckBadCommitment:  "[coniks] The name-to-key binding commitment is not verifiable",
-		CheckBadLookupIndex: "[coniks] The lookup index is inconsistent with the index of the proof node",
-		CheckBadAuthPath:    "[coniks] Returned binding is inconsistent with the tree root hash",
-		CheckBadSTR:         "[coniks] The hash chain is inconsistent",
-		CheckBadPromise:     "[coniks] The directory returned an invalid registration promise",
-		CheckBrokenPromise:  "[coniks] The directory broke the registration promise",
+		CheckBadSignature:        "[coniks] Directory's signature on STR or TB is invalid",
+		CheckBadVRFProof:         "[coniks] Returned index is not valid for the given name",
+		CheckBindingsDiffer:      "[coniks] The key in the binding is inconsistent with our expectation",
+		CheckBadCommitment:       "[coniks] The name-to-key binding commitment is not verifiable",
+		CheckBadLookupIndex:      "[coniks] The lookup index is inconsistent with the index of the proof node",
+		CheckBadAuthPath:         "[coniks] Returned binding is inconsistent with the tree root hash",
+		CheckBadSTR:              "[coniks] The hash chain is inconsistent",
+		CheckBadPromise:          "[coniks] The directory returned an invalid registration promise",
+		CheckBrokenPromise:       "[coniks] The directory broke the registration promise",
+		CheckNoQuorum:            "[coniks] Not enough auditors agree with the client's view",
+		CheckWrongShard:          "[coniks] The proof is from a shard the name doesn't belong to",
+		CheckBadChallenge:        "[coniks] The STR challenge response doesn't match the challenge",
+		CheckUnconfirmedSTR:      "[coniks] The STR hasn't been confirmed by an auditor",
+		CheckBadVRFKeyChange:     "[coniks] The STR changes the VRF key without announcing the rotation",
+		CheckBadPolicyChange:     "[coniks] The STR changes the directory's policies without announcing the change",
+		CheckImplausibleCadence:  "[coniks] The directory's epochs advance implausibly fast or slow for its epoch deadline",
+		CheckBadSigningKeyChange: "[coniks] The STR changes the signing key or algorithm without announcing the change",
+		CheckContradictoryProofs: "[coniks] The directory proved both the presence and the absence of a name in the same epoch",
+		CheckIndexMoved:          "[coniks] The lookup index of a name changed without an announced VRF key rotation",
+		CheckTombstonedBinding:   "[coniks] The name's binding was replaced with a tombstone",
 	}
 )
 
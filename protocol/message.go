@@ -5,6 +5,9 @@
 package protocol
 
 import (
+	"bytes"
+	"sort"
+
 	"github.com/coniks-sys/coniks-go/crypto"
 	"github.com/coniks-sys/coniks-go/merkletree"
 )
@@ -17,6 +20,11 @@ const (
 	MonitoringType
 	AuditType
 	STRType
+	BatchKeyLookupType
+	KeyHistoryType
+	STRChallengeType
+	KeyDeltaType
+	BatchMultiProofType
 )
 
 // A Request message defines the data a CONIKS client must send to a CONIKS
@@ -32,14 +40,20 @@ type Request struct {
 // Optionally, the client can include the user's key
 // change and visibility policies as boolean values in the
 // request. These flags are currently unused by the CONIKS protocols.
+// If TTL is non-zero, the binding expires TTL epochs after it's included
+// in the directory, i.e. the directory replaces the key with a
+// TombstoneExpired tombstone (see NewTombstone()). The username of an
+// expired binding can't be registered again. A binding whose expiry
+// epoch would exceed math.MaxUint64 never expires.
 //
 // The response to a successful request is a DirectoryProof with a TB for
 // the requested username and public key.
 type RegistrationRequest struct {
 	Username               string
 	Key                    []byte
-	AllowUnsignedKeychange bool `json:",omitempty"`
-	AllowPublicLookup      bool `json:",omitempty"`
+	AllowUnsignedKeychange bool   `json:",omitempty"`
+	AllowPublicLookup      bool   `json:",omitempty"`
+	TTL                    uint64 `json:",omitempty"`
 }
 
 // A KeyLookupRequest is a message with a username as a string
@@ -51,8 +65,74 @@ type RegistrationRequest struct {
 // The response to a successful request is a DirectoryProof with a TB if
 // the requested username was registered during the latest epoch (i.e.
 // the new binding hasn't been committed to the directory).
+// If CompressProof is set, the directory omits the empty siblings from
+// the proof's authentication path, which makes the proof considerably
+// smaller in a sparse tree (see merkletree.AuthenticationPath.Compress()).
 type KeyLookupRequest struct {
-	Username string
+	Username      string
+	CompressProof bool `json:",omitempty"`
+}
+
+// A BatchKeyLookupRequest is a message with a list of usernames as
+// strings that a CONIKS client sends to a CONIKS directory to retrieve
+// the public keys bound to all of the given usernames at the latest epoch.
+// A client sends this request type instead of issuing one
+// KeyLookupRequest per username when it needs to look up many users
+// at once (e.g. to reconcile an address book).
+//
+// The response to a successful request is a BatchDirectoryProof with
+// one KeyLookupRequest response per requested username, in the
+// same order as Usernames.
+// If the client sends the request with BatchMultiProofType instead,
+// the response is a BatchMultiProof for the same usernames.
+type BatchKeyLookupRequest struct {
+	Usernames []string
+}
+
+// A KeyHistoryRequest is a message with a username as a string and the
+// start and end epochs of an epoch range as two uint64 that a CONIKS
+// client (or auditor) sends to the directory to retrieve the sequence of
+// keys bound to the username over the given epoch range. An end epoch
+// with a value greater than the key directory's latest epoch sets the
+// end of the epoch range at the directory's latest epoch.
+//
+// The response to a successful request is a DirectoryProof with one
+// auth path and one STR per epoch in the range. The auth path is a proof
+// of absence for each epoch in which the username had no binding, e.g.
+// before it was registered.
+type KeyHistoryRequest struct {
+	Username   string
+	StartEpoch uint64
+	EndEpoch   uint64
+}
+
+// A KeyDeltaRequest is a message with a username as a string and the
+// start and end epochs of an epoch range as two uint64 that a CONIKS
+// client sends to the directory to check whether the username's binding
+// changed over the given epoch range, without retrieving a proof for
+// each epoch (see KeyHistoryRequest). An end epoch with a value greater
+// than the key directory's latest epoch sets the end of the epoch range
+// at the directory's latest epoch.
+//
+// The response to a successful request is a DirectoryProof with two
+// auth paths for the start and end epochs, and one STR per epoch in
+// the range.
+type KeyDeltaRequest struct {
+	Username   string
+	StartEpoch uint64
+	EndEpoch   uint64
+}
+
+// An STRChallengeRequest is a message with a client-chosen Nonce as
+// bytes that a CONIKS client sends to a CONIKS directory to obtain the
+// directory's latest STR along with a proof of its freshness, i.e. that
+// the directory issued the STR as its latest STR after receiving the
+// nonce, rather than an attacker replaying an older signed STR.
+// The nonce must be non-empty and should be unpredictable.
+//
+// The response to a successful request is an STRChallengeResponse.
+type STRChallengeRequest struct {
+	Nonce []byte
 }
 
 // A KeyLookupInEpochRequest is a message with a username as a string and
@@ -103,16 +183,48 @@ type MonitoringRequest struct {
 //
 // The response to a successful request is an STRHistoryRange with
 // a list of STRs covering the epoch range [StartEpoch, EndEpoch].
+//
+// A client that only knows the hash of an STR it pinned (see
+// DirSTR.Hash()) can instead set SinceSTRHash to request all STRs
+// newer than the pinned STR; StartEpoch and EndEpoch are then ignored.
+//
+// A client can set a non-zero Limit to receive at most Limit STRs.
+// If the auditor truncates the range, the response's NextToken
+// continues it: the client requests the next page by sending the
+// token as the PageToken of its next request, along with the same
+// DirInitSTRHash and any Limit. StartEpoch, EndEpoch and SinceSTRHash
+// are ignored in a request with a PageToken.
 type AuditingRequest struct {
 	DirInitSTRHash [crypto.HashSizeByte]byte
 	StartEpoch     uint64
 	EndEpoch       uint64
+	SinceSTRHash   [crypto.HashSizeByte]byte
+	Limit          uint64 `json:",omitempty"`
+	PageToken      []byte `json:",omitempty"`
+}
+
+// Validate returns ErrMalformedMessage if req is nil, if req doesn't
+// identify a directory (i.e. has a zero DirInitSTRHash), or if req
+// sets neither SinceSTRHash nor PageToken and has a StartEpoch greater
+// than its EndEpoch. Otherwise, it returns nil.
+// Validate() doesn't check the epoch range against the directory's
+// history, which only the auditor knows.
+func (req *AuditingRequest) Validate() error {
+	if req == nil || req.DirInitSTRHash == [crypto.HashSizeByte]byte{} {
+		return ErrMalformedMessage
+	}
+	if req.SinceSTRHash == [crypto.HashSizeByte]byte{} &&
+		len(req.PageToken) == 0 && req.StartEpoch > req.EndEpoch {
+		return ErrMalformedMessage
+	}
+	return nil
 }
 
 // An STRHistoryRequest is a message with a StartEpoch and optional EndEpoch
-// of an epoch range as two uint64's that a CONIKS auditor
+// of an epoch range as two uint64's that a CONIKS auditor or client
 // sends to a directory to retrieve a range of STRs starting at epoch
-// StartEpoch.
+// StartEpoch, e.g. so that a client which was offline can catch up
+// with the directory.
 //
 // The response to a successful request is an STRHistoryRange with
 // a list of STRs covering the epoch range [StartEpoch, EndEpoch],
@@ -122,6 +234,12 @@ type STRHistoryRequest struct {
 	EndEpoch   uint64
 }
 
+// MaxSTRHistoryRangeLen is the maximum number of STRs a CONIKS client or
+// auditor accepts in an STRHistoryRange, regardless of the epoch range
+// it requested, so that an unsolicited or oversized range can't exhaust
+// its memory. Applications may adjust it to their needs.
+var MaxSTRHistoryRangeLen = 1 << 16
+
 // A Response message indicates the result of a CONIKS client request
 // with an appropriate error code, and defines the set of cryptographic
 // proofs a CONIKS directory must return as part of its response.
@@ -139,20 +257,107 @@ type DirectoryResponse interface{}
 // AP for a given username-to-key binding in the directory and a list of
 // signed tree roots STR for a range of epochs, and optionally
 // a temporary binding for the given binding for a single epoch.
+// A DirectoryProof, like every response, must not contain any maps, so
+// that its encoding is canonical (see application.MarshalResponse()).
 type DirectoryProof struct {
 	AP  []*merkletree.AuthenticationPath
 	STR []*DirSTR
 	TB  *TemporaryBinding `json:",omitempty"`
 }
 
+// A BatchDirectoryProof response includes, for each username in a
+// BatchKeyLookupRequest, the response the directory would have returned
+// for an individual KeyLookupRequest for that username.
+// Each response carries its own error code and DirectoryProof,
+// and all DirectoryProofs share the same signed tree root STR
+// for the latest epoch.
+type BatchDirectoryProof struct {
+	Proofs []*Response
+}
+
+// A BatchMultiProof response is a compact encoding of a
+// BatchDirectoryProof, in which the authentication paths for all
+// usernames in a BatchKeyLookupRequest are combined into a single
+// merkletree.MultiProof Proof, and the shared signed tree root STR
+// for the latest epoch is included only once. For the i-th username,
+// Errors[i] is the error code of its KeyLookupRequest response,
+// Proof.Paths[i] its authentication path, and TB[i] its temporary
+// binding, if any.
+type BatchMultiProof struct {
+	Errors []ErrorCode
+	Proof  *merkletree.MultiProof
+	STR    *DirSTR
+	TB     []*TemporaryBinding
+}
+
+// Responses expands b into one KeyLookupRequest response per username,
+// in the same order as in the BatchKeyLookupRequest. Each response's
+// DirectoryProof includes the username's authentication path without
+// its sibling hashes, so its root must be verified using
+// b.Proof.VerifyRoot() rather than AuthenticationPath.Verify().
+// b must be validated (see Validate()).
+func (b *BatchMultiProof) Responses() []*Response {
+	proofs := make([]*Response, len(b.Errors))
+	for i, e := range b.Errors {
+		proofs[i] = &Response{
+			Error: e,
+			DirectoryResponse: &DirectoryProof{
+				AP:  []*merkletree.AuthenticationPath{b.Proof.Paths[i]},
+				STR: []*DirSTR{b.STR},
+				TB:  b.TB[i],
+			},
+		}
+	}
+	return proofs
+}
+
+// A ShardedDirectoryProof response includes the response Proof of a
+// single shard of a sharded CONIKS directory to a RegistrationRequest or
+// KeyLookupRequest, the index of that Shard, and the ShardRoot
+// committing to the shard's STR in the Proof.
+type ShardedDirectoryProof struct {
+	Shard int
+	Root  *ShardRoot
+	Proof *Response
+}
+
 // An STRHistoryRange response includes a list of signed tree roots
 // STR representing a range of the STR hash chain. If the range only
 // covers the latest epoch, the list only contains a single STR.
 // A CONIKS auditor returns this DirectoryResponse type upon an
 // AuditingRequest from a client, and a CONIKS directory returns
 // this message upon an STRHistoryRequest from an auditor.
+// If an auditor truncated the range to the Limit of an
+// AuditingRequest, NextToken is the opaque token with which the
+// client can request the rest of the range (see AuditingRequest).
 type STRHistoryRange struct {
-	STR []*DirSTR
+	STR       []*DirSTR
+	NextToken []byte `json:",omitempty"`
+}
+
+// An STRChallengeResponse includes the directory's latest signed tree
+// root STR and the client's Nonce, along with the directory's Signature
+// binding the nonce to the STR (see Serialize()).
+// A CONIKS directory returns this DirectoryResponse type upon an
+// STRChallengeRequest.
+type STRChallengeResponse struct {
+	STR       *DirSTR
+	Nonce     []byte
+	Signature []byte
+}
+
+// strChallengePrefix separates the signatures on STR challenges from
+// the directory's other signatures, e.g. on TBs.
+var strChallengePrefix = []byte("coniks-str-challenge")
+
+// Serialize serializes the STR challenge response for signing, binding
+// the Nonce to the signature of the STR.
+func (c *STRChallengeResponse) Serialize() []byte {
+	var bs []byte
+	bs = append(bs, strChallengePrefix...)
+	bs = append(bs, c.STR.Signature...)
+	bs = append(bs, c.Nonce...)
+	return bs
 }
 
 // NewErrorResponse creates a new response message indicating the error
@@ -162,8 +367,44 @@ func NewErrorResponse(e ErrorCode) *Response {
 	return &Response{Error: e}
 }
 
+// NewOrderedSTRHistoryRange is like NewSTRHistoryRange but normalizes
+// the passed list of STRs str (which won't be mutated) into a
+// well-formed range: the STRs are sorted by epoch, and duplicates of
+// the same STR are dropped. It returns ErrMalformedMessage if str
+// contains a nil STR, and CheckBadSTR if it contains two different
+// STRs (i.e. with different signatures) for the same epoch.
+// NewOrderedSTRHistoryRange doesn't check that the range is contiguous.
+func NewOrderedSTRHistoryRange(str []*DirSTR) (*Response, error) {
+	sorted := make([]*DirSTR, 0, len(str))
+	for _, s := range str {
+		if s == nil || s.SignedTreeRoot == nil {
+			return nil, ErrMalformedMessage
+		}
+		sorted = append(sorted, s)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Epoch < sorted[j].Epoch
+	})
+
+	var deduped []*DirSTR
+	for _, s := range sorted {
+		if n := len(deduped); n > 0 && deduped[n-1].Epoch == s.Epoch {
+			if !bytes.Equal(deduped[n-1].Signature, s.Signature) {
+				return nil, CheckBadSTR
+			}
+			continue
+		}
+		deduped = append(deduped, s)
+	}
+	return NewSTRHistoryRange(deduped), nil
+}
+
 var _ DirectoryResponse = (*DirectoryProof)(nil)
+var _ DirectoryResponse = (*BatchDirectoryProof)(nil)
+var _ DirectoryResponse = (*BatchMultiProof)(nil)
+var _ DirectoryResponse = (*ShardedDirectoryProof)(nil)
 var _ DirectoryResponse = (*STRHistoryRange)(nil)
+var _ DirectoryResponse = (*STRChallengeResponse)(nil)
 
 // NewRegistrationProof creates the response message a CONIKS directory
 // sends to a client upon a RegistrationRequest,
@@ -209,6 +450,59 @@ func NewKeyLookupProof(ap *merkletree.AuthenticationPath, str *DirSTR,
 	}
 }
 
+// NewBatchKeyLookupProof creates the response message a CONIKS directory
+// sends to a client upon a BatchKeyLookupRequest,
+// and returns a Response containing a BatchDirectoryProof struct.
+// directory.BatchKeyLookup() passes the list of key lookup responses
+// proofs, one for each requested username.
+//
+// See directory.BatchKeyLookup() for details on the contents of the created
+// BatchDirectoryProof.
+func NewBatchKeyLookupProof(proofs []*Response) *Response {
+	return &Response{
+		Error: ReqSuccess,
+		DirectoryResponse: &BatchDirectoryProof{
+			Proofs: proofs,
+		},
+	}
+}
+
+// NewBatchKeyLookupMultiProof creates the response message a CONIKS
+// directory sends to a client upon a BatchKeyLookupRequest of type
+// BatchMultiProofType, and returns a Response containing a
+// BatchMultiProof struct.
+// directory.BatchKeyLookupMultiProof() passes the list of key lookup
+// responses proofs, one for each requested username, which must all
+// share the same STR. NewBatchKeyLookupMultiProof() returns a
+// NewErrorResponse(ErrDirectory) if the proofs' authentication paths
+// can't be combined into a merkletree.MultiProof.
+//
+// See directory.BatchKeyLookupMultiProof() for details on the contents
+// of the created BatchMultiProof.
+func NewBatchKeyLookupMultiProof(proofs []*Response) *Response {
+	b := &BatchMultiProof{}
+	var aps []*merkletree.AuthenticationPath
+	for _, p := range proofs {
+		df, ok := p.DirectoryResponse.(*DirectoryProof)
+		if !ok || len(df.AP) != 1 || len(df.STR) != 1 {
+			return NewErrorResponse(ErrDirectory)
+		}
+		b.Errors = append(b.Errors, p.Error)
+		b.TB = append(b.TB, df.TB)
+		b.STR = df.STR[0]
+		aps = append(aps, df.AP[0])
+	}
+	mp, err := merkletree.NewMultiProof(aps)
+	if err != nil {
+		return NewErrorResponse(ErrDirectory)
+	}
+	b.Proof = mp
+	return &Response{
+		Error:             ReqSuccess,
+		DirectoryResponse: b,
+	}
+}
+
 // NewKeyLookupInEpochProof creates the response message a CONIKS directory
 // sends to a client upon a KeyLookupRequest,
 // and returns a Response containing a DirectoryProofs struct.
@@ -249,6 +543,84 @@ func NewMonitoringProof(ap []*merkletree.AuthenticationPath,
 	}
 }
 
+// NewKeyHistoryProof creates the response message a CONIKS directory
+// sends to a client upon a KeyHistoryRequest,
+// and returns a Response containing a DirectoryProof struct.
+// directory.KeyHistory() passes a list of authentication paths ap and a
+// list of signed tree roots str, one for each epoch of the requested range.
+//
+// See directory.KeyHistory() for details on the contents of the created
+// DirectoryProof.
+func NewKeyHistoryProof(ap []*merkletree.AuthenticationPath,
+	str []*DirSTR) *Response {
+	return &Response{
+		Error: ReqSuccess,
+		DirectoryResponse: &DirectoryProof{
+			AP:  ap,
+			STR: str,
+		},
+	}
+}
+
+// NewKeyDeltaProof creates the response message a CONIKS directory
+// sends to a client upon a KeyDeltaRequest,
+// and returns a Response containing a DirectoryProof struct.
+// directory.KeyDelta() passes the authentication paths startAP and endAP
+// for the start and end epochs of the requested range, and a list of
+// signed tree roots str, one for each epoch of the range.
+//
+// See directory.KeyDelta() for details on the contents of the created
+// DirectoryProof.
+func NewKeyDeltaProof(startAP, endAP *merkletree.AuthenticationPath,
+	str []*DirSTR) *Response {
+	return &Response{
+		Error: ReqSuccess,
+		DirectoryResponse: &DirectoryProof{
+			AP:  []*merkletree.AuthenticationPath{startAP, endAP},
+			STR: str,
+		},
+	}
+}
+
+// NewSTRChallengeResponse creates the response message a CONIKS
+// directory sends to a client upon an STRChallengeRequest,
+// and returns a Response containing an STRChallengeResponse struct.
+// directory.STRChallenge() passes its latest signed tree root str,
+// the client's nonce and its signature sig on the serialized
+// STRChallengeResponse.
+func NewSTRChallengeResponse(str *DirSTR, nonce, sig []byte) *Response {
+	return &Response{
+		Error: ReqSuccess,
+		DirectoryResponse: &STRChallengeResponse{
+			STR:       str,
+			Nonce:     nonce,
+			Signature: sig,
+		},
+	}
+}
+
+// NewShardedProof creates the response message a sharded CONIKS
+// directory sends to a client upon a RegistrationRequest or
+// KeyLookupRequest, and returns a Response containing a
+// ShardedDirectoryProof struct.
+// directory.ShardedDirectory passes the response proof of the shard
+// with the index shard that handled the request,
+// and the directory's latest ShardRoot root.
+// If proof indicates an error, NewShardedProof() returns proof as is.
+func NewShardedProof(shard int, root *ShardRoot, proof *Response) *Response {
+	if errors[proof.Error] {
+		return proof
+	}
+	return &Response{
+		Error: proof.Error,
+		DirectoryResponse: &ShardedDirectoryProof{
+			Shard: shard,
+			Root:  root,
+			Proof: proof,
+		},
+	}
+}
+
 // NewSTRHistoryRange creates the response message a CONIKS auditor
 // sends to a client upon an AuditingRequest,
 // and returns a Response containing an STRHistoryRange struct.
@@ -267,8 +639,17 @@ func NewSTRHistoryRange(str []*DirSTR) *Response {
 }
 
 // Validate returns immediately if the message includes an error code.
-// Otherwise, it verifies whether the message has proper format.
+// Otherwise, it verifies whether the message has proper format,
+// i.e. that the message contains a known DirectoryResponse type
+// and that all of its required fields are present:
+// every STR must be non-nil and carry a signature and policies,
+// an STRHistoryRange must contain at most MaxSTRHistoryRangeLen STRs,
+// and every authentication path must be non-nil and include a leaf.
+// Validate() returns ErrMalformedMessage if any of these checks fail.
 func (msg *Response) Validate() error {
+	if msg == nil {
+		return ErrMalformedMessage
+	}
 	if errors[msg.Error] {
 		return msg.Error
 	}
@@ -278,18 +659,116 @@ func (msg *Response) Validate() error {
 	}
 	switch df := msg.DirectoryResponse.(type) {
 	case *DirectoryProof:
-		if len(df.STR) == 0 || len(df.AP) == 0 {
+		if df == nil || len(df.STR) == 0 || len(df.AP) == 0 {
+			return ErrMalformedMessage
+		}
+		for _, ap := range df.AP {
+			if ap == nil || ap.Leaf == nil {
+				return ErrMalformedMessage
+			}
+		}
+		return validateSTRs(df.STR)
+	case *BatchDirectoryProof:
+		if df == nil || len(df.Proofs) == 0 {
 			return ErrMalformedMessage
 		}
+		var str *DirSTR
+		for _, p := range df.Proofs {
+			if err := p.ValidateFor(KeyLookupType); err != nil {
+				return err
+			}
+			proof := p.DirectoryResponse.(*DirectoryProof)
+			// all proofs must share the same STR, i.e., not only its
+			// signature but also the signed contents, since the client
+			// verifies only the first one
+			if str == nil {
+				str = proof.STR[0]
+			} else if !bytes.Equal(str.Signature, proof.STR[0].Signature) ||
+				!bytes.Equal(str.Serialize(), proof.STR[0].Serialize()) {
+				return ErrMalformedMessage
+			}
+		}
 		return nil
-	case *STRHistoryRange:
-		if len(df.STR) == 0 {
+	case *BatchMultiProof:
+		if df == nil || df.Proof == nil || len(df.Errors) == 0 ||
+			len(df.Proof.Paths) != len(df.Errors) || len(df.TB) != len(df.Errors) {
+			return ErrMalformedMessage
+		}
+		for i, ap := range df.Proof.Paths {
+			if ap == nil || ap.Leaf == nil || errors[df.Errors[i]] {
+				return ErrMalformedMessage
+			}
+		}
+		return validateSTRs([]*DirSTR{df.STR})
+	case *ShardedDirectoryProof:
+		if df == nil || df.Root == nil || len(df.Root.Signature) == 0 ||
+			df.Shard < 0 || df.Shard >= len(df.Root.ShardSTRs) {
+			return ErrMalformedMessage
+		}
+		if err := df.Proof.ValidateFor(KeyLookupType); err != nil {
+			return err
+		}
+		if df.Proof.Error != msg.Error {
 			return ErrMalformedMessage
 		}
 		return nil
+	case *STRHistoryRange:
+		if df == nil || len(df.STR) == 0 || len(df.STR) > MaxSTRHistoryRangeLen {
+			return ErrMalformedMessage
+		}
+		return validateSTRs(df.STR)
+	case *STRChallengeResponse:
+		if df == nil || len(df.Nonce) == 0 || len(df.Signature) == 0 {
+			return ErrMalformedMessage
+		}
+		return validateSTRs([]*DirSTR{df.STR})
 	default:
-		panic("[coniks] Malformed response")
+		return ErrMalformedMessage
+	}
+}
+
+// ValidateFor validates the message as Validate() does, and additionally
+// checks that the concrete type of the message's DirectoryResponse is
+// the one expected in a response to a request of type requestType.
+// ValidateFor() returns ErrMalformedMessage if the types don't match,
+// or if requestType isn't a valid/known request type.
+// Callers should use ValidateFor() before type-asserting the
+// message's DirectoryResponse.
+func (msg *Response) ValidateFor(requestType int) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	var ok bool
+	switch requestType {
+	case RegistrationType, KeyLookupType, KeyLookupInEpochType, MonitoringType,
+		KeyHistoryType, KeyDeltaType:
+		_, ok = msg.DirectoryResponse.(*DirectoryProof)
+	case BatchKeyLookupType:
+		_, ok = msg.DirectoryResponse.(*BatchDirectoryProof)
+	case BatchMultiProofType:
+		_, ok = msg.DirectoryResponse.(*BatchMultiProof)
+	case AuditType, STRType:
+		_, ok = msg.DirectoryResponse.(*STRHistoryRange)
+	case STRChallengeType:
+		_, ok = msg.DirectoryResponse.(*STRChallengeResponse)
+	}
+	if !ok {
+		return ErrMalformedMessage
+	}
+	return nil
+}
+
+// validateSTRs checks that each STR in strs has all of its
+// required fields.
+func validateSTRs(strs []*DirSTR) error {
+	for _, str := range strs {
+		if str == nil || str.SignedTreeRoot == nil ||
+			len(str.Signature) == 0 || str.Policies == nil ||
+			str.Policies.Hasher() == nil {
+			return ErrMalformedMessage
+		}
 	}
+	return nil
 }
 
 // GetKey returns the key extracted from
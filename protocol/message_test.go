@@ -0,0 +1,155 @@
+package protocol
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/merkletree"
+)
+
+func newTestSTR(t *testing.T) *DirSTR {
+	vrfKey := crypto.NewStaticTestVRFKey()
+	signKey := crypto.NewStaticTestSigningKey()
+	vrfPublicKey, _ := vrfKey.Public()
+	pad, err := merkletree.NewPAD(NewPolicies(1, vrfPublicKey), signKey, vrfKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewDirSTR(pad.LatestSTR())
+}
+
+func TestValidateMalformedResponses(t *testing.T) {
+	str := newTestSTR(t)
+	ap := &merkletree.AuthenticationPath{Leaf: &merkletree.ProofNode{}}
+	noSig := &DirSTR{
+		SignedTreeRoot: &merkletree.SignedTreeRoot{Epoch: 1},
+		Policies:       str.Policies,
+	}
+	noPolicies := &DirSTR{SignedTreeRoot: str.SignedTreeRoot}
+	root := &ShardRoot{ShardSTRs: [][]byte{ShardSTRHash(str)}, Signature: []byte{1}}
+
+	for _, tc := range []struct {
+		name string
+		msg  *Response
+		want error
+	}{
+		{"nil response", nil, ErrMalformedMessage},
+		{"error code", NewErrorResponse(ErrDirectory), ErrDirectory},
+		{"nil directory response", &Response{Error: ReqSuccess}, ErrMalformedMessage},
+		{"unknown directory response", &Response{Error: ReqSuccess,
+			DirectoryResponse: "foo"}, ErrMalformedMessage},
+		{"nil directory proof", &Response{Error: ReqSuccess,
+			DirectoryResponse: (*DirectoryProof)(nil)}, ErrMalformedMessage},
+		{"nil STR history range", &Response{Error: ReqSuccess,
+			DirectoryResponse: (*STRHistoryRange)(nil)}, ErrMalformedMessage},
+		{"empty STRs", NewSTRHistoryRange(nil), ErrMalformedMessage},
+		{"nil STR", NewSTRHistoryRange([]*DirSTR{str, nil}), ErrMalformedMessage},
+		{"nil tree root", NewSTRHistoryRange([]*DirSTR{{}}), ErrMalformedMessage},
+		{"nil signature", NewSTRHistoryRange([]*DirSTR{noSig}), ErrMalformedMessage},
+		{"nil policies", NewSTRHistoryRange([]*DirSTR{noPolicies}), ErrMalformedMessage},
+		{"empty APs", NewMonitoringProof(nil, []*DirSTR{str}), ErrMalformedMessage},
+		{"nil AP", NewKeyLookupProof(nil, str, nil, ReqSuccess), ErrMalformedMessage},
+		{"nil leaf", NewKeyLookupProof(&merkletree.AuthenticationPath{}, str,
+			nil, ReqSuccess), ErrMalformedMessage},
+		{"proof with bad STR", NewKeyLookupProof(ap, noSig, nil, ReqSuccess), ErrMalformedMessage},
+		{"empty batch", NewBatchKeyLookupProof(nil), ErrMalformedMessage},
+		{"batch with nil proof", NewBatchKeyLookupProof([]*Response{nil}), ErrMalformedMessage},
+		{"batch with wrong type", NewBatchKeyLookupProof([]*Response{
+			NewSTRHistoryRange([]*DirSTR{str})}), ErrMalformedMessage},
+		{"sharded proof without root", NewShardedProof(0, nil,
+			NewKeyLookupProof(ap, str, nil, ReqSuccess)), ErrMalformedMessage},
+		{"sharded proof out of range", NewShardedProof(1, root,
+			NewKeyLookupProof(ap, str, nil, ReqSuccess)), ErrMalformedMessage},
+		{"sharded proof with wrong type", NewShardedProof(0, root,
+			NewSTRHistoryRange([]*DirSTR{str})), ErrMalformedMessage},
+		{"valid proof", NewKeyLookupProof(ap, str, nil, ReqSuccess), nil},
+		{"valid sharded proof", NewShardedProof(0, root,
+			NewKeyLookupProof(ap, str, nil, ReqSuccess)), nil},
+		{"valid range", NewSTRHistoryRange([]*DirSTR{str}), nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.msg.Validate(); err != tc.want {
+				t.Errorf("Validate() = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateForMismatchedTypes(t *testing.T) {
+	str := newTestSTR(t)
+	ap := &merkletree.AuthenticationPath{Leaf: &merkletree.ProofNode{}}
+	proof := NewKeyLookupProof(ap, str, nil, ReqSuccess)
+	strs := NewSTRHistoryRange([]*DirSTR{str})
+	batch := NewBatchKeyLookupProof([]*Response{proof})
+
+	for _, tc := range []struct {
+		name        string
+		msg         *Response
+		requestType int
+		want        error
+	}{
+		{"proof for lookup", proof, KeyLookupType, nil},
+		{"proof for audit", proof, AuditType, ErrMalformedMessage},
+		{"proof for batch lookup", proof, BatchKeyLookupType, ErrMalformedMessage},
+		{"range for audit", strs, AuditType, nil},
+		{"range for STR history", strs, STRType, nil},
+		{"range for registration", strs, RegistrationType, ErrMalformedMessage},
+		{"batch for batch lookup", batch, BatchKeyLookupType, nil},
+		{"batch for monitoring", batch, MonitoringType, ErrMalformedMessage},
+		{"unknown request type", strs, -1, ErrMalformedMessage},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.msg.ValidateFor(tc.requestType); err != tc.want {
+				t.Errorf("ValidateFor() = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewOrderedSTRHistoryRange(t *testing.T) {
+	strs := newTestHistory(t, 4)
+	shuffled := []*DirSTR{strs[3], strs[0], strs[4], strs[1], strs[3], strs[2]}
+
+	res, err := NewOrderedSTRHistoryRange(shuffled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := res.DirectoryResponse.(*STRHistoryRange).STR
+	if !reflect.DeepEqual(got, strs) {
+		t.Fatal("Expect the STRs to be sorted by epoch without duplicates")
+	}
+	if shuffled[0] != strs[3] {
+		t.Fatal("Expect the passed STRs not to be mutated")
+	}
+}
+
+func TestNewOrderedSTRHistoryRangeConflict(t *testing.T) {
+	strs := newTestHistory(t, 2)
+	conflict := &DirSTR{
+		SignedTreeRoot: &merkletree.SignedTreeRoot{
+			Epoch:     strs[1].Epoch,
+			Signature: []byte{1},
+		},
+		Policies: strs[1].Policies,
+	}
+	if _, err := NewOrderedSTRHistoryRange([]*DirSTR{strs[1], strs[0],
+		conflict}); err != CheckBadSTR {
+		t.Fatal("Expect", CheckBadSTR, "got", err)
+	}
+	if _, err := NewOrderedSTRHistoryRange([]*DirSTR{strs[0], nil}); err != ErrMalformedMessage {
+		t.Fatal("Expect", ErrMalformedMessage, "got", err)
+	}
+}
+
+func TestValidateOversizedSTRHistoryRange(t *testing.T) {
+	strs := newTestHistory(t, 3)
+	defer func(n int) { MaxSTRHistoryRangeLen = n }(MaxSTRHistoryRangeLen)
+	MaxSTRHistoryRangeLen = 3
+	if err := NewSTRHistoryRange(strs).Validate(); err != ErrMalformedMessage {
+		t.Fatal("Expect", ErrMalformedMessage, "got", err)
+	}
+	if err := NewSTRHistoryRange(strs[:3]).Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
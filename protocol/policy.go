@@ -2,6 +2,7 @@ package protocol
 
 import (
 	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
 	"github.com/coniks-sys/coniks-go/crypto/vrf"
 	"github.com/coniks-sys/coniks-go/merkletree"
 	"github.com/coniks-sys/coniks-go/utils"
@@ -15,11 +16,112 @@ type Timestamp uint64
 // of the VRF key used to generate private indices,
 // the cryptographic algorithms in use, as well as
 // the protocol version number.
+//
+// PreviousVrfPublicKey is only set in the policies of the STR in which
+// the directory rotates its VRF key (see directory.RotateVRFKey()),
+// and announces the rotation from the VRF key of the previous STR
+// to VrfPublicKey.
+//
+// PolicyChange is only set in the policies of the STR which announces
+// a change of the directory's policies (see directory.ChangePolicies()),
+// and holds the policies that take effect in the STR of the next epoch.
+//
+// DirectoryName optionally names the directory (see directory.NewNamed()).
+// It is included in the directory's initial STR, and thus in the
+// directory's identity (see auditor.ComputeDirectoryIdentity()), so that
+// several directories signing their STRs with the same key can't end up
+// with the same identity. It never changes during the directory's lifetime.
+//
+// Metadata optionally holds the directory's declared auditors and contact
+// information (see directory.SetMetadata()), so that clients can discover
+// the directory's official auditors from its signed STRs.
 type Policies struct {
-	Version       string
-	HashID        string
-	VrfPublicKey  vrf.PublicKey
+	Version              string
+	HashID               string
+	SignatureID          string
+	VrfPublicKey         vrf.PublicKey
+	PreviousVrfPublicKey vrf.PublicKey `json:",omitempty"`
+	EpochDeadline        Timestamp
+	PolicyChange         *PolicyChange      `json:",omitempty"`
+	DirectoryName        string             `json:",omitempty"`
+	Metadata             *DirectoryMetadata `json:",omitempty"`
+}
+
+// DirectoryMetadata is the auditor-relevant metadata a directory declares
+// in the policies of its STRs: the endpoints (e.g. addresses) of the
+// Auditors the directory considers official, and the Contact
+// information of the directory's operator.
+type DirectoryMetadata struct {
+	Auditors []string
+	Contact  string `json:",omitempty"`
+}
+
+// Serialize serializes the directory metadata for signing the tree root.
+// Each field is length-prefixed, and the auditors are preceded by their
+// number.
+func (m *DirectoryMetadata) Serialize() []byte {
+	var bs []byte
+	bs = append(bs, utils.ULongToBytes(uint64(len(m.Auditors)))...)
+	for _, a := range m.Auditors {
+		bs = append(bs, utils.ULongToBytes(uint64(len(a)))...)
+		bs = append(bs, []byte(a)...)
+	}
+	bs = append(bs, utils.ULongToBytes(uint64(len(m.Contact)))...)
+	bs = append(bs, []byte(m.Contact)...)
+	return bs
+}
+
+// A PolicyChangeRequest is a request from a directory's operator to
+// change the directory's epoch deadline and the hash function linking
+// its STRs (see directory.ChangePolicies()). An empty HashID keeps
+// the directory's hash function. The directory's VRF key is changed
+// with directory.RotateVRFKey() instead.
+type PolicyChangeRequest struct {
 	EpochDeadline Timestamp
+	HashID        string `json:",omitempty"`
+}
+
+// A PolicyChange is the announcement of a change of the directory's
+// policies included in an STR: the STR for the given Epoch, which
+// directly follows the announcing STR, uses the new EpochDeadline
+// and HashID.
+// If SignatureID or SigningKey is set, the STR for Epoch is also signed
+// using the signature scheme SignatureID or the signing key SigningKey
+// (see directory.RotateSigningKey()), so that clients and auditors can
+// verify that the directory announced the change under its previous
+// signing key.
+type PolicyChange struct {
+	Epoch         uint64
+	EpochDeadline Timestamp
+	HashID        string
+	SignatureID   string         `json:",omitempty"`
+	SigningKey    sign.PublicKey `json:",omitempty"`
+}
+
+// Serialize serializes the announced policy change for signing the
+// tree root. The hash function, the signature scheme and the signing
+// key are length-prefixed; the latter two are only included if either
+// is set.
+func (c *PolicyChange) Serialize() []byte {
+	var bs []byte
+	bs = append(bs, utils.ULongToBytes(c.Epoch)...)
+	bs = append(bs, utils.ULongToBytes(uint64(len(c.HashID)))...)
+	bs = append(bs, []byte(c.HashID)...)
+	bs = append(bs, utils.ULongToBytes(uint64(c.EpochDeadline))...)
+	if c.SignatureID != "" || c.SigningKey != nil {
+		bs = append(bs, utils.ULongToBytes(uint64(len(c.SignatureID)))...)
+		bs = append(bs, []byte(c.SignatureID)...)
+		bs = append(bs, utils.ULongToBytes(uint64(len(c.SigningKey)))...)
+		bs = append(bs, c.SigningKey...)
+	}
+	return bs
+}
+
+// Adopts returns whether p are exactly the policies announced
+// by the policy change c, i.e. whether p use the announced
+// epoch deadline and hash function.
+func (c *PolicyChange) Adopts(p *Policies) bool {
+	return p.EpochDeadline == c.EpochDeadline && p.HashID == c.HashID
 }
 
 var _ merkletree.AssocData = (*Policies)(nil)
@@ -30,25 +132,100 @@ func NewPolicies(epDeadline Timestamp, vrfPublicKey vrf.PublicKey) *Policies {
 	return &Policies{
 		Version:       Version,
 		HashID:        crypto.HashID,
+		SignatureID:   sign.Ed25519ID,
 		VrfPublicKey:  vrfPublicKey,
 		EpochDeadline: epDeadline,
 	}
 }
 
+// NewPoliciesWithHasher is like NewPolicies but selects the
+// hash function h for linking the directory's STRs (see crypto.Hasher).
+func NewPoliciesWithHasher(epDeadline Timestamp, vrfPublicKey vrf.PublicKey,
+	h crypto.Hasher) *Policies {
+	p := NewPolicies(epDeadline, vrfPublicKey)
+	p.HashID = h.ID()
+	return p
+}
+
+var _ merkletree.HasherSelector = (*Policies)(nil)
+
+// Hasher returns the hash function declared by p.HashID,
+// or nil if it is unknown (see crypto.GetHasher()).
+func (p *Policies) Hasher() crypto.Hasher {
+	return crypto.GetHasher(p.HashID)
+}
+
+// SignatureAlgorithm returns the signature scheme declared by
+// p.SignatureID, or nil if it is unknown (see sign.GetAlgorithm()).
+// Policies which don't declare a signature scheme use Ed25519.
+func (p *Policies) SignatureAlgorithm() sign.Algorithm {
+	if p.SignatureID == "" {
+		return sign.DefaultAlgorithm
+	}
+	return sign.GetAlgorithm(p.SignatureID)
+}
+
+// Tags of the optional sections of the policies' serialization,
+// in the order in which they are serialized.
+const (
+	signatureIDTag byte = iota + 1
+	previousVrfKeyTag
+	policyChangeTag
+	directoryNameTag
+	metadataTag
+)
+
+// appendSection appends the optional section with the given tag to bs.
+// The section is length-prefixed, so that it can't be mistaken for
+// the following sections.
+func appendSection(bs []byte, tag byte, section []byte) []byte {
+	bs = append(bs, tag)
+	bs = append(bs, utils.ULongToBytes(uint64(len(section)))...)
+	return append(bs, section...)
+}
+
 // Serialize serializes the policies for signing the tree root.
 // Default policies serialization includes the library version
 // (see version.go),
 // the cryptographic algorithms in use (i.e., the hashing algorithm),
-// the epoch deadline and the public part of the VRF key.
+// the public part of the VRF key and the epoch deadline.
+// It is followed by the signature scheme if it isn't the default one,
+// the previous VRF key if the policies announce a rotation of the VRF
+// key, the announced policy change, the directory name and the
+// directory metadata, if any, each as a tagged section
+// (see appendSection()).
 func (p *Policies) Serialize() []byte {
 	var bs []byte
 	bs = append(bs, []byte(p.Version)...)                           // protocol version
 	bs = append(bs, []byte(p.HashID)...)                            // cryptographic algorithms in use
 	bs = append(bs, p.VrfPublicKey...)                              // vrf public key
 	bs = append(bs, utils.ULongToBytes(uint64(p.EpochDeadline))...) // epoch deadline
+	// only non-default signature schemes are serialized, so that
+	// STRs signed before schemes could be selected still verify
+	if p.SignatureID != "" && p.SignatureID != sign.Ed25519ID {
+		bs = appendSection(bs, signatureIDTag, []byte(p.SignatureID)) // signature scheme in use
+	}
+	if p.RotatesVRFKey() {
+		bs = appendSection(bs, previousVrfKeyTag, p.PreviousVrfPublicKey) // previous vrf public key
+	}
+	if p.PolicyChange != nil {
+		bs = appendSection(bs, policyChangeTag, p.PolicyChange.Serialize()) // announced policy change
+	}
+	if p.DirectoryName != "" {
+		bs = appendSection(bs, directoryNameTag, []byte(p.DirectoryName)) // directory name
+	}
+	if p.Metadata != nil {
+		bs = appendSection(bs, metadataTag, p.Metadata.Serialize()) // directory metadata
+	}
 	return bs
 }
 
+// RotatesVRFKey returns whether p announces a rotation of the
+// directory's VRF key (see PreviousVrfPublicKey).
+func (p *Policies) RotatesVRFKey() bool {
+	return len(p.PreviousVrfPublicKey) != 0
+}
+
 // GetPolicies returns the set of policies included in the STR.
 func GetPolicies(str *merkletree.SignedTreeRoot) *Policies {
 	return str.Ad.(*Policies)
@@ -0,0 +1,76 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+func TestPolicyChangeSerializeHashID(t *testing.T) {
+	// the hash function can't absorb the epoch deadline
+	c1 := &PolicyChange{Epoch: 1, HashID: "SHAKE128", EpochDeadline: 200}
+	c2 := &PolicyChange{Epoch: 1,
+		HashID: "SHAKE128" + string(utils.ULongToBytes(200))}
+	if bytes.Equal(c1.Serialize(), c2.Serialize()) {
+		t.Fatal("Expect distinct policy changes to serialize differently")
+	}
+}
+
+func TestPoliciesSerializeSections(t *testing.T) {
+	vrfPK, _ := crypto.NewStaticTestVRFKey().Public()
+	key := []byte("a signature scheme or a vrf key")
+
+	// a signature scheme can't be mistaken for a previous VRF key
+	p1 := NewPolicies(1, vrfPK)
+	p1.SignatureID = string(key)
+	p2 := NewPolicies(1, vrfPK)
+	p2.PreviousVrfPublicKey = key
+	if bytes.Equal(p1.Serialize(), p2.Serialize()) {
+		t.Fatal("Expect distinct policies to serialize differently")
+	}
+
+	// default policies serialize as they always did
+	p := NewPolicies(1, vrfPK)
+	var bs []byte
+	bs = append(bs, []byte(p.Version)...)
+	bs = append(bs, []byte(p.HashID)...)
+	bs = append(bs, p.VrfPublicKey...)
+	bs = append(bs, utils.ULongToBytes(1)...)
+	if !bytes.Equal(p.Serialize(), bs) {
+		t.Fatal("Expect the default policies' serialization to be unchanged")
+	}
+}
+
+func TestPoliciesSerializeDistinct(t *testing.T) {
+	vrfPK, _ := crypto.NewStaticTestVRFKey().Public()
+	change := &PolicyChange{Epoch: 16, HashID: "SHAKE128", EpochDeadline: 200}
+	// the length-prefixed name has the same bytes as the change
+	// if neither is tagged and the hash function isn't length-prefixed
+	name := "SHAKE128" + string(utils.ULongToBytes(200))
+	metadata := &DirectoryMetadata{Auditors: []string{"auditor"}, Contact: "contact"}
+
+	var all []*Policies
+	for _, f := range []func(p *Policies){
+		func(p *Policies) {},
+		func(p *Policies) { p.PolicyChange = change },
+		func(p *Policies) { p.DirectoryName = name },
+		func(p *Policies) { p.DirectoryName = string(metadata.Serialize()) },
+		func(p *Policies) { p.Metadata = metadata },
+		func(p *Policies) { p.PolicyChange = change; p.DirectoryName = "name" },
+		func(p *Policies) { p.DirectoryName = "name"; p.Metadata = metadata },
+		func(p *Policies) { p.PolicyChange = change; p.Metadata = metadata },
+	} {
+		p := NewPolicies(1, vrfPK)
+		f(p)
+		all = append(all, p)
+	}
+	for i := range all {
+		for j := i + 1; j < len(all); j++ {
+			if bytes.Equal(all[i].Serialize(), all[j].Serialize()) {
+				t.Error("Expect policies", i, "and", j, "to serialize differently")
+			}
+		}
+	}
+}
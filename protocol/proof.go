@@ -0,0 +1,163 @@
+// Implements the verification of a directory proof on its own, e.g. by
+// a client which receives the proof from an untrusted intermediary and
+// never talks to the directory itself.
+
+package protocol
+
+import (
+	"bytes"
+
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
+	"github.com/coniks-sys/coniks-go/merkletree"
+)
+
+// VerifyDirectoryProof verifies the directory proof df for the username
+// uname against the STR str and the VRF public key vrfKey, without any
+// further state: it checks that the proof was issued with str, that the
+// lookup index of its authentication path is the VRF output of uname
+// under vrfKey (see VerifyLookupIndex()), and that the authentication
+// path proves either the inclusion of uname's binding or its absence
+// in the tree committed to by str (see VerifyAuthPath()). The proven key,
+// if any, is the Leaf.Value of the proof's authentication path.
+//
+// VerifyDirectoryProof() doesn't verify str itself, i.e. its signature
+// or its place in the directory's hash chain; the caller must obtain
+// str from a trusted source, e.g. an auditor, or verify it before.
+// vrfKey is usually the VrfPublicKey in str's policies.
+//
+// VerifyDirectoryProof() returns ErrMalformedMessage if df or str is
+// malformed, CheckBadSTR if df wasn't issued with str, or the error of
+// the failed check.
+func VerifyDirectoryProof(df *DirectoryProof, uname string, str *DirSTR,
+	vrfKey vrf.PublicKey) error {
+	if df == nil || len(df.AP) == 0 || len(df.STR) == 0 ||
+		df.AP[0] == nil || df.AP[0].Leaf == nil ||
+		df.STR[0] == nil || df.STR[0].SignedTreeRoot == nil ||
+		str == nil || str.SignedTreeRoot == nil {
+		return ErrMalformedMessage
+	}
+	if !bytes.Equal(df.STR[0].Signature, str.Signature) ||
+		!bytes.Equal(df.STR[0].Serialize(), str.Serialize()) {
+		return CheckBadSTR
+	}
+	ap := df.AP[0]
+	if err := VerifyLookupIndex(uname, ap, vrfKey); err != nil {
+		return err
+	}
+	return VerifyAuthPath(uname, ap.Leaf.Value, ap, str)
+}
+
+// VerifyLookupIndex verifies that the lookup index of the
+// authentication path ap is the VRF output of uname under vrfKey,
+// and returns CheckBadVRFProof if it isn't.
+func VerifyLookupIndex(uname string, ap *merkletree.AuthenticationPath,
+	vrfKey vrf.PublicKey) error {
+	if !vrfKey.Verify([]byte(uname), ap.LookupIndex, ap.VrfProof) {
+		return CheckBadVRFProof
+	}
+	return nil
+}
+
+// A CommittedIndex is the lookup index Index of a username under the
+// VRF public key VrfKey, along with the VRF proof VrfProof binding the
+// index to the username, as verified once by a party which knows the
+// username (see CommitLookupIndex()). It allows a privacy-preserving
+// relay to forward only the index, never the username itself, to a
+// verifier of the username's proofs (see
+// VerifyDirectoryProofForIndex()).
+type CommittedIndex struct {
+	Index    []byte
+	VrfProof []byte
+	VrfKey   vrf.PublicKey
+}
+
+// CommitLookupIndex verifies the lookup index of the authentication
+// path ap for uname under vrfKey (see VerifyLookupIndex()), and returns
+// the verified index as a CommittedIndex. It returns
+// ErrMalformedMessage if ap is nil, or CheckBadVRFProof if the
+// verification fails.
+func CommitLookupIndex(uname string, ap *merkletree.AuthenticationPath,
+	vrfKey vrf.PublicKey) (*CommittedIndex, error) {
+	if ap == nil {
+		return nil, ErrMalformedMessage
+	}
+	if err := VerifyLookupIndex(uname, ap, vrfKey); err != nil {
+		return nil, err
+	}
+	return &CommittedIndex{
+		Index:    append([]byte(nil), ap.LookupIndex...),
+		VrfProof: append([]byte(nil), ap.VrfProof...),
+		VrfKey:   append(vrf.PublicKey(nil), vrfKey...),
+	}, nil
+}
+
+// VerifyDirectoryProofForIndex verifies the directory proof df for the
+// username whose lookup index was committed to by ci against the STR
+// str, as VerifyDirectoryProof() does, but without the username: rather
+// than verifying the VRF proof of df for the username, it checks that
+// df's lookup index and VRF proof are the ones of ci, which were
+// verified for the username. This is sound since the VRF proofs of the
+// directory are deterministic. The commitment of a proof of inclusion
+// can't be opened without the username either, so
+// VerifyDirectoryProofForIndex() only verifies whether the username is
+// bound in the tree committed to by str (see
+// merkletree.AuthenticationPath.VerifyIndex()): for a proof of
+// inclusion, the proven key, i.e. Leaf.Value, is not authenticated,
+// and only a party which knows the username can verify it (see
+// VerifyAuthPath()).
+//
+// VerifyDirectoryProofForIndex() returns ErrMalformedMessage if df,
+// ci or str is malformed, CheckBadSTR if df wasn't issued with str,
+// CheckBadVRFProof if str's VRF public key isn't ci.VrfKey (e.g. after
+// a rotation of the key, which changes the username's index), or if
+// df's lookup index or VRF proof isn't the committed one, or the error
+// of the failed check of the authentication path.
+func VerifyDirectoryProofForIndex(df *DirectoryProof, ci *CommittedIndex,
+	str *DirSTR) error {
+	if df == nil || len(df.AP) == 0 || len(df.STR) == 0 ||
+		df.AP[0] == nil || df.AP[0].Leaf == nil ||
+		df.STR[0] == nil || df.STR[0].SignedTreeRoot == nil ||
+		str == nil || str.SignedTreeRoot == nil || str.Policies == nil ||
+		ci == nil || len(ci.Index) == 0 {
+		return ErrMalformedMessage
+	}
+	if !bytes.Equal(df.STR[0].Signature, str.Signature) ||
+		!bytes.Equal(df.STR[0].Serialize(), str.Serialize()) {
+		return CheckBadSTR
+	}
+	ap := df.AP[0]
+	if !bytes.Equal(str.Policies.VrfPublicKey, ci.VrfKey) ||
+		!bytes.Equal(ap.LookupIndex, ci.Index) ||
+		!bytes.Equal(ap.VrfProof, ci.VrfProof) {
+		return CheckBadVRFProof
+	}
+	return AuthPathError(ap.VerifyIndex(str.TreeHash))
+}
+
+// VerifyAuthPath verifies that the authentication path ap proves either
+// the binding of uname to key, or the absence of uname, in the tree
+// committed to by str (see merkletree.AuthenticationPath.Verify()).
+// It returns the error of the failed check as mapped by AuthPathError().
+func VerifyAuthPath(uname string, key []byte, ap *merkletree.AuthenticationPath,
+	str *DirSTR) error {
+	return AuthPathError(ap.Verify([]byte(uname), key, str.TreeHash))
+}
+
+// AuthPathError maps an error returned by the verification of an
+// authentication path to the corresponding ErrorCode.
+func AuthPathError(err error) error {
+	switch err {
+	case merkletree.ErrBindingsDiffer:
+		return CheckBindingsDiffer
+	case merkletree.ErrUnverifiableCommitment:
+		return CheckBadCommitment
+	case merkletree.ErrIndicesMismatch:
+		return CheckBadLookupIndex
+	case merkletree.ErrUnequalTreeHashes, merkletree.ErrMalformedAuthPath:
+		return CheckBadAuthPath
+	case nil:
+		return nil
+	default:
+		panic("[coniks] Unknown error: " + err.Error())
+	}
+}
@@ -0,0 +1,175 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
+	"github.com/coniks-sys/coniks-go/merkletree"
+)
+
+// newTestProof returns the proof for uname in a new tree which only
+// binds alice, the STR it was issued with, and the tree's VRF public key.
+func newTestProof(t *testing.T, uname string) (*DirectoryProof, *DirSTR, vrf.PublicKey) {
+	vrfKey, err := vrf.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vrfPublicKey, _ := vrfKey.Public()
+	pad, err := merkletree.NewPAD(NewPolicies(10, vrfPublicKey), signKey, vrfKey, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pad.Set("alice", []byte("key")); err != nil {
+		t.Fatal(err)
+	}
+	pad.Update(nil)
+	ap, err := pad.Lookup(uname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	str := NewDirSTR(pad.LatestSTR())
+	return &DirectoryProof{
+		AP:  []*merkletree.AuthenticationPath{ap},
+		STR: []*DirSTR{str},
+	}, str, vrfPublicKey
+}
+
+func TestVerifyDirectoryProof(t *testing.T) {
+	for _, uname := range []string{"alice", "bob"} {
+		df, str, vrfKey := newTestProof(t, uname)
+		if err := VerifyDirectoryProof(df, uname, str, vrfKey); err != nil {
+			t.Error("Expect the proof for", uname, "to verify, got", err)
+		}
+	}
+}
+
+func TestVerifyDirectoryProofTamperedAuthPath(t *testing.T) {
+	df, str, vrfKey := newTestProof(t, "alice")
+	ap := *df.AP[0]
+	ap.PrunedTree = append([][crypto.HashSizeByte]byte{}, ap.PrunedTree...)
+	ap.PrunedTree[0][0] ^= 1
+	df.AP[0] = &ap
+	if err := VerifyDirectoryProof(df, "alice", str, vrfKey); err != CheckBadAuthPath {
+		t.Error("Expect", CheckBadAuthPath, "got", err)
+	}
+
+	// a forged key fails the commitment
+	df, str, vrfKey = newTestProof(t, "alice")
+	leaf := *df.AP[0].Leaf
+	leaf.Value = []byte("forged")
+	df.AP[0].Leaf = &leaf
+	if err := VerifyDirectoryProof(df, "alice", str, vrfKey); err != CheckBadCommitment {
+		t.Error("Expect", CheckBadCommitment, "got", err)
+	}
+}
+
+func TestVerifyDirectoryProofBadVRFProof(t *testing.T) {
+	df, str, vrfKey := newTestProof(t, "alice")
+	if err := VerifyDirectoryProof(df, "bob", str, vrfKey); err != CheckBadVRFProof {
+		t.Error("Expect", CheckBadVRFProof, "got", err)
+	}
+
+	// the proof doesn't verify under another VRF key
+	_, _, otherKey := newTestProof(t, "alice")
+	if err := VerifyDirectoryProof(df, "alice", str, otherKey); err != CheckBadVRFProof {
+		t.Error("Expect", CheckBadVRFProof, "got", err)
+	}
+}
+
+func TestVerifyDirectoryProofRootMismatch(t *testing.T) {
+	df, str, vrfKey := newTestProof(t, "alice")
+	forged := *str.SignedTreeRoot
+	forged.TreeHash = append([]byte{}, forged.TreeHash...)
+	forged.TreeHash[0] ^= 1
+	forgedSTR := &DirSTR{SignedTreeRoot: &forged, Policies: str.Policies}
+	df.STR[0] = forgedSTR
+	if err := VerifyDirectoryProof(df, "alice", forgedSTR, vrfKey); err != CheckBadAuthPath {
+		t.Error("Expect", CheckBadAuthPath, "got", err)
+	}
+
+	// the proof must have been issued with the given STR
+	df.STR[0] = str
+	if err := VerifyDirectoryProof(df, "alice", forgedSTR, vrfKey); err != CheckBadSTR {
+		t.Error("Expect", CheckBadSTR, "got", err)
+	}
+	if err := VerifyDirectoryProof(&DirectoryProof{}, "alice", str,
+		vrfKey); err != ErrMalformedMessage {
+		t.Error("Expect", ErrMalformedMessage, "got", err)
+	}
+}
+
+func TestVerifyDirectoryProofForIndex(t *testing.T) {
+	for _, uname := range []string{"alice", "bob"} {
+		df, str, vrfKey := newTestProof(t, uname)
+		ci, err := CommitLookupIndex(uname, df.AP[0], vrfKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := VerifyDirectoryProofForIndex(df, ci, str); err != nil {
+			t.Error("Expect the proof for", uname, "to verify, got", err)
+		}
+	}
+
+	// the index can only be committed for the right username
+	df, str, vrfKey := newTestProof(t, "alice")
+	if _, err := CommitLookupIndex("bob", df.AP[0], vrfKey); err != CheckBadVRFProof {
+		t.Error("Expect", CheckBadVRFProof, "got", err)
+	}
+
+	// a tampered authentication path fails without the username
+	ci, _ := CommitLookupIndex("alice", df.AP[0], vrfKey)
+	ap := *df.AP[0]
+	ap.PrunedTree = append([][crypto.HashSizeByte]byte{}, ap.PrunedTree...)
+	ap.PrunedTree[0][0] ^= 1
+	tampered := &DirectoryProof{AP: []*merkletree.AuthenticationPath{&ap}, STR: df.STR}
+	if err := VerifyDirectoryProofForIndex(tampered, ci, str); err != CheckBadAuthPath {
+		t.Error("Expect", CheckBadAuthPath, "got", err)
+	}
+	if err := VerifyDirectoryProofForIndex(df, nil, str); err != ErrMalformedMessage {
+		t.Error("Expect", ErrMalformedMessage, "got", err)
+	}
+}
+
+func TestVerifyDirectoryProofForWrongIndex(t *testing.T) {
+	df, str, vrfKey := newTestProof(t, "alice")
+	ci, err := CommitLookupIndex("alice", df.AP[0], vrfKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the proof for another username has another index
+	wrong := *ci
+	wrong.Index = append([]byte{}, ci.Index...)
+	wrong.Index[0] ^= 1
+	if err := VerifyDirectoryProofForIndex(df, &wrong, str); err != CheckBadVRFProof {
+		t.Error("Expect", CheckBadVRFProof, "got", err)
+	}
+
+	// the server's VRF proof must be the committed one
+	ap := *df.AP[0]
+	ap.VrfProof = append([]byte{}, ap.VrfProof...)
+	ap.VrfProof[0] ^= 1
+	forged := &DirectoryProof{AP: []*merkletree.AuthenticationPath{&ap}, STR: df.STR}
+	if err := VerifyDirectoryProofForIndex(forged, ci, str); err != CheckBadVRFProof {
+		t.Error("Expect", CheckBadVRFProof, "got", err)
+	}
+
+	// an index committed under another VRF key doesn't verify
+	other, otherSTR, otherKey := newTestProof(t, "alice")
+	otherCI, err := CommitLookupIndex("alice", other.AP[0], otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyDirectoryProofForIndex(df, otherCI, str); err != CheckBadVRFProof {
+		t.Error("Expect", CheckBadVRFProof, "got", err)
+	}
+	if err := VerifyDirectoryProofForIndex(other, ci, otherSTR); err != CheckBadVRFProof {
+		t.Error("Expect", CheckBadVRFProof, "got", err)
+	}
+}
@@ -0,0 +1,62 @@
+// Defines the top-level signed root of a sharded CONIKS directory,
+// and how usernames are assigned to the directory's shards.
+
+package protocol
+
+import (
+	"math/bits"
+
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// MaxShards is the maximum number of shards of a sharded CONIKS
+// directory, since shards are determined by the first byte of a
+// username's VRF index.
+const MaxShards = 256
+
+// A ShardRoot is the top-level signed root of a sharded CONIKS directory.
+// For a given epoch, a ShardRoot commits to the latest STR of each of
+// the directory's shards by including the hash of each shard STR
+// (see ShardSTRHash()), in shard order.
+type ShardRoot struct {
+	Epoch     uint64
+	ShardSTRs [][]byte
+	Signature []byte
+}
+
+// Serialize serializes the shard root for signing.
+func (r *ShardRoot) Serialize() []byte {
+	var bs []byte
+	bs = append(bs, utils.ULongToBytes(r.Epoch)...) // epoch
+	for _, h := range r.ShardSTRs {
+		bs = append(bs, h...) // shard STR hashes
+	}
+	return bs
+}
+
+// ShardSTRHash returns the hash of the given shard STR's signature
+// which a ShardRoot commits to (see DirSTR.Hash()).
+func ShardSTRHash(str *DirSTR) []byte {
+	return str.Hash()
+}
+
+// ValidShardCount returns whether a sharded directory can have
+// numShards shards, i.e. whether numShards is a power of two
+// no greater than MaxShards.
+func ValidShardCount(numShards int) bool {
+	return numShards > 0 && numShards <= MaxShards &&
+		numShards&(numShards-1) == 0
+}
+
+// ShardIndex returns the shard responsible for the given VRF index in
+// a sharded directory with numShards shards. The shard is determined by
+// the log2(numShards)-bit prefix of the index.
+// ShardIndex() panics if numShards isn't a valid shard count
+// (see ValidShardCount()).
+func ShardIndex(index []byte, numShards int) int {
+	if !ValidShardCount(numShards) {
+		panic("[coniks] Invalid number of shards")
+	}
+	prefixLen := uint(bits.TrailingZeros(uint(numShards)))
+	return int(index[0] >> (8 - prefixLen))
+}
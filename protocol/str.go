@@ -1,28 +1,189 @@
 package protocol
 
-import "github.com/coniks-sys/coniks-go/merkletree"
+import (
+	"bytes"
+	"sync/atomic"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/merkletree"
+)
 
 // DirSTR disambiguates merkletree.SignedTreeRoot's AssocData interface,
 // for the purpose of exporting and unmarshalling.
 type DirSTR struct {
 	*merkletree.SignedTreeRoot
 	Policies *Policies
+
+	hash atomic.Value // *strHash; caches Hash()
+}
+
+// An strHash caches the hash of an STR's signature sig
+// under the hash function identified by id.
+type strHash struct {
+	id   string
+	sig  []byte
+	hash []byte
 }
 
 // NewDirSTR constructs a new DirSTR from a merkletree.SignedTreeRoot
 func NewDirSTR(str *merkletree.SignedTreeRoot) *DirSTR {
 	return &DirSTR{
-		str,
-		str.Ad.(*Policies),
+		SignedTreeRoot: str,
+		Policies:       str.Ad.(*Policies),
 	}
 }
 
-// Serialize overrides merkletree.SignedTreeRoot.Serialize
+// Serialize overrides merkletree.SignedTreeRoot.Serialize.
+// Unlike Hash(), Serialize() isn't cached: checking whether any of the
+// serialized fields changed would cost as much as serializing them.
 func (str *DirSTR) Serialize() []byte {
 	return append(str.SerializeInternal(), str.Policies.Serialize()...)
 }
 
-// VerifyHashChain wraps merkletree.SignedTreeRoot.VerifyHashChain
+// Hash returns the hash of str's signature using the hash function
+// declared in str's policies, or nil if that hash function is unknown.
+// This is the hash the next STR commits to as its PreviousSTRHash.
+//
+// Hash() caches the hash in str, so that verifying long ranges of STRs
+// (e.g. by an auditor) doesn't hash each signature repeatedly. The
+// cached hash is recomputed if str's signature or hash function
+// changes. Hash() is safe for concurrent use.
+func (str *DirSTR) Hash() []byte {
+	h := str.Policies.Hasher()
+	if h == nil {
+		return nil
+	}
+	if c, ok := str.hash.Load().(*strHash); ok && c.id == h.ID() &&
+		bytes.Equal(c.sig, str.Signature) {
+		return append([]byte(nil), c.hash...)
+	}
+	hash := h.Digest(str.Signature)
+	str.hash.Store(&strHash{
+		id:   h.ID(),
+		sig:  append([]byte(nil), str.Signature...),
+		hash: hash,
+	})
+	return append([]byte(nil), hash...)
+}
+
+// ExpectedPreviousHash returns the hash which the STR directly following
+// prev must commit to as its PreviousSTRHash: the hash of prev's
+// signature under the hash function of the next STR, i.e. the hash
+// function announced for the next epoch by prev's policies (see
+// PolicyChange), or otherwise the one declared by prev's policies.
+// This allows a client to cheaply reject an STR which doesn't chain to
+// prev before verifying its signature. ExpectedPreviousHash() returns
+// the zero hash if the hash function is unknown.
+func ExpectedPreviousHash(prev *DirSTR) [crypto.HashSizeByte]byte {
+	var hash [crypto.HashSizeByte]byte
+	h := crypto.GetHasher(nextHashID(prev))
+	if h == nil {
+		return hash
+	}
+	if h.ID() == prev.Policies.HashID {
+		copy(hash[:], prev.Hash())
+	} else {
+		copy(hash[:], h.Digest(prev.Signature))
+	}
+	return hash
+}
+
+// nextHashID returns the identifier of the hash function which the STR
+// directly following prev declares, unless the directory changes its
+// hash function without announcing the change.
+func nextHashID(prev *DirSTR) string {
+	if c := prev.Policies.PolicyChange; c != nil && c.Epoch == prev.Epoch+1 {
+		return c.HashID
+	}
+	return prev.Policies.HashID
+}
+
+// VerifyHashChain checks whether str directly follows savedSTR,
+// as merkletree.SignedTreeRoot.VerifyHashChainWith does using the hash
+// function declared in str's policies, but reuses the cached hash of
+// savedSTR (see Hash()) if both STRs declare the same hash function.
+// It returns false if that hash function is unknown.
 func (str *DirSTR) VerifyHashChain(savedSTR *DirSTR) bool {
-	return str.SignedTreeRoot.VerifyHashChain(savedSTR.SignedTreeRoot)
+	h := str.Policies.Hasher()
+	if h == nil {
+		return false
+	}
+	var prevHash []byte
+	if savedSTR.Policies.Hasher() == h {
+		prevHash = savedSTR.Hash()
+	} else {
+		prevHash = h.Digest(savedSTR.Signature)
+	}
+	return str.PreviousEpoch == savedSTR.Epoch &&
+		str.Epoch == savedSTR.Epoch+1 &&
+		bytes.Equal(prevHash, str.PreviousSTRHash)
+}
+
+// ValidateGenesisSTR checks that str is a well-formed initial STR of a
+// directory whose public signing key is signKey, before a client or an
+// auditor pins it: str must be for epoch 0 and its previous epoch must
+// be 0, its previous STR hash must be a sentinel, i.e. either a
+// hash-sized random value (see merkletree.NewPAD()) or empty, and it
+// must be signed under signKey with the signature scheme declared in
+// its policies.
+// ValidateGenesisSTR() returns ErrMalformedMessage if str is malformed
+// or isn't an initial STR, or CheckBadSignature if str isn't signed
+// under signKey.
+func ValidateGenesisSTR(str *DirSTR, signKey sign.PublicKey) error {
+	if str == nil || str.SignedTreeRoot == nil || str.Policies == nil ||
+		str.Policies.Hasher() == nil {
+		return ErrMalformedMessage
+	}
+	if str.Epoch != 0 || str.PreviousEpoch != 0 {
+		return ErrMalformedMessage
+	}
+	if n := len(str.PreviousSTRHash); n != 0 && n != crypto.HashSizeByte {
+		return ErrMalformedMessage
+	}
+	alg := str.Policies.SignatureAlgorithm()
+	if alg == nil || !alg.Verify(signKey, str.Serialize(), str.Signature) {
+		return CheckBadSignature
+	}
+	return nil
+}
+
+// IsPrefixChain returns whether the STR range shorter is a prefix of
+// the STR range longer, i.e. whether both ranges start at the same
+// epoch, and each STR in shorter has the same contents and signature
+// as the STR of the same epoch in longer. An empty shorter range is a
+// prefix of any range.
+// Both ranges must be in chronological order, and each STR must
+// directly follow the previous STR of its range (see VerifyHashChain()).
+// IsPrefixChain() returns ErrMalformedMessage if longer is empty or if
+// either range includes a nil STR, and CheckBadSTR if the hash chain
+// of either range is broken. It doesn't verify the STRs' signatures.
+func IsPrefixChain(shorter, longer []*DirSTR) (bool, error) {
+	if len(longer) == 0 {
+		return false, ErrMalformedMessage
+	}
+	for _, strs := range [][]*DirSTR{shorter, longer} {
+		for i, str := range strs {
+			if str == nil {
+				return false, ErrMalformedMessage
+			}
+			if i > 0 && !str.VerifyHashChain(strs[i-1]) {
+				return false, CheckBadSTR
+			}
+		}
+	}
+
+	if len(shorter) == 0 {
+		return true, nil
+	}
+	if len(shorter) > len(longer) || shorter[0].Epoch != longer[0].Epoch {
+		return false, nil
+	}
+	for i, str := range shorter {
+		if !bytes.Equal(str.Serialize(), longer[i].Serialize()) ||
+			!bytes.Equal(str.Signature, longer[i].Signature) {
+			return false, nil
+		}
+	}
+	return true, nil
 }
@@ -1,8 +1,10 @@
 package protocol
 
 import (
+	"bytes"
 	"testing"
 
+	"github.com/coniks-sys/coniks-go/crypto"
 	"github.com/coniks-sys/coniks-go/crypto/sign"
 	"github.com/coniks-sys/coniks-go/crypto/vrf"
 	"github.com/coniks-sys/coniks-go/merkletree"
@@ -46,3 +48,198 @@ func TestVerifyHashChain(t *testing.T) {
 		savedSTR = str
 	}
 }
+
+func TestCachedHash(t *testing.T) {
+	str := newTestHistory(t, 1)[1]
+	h := str.Policies.Hasher()
+
+	first := str.Hash()
+	if !bytes.Equal(first, h.Digest(str.Signature)) {
+		t.Fatal("Expect the STR hash to be the digest of its signature")
+	}
+	if cached := str.Hash(); !bytes.Equal(cached, first) {
+		t.Fatal("Expect the cached hash to equal the computed hash")
+	}
+
+	// modifying the returned hash doesn't affect the cache
+	first[0] ^= 1
+	if !bytes.Equal(str.Hash(), h.Digest(str.Signature)) {
+		t.Fatal("Expect the cached hash to be unaffected by callers")
+	}
+
+	// the cache is invalidated if the signature changes
+	str.Signature = append([]byte(nil), str.Signature...)
+	str.Signature[0] ^= 1
+	if !bytes.Equal(str.Hash(), h.Digest(str.Signature)) {
+		t.Fatal("Expect the hash to be recomputed for a new signature")
+	}
+
+	// or if the hash function changes
+	p := *str.Policies
+	p.HashID = crypto.SHA512_256ID
+	str.Policies = &p
+	if !bytes.Equal(str.Hash(), crypto.GetHasher(crypto.SHA512_256ID).Digest(str.Signature)) {
+		t.Fatal("Expect the hash to be recomputed for a new hash function")
+	}
+}
+
+// BenchmarkVerifySTRRangeHashes checks the hash chain of a long range of
+// STRs repeatedly, as an auditor does when it serves and re-verifies
+// its observed history, with and without the cached STR hashes.
+func BenchmarkVerifySTRRangeHashes(b *testing.B) {
+	strs := newTestHistory(b, 1000)
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for j := 1; j < len(strs); j++ {
+				if !strs[j].VerifyHashChain(strs[j-1]) {
+					b.Fatal("Broken hash chain at epoch", j)
+				}
+			}
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for j := 1; j < len(strs); j++ {
+				h := strs[j].Policies.Hasher()
+				if !strs[j].SignedTreeRoot.VerifyHashChainWith(h, strs[j-1].SignedTreeRoot) {
+					b.Fatal("Broken hash chain at epoch", j)
+				}
+			}
+		}
+	})
+}
+
+// forkSTR returns a copy of str with a different tree hash,
+// signed under the test signing key.
+func forkSTR(str *DirSTR) *DirSTR {
+	root := *str.SignedTreeRoot
+	root.TreeHash = append([]byte{}, root.TreeHash...)
+	root.TreeHash[0] ^= 1
+	fork := &DirSTR{SignedTreeRoot: &root, Policies: str.Policies}
+	fork.Signature = crypto.NewStaticTestSigningKey().Sign(fork.Serialize())
+	return fork
+}
+
+func TestIsPrefixChain(t *testing.T) {
+	strs := newTestHistory(t, 10)
+	for _, n := range []int{0, 1, 5, len(strs)} {
+		if ok, err := IsPrefixChain(strs[:n], strs); err != nil || !ok {
+			t.Fatal("Expect a prefix of length", n, "got", ok, err)
+		}
+	}
+	if ok, err := IsPrefixChain(strs, strs[:5]); err != nil || ok {
+		t.Error("Expect a longer range not to be a prefix, got", ok, err)
+	}
+	if ok, err := IsPrefixChain(strs[1:5], strs); err != nil || ok {
+		t.Error("Expect a range starting at a later epoch not to be a prefix, got", ok, err)
+	}
+}
+
+func TestIsPrefixChainDivergent(t *testing.T) {
+	strs := newTestHistory(t, 10)
+	fork := append(append([]*DirSTR{}, strs[:3]...), forkSTR(strs[3]))
+	if ok, err := IsPrefixChain(fork, strs); err != nil || ok {
+		t.Fatal("Expect a divergent chain not to be a prefix, got", ok, err)
+	}
+
+	// the fork doesn't link to the next STR of the honest chain
+	broken := append(fork, strs[4])
+	if _, err := IsPrefixChain(broken, strs); err != CheckBadSTR {
+		t.Error("Expect", CheckBadSTR, "got", err)
+	}
+	if _, err := IsPrefixChain(strs[:2], []*DirSTR{strs[0], nil}); err != ErrMalformedMessage {
+		t.Error("Expect", ErrMalformedMessage, "got", err)
+	}
+	if _, err := IsPrefixChain(nil, nil); err != ErrMalformedMessage {
+		t.Error("Expect", ErrMalformedMessage, "got", err)
+	}
+}
+
+func TestIsPrefixChainMismatchedGenesis(t *testing.T) {
+	strs := newTestHistory(t, 5)
+	other := newTestHistory(t, 5)
+	if ok, err := IsPrefixChain(other[:3], strs); err != nil || ok {
+		t.Error("Expect a chain with another genesis STR not to be a prefix, got", ok, err)
+	}
+}
+
+func TestExpectedPreviousHash(t *testing.T) {
+	strs := newTestHistory(t, 3)
+	for i := 1; i < len(strs); i++ {
+		want := ExpectedPreviousHash(strs[i-1])
+		if !bytes.Equal(want[:], strs[i].PreviousSTRHash) {
+			t.Fatal("Expect the hash committed to by the STR for epoch", i)
+		}
+	}
+
+	// the STR following a forked STR doesn't commit to the same hash
+	fork := forkSTR(strs[1])
+	if got := ExpectedPreviousHash(fork); bytes.Equal(got[:], strs[2].PreviousSTRHash) {
+		t.Fatal("Expect a different hash for a forked STR")
+	}
+}
+
+func TestExpectedPreviousHashAnnouncedHasher(t *testing.T) {
+	str := newTestHistory(t, 1)[1]
+	p := *str.Policies
+	p.PolicyChange = &PolicyChange{
+		Epoch:         str.Epoch + 1,
+		EpochDeadline: p.EpochDeadline,
+		HashID:        crypto.SHA512_256ID,
+	}
+	announcing := &DirSTR{SignedTreeRoot: str.SignedTreeRoot, Policies: &p}
+	got := ExpectedPreviousHash(announcing)
+	want := crypto.GetHasher(crypto.SHA512_256ID).Digest(str.Signature)
+	if !bytes.Equal(got[:], want) {
+		t.Fatal("Expect the hash under the announced hash function")
+	}
+}
+
+func TestValidateGenesisSTR(t *testing.T) {
+	signKey := crypto.NewStaticTestSigningKey()
+	pk, _ := signKey.Public()
+	strs := newTestHistory(t, 1)
+	genesis := strs[0]
+	if err := ValidateGenesisSTR(genesis, pk); err != nil {
+		t.Fatal(err)
+	}
+
+	// resign returns a copy of genesis modified by f and signed by
+	// the directory, so that only the modification is malformed
+	resign := func(f func(str *merkletree.SignedTreeRoot)) *DirSTR {
+		str := *genesis.SignedTreeRoot
+		f(&str)
+		str.Signature = signKey.Sign(NewDirSTR(&str).Serialize())
+		return NewDirSTR(&str)
+	}
+	otherKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPK, _ := otherKey.Public()
+	bogus := *genesis.SignedTreeRoot
+	bogus.Signature = append([]byte{}, genesis.Signature...)
+	bogus.Signature[0] ^= 1
+
+	for _, tc := range []struct {
+		name string
+		str  *DirSTR
+		key  sign.PublicKey
+		want error
+	}{
+		{"nil", nil, pk, ErrMalformedMessage},
+		{"not the initial STR", strs[1], pk, ErrMalformedMessage},
+		{"previous epoch", resign(func(str *merkletree.SignedTreeRoot) {
+			str.PreviousEpoch = 1
+		}), pk, ErrMalformedMessage},
+		{"non-sentinel previous hash", resign(func(str *merkletree.SignedTreeRoot) {
+			str.PreviousSTRHash = str.PreviousSTRHash[:crypto.HashSizeByte/2]
+		}), pk, ErrMalformedMessage},
+		{"bogus signature", NewDirSTR(&bogus), pk, CheckBadSignature},
+		{"other key", genesis, otherPK, CheckBadSignature},
+	} {
+		if err := ValidateGenesisSTR(tc.str, tc.key); err != tc.want {
+			t.Error(tc.name, ": Expect", tc.want, "got", err)
+		}
+	}
+}
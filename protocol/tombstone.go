@@ -0,0 +1,62 @@
+// Defines the tombstones which a directory binds to a username in
+// place of its key once the binding is no longer valid.
+
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// A TombstoneReason states why a directory replaced a username's key
+// with a tombstone.
+type TombstoneReason byte
+
+const (
+	// NoTombstone means that a value isn't a tombstone.
+	NoTombstone TombstoneReason = iota
+	// TombstoneExpired means that the binding wasn't refreshed
+	// before its TTL ran out (see RegistrationRequest.TTL).
+	TombstoneExpired
+	// TombstoneDeleted is reserved for deleted bindings. The directory
+	// doesn't support deleting bindings yet.
+	TombstoneDeleted
+)
+
+// tombstonePrefix starts every tombstone. Registrations of a key
+// starting with tombstonePrefix are rejected, so that a user can't
+// register a key which looks like a tombstone.
+var tombstonePrefix = []byte("\x00coniks-tombstone")
+
+// NewTombstone returns the value a directory binds to a username in
+// place of its key from epoch on, for the given reason.
+func NewTombstone(reason TombstoneReason, epoch uint64) []byte {
+	var ts []byte
+	ts = append(ts, tombstonePrefix...)
+	ts = append(ts, byte(reason))
+	ts = append(ts, utils.ULongToBytes(epoch)...)
+	return ts
+}
+
+// ParseTombstone returns the reason and the epoch of the tombstone
+// value (see NewTombstone()), or NoTombstone if value isn't a
+// tombstone.
+func ParseTombstone(value []byte) (TombstoneReason, uint64) {
+	if len(value) != len(tombstonePrefix)+1+8 ||
+		!bytes.HasPrefix(value, tombstonePrefix) {
+		return NoTombstone, 0
+	}
+	reason := TombstoneReason(value[len(tombstonePrefix)])
+	if reason != TombstoneExpired && reason != TombstoneDeleted {
+		return NoTombstone, 0
+	}
+	return reason, binary.LittleEndian.Uint64(value[len(tombstonePrefix)+1:])
+}
+
+// IsTombstoneKey reports whether key can't be registered since it
+// starts like a tombstone.
+func IsTombstoneKey(key []byte) bool {
+	return bytes.HasPrefix(key, tombstonePrefix)
+}